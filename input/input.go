@@ -288,6 +288,11 @@ func MakeTaprootHtlcSucceedInput(op *wire.OutPoint, signDesc *SignDescriptor,
 	}
 }
 
+// Preimage returns the payment preimage revealed by the witness of the input.
+func (h *HtlcSucceedInput) Preimage() []byte {
+	return h.preimage
+}
+
 // CraftInputScript returns a valid set of input scripts allowing this output
 // to be spent. The returns input scripts should target the input at location
 // txIndex within the passed transaction. The input scripts generated by this
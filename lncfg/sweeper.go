@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/btcsuite/btcd/btcutil"
 	"github.com/lightningnetwork/lnd/contractcourt"
 	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
 	"github.com/lightningnetwork/lnd/sweep"
@@ -17,6 +18,11 @@ const (
 	// MaxAllowedFeeRate is the largest fee rate in sat/vb that we allow
 	// when configuring the MaxFeeRate.
 	MaxAllowedFeeRate = 10_000
+
+	// MaxBatchLookahead is the largest number of blocks, one week, that we
+	// allow when configuring the BatchLookahead. Beyond that, the sweeps
+	// batched with the most urgent one would no longer be near-term.
+	MaxBatchLookahead = 1008
 )
 
 //nolint:lll
@@ -26,6 +32,16 @@ type Sweeper struct {
 
 	NoDeadlineConfTarget uint32 `long:"nodeadlineconftarget" description:"The conf target to use when sweeping non-time-sensitive outputs. This is useful for sweeping outputs that are not time-sensitive, and can be swept at a lower fee rate."`
 
+	BatchLookahead uint32 `long:"batchlookahead" description:"The number of blocks after the deadline of the most urgent sweep within which other sweeps are batched into the same transaction to save fees. Set to 0 to only batch sweeps sharing the same deadline. The max value is 1008."`
+
+	WalletMinConfs int32 `long:"walletminconfs" description:"The min number of confirmations of the wallet utxos the sweeper adds as inputs to sweeps that can't pay for their own fees. Requiring deeper confirmations reduces the reorg risk of high-value sweeps."`
+
+	CoinSelectTimeout time.Duration `long:"coinselecttimeout" description:"The max time the sweeper spends adding wallet inputs to a sweep while holding the coin selection lock, so other subsystems aren't starved on large wallets. Once it's exceeded, the sweep is retried in the next block. Set to 0 for no bound."`
+
+	SweepAccount string `long:"sweepaccount" description:"The optional wallet account the sweeper takes the wallet inputs of sweeps that can't pay for their own fees from first, so the funds reserved for sweeping can be kept apart. The default account is used when it can't cover the fees."`
+
+	WalletReserve btcutil.Amount `long:"walletreserve" description:"The wallet balance in satoshis the sweeper leaves unselected when adding wallet inputs to sweeps that can't pay for their own fees, so enough funds are left to bump the fees of other channels. Set to 0 for no reserve."`
+
 	Budget *contractcourt.BudgetConfig `group:"sweeper.budget" namespace:"budget" long:"budget" description:"An optional config group that's used for the automatic sweep fee estimation. The Budget config gives options to limits ones fee exposure when sweeping unilateral close outputs and the fee rate calculated from budgets is capped at sweeper.maxfeerate. Check the budget config options for more details."`
 }
 
@@ -50,6 +66,24 @@ func (s *Sweeper) Validate() error {
 		return fmt.Errorf("nodeadlineconftarget must be at least 144")
 	}
 
+	// We require the batch lookahead to be no greater than 1008 blocks.
+	if s.BatchLookahead > MaxBatchLookahead {
+		return fmt.Errorf("batchlookahead must be <= 1008")
+	}
+
+	// Make sure the wallet utxos used by the sweeper are confirmed.
+	if s.WalletMinConfs < 1 {
+		return fmt.Errorf("walletminconfs must be at least 1")
+	}
+
+	if s.CoinSelectTimeout < 0 {
+		return fmt.Errorf("coinselecttimeout must be positive")
+	}
+
+	if s.WalletReserve < 0 {
+		return fmt.Errorf("walletreserve must be positive")
+	}
+
 	// Validate the budget configuration.
 	if err := s.Budget.Validate(); err != nil {
 		return fmt.Errorf("invalid budget config: %w", err)
@@ -63,6 +97,7 @@ func DefaultSweeperConfig() *Sweeper {
 	return &Sweeper{
 		MaxFeeRate:           sweep.DefaultMaxFeeRate,
 		NoDeadlineConfTarget: uint32(sweep.DefaultDeadlineDelta),
+		WalletMinConfs:       1,
 		Budget:               contractcourt.DefaultBudgetConfig(),
 	}
 }
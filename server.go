@@ -1064,8 +1064,20 @@ func newServer(cfg *Config, listenAddrs []net.Addr,
 		return nil, err
 	}
 
+	sweeperWallet := newSweeperWallet(cc.Wallet)
+	inputSetCfg := sweep.BudgetInputSetConfig{
+		LeaseChecker:      sweeperWallet.isLeased,
+		MinConfs:          cfg.Sweeper.WalletMinConfs,
+		CoinSelectTimeout: cfg.Sweeper.CoinSelectTimeout,
+		SweepAccount:      cfg.Sweeper.SweepAccount,
+		WalletReserve:     cfg.Sweeper.WalletReserve,
+	}
 	aggregator := sweep.NewBudgetAggregator(
 		cc.FeeEstimator, sweep.DefaultMaxInputsPerTx,
+		sweep.BudgetAggregatorConfig{
+			BatchLookahead: int32(cfg.Sweeper.BatchLookahead),
+			InputSet:       inputSetCfg,
+		},
 	)
 
 	s.txPublisher = sweep.NewTxPublisher(sweep.TxPublisherConfig{
@@ -1078,8 +1090,9 @@ func newServer(cfg *Config, listenAddrs []net.Addr,
 	s.sweeper = sweep.New(&sweep.UtxoSweeperConfig{
 		FeeEstimator:         cc.FeeEstimator,
 		GenSweepScript:       newSweepPkScriptGen(cc.Wallet),
+		GenChangeScript:      newSweepChangeScriptGen(cc.Wallet),
 		Signer:               cc.Wallet.Cfg.Signer,
-		Wallet:               newSweeperWallet(cc.Wallet),
+		Wallet:               sweeperWallet,
 		Mempool:              cc.MempoolNotifier,
 		Notifier:             cc.ChainNotifier,
 		Store:                sweeperStore,
@@ -4738,6 +4751,29 @@ func newSweepPkScriptGen(
 	}
 }
 
+// newSweepChangeScriptGen creates a closure that generates a new public key
+// script of the given wallet account and address type, which is used by the
+// sweeper to pay out the change of a sweep. The empty account is the default
+// one.
+func newSweepChangeScriptGen(wallet lnwallet.WalletController) func(string,
+	lnwallet.AddressType) ([]byte, error) {
+
+	return func(account string, addrType lnwallet.AddressType) ([]byte,
+		error) {
+
+		if account == "" {
+			account = lnwallet.DefaultAccountName
+		}
+
+		changeAddr, err := wallet.NewAddress(addrType, true, account)
+		if err != nil {
+			return nil, err
+		}
+
+		return txscript.PayToAddrScript(changeAddr)
+	}
+}
+
 // shouldPeerBootstrap returns true if we should attempt to perform peer
 // bootstrapping to actively seek our peers using the set of active network
 // bootstrappers.
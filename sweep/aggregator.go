@@ -1,10 +1,13 @@
 package sweep
 
 import (
+	"math/rand"
 	"sort"
+	"time"
 
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/fn"
 	"github.com/lightningnetwork/lnd/lnwallet"
 	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
 )
@@ -32,12 +35,13 @@ type inputCluster struct {
 }
 
 // createInputSets goes through the cluster's inputs and constructs sets of
-// inputs that can be used to generate a sweeping transaction. Each set
-// contains up to the configured maximum number of inputs. Negative yield
-// inputs are skipped.  No input sets with a total value after fees below the
-// dust limit are returned.
-func (c *inputCluster) createInputSets(maxFeeRate chainfee.SatPerKWeight,
-	maxInputs uint32) []InputSet {
+// inputs that can be used to generate a sweeping transaction, using the
+// options of the given aggregator. Each set contains up to the configured
+// maximum number of inputs. Negative yield inputs are skipped, and so are the
+// inputs whose time locks haven't expired at the current height.  No input
+// sets with a total value after fees below the dust limit are returned.
+func (c *inputCluster) createInputSets(s *SimpleAggregator,
+	currentHeight int32) []InputSet {
 
 	// Turn the inputs into a slice so we can sort them.
 	inputList := make([]*SweeperInput, 0, len(c.inputs))
@@ -53,6 +57,14 @@ func (c *inputCluster) createInputSets(maxFeeRate chainfee.SatPerKWeight,
 	// For witness size, the upper limit is taken. The actual size depends
 	// on the signature length, which is not known yet at this point.
 	calcYield := func(input *SweeperInput) int64 {
+		// Malformed inputs are rejected when building the set, so
+		// their yield doesn't matter here.
+		if err := checkSignDesc(input); err != nil {
+			log.Errorf("Malformed input: %v", err)
+
+			return 0
+		}
+
 		size, _, err := input.WitnessType().SizeUpperBound()
 		if err != nil {
 			log.Errorf("Failed to get input weight: %v", err)
@@ -81,16 +93,48 @@ func (c *inputCluster) createInputSets(maxFeeRate chainfee.SatPerKWeight,
 			return true
 		}
 
+		// Similarly, pinned inputs are placed before the remaining
+		// inputs so they are never dropped when the max number of
+		// inputs is reached.
+		pinnedI := inputList[i].parameters().Pinned
+		pinnedJ := inputList[j].parameters().Pinned
+		if pinnedI != pinnedJ && !inputList[j].parameters().Immediate {
+			return pinnedI
+		}
+
 		return calcYield(inputList[i]) > calcYield(inputList[j])
 	})
 
+	// cfg holds the settings shared by the input sets created.
+	cfg := txInputSetConfig{
+		maxInputs:            s.MaxInputsPerTx,
+		minYield:             s.MinYield,
+		maxForceSubsidy:      s.MaxForceSubsidy,
+		maxFeePerInput:       s.MaxFeePerInput,
+		rankByYieldPerWeight: s.RankByYieldPerWeight,
+		safeMode:             s.SafeMode,
+		currentHeight:        currentHeight,
+		changePolicy: ChangePolicy{
+			TargetOutputCount: s.TargetOutputCount,
+			DropUneconomical:  s.DropUneconomicalChange,
+		},
+	}
+
 	// Select blocks of inputs up to the configured maximum number.
 	var sets []InputSet
 	for len(inputList) > 0 {
 		// Start building a set of positive-yield tx inputs under the
 		// condition that the tx will be published with the specified
 		// fee rate.
-		txInputs := newTxInputSet(c.sweepFeeRate, maxFeeRate, maxInputs)
+		txInputs, err := newJitteredTxInputSet(
+			c.sweepFeeRate, s.MaxFeeRate, s.FeeRateJitter,
+			s.JitterSource, cfg,
+		)
+		if err != nil {
+			log.Errorf("Unable to create input set: %v", err)
+
+			return sets
+		}
 
 		// From the set of sweepable inputs, keep adding inputs to the
 		// input set until the tx output value no longer goes up or the
@@ -110,7 +154,15 @@ func (c *inputCluster) createInputSets(maxFeeRate chainfee.SatPerKWeight,
 			txInputs.weightEstimate(true).weight())
 
 		sets = append(sets, txInputs)
-		inputList = inputList[inputCount:]
+
+		// Remove the added inputs from the list. As inputs may be
+		// skipped or reordered when building the set, we can't assume
+		// the added ones are at the start of the list.
+		added := InputFingerprint(txInputs)
+		inputList = fn.Filter(func(inp *SweeperInput) bool {
+			_, ok := added[inp.OutPoint()]
+			return !ok
+		}, inputList)
 	}
 
 	return sets
@@ -121,8 +173,9 @@ func (c *inputCluster) createInputSets(maxFeeRate chainfee.SatPerKWeight,
 // sweeping transaction.
 type UtxoAggregator interface {
 	// ClusterInputs takes a list of inputs and groups them into input
-	// sets. Each input set will be used to create a sweeping transaction.
-	ClusterInputs(inputs InputsMap) []InputSet
+	// sets at the given block height. Each input set will be used to
+	// create a sweeping transaction.
+	ClusterInputs(inputs InputsMap, currentHeight int32) []InputSet
 }
 
 // SimpleAggregator aggregates inputs known by the Sweeper based on each
@@ -153,6 +206,57 @@ type SimpleAggregator struct {
 	//   #1: min = 1 sat/vbyte, max (exclusive) = 11 sat/vbyte
 	//   #2: min = 11 sat/vbyte, max (exclusive) = 21 sat/vbyte...
 	FeeRateBucketSize int
+
+	// MinYield is the minimum yield a regular input must provide to be
+	// included in a sweep tx. Inputs with a positive yield below this
+	// value are not swept. A zero value accepts any positive yield.
+	MinYield btcutil.Amount
+
+	// MaxForceSubsidy is the max wallet value that can be spent to cover
+	// the negative change caused by force sweeps in a sweep tx. A zero
+	// value means no limit.
+	MaxForceSubsidy btcutil.Amount
+
+	// MaxFeePerInput is the max fee a regular input may add to a sweep
+	// tx. Heavier inputs are not swept, which steers the selection
+	// towards lighter inputs. Force sweeps and inputs with required
+	// outputs are exempt. A zero value means no limit.
+	MaxFeePerInput btcutil.Amount
+
+	// RankByYieldPerWeight indicates the inputs of a sweep tx should be
+	// selected in descending order of their yield per weight unit instead
+	// of their absolute yield. This produces more fee efficient sets when
+	// the number of inputs is limited.
+	RankByYieldPerWeight bool
+
+	// SafeMode makes the input sets refuse, when validated, to pay more in
+	// fees than the value they recover, unless a force sweep or a
+	// required output justifies it.
+	SafeMode bool
+
+	// FeeRateJitter is the max fraction, e.g., 0.05 for 5%, by which the
+	// fee rate of each sweep tx is randomly perturbed, so the sweeps
+	// can't be linked by their identical fee rates. The fee rate is kept
+	// within the min relay fee rate and the max fee rate. A zero value
+	// disables the jitter.
+	FeeRateJitter float64
+
+	// JitterSource is the source of randomness used to jitter the fee
+	// rates. It's seeded with the current time by NewSimpleUtxoAggregator,
+	// and can be replaced with a fixed seed to make the jitter
+	// deterministic. If nil, a time-seeded source is created on first use.
+	JitterSource *rand.Rand
+
+	// TargetOutputCount is the exact number of change outputs each sweep
+	// tx splits its change into, e.g., to keep the utxos of the wallet
+	// uniform. Enough wallet inputs are added to keep every output above
+	// the dust limit. A zero value creates a single change output.
+	TargetOutputCount int
+
+	// DropUneconomicalChange gives the change of each sweep tx up to the
+	// fees when it's worth less than the fee needed to spend it later, as
+	// recommended by the change decision.
+	DropUneconomicalChange bool
 }
 
 // Compile-time constraint to ensure SimpleAggregator implements UtxoAggregator.
@@ -167,14 +271,31 @@ func NewSimpleUtxoAggregator(estimator chainfee.Estimator,
 		MaxFeeRate:        max,
 		MaxInputsPerTx:    maxTx,
 		FeeRateBucketSize: DefaultFeeRateBucketSize,
+		JitterSource:      newJitterSource(),
 	}
 }
 
+// newJitterSource returns a source of randomness for the fee rate jitter that
+// is seeded with the current time, so it doesn't share state with the global
+// source of math/rand.
+func newJitterSource() *rand.Rand {
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
+
 // ClusterInputs creates a list of input clusters from the set of pending
 // inputs known by the UtxoSweeper. It clusters inputs by
 // 1) Required tx locktime
 // 2) Similar fee rates.
-func (s *SimpleAggregator) ClusterInputs(inputs InputsMap) []InputSet {
+//
+// The inputs whose time locks haven't expired at the given height are left
+// out of the sets and retried once they mature.
+func (s *SimpleAggregator) ClusterInputs(inputs InputsMap,
+	currentHeight int32) []InputSet {
+
+	if s.FeeRateJitter > 0 && s.JitterSource == nil {
+		s.JitterSource = newJitterSource()
+	}
+
 	// We start by getting the inputs clusters by locktime. Since the
 	// inputs commit to the locktime, they can only be clustered together
 	// if the locktime is equal.
@@ -196,9 +317,7 @@ func (s *SimpleAggregator) ClusterInputs(inputs InputsMap) []InputSet {
 	// Now that we have the clusters, we can create the input sets.
 	var inputSets []InputSet
 	for _, cluster := range clusters {
-		sets := cluster.createInputSets(
-			s.MaxFeeRate, s.MaxInputsPerTx,
-		)
+		sets := cluster.createInputSets(s, currentHeight)
 		inputSets = append(inputSets, sets...)
 	}
 
@@ -464,6 +583,31 @@ func zipClusters(as, bs []inputCluster) []inputCluster {
 	return finalClusters
 }
 
+// BudgetAggregatorConfig holds the optional settings of a BudgetAggregator.
+type BudgetAggregatorConfig struct {
+	// MaxRequiredOutputs specifies the maximum number of inputs with
+	// required outputs allowed in a single sweep tx. Each of them adds an
+	// output and borrows budget from the other inputs, so a cluster
+	// exceeding the max is split into multiple sets. Zero means no limit.
+	MaxRequiredOutputs uint32
+
+	// SpendChecker is an optional checker used to skip the inputs whose
+	// outpoints are already spent, which guards against stale sweep
+	// requests.
+	SpendChecker SpendChecker
+
+	// BatchLookahead is the number of blocks after the deadline of the
+	// most urgent set within which the other sets are merged into it, so
+	// near-term sweeps ride along with urgent ones to save fees. Zero
+	// disables the batching across deadlines.
+	BatchLookahead int32
+
+	// InputSet is the config shared by the input sets created. Its max
+	// inputs and max required outputs are overridden by the ones of the
+	// aggregator.
+	InputSet BudgetInputSetConfig
+}
+
 // BudgetAggregator is a budget-based aggregator that creates clusters based on
 // deadlines and budgets of inputs.
 type BudgetAggregator struct {
@@ -475,18 +619,22 @@ type BudgetAggregator struct {
 	// maxInputs specifies the maximum number of inputs allowed in a single
 	// sweep tx.
 	maxInputs uint32
+
+	// cfg holds the optional settings of the aggregator.
+	cfg BudgetAggregatorConfig
 }
 
 // Compile-time constraint to ensure BudgetAggregator implements UtxoAggregator.
 var _ UtxoAggregator = (*BudgetAggregator)(nil)
 
 // NewBudgetAggregator creates a new instance of a BudgetAggregator.
-func NewBudgetAggregator(estimator chainfee.Estimator,
-	maxInputs uint32) *BudgetAggregator {
+func NewBudgetAggregator(estimator chainfee.Estimator, maxInputs uint32,
+	cfg BudgetAggregatorConfig) *BudgetAggregator {
 
 	return &BudgetAggregator{
 		estimator: estimator,
 		maxInputs: maxInputs,
+		cfg:       cfg,
 	}
 }
 
@@ -500,8 +648,11 @@ type clusterGroup map[int32][]SweeperInput
 // 4. sort the inputs in each cluster by their budget.
 // 5. optionally split a cluster if it exceeds the max input limit.
 // 6. create input sets from each of the clusters.
-// 7. create input sets for each of the exclusive inputs.
-func (b *BudgetAggregator) ClusterInputs(inputs InputsMap) []InputSet {
+// 7. optionally merge the sets whose deadlines are close.
+// 8. create input sets for each of the exclusive inputs.
+func (b *BudgetAggregator) ClusterInputs(inputs InputsMap,
+	currentHeight int32) []InputSet {
+
 	// Filter out inputs that have a budget below min relay fee.
 	filteredInputs := b.filterInputs(inputs)
 
@@ -554,15 +705,24 @@ func (b *BudgetAggregator) ClusterInputs(inputs InputsMap) []InputSet {
 
 		// Create input sets from the cluster.
 		for _, cluster := range splitClusters {
-			sets := b.createInputSets(cluster, height)
+			sets := b.createInputSets(
+				cluster, height, currentHeight,
+			)
 			inputSets = append(inputSets, sets...)
 		}
 	}
 
+	// Merge the sets whose deadlines fall within the lookahead of a more
+	// urgent one. The exclusive inputs are left out as they must be swept
+	// on their own.
+	inputSets = b.batchInputSets(inputSets, currentHeight)
+
 	// Create input sets from the exclusive inputs.
 	for _, cluster := range exclusiveInputs {
 		for height, input := range cluster {
-			sets := b.createInputSets(input, height)
+			sets := b.createInputSets(
+				input, height, currentHeight,
+			)
 			inputSets = append(inputSets, sets...)
 		}
 	}
@@ -570,9 +730,40 @@ func (b *BudgetAggregator) ClusterInputs(inputs InputsMap) []InputSet {
 	return inputSets
 }
 
+// batchInputSets merges the given budget sets using PlanBatches if a batch
+// lookahead is set. Other sets are returned as they are.
+func (b *BudgetAggregator) batchInputSets(sets []InputSet,
+	currentHeight int32) []InputSet {
+
+	if b.cfg.BatchLookahead <= 0 {
+		return sets
+	}
+
+	budgetSets := make([]*BudgetInputSet, 0, len(sets))
+	others := make([]InputSet, 0)
+	for _, set := range sets {
+		budgetSet, ok := set.(*BudgetInputSet)
+		if !ok {
+			others = append(others, set)
+			continue
+		}
+
+		budgetSets = append(budgetSets, budgetSet)
+	}
+
+	planned := PlanBatches(budgetSets, currentHeight, b.cfg.BatchLookahead)
+
+	batched := make([]InputSet, 0, len(planned)+len(others))
+	for _, set := range planned {
+		batched = append(batched, set)
+	}
+
+	return append(batched, others...)
+}
+
 // createInputSet takes a set of inputs which share the same deadline height
 // and turns them into a list of `InputSet`, each set is then used to create a
-// sweep transaction.
+// sweep transaction. The sets are created at the given current height.
 //
 // TODO(yy): by the time we call this method, all the invalid/uneconomical
 // inputs have been filtered out, all the inputs have been sorted based on
@@ -580,11 +771,31 @@ func (b *BudgetAggregator) ClusterInputs(inputs InputsMap) []InputSet {
 // here is, we need to group the inputs here even further based on whether
 // their budgets can cover the starting fee rate used for this input set.
 func (b *BudgetAggregator) createInputSets(inputs []SweeperInput,
-	deadlineHeight int32) []InputSet {
+	deadlineHeight, currentHeight int32) []InputSet {
 
 	// sets holds the InputSets that we will return.
 	sets := make([]InputSet, 0)
 
+	// If the inputs have more required outputs than allowed, split them
+	// into groups first and create the input sets from each group. As the
+	// inputs share the same deadline height, so do the groups.
+	groups := splitOnRequiredOutputs(inputs, b.cfg.MaxRequiredOutputs)
+	if len(groups) > 1 {
+		for _, group := range groups {
+			groupSets := b.createInputSets(
+				group, deadlineHeight, currentHeight,
+			)
+			sets = append(sets, groupSets...)
+		}
+
+		return sets
+	}
+
+	// cfg holds the settings shared by the input sets created.
+	cfg := b.cfg.InputSet
+	cfg.MaxInputs = b.maxInputs
+	cfg.MaxRequiredOutputs = b.cfg.MaxRequiredOutputs
+
 	// Copy the inputs to a new slice so we can modify it.
 	remainingInputs := make([]SweeperInput, len(inputs))
 	copy(remainingInputs, inputs)
@@ -595,15 +806,17 @@ func (b *BudgetAggregator) createInputSets(inputs []SweeperInput,
 		log.Tracef("Cluster has %v inputs, max is %v, dividing...",
 			len(inputs), b.maxInputs)
 
-		// Copy the inputs to be put into the new set, and update the
-		// remaining inputs by removing currentInputs.
-		currentInputs := make([]SweeperInput, b.maxInputs)
-		copy(currentInputs, remainingInputs[:b.maxInputs])
-		remainingInputs = remainingInputs[b.maxInputs:]
+		// Trim the inputs to be put into the new set, keeping the
+		// pinned ones, and update the remaining inputs with the
+		// trimmed ones.
+		currentInputs, trimmed := trimInputs(
+			remainingInputs, b.maxInputs,
+		)
+		remainingInputs = trimmed
 
 		// Create an InputSet using the max allowed number of inputs.
 		set, err := NewBudgetInputSet(
-			currentInputs, deadlineHeight,
+			currentInputs, deadlineHeight, currentHeight, cfg,
 		)
 		if err != nil {
 			log.Errorf("unable to create input set: %v", err)
@@ -617,7 +830,7 @@ func (b *BudgetAggregator) createInputSets(inputs []SweeperInput,
 	// Create an InputSet from the remaining inputs.
 	if len(remainingInputs) > 0 {
 		set, err := NewBudgetInputSet(
-			remainingInputs, deadlineHeight,
+			remainingInputs, deadlineHeight, currentHeight, cfg,
 		)
 		if err != nil {
 			log.Errorf("unable to create input set: %v", err)
@@ -631,6 +844,7 @@ func (b *BudgetAggregator) createInputSets(inputs []SweeperInput,
 }
 
 // filterInputs filters out inputs that have,
+// - an outpoint already spent, if a spend checker is set.
 // - a budget below the min relay fee.
 // - a budget below its requested starting fee.
 // - a required output that's below the dust.
@@ -647,6 +861,14 @@ func (b *BudgetAggregator) filterInputs(inputs InputsMap) InputsMap {
 	for _, pi := range inputs {
 		op := pi.OutPoint()
 
+		// Skip inputs that are already spent, as sweeping them would
+		// create an invalid tx.
+		if b.cfg.SpendChecker != nil && b.cfg.SpendChecker(op) {
+			log.Warnf("Skipped input=%v: already spent", op)
+
+			continue
+		}
+
 		// Get the size and skip if there's an error.
 		size, _, err := pi.WitnessType().SizeUpperBound()
 		if err != nil {
@@ -801,6 +1023,39 @@ func splitOnLocktime(inputs []SweeperInput) map[uint32][]SweeperInput {
 	return result
 }
 
+// splitOnRequiredOutputs splits the given inputs into groups so that each
+// group has at most maxRequired inputs with required outputs. The inputs with
+// required outputs fill the groups in their given order, while the inputs
+// without required outputs are all put into the first group. The order of the
+// inputs is preserved within each group. A single group is returned if
+// maxRequired is zero or not exceeded.
+func splitOnRequiredOutputs(inputs []SweeperInput,
+	maxRequired uint32) [][]SweeperInput {
+
+	if maxRequired == 0 {
+		return [][]SweeperInput{inputs}
+	}
+
+	var (
+		groups      [][]SweeperInput
+		numRequired uint32
+	)
+	for _, inp := range inputs {
+		idx := 0
+		if inp.RequiredTxOut() != nil {
+			idx = int(numRequired / maxRequired)
+			numRequired++
+		}
+
+		for len(groups) <= idx {
+			groups = append(groups, nil)
+		}
+		groups[idx] = append(groups[idx], inp)
+	}
+
+	return groups
+}
+
 // isDustOutput checks if the given output is considered as dust.
 func isDustOutput(output *wire.TxOut) bool {
 	// Fetch the dust limit for this output.
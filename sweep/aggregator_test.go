@@ -427,6 +427,44 @@ func TestClusterByLockTime(t *testing.T) {
 	}
 }
 
+// TestCreateInputSetsCurrentHeight checks that a cluster leaves out the inputs
+// whose time locks haven't expired at the given height.
+func TestCreateInputSetsCurrentHeight(t *testing.T) {
+	t.Parallel()
+
+	signDesc := &input.SignDescriptor{
+		Output: &wire.TxOut{Value: 100_000},
+	}
+
+	// The CSV input confirmed at height 95 can be spent in block 105,
+	// i.e., once the current height is 104.
+	csvInp := input.NewCsvInput(
+		&wire.OutPoint{Index: 1}, input.CommitmentTimeLock, signDesc,
+		95, 10,
+	)
+	regular := createP2WKHInput(100_000)
+
+	cluster := inputCluster{
+		sweepFeeRate: chainfee.FeePerKwFloor,
+		inputs: InputsMap{
+			csvInp.OutPoint():  &SweeperInput{Input: csvInp},
+			regular.OutPoint(): &SweeperInput{Input: regular},
+		},
+	}
+	s := NewSimpleUtxoAggregator(nil, 0, 10)
+
+	// Before the CSV input matures, only the regular input is swept.
+	sets := cluster.createInputSets(s, 103)
+	require.Len(t, sets, 1)
+	require.Len(t, sets[0].Inputs(), 1)
+	require.Equal(t, regular.OutPoint(), sets[0].Inputs()[0].OutPoint())
+
+	// Once it matures, both inputs are swept in the same set.
+	sets = cluster.createInputSets(s, 104)
+	require.Len(t, sets, 1)
+	require.Len(t, sets[0].Inputs(), 2)
+}
+
 // TestBudgetAggregatorFilterInputs checks that inputs with low budget are
 // filtered out.
 func TestBudgetAggregatorFilterInputs(t *testing.T) {
@@ -554,7 +592,7 @@ func TestBudgetAggregatorFilterInputs(t *testing.T) {
 
 	// Init the budget aggregator with the mocked estimator and zero max
 	// num of inputs.
-	b := NewBudgetAggregator(estimator, 0)
+	b := NewBudgetAggregator(estimator, 0, BudgetAggregatorConfig{})
 
 	// Call the method under test.
 	result := b.filterInputs(inputs)
@@ -568,6 +606,45 @@ func TestBudgetAggregatorFilterInputs(t *testing.T) {
 	require.Contains(t, result, opHigh)
 }
 
+// TestBudgetAggregatorFilterSpentInputs checks that the inputs whose outpoints
+// are already spent are filtered out when a spend checker is set.
+func TestBudgetAggregatorFilterSpentInputs(t *testing.T) {
+	t.Parallel()
+
+	estimator := &chainfee.MockEstimator{}
+	defer estimator.AssertExpectations(t)
+	estimator.On("RelayFeePerKW").Return(chainfee.FeePerKwFloor).Twice()
+
+	spent := createTestInput(100_000, input.WitnessKeyHash)
+	live := createTestInput(100_000, input.WitnessKeyHash)
+
+	inputs := InputsMap{
+		spent.OutPoint(): {
+			Input:  &spent,
+			params: Params{Budget: 1_000},
+		},
+		live.OutPoint(): {
+			Input:  &live,
+			params: Params{Budget: 1_000},
+		},
+	}
+
+	b := NewBudgetAggregator(
+		estimator, DefaultMaxInputsPerTx, BudgetAggregatorConfig{},
+	)
+
+	// Without a spend checker, both inputs are kept.
+	require.Len(t, b.filterInputs(inputs), 2)
+
+	// With a spend checker, the spent input is skipped.
+	b.cfg.SpendChecker = func(op wire.OutPoint) bool {
+		return op == spent.OutPoint()
+	}
+	result := b.filterInputs(inputs)
+	require.Len(t, result, 1)
+	require.Contains(t, result, live.OutPoint())
+}
+
 // TestBudgetAggregatorSortInputs checks that inputs are sorted by based on
 // their budgets and force flag.
 func TestBudgetAggregatorSortInputs(t *testing.T) {
@@ -618,7 +695,7 @@ func TestBudgetAggregatorSortInputs(t *testing.T) {
 	}
 
 	// Init the budget aggregator with zero max num of inputs.
-	b := NewBudgetAggregator(nil, 0)
+	b := NewBudgetAggregator(nil, 0, BudgetAggregatorConfig{})
 
 	// Call the method under test.
 	result := b.sortInputs(inputs)
@@ -653,6 +730,13 @@ func TestBudgetAggregatorCreateInputSets(t *testing.T) {
 	mockInput4 := &input.MockInput{}
 	defer mockInput4.AssertExpectations(t)
 
+	// The economics of the inputs are checked when creating the sets.
+	for _, m := range []*input.MockInput{
+		mockInput1, mockInput2, mockInput3, mockInput4,
+	} {
+		m.On("RequiredTxOut").Return(nil).Maybe()
+	}
+
 	// Create testing pending inputs.
 	pi1 := SweeperInput{
 		Input: mockInput1,
@@ -683,7 +767,7 @@ func TestBudgetAggregatorCreateInputSets(t *testing.T) {
 	}
 
 	// Create a budget aggregator with max number of inputs set to 2.
-	b := NewBudgetAggregator(nil, 2)
+	b := NewBudgetAggregator(nil, 2, BudgetAggregatorConfig{})
 
 	// Create test cases.
 	testCases := []struct {
@@ -781,7 +865,9 @@ func TestBudgetAggregatorCreateInputSets(t *testing.T) {
 			tc.setupMock()
 
 			// Call the method under test.
-			result := b.createInputSets(tc.inputs, testHeight)
+			result := b.createInputSets(
+				tc.inputs, testHeight, testHeight,
+			)
 
 			// Validate the expected number of input sets are
 			// returned.
@@ -790,6 +876,35 @@ func TestBudgetAggregatorCreateInputSets(t *testing.T) {
 	}
 }
 
+// TestBudgetAggregatorInputSetConfig checks that the input sets created by the
+// aggregator share its input set config, except for the max inputs and max
+// required outputs of the aggregator.
+func TestBudgetAggregatorInputSetConfig(t *testing.T) {
+	t.Parallel()
+
+	isLeased := func(wire.OutPoint) bool { return true }
+	b := NewBudgetAggregator(nil, 2, BudgetAggregatorConfig{
+		MaxRequiredOutputs: 1,
+		InputSet: BudgetInputSetConfig{
+			MaxInputs:    10,
+			LeaseChecker: isLeased,
+			SweepAccount: "sweep",
+		},
+	})
+
+	sets := b.createInputSets([]SweeperInput{{
+		Input:  createP2WKHInput(10_000),
+		params: Params{DeadlineHeight: fn.Some(testHeight)},
+	}}, testHeight, testHeight)
+	require.Len(t, sets, 1)
+
+	cfg := sets[0].(*BudgetInputSet).cfg
+	require.Equal(t, uint32(2), cfg.MaxInputs)
+	require.Equal(t, uint32(1), cfg.MaxRequiredOutputs)
+	require.NotNil(t, cfg.LeaseChecker)
+	require.Equal(t, "sweep", cfg.SweepAccount)
+}
+
 // TestBudgetInputSetClusterInputs checks that the budget aggregator clusters
 // inputs into input sets based on their deadline heights.
 func TestBudgetInputSetClusterInputs(t *testing.T) {
@@ -940,10 +1055,12 @@ func TestBudgetInputSetClusterInputs(t *testing.T) {
 	}
 
 	// Create a budget aggregator with a max number of inputs set to 100.
-	b := NewBudgetAggregator(estimator, DefaultMaxInputsPerTx)
+	b := NewBudgetAggregator(
+		estimator, DefaultMaxInputsPerTx, BudgetAggregatorConfig{},
+	)
 
 	// Call the method under test.
-	result := b.ClusterInputs(inputs)
+	result := b.ClusterInputs(inputs, testHeight)
 
 	// We expect four input sets to be returned, one for each deadline and
 	// extra one for the exclusive input.
@@ -1040,3 +1157,116 @@ func TestSplitOnLocktime(t *testing.T) {
 	require.Len(t, result[uint32(0)], 2)
 	require.Equal(t, expectedResult, result)
 }
+
+// TestBudgetAggregatorMaxRequiredOutputs checks that the number of inputs with
+// required outputs is capped per set, and a cluster exceeding the cap is split
+// into sets sharing its deadline height.
+func TestBudgetAggregatorMaxRequiredOutputs(t *testing.T) {
+	t.Parallel()
+
+	pkScript := make([]byte, input.P2WPKHSize)
+	newReqInput := func() SweeperInput {
+		return SweeperInput{
+			Input: &reqInput{
+				Input: createP2WKHInput(10_000),
+				txOut: &wire.TxOut{
+					Value:    10_000,
+					PkScript: pkScript,
+				},
+			},
+			params: Params{Budget: 1000},
+		}
+	}
+
+	r1, r2, r3 := newReqInput(), newReqInput(), newReqInput()
+	regular := SweeperInput{
+		Input:  createP2WKHInput(10_000),
+		params: Params{Budget: 1000},
+	}
+	inputs := []SweeperInput{r1, regular, r2, r3}
+
+	// A set can't be created when it exceeds the cap.
+	_, err := NewBudgetInputSet(
+		inputs, testHeight, testHeight,
+		BudgetInputSetConfig{MaxRequiredOutputs: 2},
+	)
+	require.ErrorIs(t, err, ErrTooManyRequiredOutputs)
+
+	_, err = NewBudgetInputSet(
+		inputs, testHeight, testHeight,
+		BudgetInputSetConfig{MaxRequiredOutputs: 3},
+	)
+	require.NoError(t, err)
+
+	// The required-output inputs are split by the cap while the regular
+	// input stays in the first group.
+	require.Equal(t, [][]SweeperInput{inputs},
+		splitOnRequiredOutputs(inputs, 0))
+	require.Equal(t, [][]SweeperInput{inputs},
+		splitOnRequiredOutputs(inputs, 3))
+	require.Equal(t, [][]SweeperInput{{r1, regular, r2}, {r3}},
+		splitOnRequiredOutputs(inputs, 2))
+
+	// The aggregator splits the cluster into two sets with the same
+	// deadline height.
+	b := NewBudgetAggregator(
+		nil, DefaultMaxInputsPerTx,
+		BudgetAggregatorConfig{MaxRequiredOutputs: 2},
+	)
+
+	sets := b.createInputSets(inputs, testHeight, testHeight)
+	require.Len(t, sets, 2)
+	require.Len(t, sets[0].Inputs(), 3)
+	require.Len(t, sets[1].Inputs(), 1)
+
+	for _, set := range sets {
+		require.Equal(t, testHeight, set.DeadlineHeight())
+	}
+}
+
+// TestBudgetAggregatorBatchLookahead checks that the sets whose deadlines fall
+// within the batch lookahead are merged, and that they are left as they are
+// when no lookahead is set.
+func TestBudgetAggregatorBatchLookahead(t *testing.T) {
+	t.Parallel()
+
+	newSet := func(deadline int32, maxInputs uint32) *BudgetInputSet {
+		set, err := NewBudgetInputSet([]SweeperInput{{
+			Input:  createP2WKHInput(100_000),
+			params: Params{Budget: 1_000},
+		}}, deadline, testHeight, BudgetInputSetConfig{
+			MaxInputs: maxInputs,
+		})
+		require.NoError(t, err)
+
+		return set
+	}
+
+	urgent := newSet(testHeight+2, DefaultMaxInputsPerTx)
+	soon := newSet(testHeight+5, DefaultMaxInputsPerTx)
+	far := newSet(testHeight+100, DefaultMaxInputsPerTx)
+	mockSet := &MockInputSet{}
+	sets := []InputSet{far, mockSet, soon, urgent}
+
+	// Without a lookahead, the sets are left as they are.
+	b := NewBudgetAggregator(
+		nil, DefaultMaxInputsPerTx, BudgetAggregatorConfig{},
+	)
+	require.Equal(t, sets, b.batchInputSets(sets, testHeight))
+
+	// With a lookahead, the soon-due set is merged into the urgent one,
+	// while the far-future set and the non-budget set are kept.
+	b.cfg.BatchLookahead = 6
+	batched := b.batchInputSets(sets, testHeight)
+	require.Len(t, batched, 3)
+	require.Len(t, batched[0].Inputs(), 2)
+	require.Equal(t, testHeight+2, batched[0].DeadlineHeight())
+	require.Same(t, far, batched[1])
+	require.Same(t, mockSet, batched[2])
+
+	// Sets whose merge would exceed the max inputs are kept apart.
+	small1 := newSet(testHeight+2, 1)
+	small2 := newSet(testHeight+5, 1)
+	batched = b.batchInputSets([]InputSet{small1, small2}, testHeight)
+	require.Equal(t, []InputSet{small1, small2}, batched)
+}
@@ -0,0 +1,245 @@
+package sweep
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/fn"
+)
+
+// InputFingerprint returns the set of outpoints spent by the given input set.
+func InputFingerprint(set InputSet) map[wire.OutPoint]struct{} {
+	inputs := set.Inputs()
+
+	outpoints := make(map[wire.OutPoint]struct{}, len(inputs))
+	for _, inp := range inputs {
+		outpoints[inp.OutPoint()] = struct{}{}
+	}
+
+	return outpoints
+}
+
+// ConflictingSets returns the index pairs of the given input sets that spend
+// at least one common outpoint, and thus cannot be broadcast together. Each
+// pair is ordered by index, and the pairs are sorted in ascending order.
+func ConflictingSets(sets []InputSet) [][2]int {
+	fingerprints := make([]map[wire.OutPoint]struct{}, len(sets))
+	for i, set := range sets {
+		fingerprints[i] = InputFingerprint(set)
+	}
+
+	var conflicts [][2]int
+	for i := 0; i < len(sets); i++ {
+		for j := i + 1; j < len(sets); j++ {
+			a, b := fingerprints[i], fingerprints[j]
+			if !fingerprintsOverlap(a, b) {
+				continue
+			}
+
+			log.Warnf("Input sets %d and %d spend the same "+
+				"outpoint", i, j)
+
+			conflicts = append(conflicts, [2]int{i, j})
+		}
+	}
+
+	return conflicts
+}
+
+// fingerprintsOverlap returns true if the two fingerprints share an outpoint.
+func fingerprintsOverlap(a, b map[wire.OutPoint]struct{}) bool {
+	// Iterate over the smaller fingerprint.
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+
+	for op := range a {
+		if _, ok := b[op]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PlanBatches merges the sets whose deadlines fall within lookahead blocks of
+// the most urgent remaining set into a single set, so the near-term sweeps
+// ride along with the urgent ones and share the fees of one tx. The sets are
+// grouped from the earliest deadline, and a deadline already passed at the
+// current height counts as the current height. A merged set keeps the options
+// of its most urgent set and its deadline, which the inputs specifying a
+// deadline are moved to, and an input present in several sets is only kept
+// once. The sets whose lock time or tx version differs from the most urgent
+// one, or which can't be merged, are left as they are. The given sets are not
+// modified.
+func PlanBatches(sets []*BudgetInputSet, currentHeight int32,
+	lookahead int32) []*BudgetInputSet {
+
+	// effectiveDeadline returns the deadline of the set, which is never
+	// earlier than the current height.
+	effectiveDeadline := func(set *BudgetInputSet) int32 {
+		return max(set.deadlineHeight, currentHeight)
+	}
+
+	remaining := make([]*BudgetInputSet, 0, len(sets))
+	for _, set := range sets {
+		if set != nil && len(set.inputs) > 0 {
+			remaining = append(remaining, set)
+		}
+	}
+	sort.SliceStable(remaining, func(i, j int) bool {
+		return effectiveDeadline(remaining[i]) <
+			effectiveDeadline(remaining[j])
+	})
+
+	planned := make([]*BudgetInputSet, 0, len(remaining))
+	for len(remaining) > 0 {
+		urgent := remaining[0]
+		limit := effectiveDeadline(urgent) + lookahead
+
+		group := []*BudgetInputSet{urgent}
+		var rest []*BudgetInputSet
+		for _, set := range remaining[1:] {
+			compatible := set.TxVersion() == urgent.TxVersion() &&
+				set.LockTime() == urgent.LockTime()
+
+			if effectiveDeadline(set) <= limit && compatible {
+				group = append(group, set)
+				continue
+			}
+
+			rest = append(rest, set)
+		}
+		remaining = rest
+
+		if len(group) == 1 {
+			planned = append(planned, urgent)
+			continue
+		}
+
+		merged, err := mergeBudgetInputSets(group)
+		if err != nil {
+			log.Debugf("Unable to batch %v sets with deadline "+
+				"%v: %v", len(group), urgent.deadlineHeight,
+				err)
+
+			planned = append(planned, group...)
+
+			continue
+		}
+
+		planned = append(planned, merged)
+	}
+
+	return planned
+}
+
+// requiredOutputKey identifies a required output by its value and script.
+type requiredOutputKey struct {
+	value    int64
+	pkScript string
+}
+
+// mergeBudgetInputSets merges the given sets into a new set with the options
+// and deadline of the first one. The inputs specifying a deadline are moved to
+// the deadline of the merged set, the duplicate inputs are skipped, and the
+// record of the wallet inputs is kept. ErrDuplicateRequiredOutput is returned
+// if distinct inputs commit to identical required outputs. They can't be
+// deduplicated, as the signature of each input commits to its own output. The
+// merged set must also respect the input limits of the first set, and its
+// inputs must agree on the lock time they require.
+func mergeBudgetInputSets(group []*BudgetInputSet) (*BudgetInputSet, error) {
+	urgent := group[0]
+	deadline := urgent.deadlineHeight
+
+	var inputs []SweeperInput
+	seen := fn.NewSet[wire.OutPoint]()
+	walletInputs := fn.NewSet[wire.OutPoint]()
+	requiredOutputs := make(map[requiredOutputKey]wire.OutPoint)
+	for _, set := range group {
+		for _, inp := range set.inputs {
+			op := inp.OutPoint()
+			if seen.Contains(op) {
+				continue
+			}
+			seen.Add(op)
+
+			if reqOut := inp.RequiredTxOut(); reqOut != nil {
+				key := requiredOutputKey{
+					value:    reqOut.Value,
+					pkScript: string(reqOut.PkScript),
+				}
+				if prev, ok := requiredOutputs[key]; ok {
+					return nil, fmt.Errorf("%w: inputs %v "+
+						"and %v both require value=%v",
+						ErrDuplicateRequiredOutput,
+						prev, op, reqOut.Value)
+				}
+				requiredOutputs[key] = op
+			}
+
+			merged := *inp
+			if merged.params.DeadlineHeight.IsSome() {
+				merged.params.DeadlineHeight = fn.Some(deadline)
+			}
+			inputs = append(inputs, merged)
+
+			if set.walletInputs.Contains(op) {
+				walletInputs.Add(op)
+			}
+		}
+	}
+
+	cfg := urgent.cfg
+	if cfg.MaxInputs > 0 && uint32(len(inputs)) > cfg.MaxInputs {
+		return nil, fmt.Errorf("%w: inputs=%v, max inputs=%v",
+			ErrTooManyInputs, len(inputs), cfg.MaxInputs)
+	}
+
+	err := validateInputs(
+		inputs, deadline, cfg.MaxInputs, cfg.MaxRequiredOutputs,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// The inputs of the merged sets must agree on the lock time they
+	// require, as they share a tx.
+	var (
+		lockTime    uint32
+		hasLockTime bool
+	)
+	for _, inp := range inputs {
+		required, ok := inp.RequiredLockTime()
+		if !ok {
+			continue
+		}
+
+		if hasLockTime && required != lockTime {
+			return nil, fmt.Errorf("%w: input=%v requires lock "+
+				"time=%v, got %v", ErrLocktimeConflict,
+				inp.OutPoint(), required, lockTime)
+		}
+		lockTime, hasLockTime = required, true
+	}
+
+	// Copy the most urgent set to keep its options, and replace its
+	// inputs along with the record of its wallet inputs.
+	set := *urgent
+	set.inputs = make([]*SweeperInput, 0, len(inputs))
+	set.walletInputs = walletInputs
+	set.walletInputTotal = 0
+	for _, inp := range inputs {
+		set.addInput(inp)
+
+		if walletInputs.Contains(inp.OutPoint()) {
+			set.walletInputTotal += btcutil.Amount(
+				inp.SignDesc().Output.Value,
+			)
+		}
+	}
+
+	return &set, nil
+}
@@ -0,0 +1,1155 @@
+package sweep
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	base "github.com/btcsuite/btcwallet/wallet"
+	"github.com/btcsuite/btcwallet/wtxmgr"
+	"github.com/lightningnetwork/lnd/fn"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/labels"
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
+)
+
+// BudgetInputSetConfig houses the settings of a BudgetInputSet, which are
+// fixed once the set is created. The zero value gives the defaults.
+type BudgetInputSetConfig struct {
+	// MaxInputs is the max number of inputs allowed in the set, which is
+	// enforced when merging sets. The pinned inputs alone must not exceed
+	// it. Zero means no limit.
+	MaxInputs uint32
+
+	// MaxRequiredOutputs is the max number of inputs with required outputs
+	// allowed in the set. Zero means no limit.
+	MaxRequiredOutputs uint32
+
+	// LeaseChecker is an optional checker used to skip wallet utxos that
+	// are leased by other subsystems. When nil, all wallet utxos are
+	// considered.
+	LeaseChecker LeaseChecker
+
+	// WarningRecorder is an optional recorder for the warnings emitted
+	// when adding wallet inputs.
+	WarningRecorder WarningRecorder
+
+	// NotBefore is an optional height before which the set must not be
+	// broadcast, e.g., to wait for the CSV maturity of a sibling output.
+	//
+	// NOTE: the aggregator shares one config across all its sets, so lnd
+	// doesn't set it. It's for the callers creating a set via
+	// NewBudgetInputSet.
+	NotBefore fn.Option[int32]
+
+	// NonReplaceable indicates the tx created from the set should opt out
+	// of replaceability, e.g., for final-settlement sweeps avoiding
+	// fee-sniping games. Defaults to false, i.e., replaceable.
+	//
+	// NOTE: lnd doesn't set it, as opting out of RBF only suits a few
+	// specific sweeps, which callers create via NewBudgetInputSet.
+	NonReplaceable bool
+
+	// TxVersion is the version of the tx created from the set. Only
+	// versions 2 and 3 are supported. Zero means the default version 2.
+	//
+	// NOTE: the wallet inputs are always confirmed, as MinConfs can't go
+	// below defaultWalletMinConfs, so they never add an unconfirmed TRUC
+	// parent to a v3 tx. The TRUC limits on the unconfirmed inputs given
+	// to the sweeper are left to the mempool, which is also why lnd keeps
+	// its own sweeps at version 2 and doesn't set it.
+	TxVersion int32
+
+	// CoinSelectionStrategy is the optional default strategy used to
+	// select the wallet utxos. It's overridden by the strategy of the most
+	// urgent input that specifies one. If neither is set, smaller utxos
+	// are selected first.
+	CoinSelectionStrategy base.CoinSelectionStrategy
+
+	// DisplayUnit is the unit used to render the amounts in the
+	// description of the set. It only affects presentation.
+	DisplayUnit DisplayUnit
+
+	// BudgetCurve is an optional provider of the budget of the set based
+	// on the number of blocks left until the deadline at the height the
+	// set was created, so the budget can grow as the deadline approaches.
+	// When nil, the summed budget of the inputs is used.
+	//
+	// NOTE: a curve prices the specific inputs of a set, so lnd doesn't set
+	// it. It's for the callers creating a set via NewBudgetInputSet.
+	BudgetCurve BudgetCurve
+
+	// BudgetCapsFeeRate indicates the max fee rate of the set is capped
+	// at the fee rate its budget affords.
+	BudgetCapsFeeRate bool
+
+	// PreserveInputOrder indicates the inputs must be returned in the
+	// order they were added rather than ordered by their urgency, so
+	// signatures committing to the input and output positions stay valid.
+	//
+	// NOTE: lnd doesn't set it. It's for the callers of NewBudgetInputSet
+	// whose inputs were signed at fixed positions.
+	PreserveInputOrder bool
+
+	// SortOutputs indicates the outputs of the tx created from the set,
+	// including the change, should be sorted per BIP69, which removes a
+	// fingerprint of the wallet software.
+	//
+	// NOTE: lnd doesn't set it. It can be applied to all the sets of an
+	// aggregator via BudgetAggregatorConfig.InputSet.
+	SortOutputs bool
+
+	// MinConfs is the min number of confirmations of the wallet utxos
+	// used as wallet inputs. Requiring deeper confirmations reduces the
+	// reorg risk of high-value sweeps. Zero means defaultWalletMinConfs.
+	MinConfs int32
+
+	// ConfirmationBucket is the optional range of confirmation depths the
+	// wallet utxos used as wallet inputs must be within. MinConfs still
+	// applies if it's deeper than the min of the bucket.
+	ConfirmationBucket fn.Option[ConfirmationBucket]
+
+	// MinWalletInputValue is the min value of the wallet utxos used as
+	// wallet inputs. Smaller utxos are never considered. Zero means no
+	// min.
+	MinWalletInputValue btcutil.Amount
+
+	// WalletReserve is the wallet balance that must stay unselected when
+	// adding wallet inputs, so enough funds are left to bump the fees of
+	// other sweeps. Zero means no reserve.
+	WalletReserve btcutil.Amount
+
+	// UtxoScorer is an optional scorer used to spend the wallet utxos
+	// with lower scores first, the value only breaking ties. When nil, the
+	// utxos are ordered by value. A coin selection strategy still takes
+	// precedence.
+	//
+	// NOTE: lnd has no scorer of its own, so it's left to the callers,
+	// which can apply one to all the sets of an aggregator via
+	// BudgetAggregatorConfig.InputSet.
+	UtxoScorer UtxoScorer
+
+	// SweepAccount is the optional wallet account the wallet inputs are
+	// taken from first. The default account is used for the rest.
+	SweepAccount string
+
+	// MaxAncestors is the max number of unconfirmed ancestors of the
+	// wallet utxos used as wallet inputs, which is enforced if the wallet
+	// implements AncestorCounter. Zero means no max.
+	//
+	// NOTE: lnd doesn't set it, as its wallet doesn't implement
+	// AncestorCounter and the wallet inputs are confirmed anyway, see
+	// MinConfs.
+	MaxAncestors uint32
+
+	// MaxOutputs is the max number of outputs of the tx created from the
+	// set, which is enforced by Validate. Zero means no max.
+	MaxOutputs int
+
+	// MaxTxVSize is the max virtual size in vbytes of the tx created from
+	// the set, which is enforced by Validate. Zero means no max.
+	//
+	// NOTE: lnd bounds the size of its sweeps via MaxInputs instead, so it
+	// doesn't set it.
+	MaxTxVSize int
+
+	// OwnChangeHint holds the change outputs of prior sweeps, which are
+	// selected ahead of the other wallet utxos when adding wallet inputs,
+	// so the sweep change is recycled. They still need to pass the utxo
+	// filters of the set, and a coin selection strategy still takes
+	// precedence.
+	OwnChangeHint []wire.OutPoint
+
+	// RequiredScriptClasses are the script classes the required outputs
+	// of the inputs must match, e.g., p2wsh for the outputs of
+	// second-level HTLC txns. Empty means any script.
+	RequiredScriptClasses []txscript.ScriptClass
+
+	// FeeBumpPolicy is the optional policy used to escalate the fee rate
+	// of the set. When nil, a LinearFeeBumpPolicy capped by the budget
+	// fee rate is used.
+	//
+	// NOTE: lnd uses the default policy. A custom one can be applied to all
+	// the sets of an aggregator via BudgetAggregatorConfig.InputSet.
+	FeeBumpPolicy FeeBumpPolicy
+
+	// CoinSelectTimeout bounds the time spent adding wallet inputs while
+	// holding the coin select lock. Once it's exceeded, the selection is
+	// aborted with ErrCoinSelectTimeout. Zero means no bound.
+	CoinSelectTimeout time.Duration
+
+	// ChangeReservation is the optional reservation of the change output
+	// for a downstream obligation.
+	//
+	// NOTE: a reservation belongs to a single sweep, so lnd doesn't set it.
+	// It's for the callers creating a set via NewBudgetInputSet.
+	ChangeReservation fn.Option[ChangeReservation]
+}
+
+// validate checks the settings of the config against the given inputs of the
+// set.
+func (c *BudgetInputSetConfig) validate(inputs []SweeperInput) error {
+	if c.TxVersion != 0 {
+		if err := validateTxVersion(c.TxVersion); err != nil {
+			return err
+		}
+	}
+
+	if c.ConfirmationBucket.IsSome() {
+		err := c.ConfirmationBucket.UnsafeFromSome().validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range inputs {
+		err := checkRequiredScript(&inputs[i], c.RequiredScriptClasses)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BudgetInputSet implements the interface `InputSet`. It takes a list of
+// pending inputs which share the same deadline height and groups them into a
+// set conditionally based on their economical values.
+type BudgetInputSet struct {
+	// inputs is the set of inputs that have been added to the set after
+	// considering their economical contribution.
+	inputs []*SweeperInput
+
+	// deadlineHeight is the height which the inputs in this set must be
+	// confirmed by.
+	deadlineHeight int32
+
+	// currentHeight is the current height used to evaluate the budget
+	// curve.
+	currentHeight int32
+
+	// cfg holds the settings of the set.
+	cfg BudgetInputSetConfig
+
+	// ownChange holds the change outputs of prior sweeps, which are
+	// preferred as wallet inputs.
+	ownChange fn.Set[wire.OutPoint]
+
+	// lockTime is the optional lock time of the tx created from the set.
+	// When none, the tx builder picks the lock time.
+	lockTime fn.Option[uint32]
+
+	// feeRate is the fee rate of the set driven by its fee bump policy.
+	// Zero means no fee rate has been set.
+	feeRate chainfee.SatPerKWeight
+
+	// walletInputTotal is the total value of the wallet inputs added to
+	// the set.
+	walletInputTotal btcutil.Amount
+
+	// walletInputs tracks the outpoints of the wallet inputs added to the
+	// set.
+	walletInputs fn.Set[wire.OutPoint]
+}
+
+// BudgetCurve returns the budget of a set given the number of blocks left
+// until its deadline, which is negative once the deadline has passed.
+type BudgetCurve func(blocksUntilDeadline int32) btcutil.Amount
+
+// Compile-time constraint to ensure budgetInputSet implements InputSet.
+var _ InputSet = (*BudgetInputSet)(nil)
+
+// validateInputs is used when creating new BudgetInputSet to ensure there are
+// no duplicate inputs, they all share the same deadline heights, if set, the
+// pinned inputs alone don't exceed maxInputs, and the inputs with required
+// outputs don't exceed maxRequiredOutputs. A zero maxInputs or
+// maxRequiredOutputs means no limit.
+func validateInputs(inputs []SweeperInput, deadlineHeight int32, maxInputs,
+	maxRequiredOutputs uint32) error {
+
+	// Sanity check the input slice to ensure it's non-empty.
+	if len(inputs) == 0 {
+		return fmt.Errorf("inputs slice is empty")
+	}
+
+	// Make sure the pinned inputs fit into the set, as they can't be
+	// trimmed.
+	numPinned := uint32(len(fn.Filter(func(inp SweeperInput) bool {
+		return inp.params.Pinned
+	}, inputs)))
+	if maxInputs > 0 && numPinned > maxInputs {
+		return fmt.Errorf("%w: pinned=%v, max inputs=%v",
+			ErrTooManyPinnedInputs, numPinned, maxInputs)
+	}
+
+	// Make sure the required outputs don't bloat the set.
+	if maxRequiredOutputs > 0 {
+		numRequired := uint32(len(fn.Filter(
+			func(inp SweeperInput) bool {
+				return inp.RequiredTxOut() != nil
+			}, inputs,
+		)))
+		if numRequired > maxRequiredOutputs {
+			return fmt.Errorf("%w: required=%v, max required "+
+				"outputs=%v", ErrTooManyRequiredOutputs,
+				numRequired, maxRequiredOutputs)
+		}
+	}
+
+	// inputDeadline tracks the input's deadline height. It will be updated
+	// if the input has a different deadline than the specified
+	// deadlineHeight.
+	inputDeadline := deadlineHeight
+
+	// dedupInputs is a set used to track unique outpoints of the inputs.
+	dedupInputs := fn.NewSet(
+		// Iterate all the inputs and map the function.
+		fn.Map(func(inp SweeperInput) wire.OutPoint {
+			// If the input has a deadline height, we'll check if
+			// it's the same as the specified.
+			inp.params.DeadlineHeight.WhenSome(func(h int32) {
+				// Exit early if the deadlines matched.
+				if h == deadlineHeight {
+					return
+				}
+
+				// Update the deadline height if it's
+				// different.
+				inputDeadline = h
+			})
+
+			return inp.OutPoint()
+		}, inputs)...,
+	)
+
+	// Make sure the inputs share the same deadline height when there is
+	// one.
+	if inputDeadline != deadlineHeight {
+		return fmt.Errorf("input deadline height not matched: want "+
+			"%d, got %d", deadlineHeight, inputDeadline)
+	}
+
+	// Provide a defensive check to ensure that we don't have any duplicate
+	// inputs within the set.
+	if len(dedupInputs) != len(inputs) {
+		return fmt.Errorf("duplicate inputs")
+	}
+
+	// Make sure the witness of every input can be created.
+	for _, inp := range inputs {
+		if err := checkPreimage(inp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateEconomics checks that a set made only of inputs with required
+// outputs doesn't commit to more output value than its inputs are worth. Such
+// a set is misconfigured, as its sweep can never balance. Sets with other
+// inputs are not checked, as those inputs can make up the difference.
+func validateEconomics(inputs []SweeperInput) error {
+	var inputTotal, requiredOutput btcutil.Amount
+	for _, inp := range inputs {
+		reqOut := inp.RequiredTxOut()
+		if reqOut == nil {
+			return nil
+		}
+
+		requiredOutput += btcutil.Amount(reqOut.Value)
+		inputTotal += btcutil.Amount(inp.SignDesc().Output.Value)
+	}
+
+	if requiredOutput > inputTotal {
+		return fmt.Errorf("%w: required=%v, inputs=%v",
+			ErrRequiredOutputsExceedInputs, requiredOutput,
+			inputTotal)
+	}
+
+	return nil
+}
+
+// NewBudgetInputSet creates a new BudgetInputSet at the given current height,
+// which is used to evaluate the budget curve of the set. The inputs are
+// validated against the given config, which holds the settings of the set.
+func NewBudgetInputSet(inputs []SweeperInput, deadlineHeight,
+	currentHeight int32, cfg BudgetInputSetConfig) (*BudgetInputSet,
+	error) {
+
+	// Validate the supplied inputs.
+	err := validateInputs(
+		inputs, deadlineHeight, cfg.MaxInputs, cfg.MaxRequiredOutputs,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure the set can balance its required outputs.
+	if err := validateEconomics(inputs); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.validate(inputs); err != nil {
+		return nil, err
+	}
+
+	bi := &BudgetInputSet{
+		deadlineHeight: deadlineHeight,
+		currentHeight:  currentHeight,
+		cfg:            cfg,
+		ownChange:      newOwnChangeHint(cfg.OwnChangeHint),
+		inputs:         make([]*SweeperInput, 0, len(inputs)),
+	}
+
+	for _, input := range inputs {
+		bi.addInput(input)
+	}
+
+	log.Tracef("Created %v", bi.String())
+
+	return bi, nil
+}
+
+// String returns a human-readable description of the input set. The budget
+// is rendered in the display unit of the set.
+func (b *BudgetInputSet) String() string {
+	inputsDesc := ""
+	for _, input := range b.inputs {
+		inputsDesc += fmt.Sprintf("\n%v", input)
+	}
+
+	return fmt.Sprintf("BudgetInputSet(budget=%v, deadline=%v, "+
+		"inputs=[%v])", b.cfg.DisplayUnit.Format(b.Budget()),
+		b.DeadlineHeight(), inputsDesc)
+}
+
+// Label returns the wallet label for the sweeping tx created from the set,
+// which describes its deadline, budget and the witness types of its inputs,
+// e.g., "0:sweep:deadline-850000:budget-1000:inputs-CommitmentAnchor*1". The
+// label is truncated to the max length allowed by the wallet.
+func (b *BudgetInputSet) Label() string {
+	// Count the inputs of each witness type.
+	counts := make(map[string]int)
+	for _, inp := range b.inputs {
+		counts[inp.WitnessType().String()]++
+	}
+
+	types := make([]string, 0, len(counts))
+	for witnessType, count := range counts {
+		types = append(types, fmt.Sprintf("%v*%d", witnessType, count))
+	}
+	sort.Strings(types)
+
+	label := fmt.Sprintf("%v:deadline-%d:budget-%d:inputs-%v",
+		labels.MakeLabel(labels.LabelTypeSweepTransaction, nil),
+		b.deadlineHeight, int64(b.Budget()), strings.Join(types, ","))
+
+	if len(label) > wtxmgr.TxLabelLimit {
+		label = label[:wtxmgr.TxLabelLimit]
+	}
+
+	return label
+}
+
+// walletBalance returns the total value of the wallet utxos that can be used
+// as wallet inputs of the set.
+func (b *BudgetInputSet) walletBalance(wallet Wallet) (btcutil.Amount, error) {
+	var balance btcutil.Amount
+	_, err := forEachWalletUtxoBatch(
+		wallet, b.utxoFilter(),
+		func(utxos []*lnwallet.Utxo) (bool, error) {
+			for _, utxo := range utxos {
+				balance += utxo.Value
+			}
+
+			return false, nil
+		},
+	)
+
+	return balance, err
+}
+
+// utxoFilter returns the filter of the wallet utxos used as wallet inputs.
+func (b *BudgetInputSet) utxoFilter() walletUtxoFilter {
+	return walletUtxoFilter{
+		isLeased:     b.cfg.LeaseChecker,
+		exclude:      b.walletInputs,
+		minConfs:     b.cfg.MinConfs,
+		minValue:     b.cfg.MinWalletInputValue,
+		scorer:       b.cfg.UtxoScorer,
+		account:      b.cfg.SweepAccount,
+		maxAncestors: b.cfg.MaxAncestors,
+		ownChange:    b.ownChange,
+	}.withBucket(b.cfg.ConfirmationBucket)
+}
+
+// newOwnChangeHint returns the set of the given change outpoints, or nil if
+// there are none.
+func newOwnChangeHint(ops []wire.OutPoint) fn.Set[wire.OutPoint] {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	return fn.NewSet(ops...)
+}
+
+// ReadyAt returns the height from which the set can be broadcast. Zero is
+// returned if the set has no such constraint.
+func (b *BudgetInputSet) ReadyAt() int32 {
+	return b.cfg.NotBefore.UnwrapOr(0)
+}
+
+// IsReplaceable returns true if the tx created from the set signals
+// replaceability, which is the default.
+//
+// NOTE: part of the InputSet interface.
+func (b *BudgetInputSet) IsReplaceable() bool {
+	return !b.cfg.NonReplaceable
+}
+
+// TxVersion returns the version of the tx created from the set, which
+// defaults to 2.
+//
+// NOTE: part of the InputSet interface.
+func (b *BudgetInputSet) TxVersion() int32 {
+	if b.cfg.TxVersion == 0 {
+		return defaultTxVersion
+	}
+
+	return b.cfg.TxVersion
+}
+
+// SetLockTime sets the lock time of the tx created from the set, e.g., to
+// discourage fee sniping or as required by a protocol. It must be a block
+// height matching the lock time required by the CLTV-encumbered inputs of the
+// set.
+func (b *BudgetInputSet) SetLockTime(lockTime uint32) error {
+	if err := validateLockTime(lockTime, b.Inputs()); err != nil {
+		return err
+	}
+
+	b.lockTime = fn.Some(lockTime)
+
+	return nil
+}
+
+// SetAntiFeeSnipingLockTime sets the lock time of the tx created from the set
+// to the current height given by the source, occasionally randomized below
+// it, which discourages fee sniping. If an input of the set is
+// CLTV-encumbered, its required lock time is used instead.
+func (b *BudgetInputSet) SetAntiFeeSnipingLockTime(source HeightSource) error {
+	lockTime, err := deriveAntiFeeSnipingLockTime(source, b.Inputs())
+	if err != nil {
+		return err
+	}
+
+	return b.SetLockTime(lockTime)
+}
+
+// LockTime returns the lock time of the tx created from the set, if one is
+// set.
+//
+// NOTE: part of the InputSet interface.
+func (b *BudgetInputSet) LockTime() fn.Option[uint32] {
+	return b.lockTime
+}
+
+// PreservesInputOrder returns true if the inputs of the set are returned in
+// the order they were added.
+func (b *BudgetInputSet) PreservesInputOrder() bool {
+	return b.cfg.PreserveInputOrder
+}
+
+// SortsOutputs returns true if the outputs of the tx created from the set
+// should be sorted per BIP69. It's false if the set preserves its input order
+// or an input commits to the position of its required output via
+// SIGHASH_SINGLE, as sorting would invalidate the signatures.
+func (b *BudgetInputSet) SortsOutputs() bool {
+	if !b.cfg.SortOutputs || b.cfg.PreserveInputOrder {
+		return false
+	}
+
+	return !hasOutputPositionCommitment(b.Inputs())
+}
+
+// coinSelectionStrategy returns the strategy used to select the wallet utxos,
+// which is the strategy of the most urgent input that specifies one, falling
+// back to the default of the set. Nil is returned if none is specified.
+func (b *BudgetInputSet) coinSelectionStrategy() base.CoinSelectionStrategy {
+	for _, inp := range b.sortedByUrgency() {
+		strategy := inp.params.CoinSelectionStrategy
+		if strategy.IsSome() {
+			return strategy.UnsafeFromSome()
+		}
+	}
+
+	return b.cfg.CoinSelectionStrategy
+}
+
+// addInput adds an input to the input set.
+func (b *BudgetInputSet) addInput(input SweeperInput) {
+	b.inputs = append(b.inputs, &input)
+}
+
+// NeedWalletInput returns true if the input set needs more wallet inputs.
+//
+// A set may need wallet inputs when it has a required output or its total
+// value cannot cover its total budget.
+func (b *BudgetInputSet) NeedWalletInput() bool {
+	// If we don't have enough extra budget to borrow, we need wallet
+	// inputs.
+	return b.Shortfall() > 0
+}
+
+// Shortfall returns the amount of budget that cannot be borrowed from the
+// inputs in the set and must be covered by wallet inputs. Zero is returned if
+// the set doesn't need wallet inputs.
+func (b *BudgetInputSet) Shortfall() btcutil.Amount {
+	budgetNeeded, budgetBorrowable := b.budgetBalance()
+
+	return max(budgetNeeded-budgetBorrowable, 0)
+}
+
+// budgetBalance returns the amount of budget that needs to be covered from
+// other inputs, and the amount of budget that can be borrowed from other
+// inputs.
+func (b *BudgetInputSet) budgetBalance() (btcutil.Amount, btcutil.Amount) {
+	var (
+		// budgetNeeded is the amount that needs to be covered from
+		// other inputs.
+		budgetNeeded btcutil.Amount
+
+		// budgetBorrowable is the amount that can be borrowed from
+		// other inputs.
+		budgetBorrowable btcutil.Amount
+	)
+
+	for _, inp := range b.inputs {
+		// If this input has a required output, we can assume it's a
+		// second-level htlc txns input. Although this input must have
+		// a value that can cover its budget, it cannot be used to pay
+		// fees. Instead, we need to borrow budget from other inputs to
+		// make the sweep happen. Once swept, the input value will be
+		// credited to the wallet.
+		if inp.RequiredTxOut() != nil {
+			budgetNeeded += inp.params.Budget
+			continue
+		}
+
+		// Get the amount left after covering the input's own budget.
+		// This amount can then be lent to the above input.
+		budget := inp.params.Budget
+		output := btcutil.Amount(inp.SignDesc().Output.Value)
+		budgetBorrowable += output - budget
+
+		// If the input's budget is not even covered by itself, we need
+		// to borrow outputs from other inputs.
+		if budgetBorrowable < 0 {
+			log.Debugf("Input %v specified a budget that exceeds "+
+				"its output value: %v > %v", inp, budget,
+				output)
+		}
+	}
+
+	// The budget of the inputs may differ from the one of the set if a
+	// budget curve is used. Any extra budget must be borrowed from other
+	// inputs, while a lower budget leaves more to lend.
+	extra := b.Budget() - b.inputBudget()
+	if extra > 0 {
+		budgetNeeded += extra
+	} else {
+		budgetBorrowable -= extra
+	}
+
+	log.Tracef("Budget balance: budgetNeeded=%v, budgetBorrowable=%v",
+		budgetNeeded, budgetBorrowable)
+
+	return budgetNeeded, budgetBorrowable
+}
+
+// copyInputs returns a copy of the slice of the inputs in the set.
+func (b *BudgetInputSet) copyInputs() []*SweeperInput {
+	inputs := make([]*SweeperInput, len(b.inputs))
+	copy(inputs, b.inputs)
+	return inputs
+}
+
+// AddWalletInputs adds wallet inputs to the set until the specified budget is
+// met. When sweeping inputs with required outputs, although there's budget
+// specified, it cannot be directly spent from these required outputs. Instead,
+// we need to borrow budget from other inputs to make the sweep happen.
+// There are two sources to borrow from: 1) other inputs, 2) wallet utxos. If
+// we are calling this method, it means other inputs cannot cover the specified
+// budget, so we need to borrow from wallet utxos.
+//
+// Return an error if there are not enough wallet inputs, or if the selection
+// exceeds the coin select timeout, and the budget set is set to its initial
+// state by removing any wallet inputs added. If the wallet has no spendable
+// utxos at all, ErrNoWalletUtxos is returned. If a wallet reserve is set, the
+// selection stops before the unselected wallet balance drops below it, and the
+// set is reverted unless the budget is already covered.
+//
+// NOTE: must be called with the wallet lock held via `WithCoinSelectLock`.
+func (b *BudgetInputSet) AddWalletInputs(wallet Wallet) error {
+	// Make a copy of the current inputs. If the wallet doesn't have enough
+	// utxos to cover the budget, we will revert the current set to its
+	// original state by removing the added wallet inputs.
+	originalInputs := b.copyInputs()
+
+	// Get the current budget balance of the set. As wallet inputs have
+	// neither a budget nor a required output, adding one only increases
+	// the borrowable budget by its value, so we can track the balance
+	// here instead of recalculating it over all the inputs.
+	budgetNeeded, budgetBorrowable := b.budgetBalance()
+
+	// If a reserve is set, get the wallet balance so the selection can
+	// stop before the unselected balance drops below the reserve.
+	var walletBalance btcutil.Amount
+	if b.cfg.WalletReserve > 0 {
+		balance, err := b.walletBalance(wallet)
+		if err != nil {
+			return err
+		}
+		walletBalance = balance
+	}
+
+	// added tracks the wallet utxos added to the set, and selected their
+	// total value.
+	var (
+		added      []*lnwallet.Utxo
+		selected   btcutil.Amount
+		reserveHit bool
+	)
+
+	// checkTimeout returns ErrCoinSelectTimeout if the selection has
+	// exceeded its time bound.
+	start := time.Now()
+	checkTimeout := func() error {
+		if b.cfg.CoinSelectTimeout == 0 {
+			return nil
+		}
+
+		elapsed := time.Since(start)
+		if elapsed <= b.cfg.CoinSelectTimeout {
+			return nil
+		}
+
+		return fmt.Errorf("%w: elapsed=%v, timeout=%v",
+			ErrCoinSelectTimeout, elapsed, b.cfg.CoinSelectTimeout)
+	}
+
+	// addBatch adds the given confirmed wallet utxos, ordered using the
+	// coin selection strategy of the set, until the budget is covered,
+	// and returns true once it is.
+	addBatch := func(utxos []*lnwallet.Utxo) (bool, error) {
+		if err := checkTimeout(); err != nil {
+			return false, err
+		}
+
+		utxos, err := arrangeUtxos(utxos, b.coinSelectionStrategy())
+		if err != nil {
+			return false, err
+		}
+
+		for _, utxo := range utxos {
+			if err := checkTimeout(); err != nil {
+				return false, err
+			}
+
+			// Stop if selecting the utxo would leave less than the
+			// reserve unselected.
+			remaining := walletBalance - selected - utxo.Value
+			reserve := b.cfg.WalletReserve
+			if reserve > 0 && remaining < reserve {
+				log.Debugf("Stopped selecting wallet utxos at "+
+					"%v: remaining balance %v below "+
+					"reserve %v", utxo.OutPoint, remaining,
+					b.cfg.WalletReserve)
+
+				reserveHit = true
+
+				return true, nil
+			}
+
+			input, err := createWalletTxInput(utxo)
+			if err != nil {
+				return false, err
+			}
+
+			pi := SweeperInput{
+				Input: input,
+				params: Params{
+					DeadlineHeight: fn.Some(
+						b.deadlineHeight,
+					),
+				},
+			}
+			b.addInput(pi)
+			added = append(added, utxo)
+			selected += utxo.Value
+
+			budgetBorrowable += utxo.Value
+
+			// Stop if we've reached the minimum output amount.
+			if budgetBorrowable >= budgetNeeded {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	}
+
+	numUtxos, err := forEachWalletUtxoBatch(
+		wallet, b.utxoFilter(), addBatch,
+	)
+	if err == nil && numUtxos == 0 {
+		var hasUtxos bool
+		hasUtxos, err = walletHasUtxos(wallet, b.utxoFilter())
+
+		// Exit with a distinct error if the wallet has nothing to add,
+		// rather than all its utxos being filtered out.
+		if err == nil && !hasUtxos {
+			return ErrNoWalletUtxos
+		}
+	}
+
+	switch {
+	case err != nil:
+		b.inputs = originalInputs
+
+		return err
+
+	case budgetBorrowable >= budgetNeeded:
+		checkDominantWalletInput(added, b.cfg.WarningRecorder)
+
+		b.recordWalletInputs(added)
+
+		return nil
+	}
+
+	// The wallet doesn't have enough utxos to cover the budget. Revert the
+	// input set to its original state.
+	b.inputs = originalInputs
+
+	notEnough := &NotEnoughInputsError{
+		Shortfall:      budgetNeeded - budgetBorrowable,
+		DeadlineHeight: b.deadlineHeight,
+		NumWalletUtxos: numUtxos,
+	}
+	if reserveHit {
+		notEnough.WalletReserve = b.cfg.WalletReserve
+	}
+
+	return notEnough
+}
+
+// recordWalletInputs records the given utxos as wallet inputs of the set.
+func (b *BudgetInputSet) recordWalletInputs(utxos []*lnwallet.Utxo) {
+	if b.walletInputs == nil {
+		b.walletInputs = fn.NewSet[wire.OutPoint]()
+	}
+
+	for _, utxo := range utxos {
+		b.walletInputs.Add(utxo.OutPoint)
+		b.walletInputTotal += utxo.Value
+	}
+}
+
+// IsWalletOnly returns true if the set only consolidates wallet utxos, i.e.,
+// every input spends a wallet utxo, and none of them has a required output or
+// is a force sweep. The wallet utxos are either added as wallet inputs, or
+// offered to the sweeper, e.g., to bump the fee of an unconfirmed wallet tx
+// via CPFP. An empty set is not wallet only.
+func (b *BudgetInputSet) IsWalletOnly() bool {
+	if len(b.inputs) == 0 {
+		return false
+	}
+
+	for _, inp := range b.inputs {
+		isWalletInput := b.walletInputs.Contains(inp.OutPoint()) ||
+			isWalletUtxoSpend(inp.WitnessType())
+		if !isWalletInput {
+			return false
+		}
+
+		if inp.RequiredTxOut() != nil || inp.params.Immediate {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Budget returns the total budget of the set. If a budget curve is set, the
+// budget is given by the curve for the number of blocks left until the
+// deadline at the current height.
+//
+// NOTE: part of the InputSet interface.
+func (b *BudgetInputSet) Budget() btcutil.Amount {
+	if b.cfg.BudgetCurve != nil {
+		return b.cfg.BudgetCurve(b.deadlineHeight - b.currentHeight)
+	}
+
+	return b.inputBudget()
+}
+
+// inputBudget returns the summed budget of the inputs in the set.
+func (b *BudgetInputSet) inputBudget() btcutil.Amount {
+	budget := btcutil.Amount(0)
+	for _, input := range b.inputs {
+		budget += input.params.Budget
+	}
+
+	return budget
+}
+
+// DeadlineHeight returns the deadline height of the set.
+//
+// NOTE: part of the InputSet interface.
+func (b *BudgetInputSet) DeadlineHeight() int32 {
+	return b.deadlineHeight
+}
+
+// OutputCount returns the number of outputs of the tx created from the set.
+// On top of the required outputs, a p2tr change output is projected by
+// assuming the whole budget is spent, unless the change left is dust.
+func (b *BudgetInputSet) OutputCount() int {
+	var (
+		count                      int
+		inputTotal, requiredOutput btcutil.Amount
+	)
+	for _, inp := range b.Inputs() {
+		inputTotal += btcutil.Amount(inp.SignDesc().Output.Value)
+
+		if out := inp.RequiredTxOut(); out != nil {
+			requiredOutput += btcutil.Amount(out.Value)
+			count++
+		}
+	}
+
+	change := inputTotal - requiredOutput - b.Budget()
+	if change >= lnwallet.DustLimitForSize(input.P2TRSize) {
+		count++
+	}
+
+	return count
+}
+
+// VirtualSize returns the projected virtual size in vbytes of the tx created
+// from the set, including a p2tr change output. An error is returned if the
+// weight of an input is unknown.
+func (b *BudgetInputSet) VirtualSize() (int, error) {
+	_, weight, err := estimateFeeAt(b.Inputs(), 0)
+	if err != nil {
+		return 0, err
+	}
+
+	return virtualSize(weight), nil
+}
+
+// Inputs returns the inputs that should be used to create a tx. The inputs
+// are ordered by their urgency, with the earliest-deadline required-output
+// inputs placed first, so that if the tx must be trimmed, the least urgent
+// inputs are dropped. If the set preserves its input order, the inputs are
+// returned in the order they were added instead.
+//
+// NOTE: part of the InputSet interface.
+func (b *BudgetInputSet) Inputs() []input.Input {
+	sorted := b.sortedByUrgency()
+	if b.cfg.PreserveInputOrder {
+		sorted = b.copyInputs()
+	}
+
+	inputs := make([]input.Input, 0, len(sorted))
+	for _, inp := range sorted {
+		inputs = append(inputs, inp.Input)
+	}
+
+	return inputs
+}
+
+// BudgetImpliedMaxFeeRate returns the fee rate at which the fee of the tx
+// created from the set uses up its whole budget, i.e., the budget divided by
+// the weight of the tx. The weight is estimated with a p2tr change output.
+// Zero is returned if the weight of an input is unknown.
+func (b *BudgetInputSet) BudgetImpliedMaxFeeRate() chainfee.SatPerKWeight {
+	_, weight, err := estimateFeeAt(b.Inputs(), 0)
+	if err != nil {
+		log.Debugf("Unable to estimate the budget implied max fee "+
+			"rate: %v", err)
+
+		return 0
+	}
+
+	return chainfee.SatPerKWeight(
+		b.Budget() * 1000 / btcutil.Amount(weight),
+	)
+}
+
+// EffectiveMaxFeeRate returns the max fee rate to use for the tx created from
+// the set given the configured one. If the budget caps the fee rate, the
+// lower of the configured and the budget implied max fee rates is returned.
+// A zero configured max fee rate means no cap other than the budget.
+func (b *BudgetInputSet) EffectiveMaxFeeRate(
+	maxFeeRate chainfee.SatPerKWeight) chainfee.SatPerKWeight {
+
+	if !b.cfg.BudgetCapsFeeRate {
+		return maxFeeRate
+	}
+
+	budgetImplied := b.BudgetImpliedMaxFeeRate()
+	if budgetImplied == 0 {
+		return maxFeeRate
+	}
+
+	if maxFeeRate == 0 {
+		return budgetImplied
+	}
+
+	return min(maxFeeRate, budgetImplied)
+}
+
+// inputDeadline returns the deadline height of the given input. If the input
+// doesn't specify one, the deadline height of the set is used.
+func (b *BudgetInputSet) inputDeadline(inp *SweeperInput) int32 {
+	return inp.params.DeadlineHeight.UnwrapOr(b.deadlineHeight)
+}
+
+// sortedByUrgency returns a copy of the inputs in the set ordered by their
+// urgency. Inputs with required outputs are placed first, sorted by their
+// deadline heights in ascending order. The remaining inputs keep their
+// original order.
+func (b *BudgetInputSet) sortedByUrgency() []*SweeperInput {
+	inputs := b.copyInputs()
+
+	sort.SliceStable(inputs, func(i, j int) bool {
+		reqI := inputs[i].RequiredTxOut() != nil
+		reqJ := inputs[j].RequiredTxOut() != nil
+
+		// Inputs with required outputs always come first.
+		if reqI != reqJ {
+			return reqI
+		}
+
+		// Inputs without required outputs keep their original order.
+		if !reqI {
+			return false
+		}
+
+		// Otherwise the input with an earlier deadline is more urgent.
+		return b.inputDeadline(inputs[i]) < b.inputDeadline(inputs[j])
+	})
+
+	return inputs
+}
+
+// StartingFeeRate returns the max starting fee rate found in the inputs, or
+// the fee rate of the set if it's higher.
+//
+// NOTE: part of the InputSet interface.
+func (b *BudgetInputSet) StartingFeeRate() fn.Option[chainfee.SatPerKWeight] {
+	maxFeeRate := chainfee.SatPerKWeight(0)
+	startingFeeRate := fn.None[chainfee.SatPerKWeight]()
+	if b.feeRate > 0 {
+		maxFeeRate = b.feeRate
+		startingFeeRate = fn.Some(b.feeRate)
+	}
+
+	for _, inp := range b.inputs {
+		feerate := inp.params.StartingFeeRate.UnwrapOr(0)
+		if feerate > maxFeeRate {
+			maxFeeRate = feerate
+			startingFeeRate = fn.Some(maxFeeRate)
+		}
+	}
+
+	return startingFeeRate
+}
+
+// Validate checks that the set is ready to be broadcast at the current height,
+// its budget is covered without needing more wallet inputs, that none of its
+// required outputs are dust and that the tx is within the size limits of its
+// version. A set opting out of replaceability must not spend inputs with a CSV
+// delay.
+func (b *BudgetInputSet) Validate(currentHeight int32) error {
+	// Make sure the set is not broadcast before its not-before height.
+	if currentHeight < b.ReadyAt() {
+		return fmt.Errorf("%w: current height=%v, ready at=%v",
+			ErrNotReady, currentHeight, b.ReadyAt())
+	}
+
+	// Make sure all the required outputs are above their dust limits and
+	// pay to the expected scripts.
+	for _, inp := range b.inputs {
+		reqOut := inp.RequiredTxOut()
+		if reqOut == nil {
+			continue
+		}
+
+		err := checkRequiredScript(inp, b.cfg.RequiredScriptClasses)
+		if err != nil {
+			return err
+		}
+
+		dustLimit := lnwallet.DustLimitForSize(len(reqOut.PkScript))
+		if btcutil.Amount(reqOut.Value) < dustLimit {
+			return fmt.Errorf("%w: input=%v has required "+
+				"output=%v below dust limit=%v", ErrDustOutput,
+				inp, reqOut.Value, dustLimit)
+		}
+	}
+
+	// Make sure the lock time agrees with the inputs added since it was
+	// set, and the tx is final in the next block.
+	if b.lockTime.IsSome() {
+		lockTime := b.lockTime.UnsafeFromSome()
+		if err := validateLockTime(lockTime, b.Inputs()); err != nil {
+			return err
+		}
+
+		if lockTime > uint32(currentHeight) {
+			return fmt.Errorf("%w: lock time=%v, current height=%v",
+				ErrLocktimeImmature, lockTime, currentHeight)
+		}
+	}
+
+	err := validateNonReplaceable(b.cfg.NonReplaceable, b.Inputs())
+	if err != nil {
+		return err
+	}
+
+	// Make sure the budget can be covered by the inputs in the set.
+	if b.NeedWalletInput() {
+		return fmt.Errorf("%w: budget=%v not covered",
+			ErrNotEnoughInputs, b.Budget())
+	}
+
+	err = checkOutputCount(b.OutputCount(), b.cfg.MaxOutputs)
+	if err != nil {
+		return err
+	}
+
+	// Make sure the tx, including a change output, is within the size
+	// limits of its version. The fee rate doesn't affect the weight.
+	_, weight, err := estimateFeeAt(b.Inputs(), 0)
+	if err != nil {
+		return err
+	}
+
+	if err := checkTxVersionWeight(b.TxVersion(), weight); err != nil {
+		return err
+	}
+
+	vsize, err := b.VirtualSize()
+	if err != nil {
+		return err
+	}
+
+	return checkTxVSize(vsize, b.cfg.MaxTxVSize)
+}
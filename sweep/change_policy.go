@@ -0,0 +1,578 @@
+package sweep
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
+)
+
+// ChangePolicy describes how the change of a sweeping tx is paid out. The
+// zero value sends all the change to a single output of the default account.
+type ChangePolicy struct {
+	// Distribution optionally maps account names to the fraction of the
+	// change sent to each of them. One change output is created per
+	// account. It must not be modified once set.
+	Distribution map[string]float64
+
+	// MaxChangeValue is the optional max value of a change output. If the
+	// share of an account exceeds it, it's split into multiple outputs.
+	// Zero means no max.
+	MaxChangeValue btcutil.Amount
+
+	// MinChange is the optional min value of the change output, which is
+	// kept as an anchor for a future CPFP. When set, the tx must always
+	// have a single change output of at least this value. Zero means no
+	// min.
+	MinChange btcutil.Amount
+
+	// Drop indicates the change is given up to the fees, so the tx has no
+	// change output.
+	Drop bool
+
+	// EphemeralAnchor indicates the tx has a zero-value keyless anchor
+	// output in place of the change output, which is only used to CPFP
+	// the tx. As the anchor is dust, the tx is only relayed along with
+	// the child spending it, so it must be a v3 tx paying no fee, i.e.,
+	// its inputs are fully paid out to their required outputs.
+	EphemeralAnchor bool
+
+	// TargetOutputCount is the optional exact number of change outputs of
+	// the default account the change is evenly split into. When set, the
+	// change is mandatory and every change output must be above the dust
+	// limit. Zero means no target.
+	TargetOutputCount int
+
+	// DropUneconomical indicates the change is given up to the fees when
+	// it's worth less than the fee needed to spend it later at the fee
+	// rate of the tx, as recommended by the change decision.
+	DropUneconomical bool
+
+	// ChangeType is the address type of the change outputs. The unknown
+	// address type means the default p2tr.
+	ChangeType lnwallet.AddressType
+}
+
+// ephemeralAnchorScript is the keyless pay-to-anchor (P2A) script, i.e.,
+// OP_1 <0x4e73>, which anyone can spend to CPFP the tx.
+var ephemeralAnchorScript = []byte{
+	txscript.OP_1, txscript.OP_DATA_2, 0x4e, 0x73,
+}
+
+// ephemeralAnchorOutput returns the zero-value keyless anchor output.
+func ephemeralAnchorOutput() *wire.TxOut {
+	pkScript := make([]byte, len(ephemeralAnchorScript))
+	copy(pkScript, ephemeralAnchorScript)
+
+	return &wire.TxOut{Value: 0, PkScript: pkScript}
+}
+
+// accounts returns the accounts receiving the change sorted by name, along
+// with the fraction of the change each of them receives. If no distribution is
+// set, the default account, which is the empty string, receives all the
+// change.
+func (p *ChangePolicy) accounts() ([]string, map[string]float64) {
+	dist := p.Distribution
+	if len(dist) == 0 {
+		dist = map[string]float64{"": 1}
+	}
+
+	accounts := make([]string, 0, len(dist))
+	for account := range dist {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+
+	return accounts, dist
+}
+
+// split splits the given change value across the accounts, which are sorted
+// by name. The rounding remainder goes to the last account.
+func (p *ChangePolicy) split(change btcutil.Amount) []btcutil.Amount {
+	accounts, dist := p.accounts()
+
+	shares := make([]btcutil.Amount, len(accounts))
+	remaining := change
+	for i, account := range accounts {
+		share := btcutil.Amount(float64(change) * dist[account])
+		if i == len(accounts)-1 {
+			share = remaining
+		}
+		remaining -= share
+
+		shares[i] = share
+	}
+
+	return shares
+}
+
+// splitsChange returns true if the change is distributed across accounts or
+// split to respect the max change value.
+func (p *ChangePolicy) splitsChange() bool {
+	return p.Distribution != nil || p.MaxChangeValue > 0
+}
+
+// validate checks the change options of the policy can be combined, and that
+// the change outputs they imply aren't below the given dust limit. A change
+// distribution and a max change value can be combined, while a CPFP anchor,
+// an ephemeral anchor and a target output count exclude any other option.
+func (p *ChangePolicy) validate(dustLimit btcutil.Amount) error {
+	options := 0
+	if p.splitsChange() {
+		options++
+	}
+	if p.MinChange > 0 {
+		options++
+	}
+	if p.EphemeralAnchor {
+		options++
+	}
+	if p.TargetOutputCount > 0 {
+		options++
+	}
+	if options > 1 {
+		return fmt.Errorf("cannot combine a split change, a CPFP " +
+			"anchor, an ephemeral anchor and a target output count")
+	}
+
+	if p.TargetOutputCount < 0 {
+		return fmt.Errorf("invalid target output count=%v",
+			p.TargetOutputCount)
+	}
+
+	if p.MaxChangeValue > 0 && p.MaxChangeValue < dustLimit {
+		return fmt.Errorf("%w: max change value=%v is below dust "+
+			"limit=%v", ErrDustOutput, p.MaxChangeValue, dustLimit)
+	}
+
+	if p.MinChange > 0 && p.MinChange < dustLimit {
+		return fmt.Errorf("%w: min anchor value=%v is below dust "+
+			"limit=%v", ErrDustOutput, p.MinChange, dustLimit)
+	}
+
+	if p.Distribution == nil {
+		return nil
+	}
+
+	if len(p.Distribution) == 0 {
+		return fmt.Errorf("%w: no accounts",
+			ErrInvalidChangeDistribution)
+	}
+
+	total := 0.0
+	for account, fraction := range p.Distribution {
+		if fraction <= 0 {
+			return fmt.Errorf("%w: account=%v has fraction=%v",
+				ErrInvalidChangeDistribution, account, fraction)
+		}
+
+		total += fraction
+	}
+
+	// Allow for rounding errors in the fractions.
+	const epsilon = 1e-9
+	if math.Abs(total-1) > epsilon {
+		return fmt.Errorf("%w: fractions sum up to %v",
+			ErrInvalidChangeDistribution, total)
+	}
+
+	return nil
+}
+
+// changeMandatory returns true if the change can't be given up to the fees, as
+// it's used as a CPFP anchor, or a target output count is set.
+func (p *ChangePolicy) changeMandatory() bool {
+	return p.MinChange > 0 || p.TargetOutputCount > 0
+}
+
+// outputCounts returns the number of change outputs of each account, which
+// are sorted by name, given the value available for the change and the fees.
+// Without a max change value, every account gets a single output, otherwise
+// the share of each account is split into outputs no larger than the max. As
+// the change is only known once the fee is, which in turn depends on the
+// number of outputs, the counts are derived from the value before fees. This
+// may add one output more than strictly needed, but the outputs never exceed
+// the max.
+func (p *ChangePolicy) outputCounts(available btcutil.Amount) []int {
+	// A target output count fixes the number of change outputs of the
+	// default account.
+	if p.TargetOutputCount > 0 {
+		return []int{p.TargetOutputCount}
+	}
+
+	shares := p.split(max(available, 0))
+
+	counts := make([]int, len(shares))
+	for i, share := range shares {
+		counts[i] = 1
+		if p.MaxChangeValue > 0 && share > p.MaxChangeValue {
+			counts[i] = int(
+				(share + p.MaxChangeValue - 1) /
+					p.MaxChangeValue,
+			)
+		}
+	}
+
+	return counts
+}
+
+// outputAccounts returns the account of each change output, in the order the
+// outputs are created, given the value available for the change and the fees.
+func (p *ChangePolicy) outputAccounts(available btcutil.Amount) []string {
+	if p.Drop || p.EphemeralAnchor {
+		return nil
+	}
+
+	accounts, _ := p.accounts()
+	counts := p.outputCounts(available)
+
+	var outputAccounts []string
+	for i, account := range accounts {
+		for j := 0; j < counts[i]; j++ {
+			outputAccounts = append(outputAccounts, account)
+		}
+	}
+
+	return outputAccounts
+}
+
+// outputs splits the change into the change outputs of the policy, paying to
+// the given scripts, which are index-aligned with outputAccounts for the given
+// available value. The share of an account is split evenly across its outputs.
+// No output is returned if the change is dropped, and only the ephemeral
+// anchor if it takes the place of the change.
+// Return ErrDustOutput if any of the outputs is below the dust limit of its
+// script, or ErrChangeBelowMin if the change is below the min value of a CPFP
+// anchor.
+func (p *ChangePolicy) outputs(change, available btcutil.Amount,
+	scripts [][]byte) ([]*wire.TxOut, error) {
+
+	switch {
+	case p.Drop:
+		return nil, nil
+
+	case p.EphemeralAnchor:
+		return []*wire.TxOut{ephemeralAnchorOutput()}, nil
+	}
+
+	if change < p.MinChange {
+		return nil, fmt.Errorf("%w: change=%v, min=%v",
+			ErrChangeBelowMin, change, p.MinChange)
+	}
+
+	accounts, _ := p.accounts()
+	counts := p.outputCounts(available)
+
+	numOutputs := 0
+	for _, count := range counts {
+		numOutputs += count
+	}
+	if len(scripts) != numOutputs {
+		return nil, fmt.Errorf("got %d change scripts for %d change "+
+			"outputs", len(scripts), numOutputs)
+	}
+
+	shares := p.split(change)
+
+	txOuts := make([]*wire.TxOut, 0, numOutputs)
+	for i, account := range accounts {
+		// Split the share evenly, giving one extra satoshi of the
+		// rounding remainder to each of the first outputs so none of
+		// them exceeds the max.
+		count := btcutil.Amount(counts[i])
+		base, remainder := shares[i]/count, shares[i]%count
+
+		for j := btcutil.Amount(0); j < count; j++ {
+			value := base
+			if j < remainder {
+				value++
+			}
+
+			pkScript := scripts[len(txOuts)]
+			if !p.matchesChangeType(pkScript) {
+				return nil, fmt.Errorf("%w: change script "+
+					"%x isn't of type %v",
+					ErrUnsupportedChangeType, pkScript,
+					p.ChangeType)
+			}
+
+			dustLimit := lnwallet.DustLimitForSize(len(pkScript))
+			if value < dustLimit {
+				return nil, fmt.Errorf("%w: account=%v has "+
+					"change=%v, dust limit=%v",
+					ErrDustOutput, account, value,
+					dustLimit)
+			}
+
+			txOuts = append(txOuts, &wire.TxOut{
+				Value:    int64(value),
+				PkScript: pkScript,
+			})
+		}
+	}
+
+	return txOuts, nil
+}
+
+// matchesChangeType returns true if the given change script is of the change
+// type of the policy. Any script matches if no change type is set.
+func (p *ChangePolicy) matchesChangeType(pkScript []byte) bool {
+	if p.ChangeType == lnwallet.UnknownAddressType {
+		return true
+	}
+
+	return scriptChangeType(pkScript) == p.ChangeType
+}
+
+// changePolicySet is implemented by the input sets that decide how the change
+// of their sweeping tx is paid out.
+type changePolicySet interface {
+	// ChangePolicy returns the change policy of the set.
+	ChangePolicy() ChangePolicy
+}
+
+// weightOutputs returns the script of the first change output, which is given
+// to the weight estimator, along with the other outputs the policy creates
+// next to or in place of it, so their weight is accounted for. Zero-value
+// outputs are enough to estimate the weight. No change output is estimated if
+// the change is dropped or no scripts are given.
+func (p *ChangePolicy) weightOutputs(scripts [][]byte) ([]byte,
+	[]*wire.TxOut) {
+
+	switch {
+	case p.Drop:
+		return nil, nil
+
+	case p.EphemeralAnchor:
+		return nil, []*wire.TxOut{ephemeralAnchorOutput()}
+
+	case len(scripts) == 0:
+		return nil, nil
+	}
+
+	txOuts := make([]*wire.TxOut, 0, len(scripts)-1)
+	for _, pkScript := range scripts[1:] {
+		txOuts = append(txOuts, &wire.TxOut{PkScript: pkScript})
+	}
+
+	return scripts[0], txOuts
+}
+
+// scriptChangeType returns the address type of the given change script, or
+// the unknown address type if it's not one the wallet creates.
+func scriptChangeType(pkScript []byte) lnwallet.AddressType {
+	switch {
+	case txscript.IsPayToTaproot(pkScript):
+		return lnwallet.TaprootPubkey
+
+	case txscript.IsPayToWitnessPubKeyHash(pkScript):
+		return lnwallet.WitnessPubKey
+
+	case txscript.IsPayToScriptHash(pkScript):
+		return lnwallet.NestedWitnessPubKey
+
+	default:
+		return lnwallet.UnknownAddressType
+	}
+}
+
+// outputsSpendCost returns the fee needed to spend the given change outputs
+// later at the given fee rate. An output of an unknown type is counted as a
+// p2tr one.
+func outputsSpendCost(txOuts []*wire.TxOut,
+	feeRate chainfee.SatPerKWeight) btcutil.Amount {
+
+	var total btcutil.Amount
+	for _, txOut := range txOuts {
+		addrType := effectiveChangeType(scriptChangeType(txOut.PkScript))
+
+		cost, err := changeSpendCost(addrType, feeRate)
+		if err == nil {
+			total += cost
+		}
+	}
+
+	return total
+}
+
+// availableChange returns the value of the inputs left for the change and the
+// fees once their required outputs are paid.
+func availableChange(inputs []input.Input) btcutil.Amount {
+	var available btcutil.Amount
+	for _, inp := range inputs {
+		available += btcutil.Amount(inp.SignDesc().Output.Value)
+
+		if inp.RequiredTxOut() != nil {
+			available -= btcutil.Amount(inp.RequiredTxOut().Value)
+		}
+	}
+
+	return available
+}
+
+// ChangeRecommendation is the recommended handling of the change of a set.
+type ChangeRecommendation uint8
+
+const (
+	// ChangeKeep recommends creating the change outputs.
+	ChangeKeep ChangeRecommendation = iota
+
+	// ChangeDrop recommends leaving the change to the fees.
+	ChangeDrop
+)
+
+// String returns a human readable description of the recommendation.
+func (r ChangeRecommendation) String() string {
+	switch r {
+	case ChangeKeep:
+		return "Keep"
+
+	case ChangeDrop:
+		return "Drop"
+
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(r))
+	}
+}
+
+// ChangeDecisionResult compares keeping the change of a set to dropping it to
+// the fees.
+type ChangeDecisionResult struct {
+	// KeepValue is the value of the change outputs when the change is
+	// kept.
+	KeepValue btcutil.Amount
+
+	// KeepSpendCost is the fee needed to spend the change outputs later.
+	KeepSpendCost btcutil.Amount
+
+	// CanKeep is true if the change outputs would be above dust.
+	CanKeep bool
+
+	// DropExtraFee is the fee paid on top of the current one when the
+	// change is dropped, which creates no future utxo.
+	DropExtraFee btcutil.Amount
+
+	// CanDrop is true if the tx stays valid without change outputs, i.e.,
+	// it has a required output and the change isn't mandatory.
+	CanDrop bool
+
+	// Recommendation is the recommended alternative.
+	Recommendation ChangeRecommendation
+}
+
+// KeepNetValue returns the value the change brings to the wallet once spent,
+// which is its value minus the fee needed to spend it.
+func (r ChangeDecisionResult) KeepNetValue() btcutil.Amount {
+	return r.KeepValue - r.KeepSpendCost
+}
+
+// decideChange compares keeping the given change, whose outputs cost spendCost
+// to spend later, to dropping it to the fees, given whether each alternative
+// is possible.
+func decideChange(change, spendCost btcutil.Amount, canKeep,
+	canDrop bool) ChangeDecisionResult {
+
+	result := ChangeDecisionResult{
+		KeepValue:     max(change, 0),
+		KeepSpendCost: spendCost,
+		CanKeep:       canKeep,
+		DropExtraFee:  max(change, 0),
+		CanDrop:       canDrop,
+	}
+
+	keep := result.CanKeep &&
+		(result.KeepNetValue() > 0 || !result.CanDrop)
+	if !keep && result.CanDrop {
+		result.Recommendation = ChangeDrop
+	}
+
+	return result
+}
+
+// DefaultChangeTypePreference is the default order of preference of the
+// change output types.
+var DefaultChangeTypePreference = []lnwallet.AddressType{
+	lnwallet.TaprootPubkey,
+	lnwallet.WitnessPubKey,
+}
+
+// effectiveChangeType returns the address type of the change outputs, mapping
+// the unknown address type to the default p2tr.
+func effectiveChangeType(addrType lnwallet.AddressType) lnwallet.AddressType {
+	if addrType == lnwallet.UnknownAddressType {
+		return lnwallet.TaprootPubkey
+	}
+
+	return addrType
+}
+
+// changeScriptSize returns the size of the pkScript of a change output of the
+// given address type.
+func changeScriptSize(addrType lnwallet.AddressType) int {
+	switch effectiveChangeType(addrType) {
+	case lnwallet.WitnessPubKey:
+		return input.P2WPKHSize
+
+	case lnwallet.NestedWitnessPubKey:
+		return input.P2SHSize
+
+	default:
+		return input.P2TRSize
+	}
+}
+
+// SelectChangeType returns the first address type of the preference list that
+// is supported by the wallet and can be used as a change output. Return
+// ErrUnsupportedChangeType if there's none.
+func SelectChangeType(prefs,
+	supported []lnwallet.AddressType) (lnwallet.AddressType, error) {
+
+	for _, pref := range prefs {
+		switch pref {
+		case lnwallet.WitnessPubKey, lnwallet.NestedWitnessPubKey,
+			lnwallet.TaprootPubkey:
+
+		default:
+			continue
+		}
+
+		for _, addrType := range supported {
+			if addrType == pref {
+				return pref, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("%w: prefs=%v, supported=%v",
+		ErrUnsupportedChangeType, prefs, supported)
+}
+
+// changeSpendCost returns the fee needed to spend a change output of the given
+// address type at the given fee rate.
+func changeSpendCost(addrType lnwallet.AddressType,
+	feeRate chainfee.SatPerKWeight) (btcutil.Amount, error) {
+
+	var iw inputWeight
+	switch addrType {
+	case lnwallet.WitnessPubKey:
+		iw.witnessSize = input.P2WKHWitnessSize
+
+	case lnwallet.NestedWitnessPubKey:
+		iw.witnessSize = input.P2WKHWitnessSize
+		iw.nestedP2SH = true
+
+	case lnwallet.TaprootPubkey:
+		iw.witnessSize = input.TaprootKeyPathWitnessSize
+
+	default:
+		return 0, fmt.Errorf("unknown address type %v", addrType)
+	}
+
+	return feeRate.FeeForWeight(iw.weight()), nil
+}
@@ -0,0 +1,120 @@
+package sweep
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcwallet/wtxmgr"
+	"github.com/lightningnetwork/lnd/fn"
+)
+
+// ChangeReservation earmarks the change output of a sweep for a downstream
+// obligation, such as funding a channel open. The change output is leased as
+// soon as the sweeping tx is assembled, so coin selection elsewhere won't
+// spend it before the sweep confirms.
+type ChangeReservation struct {
+	// ID is the lock ID the change output is leased under.
+	ID wtxmgr.LockID
+
+	// Duration is how long the change output is leased for.
+	Duration time.Duration
+
+	// Leaser is used to lease the change output.
+	Leaser OutputLeaser
+}
+
+// changeOutputIndex returns the index of the change output of the given tx,
+// which is the first output not matching one of the required outputs by value
+// and script. Matching by content rather than position locates the change
+// even when the outputs are sorted. False is returned if the tx has no
+// change output.
+func changeOutputIndex(tx *wire.MsgTx, required []*wire.TxOut) (int, bool) {
+	matched := make([]bool, len(tx.TxOut))
+	for _, reqOut := range required {
+		for i, txOut := range tx.TxOut {
+			if matched[i] || txOut.Value != reqOut.Value ||
+				!bytes.Equal(txOut.PkScript, reqOut.PkScript) {
+
+				continue
+			}
+
+			matched[i] = true
+
+			break
+		}
+	}
+
+	for i := range tx.TxOut {
+		if !matched[i] {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// PredictChangeOutPoint returns the outpoint of the change output of the given
+// sweeping tx assembled from the set. The change output is the one not
+// matching a required output of the set, as the required outputs folded into
+// the change aren't created. The txid of the tx doesn't commit to its
+// witnesses, so the outpoint is known before the tx is signed. None is
+// returned if the tx has no change output.
+func (b *BudgetInputSet) PredictChangeOutPoint(
+	tx *wire.MsgTx) fn.Option[wire.OutPoint] {
+
+	var required []*wire.TxOut
+	for _, inp := range b.Inputs() {
+		if reqOut := inp.RequiredTxOut(); reqOut != nil {
+			required = append(required, reqOut)
+		}
+	}
+
+	idx, ok := changeOutputIndex(tx, required)
+	if !ok {
+		return fn.None[wire.OutPoint]()
+	}
+
+	return fn.Some(wire.OutPoint{
+		Hash:  tx.TxHash(),
+		Index: uint32(idx),
+	})
+}
+
+// ReserveChange leases the change output of the given sweeping tx assembled
+// from the set if a change reservation is set, and returns its outpoint. None
+// is returned if no reservation is set or the tx has no change output.
+//
+// NOTE: part of the ChangeReserver interface.
+func (b *BudgetInputSet) ReserveChange(
+	tx *wire.MsgTx) (fn.Option[wire.OutPoint], error) {
+
+	none := fn.None[wire.OutPoint]()
+
+	if b.cfg.ChangeReservation.IsNone() {
+		return none, nil
+	}
+	reservation := b.cfg.ChangeReservation.UnsafeFromSome()
+
+	changeOp := b.PredictChangeOutPoint(tx)
+	if changeOp.IsNone() {
+		log.Debugf("Sweeping tx %v has no change output to reserve",
+			tx.TxHash())
+
+		return none, nil
+	}
+	op := changeOp.UnsafeFromSome()
+
+	_, _, _, err := reservation.Leaser.LeaseOutput(
+		reservation.ID, op, reservation.Duration,
+	)
+	if err != nil {
+		return none, fmt.Errorf("lease change output %v: %w", op, err)
+	}
+
+	log.Debugf("Reserved change output %v for %v", op,
+		reservation.Duration)
+
+	return changeOp, nil
+}
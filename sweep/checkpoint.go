@@ -0,0 +1,231 @@
+package sweep
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/fn"
+	"github.com/lightningnetwork/lnd/lnwallet"
+)
+
+// listUnspentOutPoints returns the outpoints of all the unspent wallet utxos
+// of the default account and, like forEachWalletUtxoBatch, of the given sweep
+// account if the wallet implements AccountUtxoLister, regardless of their
+// confirmations.
+func listUnspentOutPoints(wallet Wallet,
+	account string) (fn.Set[wire.OutPoint], error) {
+
+	unspent, err := wallet.ListUnspentWitnessFromDefaultAccount(
+		0, math.MaxInt32,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list unspent witness: %w", err)
+	}
+
+	if lister, ok := wallet.(AccountUtxoLister); ok && account != "" {
+		utxos, err := lister.ListUnspentWitnessFromAccount(
+			account, 0, math.MaxInt32,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("list unspent witness from "+
+				"account %v: %w", account, err)
+		}
+
+		unspent = append(unspent, utxos...)
+	}
+
+	outpoints := fn.NewSet[wire.OutPoint]()
+	for _, utxo := range unspent {
+		outpoints.Add(utxo.OutPoint)
+	}
+
+	return outpoints, nil
+}
+
+// walletUtxos returns the wallet utxos spent by the wallet inputs of the set.
+// Return an error if the address type of a wallet input can't be derived from
+// its witness type.
+func (b *BudgetInputSet) walletUtxos() ([]*lnwallet.Utxo, error) {
+	var utxos []*lnwallet.Utxo
+	for _, inp := range b.inputs {
+		if !b.walletInputs.Contains(inp.OutPoint()) {
+			continue
+		}
+
+		addrType, ok := walletAddressType(inp.WitnessType())
+		if !ok {
+			return nil, fmt.Errorf("unknown address type of "+
+				"wallet input %v with witness type %v",
+				inp.OutPoint(), inp.WitnessType())
+		}
+
+		output := inp.SignDesc().Output
+		utxos = append(utxos, &lnwallet.Utxo{
+			AddressType: addrType,
+			Value:       btcutil.Amount(output.Value),
+			PkScript:    output.PkScript,
+			OutPoint:    inp.OutPoint(),
+		})
+	}
+
+	return utxos, nil
+}
+
+// nonWalletOutPoints returns the outpoints of the inputs of the set which are
+// not wallet inputs.
+func (b *BudgetInputSet) nonWalletOutPoints() []wire.OutPoint {
+	ops := make([]wire.OutPoint, 0, len(b.inputs))
+	for _, inp := range b.inputs {
+		if b.walletInputs.Contains(inp.OutPoint()) {
+			continue
+		}
+
+		ops = append(ops, inp.OutPoint())
+	}
+
+	return ops
+}
+
+// checkpointID returns the id of the set used to key its checkpoint, which is
+// the hash of the sorted outpoints of its non-wallet inputs. Unlike the
+// deadline height, it tells apart the sets sharing a deadline, and it's stable
+// across restarts as the wallet inputs are excluded.
+func (b *BudgetInputSet) checkpointID() (chainhash.Hash, error) {
+	return checkpointID(b.nonWalletOutPoints())
+}
+
+// checkpointID returns the hash of the given outpoints once sorted, which is
+// the id of the checkpoint of a set with these non-wallet inputs.
+func checkpointID(ops []wire.OutPoint) (chainhash.Hash, error) {
+	sort.Slice(ops, func(i, j int) bool {
+		return outpointLess(ops[i], ops[j])
+	})
+
+	var buf bytes.Buffer
+	for i := range ops {
+		if err := wire.WriteOutPoint(&buf, 0, 0, &ops[i]); err != nil {
+			return chainhash.Hash{}, err
+		}
+	}
+
+	return chainhash.HashH(buf.Bytes()), nil
+}
+
+// Checkpoint stores the wallet inputs selected for the set, keyed by its
+// non-wallet inputs. After a restart, RestoreWalletInputs restores the exact
+// selection instead of running coin selection again, which might pick other
+// utxos and leave the ones selected before locked. Nothing is stored if the
+// set has no wallet inputs.
+func (b *BudgetInputSet) Checkpoint(store SweeperStore) error {
+	utxos, err := b.walletUtxos()
+	if err != nil {
+		return err
+	}
+
+	if len(utxos) == 0 {
+		return nil
+	}
+
+	setInputs := b.nonWalletOutPoints()
+	setID, err := checkpointID(setInputs)
+	if err != nil {
+		return err
+	}
+
+	return store.StoreWalletInputs(setID, setInputs, utxos)
+}
+
+// RestoreWalletInputs adds the wallet inputs checkpointed for the non-wallet
+// inputs of the set. It returns false if there's no checkpoint, or if any of
+// the checkpointed utxos is neither listed as unspent by the wallet, leased,
+// nor spent by our sweeping tx according to the optional isSweeping, in which
+// case the stale checkpoint is deleted. The wallet inputs must then be
+// selected via AddWalletInputs. The utxos already in the set are skipped, and
+// the set is left unchanged if an error is returned.
+//
+// NOTE: must be called with the wallet lock held via `WithCoinSelectLock`.
+func (b *BudgetInputSet) RestoreWalletInputs(store SweeperStore,
+	wallet Wallet, isSweeping SpendChecker) (bool, error) {
+
+	setID, err := b.checkpointID()
+	if err != nil {
+		return false, err
+	}
+
+	utxos, err := store.FetchWalletInputs(setID)
+	switch {
+	case errors.Is(err, ErrWalletInputsNotFound):
+		return false, nil
+
+	case err != nil:
+		return false, err
+	}
+
+	// Make sure the checkpointed utxos can still be spent. The leased
+	// ones and the ones spent by our sweeping tx in the mempool, which is
+	// replaced by the next sweep of the set, are not listed by the wallet
+	// but remain spendable.
+	spendable, err := listUnspentOutPoints(wallet, b.cfg.SweepAccount)
+	if err != nil {
+		return false, err
+	}
+
+	for _, utxo := range utxos {
+		if spendable.Contains(utxo.OutPoint) {
+			continue
+		}
+
+		isLeased := b.cfg.LeaseChecker
+		if isLeased != nil && isLeased(utxo.OutPoint) {
+			continue
+		}
+
+		if isSweeping != nil && isSweeping(utxo.OutPoint) {
+			continue
+		}
+
+		log.Infof("Discarding checkpoint of set %v as wallet utxo=%v "+
+			"is no longer unspent", setID, utxo.OutPoint)
+
+		return false, store.DeleteWalletInputs(setID)
+	}
+
+	existing := fn.NewSet[wire.OutPoint]()
+	for _, inp := range b.inputs {
+		existing.Add(inp.OutPoint())
+	}
+
+	restored := make([]SweeperInput, 0, len(utxos))
+	added := make([]*lnwallet.Utxo, 0, len(utxos))
+	for _, utxo := range utxos {
+		if existing.Contains(utxo.OutPoint) {
+			continue
+		}
+
+		input, err := createWalletTxInput(utxo)
+		if err != nil {
+			return false, err
+		}
+
+		restored = append(restored, SweeperInput{
+			Input: input,
+			params: Params{
+				DeadlineHeight: fn.Some(b.deadlineHeight),
+			},
+		})
+		added = append(added, utxo)
+	}
+
+	for _, inp := range restored {
+		b.addInput(inp)
+	}
+	b.recordWalletInputs(added)
+
+	return true, nil
+}
@@ -0,0 +1,40 @@
+package sweep
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
+)
+
+// DisplayUnit is the unit used to render amounts in the descriptions of the
+// input sets. The amounts are always accounted in satoshis internally.
+type DisplayUnit uint8
+
+const (
+	// DisplayUnitBTC renders amounts in BTC, e.g., "0.00010000 BTC". It's
+	// the default.
+	DisplayUnitBTC DisplayUnit = iota
+
+	// DisplayUnitSat renders amounts in satoshis, e.g., "10000 sat".
+	DisplayUnitSat
+
+	// DisplayUnitMSat renders amounts in millisatoshis, e.g., "10000000
+	// mSAT".
+	DisplayUnitMSat
+)
+
+// Format renders the given amount in the display unit.
+func (u DisplayUnit) Format(amt btcutil.Amount) string {
+	switch u {
+	case DisplayUnitSat:
+		return fmt.Sprintf("%d sat", int64(amt))
+
+	// NOTE: we don't use lnwire.MilliSatoshi here as the amount, e.g., a
+	// change output, may be negative.
+	case DisplayUnitMSat:
+		return fmt.Sprintf("%d mSAT", int64(amt)*1000)
+
+	default:
+		return amt.String()
+	}
+}
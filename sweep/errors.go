@@ -0,0 +1,153 @@
+package sweep
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
+)
+
+var (
+	// ErrNotEnoughInputs is returned when there are not enough wallet
+	// inputs to construct a non-dust change output for an input set.
+	ErrNotEnoughInputs = fmt.Errorf("not enough inputs")
+
+	// ErrNoWalletUtxos is returned when wallet inputs are needed but the
+	// wallet has no spendable utxos at all.
+	ErrNoWalletUtxos = fmt.Errorf("no spendable wallet utxos")
+
+	// ErrDeadlinesMismatch is returned when the deadlines of the input
+	// sets do not match.
+	ErrDeadlinesMismatch = fmt.Errorf("deadlines mismatch")
+
+	// ErrDustOutput is returned when the output value is below the dust
+	// limit.
+	ErrDustOutput = fmt.Errorf("dust output")
+
+	// ErrNotReady is returned when an input set is validated before the
+	// height it can be broadcast at.
+	ErrNotReady = fmt.Errorf("input set not ready")
+
+	// ErrForceSubsidyExceeded is returned when the wallet value needed to
+	// cover the negative change caused by force sweeps exceeds the
+	// configured max.
+	ErrForceSubsidyExceeded = fmt.Errorf("force sweep subsidy exceeded")
+
+	// ErrFeeExceedsValue is returned in safe mode when the fees of a set
+	// exceed the value it recovers.
+	ErrFeeExceedsValue = fmt.Errorf("fee exceeds swept value")
+
+	// ErrTooManyPinnedInputs is returned when the number of pinned inputs
+	// of a set exceeds the max number of inputs allowed.
+	ErrTooManyPinnedInputs = fmt.Errorf("too many pinned inputs")
+
+	// ErrTooManyInputs is returned when merging sets would exceed the max
+	// number of inputs allowed in a set.
+	ErrTooManyInputs = fmt.Errorf("too many inputs")
+
+	// ErrTooManyRequiredOutputs is returned when the number of inputs with
+	// required outputs in a set exceeds the max allowed.
+	ErrTooManyRequiredOutputs = fmt.Errorf("too many required outputs")
+
+	// ErrInvalidChangeDistribution is returned when the fractions of a
+	// change distribution are not positive or don't sum up to 1.0.
+	ErrInvalidChangeDistribution = fmt.Errorf("invalid change " +
+		"distribution")
+
+	// ErrUnsupportedTxVersion is returned when a set is configured with a
+	// tx version other than 2 or 3.
+	ErrUnsupportedTxVersion = fmt.Errorf("unsupported tx version")
+
+	// ErrInvalidLockTime is returned when a set is configured with a lock
+	// time that isn't a block height.
+	ErrInvalidLockTime = fmt.Errorf("invalid lock time")
+
+	// ErrTxTooLarge is returned when the tx created from a set exceeds the
+	// max weight allowed by the policy of its version.
+	ErrTxTooLarge = fmt.Errorf("tx too large")
+
+	// ErrCoinSelectTimeout is returned when adding wallet inputs takes
+	// longer than the configured coin selection timeout.
+	ErrCoinSelectTimeout = fmt.Errorf("coin selection timed out")
+
+	// ErrRequiredOutputsExceedInputs is returned when a set made only of
+	// inputs with required outputs commits to more output value than its
+	// inputs are worth, so its sweep can never balance.
+	ErrRequiredOutputsExceedInputs = fmt.Errorf("required outputs exceed " +
+		"input value")
+
+	// ErrFeeToChangeRatioExceeded is returned when the fee of a set exceeds
+	// the max ratio to its change, and neither more wallet inputs nor
+	// dropping the change can fix it.
+	ErrFeeToChangeRatioExceeded = fmt.Errorf("fee to change ratio " +
+		"exceeded")
+
+	// ErrTooManyOutputs is returned when the tx created from a set would
+	// have more outputs than the max configured for the set.
+	ErrTooManyOutputs = fmt.Errorf("too many outputs")
+
+	// ErrUnexpectedScript is returned when the required output of an
+	// input pays to a script that doesn't match any of the expected script
+	// classes of the set.
+	ErrUnexpectedScript = fmt.Errorf("unexpected required output script")
+
+	// ErrDuplicateRequiredOutput is returned when merging sets whose
+	// inputs commit to identical required outputs, e.g., duplicate HTLC
+	// resolution attempts, which would create a tx with a double output.
+	ErrDuplicateRequiredOutput = fmt.Errorf("duplicate required output")
+
+	// ErrChangeBelowMin is returned when the change of a tx is below the
+	// min value required to keep it as a CPFP anchor.
+	ErrChangeBelowMin = fmt.Errorf("change below min value")
+
+	// ErrUnsupportedChangeType is returned when none of the preferred
+	// change output types is supported.
+	ErrUnsupportedChangeType = fmt.Errorf("unsupported change type")
+
+	// ErrReplaceableInput is returned when a set opting out of
+	// replaceability spends an input with a CSV delay, whose sequence
+	// always signals replaceability.
+	ErrReplaceableInput = fmt.Errorf("input signals replaceability")
+
+	// ErrInvalidEphemeralAnchor is returned when a tx with an ephemeral
+	// anchor isn't a v3 tx, or would pay a fee, in which case the anchor
+	// isn't relayed.
+	ErrInvalidEphemeralAnchor = fmt.Errorf("ephemeral anchor requires " +
+		"a zero-fee v3 tx")
+)
+
+// NotEnoughInputsError is returned when a set remains under-funded after
+// adding wallet inputs. It wraps ErrNotEnoughInputs and carries the context
+// needed to debug the failed sweep.
+type NotEnoughInputsError struct {
+	// Shortfall is the amount still missing to fund the set.
+	Shortfall btcutil.Amount
+
+	// DeadlineHeight is the deadline height of the set, which is zero if
+	// the set has no deadline.
+	DeadlineHeight int32
+
+	// NumWalletUtxos is the number of wallet utxos examined.
+	NumWalletUtxos int
+
+	// WalletReserve is the reserve of the wallet balance that stopped the
+	// selection of wallet utxos, which is zero if no reserve was hit.
+	WalletReserve btcutil.Amount
+}
+
+// Error returns a human-readable description of the error.
+func (e *NotEnoughInputsError) Error() string {
+	msg := fmt.Sprintf("%v: shortfall=%v, deadline=%v, wallet_utxos=%v",
+		ErrNotEnoughInputs, e.Shortfall, e.DeadlineHeight,
+		e.NumWalletUtxos)
+
+	if e.WalletReserve > 0 {
+		msg += fmt.Sprintf(", wallet_reserve=%v", e.WalletReserve)
+	}
+
+	return msg
+}
+
+// Unwrap returns ErrNotEnoughInputs so the error matches it via errors.Is.
+func (e *NotEnoughInputsError) Unwrap() error {
+	return ErrNotEnoughInputs
+}
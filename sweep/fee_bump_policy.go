@@ -0,0 +1,140 @@
+package sweep
+
+import (
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
+)
+
+// nextRBFFee returns the min absolute fee of a tx of the given weight replacing
+// one paying the current fee, which must pay for its own size at the
+// incremental relay fee rate on top of the current fee as required by the
+// BIP125 rule 4. As done by the mempool policy, the incremental fee is charged
+// on the virtual size rounded up.
+func nextRBFFee(currentFee btcutil.Amount, weight int) btcutil.Amount {
+	incrementalFee := incrementalRelayFeeRate.FeePerKVByte().FeeForVSize(
+		int64(virtualSize(weight)),
+	)
+
+	return currentFee + incrementalFee
+}
+
+// NextRBFFee returns the min absolute fee the tx created from the set must pay
+// to replace a tx paying the current fee, which is the current fee plus the
+// incremental relay fee for the weight of the tx, including the change
+// output. This lets the fee be bumped by the smallest valid step.
+//
+// NOTE: part of the RBFFeeCalculator interface.
+func (t *txInputSet) NextRBFFee(currentFee btcutil.Amount) btcutil.Amount {
+	return nextRBFFee(currentFee, t.weightEstimate(true).weight())
+}
+
+// FeeBumpPolicy decides how the fee rate of a set escalates as its deadline
+// approaches, so operators can supply custom escalation curves.
+type FeeBumpPolicy interface {
+	// NextFeeRate returns the fee rate to use after the given current fee
+	// rate, given the number of blocks left until the deadline, which is
+	// negative once the deadline has passed.
+	NextFeeRate(current chainfee.SatPerKWeight,
+		blocksUntilDeadline int32) chainfee.SatPerKWeight
+}
+
+// LinearFeeBumpPolicy raises the fee rate by an even step each block so it
+// reaches the max fee rate at the deadline, which matches the escalation of
+// the LinearFeeFunction. It's the default policy of BudgetInputSet.
+type LinearFeeBumpPolicy struct {
+	// MaxFeeRate is the fee rate reached at the deadline.
+	MaxFeeRate chainfee.SatPerKWeight
+}
+
+// Compile-time constraint to ensure LinearFeeBumpPolicy implements
+// FeeBumpPolicy.
+var _ FeeBumpPolicy = (*LinearFeeBumpPolicy)(nil)
+
+// NextFeeRate returns the current fee rate raised by the remaining distance to
+// the max fee rate divided by the number of blocks left. The max fee rate is
+// returned once the deadline is reached, and the fee rate is never lowered.
+//
+// NOTE: part of the FeeBumpPolicy interface.
+func (l *LinearFeeBumpPolicy) NextFeeRate(current chainfee.SatPerKWeight,
+	blocksUntilDeadline int32) chainfee.SatPerKWeight {
+
+	if current >= l.MaxFeeRate {
+		return current
+	}
+
+	if blocksUntilDeadline <= 1 {
+		return l.MaxFeeRate
+	}
+
+	step := (l.MaxFeeRate - current) /
+		chainfee.SatPerKWeight(blocksUntilDeadline)
+
+	return current + step
+}
+
+// SetFeeRate sets the fee rate of the set, which is used as the starting fee
+// rate of the sweep if it's higher than the ones of the inputs.
+func (b *BudgetInputSet) SetFeeRate(feeRate chainfee.SatPerKWeight) {
+	b.feeRate = feeRate
+}
+
+// FeeRate returns the fee rate of the set, which is zero if none was set.
+//
+// NOTE: part of the FeeRateBumper interface.
+func (b *BudgetInputSet) FeeRate() chainfee.SatPerKWeight {
+	return b.feeRate
+}
+
+// BumpFeeRate sets the fee rate of the set to the next fee rate given by its
+// fee bump policy at the given current height, and returns it. If no fee rate
+// has been set, the escalation starts from the starting fee rate of the
+// inputs, or the fee rate floor. The default policy raises the fee rate
+// linearly so the whole budget is used at the deadline.
+//
+// NOTE: part of the FeeRateBumper interface.
+func (b *BudgetInputSet) BumpFeeRate(
+	currentHeight int32) (chainfee.SatPerKWeight, error) {
+
+	current := b.feeRate
+	if current == 0 {
+		current = b.StartingFeeRate().UnwrapOr(chainfee.FeePerKwFloor)
+	}
+
+	policy := b.cfg.FeeBumpPolicy
+	if policy == nil {
+		_, weight, err := estimateFeeAt(b.Inputs(), current)
+		if err != nil {
+			return 0, err
+		}
+
+		policy = &LinearFeeBumpPolicy{
+			MaxFeeRate: chainfee.NewSatPerKWeight(
+				b.Budget(), uint64(weight),
+			),
+		}
+	}
+
+	b.SetFeeRate(policy.NextFeeRate(
+		current, b.deadlineHeight-currentHeight,
+	))
+
+	return b.feeRate, nil
+}
+
+// NextRBFFee returns the min absolute fee the tx created from the set must pay
+// to replace a tx paying the current fee, which is the current fee plus the
+// incremental relay fee for the weight of the tx, including a p2tr change
+// output. This lets the fee be bumped by the smallest valid step. Zero is
+// returned if the weight of an input is unknown.
+//
+// NOTE: part of the RBFFeeCalculator interface.
+func (b *BudgetInputSet) NextRBFFee(currentFee btcutil.Amount) btcutil.Amount {
+	_, weight, err := estimateFeeAt(b.Inputs(), 0)
+	if err != nil {
+		log.Debugf("Unable to estimate the next RBF fee: %v", err)
+
+		return 0
+	}
+
+	return nextRBFFee(currentFee, weight)
+}
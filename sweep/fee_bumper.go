@@ -1,8 +1,10 @@
 package sweep
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"sync/atomic"
 
@@ -118,6 +120,139 @@ type BumpRequest struct {
 	// StartingFeeRate is an optional parameter that can be used to specify
 	// the initial fee rate to use for the fee function.
 	StartingFeeRate fn.Option[chainfee.SatPerKWeight]
+
+	// NonReplaceable indicates the sweeping tx should opt out of
+	// replaceability via the nSequence of its inputs.
+	NonReplaceable bool
+
+	// Label is an optional label attached to the sweeping tx when it's
+	// published. If empty, the generic sweep label is used.
+	Label string
+
+	// PreserveInputOrder indicates the inputs must be added to the sweeping
+	// tx in the given order, with the required outputs following the
+	// order of their inputs, to keep position-dependent signatures valid.
+	PreserveInputOrder bool
+
+	// SortOutputs indicates the outputs of the sweeping tx, including the
+	// change, must be sorted per BIP69 to avoid fingerprinting the wallet.
+	// It's ignored if an input commits to the position of its required
+	// output.
+	SortOutputs bool
+
+	// TxVersion is the version of the sweeping tx. If zero, version 2 is
+	// used.
+	TxVersion int32
+
+	// LockTime is the optional lock time of the sweeping tx. If none, the
+	// lock time the inputs commit to is used, or else the current height
+	// to discourage fee sniping.
+	LockTime fn.Option[uint32]
+
+	// FeeRateBumper is an optional bumper driving the fee rate of the
+	// sweeping tx, capped at the max fee rate allowed. If nil, the fee
+	// rate is raised linearly until the deadline.
+	FeeRateBumper FeeRateBumper
+
+	// ChangePolicy describes how the change of the sweeping tx is paid
+	// out. The zero value sends it to the delivery address.
+	ChangePolicy ChangePolicy
+
+	// ChangeScripts are the scripts of the change outputs created by the
+	// change policy, in the order of its outputs. If empty, the single
+	// change output pays to the delivery address.
+	ChangeScripts [][]byte
+
+	// ChangeReserver is an optional reserver leasing the change output of
+	// every sweeping tx assembled for the request, so it's not spent
+	// elsewhere before the tx confirms.
+	ChangeReserver ChangeReserver
+
+	// RBFFeeCalculator is an optional calculator of the min fee a
+	// replacement of the sweeping tx must pay. If set, a replacement
+	// paying less isn't broadcast.
+	RBFFeeCalculator RBFFeeCalculator
+}
+
+// ChangeReserver reserves the change output of a sweeping tx for a downstream
+// obligation. It's implemented by BudgetInputSet, so a set carrying a change
+// reservation leases the change of its sweeping tx.
+type ChangeReserver interface {
+	// ReserveChange leases the change output of the given sweeping tx and
+	// returns its outpoint, or none if the tx has no change output.
+	ReserveChange(tx *wire.MsgTx) (fn.Option[wire.OutPoint], error)
+}
+
+// txVersion returns the version of the sweeping tx of the request.
+func (r *BumpRequest) txVersion() int32 {
+	if r == nil || r.TxVersion == 0 {
+		return defaultTxVersion
+	}
+
+	return r.TxVersion
+}
+
+// sweepTxOptions holds the options used when creating the sweeping tx of a
+// request.
+type sweepTxOptions struct {
+	// version is the version of the sweeping tx.
+	version int32
+
+	// lockTime is the optional lock time of the sweeping tx. If none, the
+	// lock time the inputs commit to is used, or else the current height.
+	lockTime fn.Option[uint32]
+
+	// replaceable decides whether the tx signals replaceability via the
+	// nSequence of its inputs.
+	replaceable bool
+
+	// preserveOrder indicates the inputs are added in the given order
+	// instead of placing the inputs with required outputs first.
+	preserveOrder bool
+
+	// sortOutputs indicates the outputs are sorted per BIP69 unless an
+	// input commits to the position of its required output.
+	sortOutputs bool
+
+	// changePolicy describes how the change is paid out.
+	changePolicy ChangePolicy
+
+	// changeScripts are the scripts of the change outputs of the change
+	// policy. If empty, the change pays to the given change script.
+	changeScripts [][]byte
+}
+
+// changeScriptsOr returns the scripts of the change outputs, falling back to a
+// single output paying to the given script if none are set.
+func (o *sweepTxOptions) changeScriptsOr(pkScript []byte) [][]byte {
+	if len(o.changeScripts) == 0 {
+		return [][]byte{pkScript}
+	}
+
+	return o.changeScripts
+}
+
+// txOptions returns the options used to create the sweeping tx of the
+// request.
+func (r *BumpRequest) txOptions() sweepTxOptions {
+	return sweepTxOptions{
+		version:       r.txVersion(),
+		lockTime:      r.LockTime,
+		replaceable:   !r.NonReplaceable,
+		preserveOrder: r.PreserveInputOrder,
+		sortOutputs:   r.SortOutputs,
+		changePolicy:  r.ChangePolicy,
+		changeScripts: r.ChangeScripts,
+	}
+}
+
+// txLabel returns the label to attach to the sweeping tx of the request.
+func (r *BumpRequest) txLabel() string {
+	if r == nil || r.Label == "" {
+		return labels.MakeLabel(labels.LabelTypeSweepTransaction, nil)
+	}
+
+	return r.Label
 }
 
 // MaxFeeRateAllowed returns the maximum fee rate allowed for the given
@@ -127,7 +262,9 @@ type BumpRequest struct {
 func (r *BumpRequest) MaxFeeRateAllowed() (chainfee.SatPerKWeight, error) {
 	// Get the size of the sweep tx, which will be used to calculate the
 	// budget fee rate.
-	size, err := calcSweepTxWeight(r.Inputs, r.DeliveryAddress)
+	opts := r.txOptions()
+	changeScripts := opts.changeScriptsOr(r.DeliveryAddress)
+	size, err := calcSweepTxWeight(r.Inputs, r.ChangePolicy, changeScripts)
 	if err != nil {
 		return 0, err
 	}
@@ -153,23 +290,25 @@ func (r *BumpRequest) MaxFeeRateAllowed() (chainfee.SatPerKWeight, error) {
 }
 
 // calcSweepTxWeight calculates the weight of the sweep tx. It assumes a
-// sweeping tx always has a single output(change).
-func calcSweepTxWeight(inputs []input.Input,
-	outputPkScript []byte) (uint64, error) {
+// sweeping tx only has the outputs of its change policy, paying to the given
+// change scripts.
+func calcSweepTxWeight(inputs []input.Input, policy ChangePolicy,
+	changeScripts [][]byte) (uint64, error) {
 
 	// Use a const fee rate as we only use the weight estimator to
 	// calculate the size.
 	const feeRate = 1
 
 	// Initialize the tx weight estimator with,
-	// - nil outputs as we only have one single change output.
+	// - the extra change outputs, if any, next to the first one.
 	// - const fee rate as we don't care about the fees here.
 	// - 0 maxfeerate as we don't care about fees here.
 	//
 	// TODO(yy): we should refactor the weight estimator to not require a
 	// fee rate and max fee rate and make it a pure tx weight calculator.
+	changePkScript, extraOutputs := policy.weightOutputs(changeScripts)
 	_, estimator, err := getWeightEstimate(
-		inputs, nil, feeRate, 0, outputPkScript,
+		inputs, extraOutputs, feeRate, 0, changePkScript,
 	)
 	if err != nil {
 		return 0, err
@@ -379,6 +518,14 @@ func (t *TxPublisher) initializeFeeFunction(
 		"maxFeeRateAllowed=%v", confTarget, req.Budget,
 		maxFeeRateAllowed)
 
+	// Let the bumper of the request drive the fee rate if it has one.
+	if req.FeeRateBumper != nil {
+		return newBumperFeeFunction(
+			req.FeeRateBumper, maxFeeRateAllowed,
+			req.DeadlineHeight, t.currentHeight,
+		)
+	}
+
 	// Initialize the fee function and return it.
 	//
 	// TODO(yy): return based on differet req.Strategy?
@@ -402,7 +549,9 @@ func (t *TxPublisher) createRBFCompliantTx(req *BumpRequest,
 
 		switch {
 		case err == nil:
-			// The tx is valid, return the request ID.
+			// The tx is valid, reserve its change and return the
+			// request ID.
+			t.reserveChange(req, tx)
 			requestID := t.storeRecord(tx, req, f, fee)
 
 			log.Infof("Created tx %v for %v inputs: feerate=%v, "+
@@ -456,6 +605,30 @@ func (t *TxPublisher) createRBFCompliantTx(req *BumpRequest,
 	}
 }
 
+// reserveChange leases the change output of the given sweeping tx if the
+// request asks for it. Failing to lease the change doesn't stop the sweep, as
+// the inputs still need to be swept, so the error is only logged.
+func (t *TxPublisher) reserveChange(req *BumpRequest, tx *wire.MsgTx) {
+	if req.ChangeReserver == nil {
+		return
+	}
+
+	_, err := req.ChangeReserver.ReserveChange(tx)
+	if err != nil {
+		log.Errorf("Failed to reserve change of tx %v: %v",
+			tx.TxHash(), err)
+	}
+}
+
+// RBFFeeCalculator computes the min fee of a valid replacement of a sweeping
+// tx. It's implemented by the input sets, which know the weight of the tx they
+// create.
+type RBFFeeCalculator interface {
+	// NextRBFFee returns the min absolute fee a replacement of a tx paying
+	// the current fee must pay to satisfy the BIP125 rule 4.
+	NextRBFFee(currentFee btcutil.Amount) btcutil.Amount
+}
+
 // storeRecord stores the given record in the records map.
 func (t *TxPublisher) storeRecord(tx *wire.MsgTx, req *BumpRequest,
 	f FeeFunction, fee btcutil.Amount) uint64 {
@@ -487,7 +660,7 @@ func (t *TxPublisher) createAndCheckTx(req *BumpRequest, f FeeFunction) (
 	// Create the sweep tx with max fee rate of 0 as the fee function
 	// guarantees the fee rate used here won't exceed the max fee rate.
 	tx, fee, err := t.createSweepTx(
-		req.Inputs, req.DeliveryAddress, f.FeeRate(),
+		req.Inputs, req.DeliveryAddress, f.FeeRate(), req.txOptions(),
 	)
 	if err != nil {
 		return nil, fee, fmt.Errorf("create sweep tx: %w", err)
@@ -551,9 +724,7 @@ func (t *TxPublisher) broadcast(requestID uint64) (*BumpResult, error) {
 	// Publish the sweeping tx with customized label. If the publish fails,
 	// this error will be saved in the `BumpResult` and it will be removed
 	// from being monitored.
-	err := t.cfg.Wallet.PublishTransaction(
-		tx, labels.MakeLabel(labels.LabelTypeSweepTransaction, nil),
-	)
+	err := t.cfg.Wallet.PublishTransaction(tx, record.req.txLabel())
 	if err != nil {
 		// NOTE: we decide to attach this error to the result instead
 		// of returning it here because by the time the tx reaches
@@ -938,6 +1109,22 @@ func (t *TxPublisher) createAndPublishTx(requestID uint64,
 		return fn.None[BumpResult]()
 	}
 
+	// If the replacement doesn't pay the min fee of a valid RBF, it'd be
+	// rejected, so we let the fee bumper retry it at next block.
+	//
+	// NOTE: the mempool check above already rejects such a tx, but it's
+	// skipped on backends not supporting it, eg, neutrino.
+	if err == nil && r.req.RBFFeeCalculator != nil {
+		minFee := r.req.RBFFeeCalculator.NextRBFFee(r.fee)
+		if fee < minFee {
+			log.Debugf("Skipped bumping tx %v: fee=%v is below the "+
+				"min replacement fee=%v", oldTx.TxHash(), fee,
+				minFee)
+
+			return fn.None[BumpResult]()
+		}
+	}
+
 	// If the error is not fee related, we will return a `TxFailed` event
 	// so this input can be retried.
 	if err != nil {
@@ -963,8 +1150,9 @@ func (t *TxPublisher) createAndPublishTx(requestID uint64,
 		})
 	}
 
-	// The tx has been created without any errors, we now register a new
-	// record by overwriting the same requestID.
+	// The tx has been created without any errors, we now reserve its
+	// change and register a new record by overwriting the same requestID.
+	t.reserveChange(r.req, tx)
 	t.records.Store(requestID, &monitorRecord{
 		tx:          tx,
 		req:         r.req,
@@ -1116,40 +1304,75 @@ func calcCurrentConfTarget(currentHeight, deadline int32) uint32 {
 }
 
 // createSweepTx creates a sweeping tx based on the given inputs, change
-// address and fee rate.
+// address, fee rate and tx options. The optional lock time of the options is
+// used unless the inputs commit to one, in which case they must agree.
 func (t *TxPublisher) createSweepTx(inputs []input.Input, changePkScript []byte,
-	feeRate chainfee.SatPerKWeight) (*wire.MsgTx, btcutil.Amount, error) {
+	feeRate chainfee.SatPerKWeight, opts sweepTxOptions) (*wire.MsgTx,
+	btcutil.Amount, error) {
 
-	// Validate and calculate the fee and change amount.
-	txFee, changeAmtOpt, locktimeOpt, err := prepareSweepTx(
-		inputs, changePkScript, feeRate, t.currentHeight,
+	// The ephemeral anchor is only relayed in a v3 tx.
+	if opts.changePolicy.EphemeralAnchor && opts.version != trucTxVersion {
+		return nil, 0, fmt.Errorf("%w: tx version=%v",
+			ErrInvalidEphemeralAnchor, opts.version)
+	}
+
+	// Validate and calculate the fee and change outputs.
+	txFee, changeOutputs, locktimeOpt, err := prepareSweepTx(
+		inputs, opts.changeScriptsOr(changePkScript), opts.changePolicy,
+		feeRate, t.currentHeight,
 	)
 	if err != nil {
 		return nil, 0, err
 	}
 
+	// The given lock time must agree with the one the inputs commit to.
+	if locktimeOpt.IsSome() && opts.lockTime.IsSome() {
+		required := uint32(locktimeOpt.UnsafeFromSome())
+		if required != opts.lockTime.UnsafeFromSome() {
+			return nil, 0, fmt.Errorf("%w: inputs require lock "+
+				"time=%v, got %v", ErrLocktimeConflict,
+				required, opts.lockTime.UnsafeFromSome())
+		}
+	}
+
 	var (
-		// Create the sweep transaction that we will be building. We
-		// use version 2 as it is required for CSV.
-		sweepTx = wire.NewMsgTx(2)
+		// Create the sweep transaction that we will be building. The
+		// version is at least 2 as it is required for CSV.
+		sweepTx = wire.NewMsgTx(opts.version)
 
 		// We'll add the inputs as we go so we know the final ordering
 		// of inputs to sign.
 		idxs []input.Input
 	)
 
+	// If the order must be preserved, we add the inputs as given, along
+	// with their required outputs, so the positions match what was signed.
+	if opts.preserveOrder {
+		for _, o := range inputs {
+			idxs = append(idxs, o)
+			sweepTx.AddTxIn(&wire.TxIn{
+				PreviousOutPoint: o.OutPoint(),
+				Sequence:         txInSequence(o, opts.replaceable),
+			})
+
+			if o.RequiredTxOut() != nil {
+				sweepTx.AddTxOut(o.RequiredTxOut())
+			}
+		}
+	}
+
 	// We start by adding all inputs that commit to an output. We do this
 	// since the input and output index must stay the same for the
 	// signatures to be valid.
 	for _, o := range inputs {
-		if o.RequiredTxOut() == nil {
+		if opts.preserveOrder || o.RequiredTxOut() == nil {
 			continue
 		}
 
 		idxs = append(idxs, o)
 		sweepTx.AddTxIn(&wire.TxIn{
 			PreviousOutPoint: o.OutPoint(),
-			Sequence:         o.BlocksToMaturity(),
+			Sequence:         txInSequence(o, opts.replaceable),
 		})
 		sweepTx.AddTxOut(o.RequiredTxOut())
 	}
@@ -1157,28 +1380,34 @@ func (t *TxPublisher) createSweepTx(inputs []input.Input, changePkScript []byte,
 	// Sum up the value contained in the remaining inputs, and add them to
 	// the sweep transaction.
 	for _, o := range inputs {
-		if o.RequiredTxOut() != nil {
+		if opts.preserveOrder || o.RequiredTxOut() != nil {
 			continue
 		}
 
 		idxs = append(idxs, o)
 		sweepTx.AddTxIn(&wire.TxIn{
 			PreviousOutPoint: o.OutPoint(),
-			Sequence:         o.BlocksToMaturity(),
+			Sequence:         txInSequence(o, opts.replaceable),
 		})
 	}
 
-	// If there's a change amount, add it to the transaction.
-	changeAmtOpt.WhenSome(func(changeAmt btcutil.Amount) {
-		sweepTx.AddTxOut(&wire.TxOut{
-			PkScript: changePkScript,
-			Value:    int64(changeAmt),
-		})
-	})
+	// If there's any change, add its outputs to the transaction.
+	for _, txOut := range changeOutputs {
+		sweepTx.AddTxOut(txOut)
+	}
 
-	// We'll default to using the current block height as locktime, if none
-	// of the inputs commits to a different locktime.
-	sweepTx.LockTime = uint32(locktimeOpt.UnwrapOr(t.currentHeight))
+	// Sort the outputs per BIP69 if asked to, as long as no signature
+	// commits to the position of an output.
+	if opts.sortOutputs && !hasOutputPositionCommitment(inputs) {
+		sortOutputsBIP69(sweepTx.TxOut)
+	}
+
+	// We'll default to using the given lock time, or else the current
+	// block height, if none of the inputs commits to a different locktime.
+	sweepTx.LockTime = opts.lockTime.UnwrapOr(uint32(t.currentHeight))
+	locktimeOpt.WhenSome(func(lt int32) {
+		sweepTx.LockTime = uint32(lt)
+	})
 
 	prevInputFetcher, err := input.MultiPrevOutFetcher(inputs)
 	if err != nil {
@@ -1220,31 +1449,57 @@ func (t *TxPublisher) createSweepTx(inputs []input.Input, changePkScript []byte,
 	return sweepTx, txFee, nil
 }
 
-// prepareSweepTx returns the tx fee, an optional change amount and an optional
+// sortOutputsBIP69 sorts the outputs per BIP69, i.e., by ascending value, then
+// by lexicographic order of their pkScripts.
+func sortOutputsBIP69(outputs []*wire.TxOut) {
+	sort.SliceStable(outputs, func(i, j int) bool {
+		if outputs[i].Value != outputs[j].Value {
+			return outputs[i].Value < outputs[j].Value
+		}
+
+		return bytes.Compare(
+			outputs[i].PkScript, outputs[j].PkScript,
+		) < 0
+	})
+}
+
+// prepareSweepTx returns the tx fee, the change outputs and an optional
 // locktime after a series of validations:
 // 1. check the locktime has been reached.
 // 2. check the locktimes are the same.
 // 3. check the inputs cover the outputs.
 //
-// NOTE: if the change amount is below dust, it will be added to the tx fee.
-func prepareSweepTx(inputs []input.Input, changePkScript []byte,
-	feeRate chainfee.SatPerKWeight, currentHeight int32) (
-	btcutil.Amount, fn.Option[btcutil.Amount], fn.Option[int32], error) {
+// The change is paid out to the given scripts as the change policy asks for.
+//
+// NOTE: if the change is dropped, any of the change outputs is below dust, or
+// the change is uneconomical and the policy drops it, the change will be added
+// to the tx fee. With an ephemeral anchor, the tx pays no fee, so its inputs
+// must be fully paid out to their required outputs.
+func prepareSweepTx(inputs []input.Input, changeScripts [][]byte,
+	policy ChangePolicy, feeRate chainfee.SatPerKWeight,
+	currentHeight int32) (btcutil.Amount, []*wire.TxOut,
+	fn.Option[int32], error) {
 
-	noChange := fn.None[btcutil.Amount]()
 	noLocktime := fn.None[int32]()
 
-	// Creating a weight estimator with nil outputs and zero max fee rate.
-	// We don't allow adding customized outputs in the sweeping tx, and the
-	// fee rate is already being managed before we get here.
+	// Creating a weight estimator with the extra change outputs and zero
+	// max fee rate. We don't allow adding customized outputs in the
+	// sweeping tx, and the fee rate is already being managed before we get
+	// here.
+	changePkScript, extraOutputs := policy.weightOutputs(changeScripts)
 	inputs, estimator, err := getWeightEstimate(
-		inputs, nil, feeRate, 0, changePkScript,
+		inputs, extraOutputs, feeRate, 0, changePkScript,
 	)
 	if err != nil {
-		return 0, noChange, noLocktime, err
+		return 0, nil, noLocktime, err
 	}
 
+	// The tx with an ephemeral anchor pays no fee, as the child spending
+	// the anchor pays for both.
 	txFee := estimator.fee()
+	if policy.EphemeralAnchor {
+		txFee = 0
+	}
 
 	var (
 		// Track whether any of the inputs require a certain locktime.
@@ -1279,53 +1534,84 @@ func prepareSweepTx(inputs []input.Input, changePkScript []byte,
 
 		// Check if the lock time has reached
 		if lt > uint32(currentHeight) {
-			return 0, noChange, noLocktime, ErrLocktimeImmature
+			return 0, nil, noLocktime, ErrLocktimeImmature
 		}
 
 		// If another input commits to a different locktime, they
 		// cannot be combined in the same transaction.
 		if locktime != -1 && locktime != int32(lt) {
-			return 0, noChange, noLocktime, ErrLocktimeConflict
+			return 0, nil, noLocktime, ErrLocktimeConflict
 		}
 
 		// Update the locktime for next iteration.
 		locktime = int32(lt)
 	}
 
+	// Any value left after the required outputs would be paid as fee by
+	// the tx with an ephemeral anchor.
+	if policy.EphemeralAnchor && totalInput != requiredOutput {
+		return 0, nil, noLocktime, fmt.Errorf("%w: input_sum=%v, "+
+			"output_sum=%v", ErrInvalidEphemeralAnchor, totalInput,
+			requiredOutput)
+	}
+
 	// Make sure total output amount is less than total input amount.
 	if requiredOutput+txFee > totalInput {
-		return 0, noChange, noLocktime, fmt.Errorf("insufficient "+
+		return 0, nil, noLocktime, fmt.Errorf("insufficient "+
 			"input to create sweep tx: input_sum=%v, "+
 			"output_sum=%v", totalInput, requiredOutput+txFee)
 	}
 
 	// The value remaining after the required output and fees is the
-	// change output.
+	// change, which is paid out to the change outputs.
 	changeAmt := totalInput - requiredOutput - txFee
-	changeAmtOpt := fn.Some(changeAmt)
+	changeOutputs, err := policy.outputs(
+		changeAmt, totalInput-requiredOutput, changeScripts,
+	)
+
+	switch {
+	// If any of the change outputs is dust, we'll move the change into the
+	// fees, unless the change output is mandatory.
+	case errors.Is(err, ErrDustOutput) && !policy.changeMandatory():
+		log.Infof("Change amt %v has outputs below dust, not adding "+
+			"change outputs: %v", changeAmt, err)
+
+		changeOutputs = nil
 
-	// We'll calculate the dust limit for the given changePkScript since it
-	// is variable.
-	changeFloor := lnwallet.DustLimitForSize(len(changePkScript))
+	case err != nil:
+		return 0, nil, noLocktime, err
 
-	// If the change amount is dust, we'll move it into the fees.
-	if changeAmt < changeFloor {
-		log.Infof("Change amt %v below dustlimit %v, not adding "+
-			"change output", changeAmt, changeFloor)
+	// If the change is worth less than the fee needed to spend it later,
+	// we'll move it into the fees when the policy asks for it and the tx
+	// stays valid without it.
+	case policy.DropUneconomical && !policy.EphemeralAnchor &&
+		len(changeOutputs) > 0:
+
+		decision := decideChange(
+			changeAmt, outputsSpendCost(changeOutputs, feeRate),
+			true, requiredOutput > 0 && !policy.changeMandatory(),
+		)
+		if decision.Recommendation == ChangeDrop {
+			log.Infof("Change amt %v below its spend cost %v, not "+
+				"adding change outputs", changeAmt,
+				decision.KeepSpendCost)
 
-		// If there's no required output, and the change output is a
-		// dust, it means we are creating a tx without any outputs. In
-		// this case we'll return an error. This could happen when
-		// creating a tx that has an anchor as the only input.
+			changeOutputs = nil
+		}
+	}
+
+	// Without change outputs, or with the ephemeral anchor taking their
+	// place, the change is added to the fee.
+	if len(changeOutputs) == 0 || policy.EphemeralAnchor {
+		// If there's no required output, it means we are creating a
+		// tx without any outputs. In this case we'll return an error.
+		// This could happen when creating a tx that has an anchor as
+		// the only input.
 		if requiredOutput == 0 {
-			return 0, noChange, noLocktime, ErrTxNoOutput
+			return 0, nil, noLocktime, ErrTxNoOutput
 		}
 
-		// The dust amount is added to the fee.
 		txFee += changeAmt
-
-		// Set the change amount to none.
-		changeAmtOpt = fn.None[btcutil.Amount]()
 	}
 
 	// Optionally set the locktime.
@@ -1341,5 +1627,5 @@ func prepareSweepTx(inputs []input.Input, changePkScript []byte,
 		estimator.weight(), txFee, locktimeOpt, len(estimator.parents),
 		estimator.parentsFee, estimator.parentsWeight, currentHeight)
 
-	return txFee, changeAmtOpt, locktimeOpt, nil
+	return txFee, changeOutputs, locktimeOpt, nil
 }
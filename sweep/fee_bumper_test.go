@@ -1,14 +1,19 @@
 package sweep
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
 	"time"
 
+	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcwallet/wtxmgr"
 	"github.com/lightningnetwork/lnd/chainntnfs"
+	"github.com/lightningnetwork/lnd/fn"
 	"github.com/lightningnetwork/lnd/input"
 	"github.com/lightningnetwork/lnd/lnwallet"
 	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
@@ -80,12 +85,16 @@ func TestCalcSweepTxWeight(t *testing.T) {
 	inp := createTestInput(100, input.WitnessKeyHash)
 
 	// Use a wrong change script to test the error case.
-	weight, err := calcSweepTxWeight([]input.Input{&inp}, []byte{0})
+	weight, err := calcSweepTxWeight(
+		[]input.Input{&inp}, ChangePolicy{}, [][]byte{{0}},
+	)
 	require.Error(t, err)
 	require.Zero(t, weight)
 
 	// Use a correct change script to test the success case.
-	weight, err = calcSweepTxWeight([]input.Input{&inp}, changePkScript)
+	weight, err = calcSweepTxWeight(
+		[]input.Input{&inp}, ChangePolicy{}, [][]byte{changePkScript},
+	)
 	require.NoError(t, err)
 
 	// BaseTxSize 8 bytes
@@ -94,6 +103,16 @@ func TestCalcSweepTxWeight(t *testing.T) {
 	// One P2WKHWitnessSize 2+109 bytes
 	// Total weight = (8+42+44) * 4 + 111 = 487
 	require.EqualValuesf(t, 487, weight, "unexpected weight %v", weight)
+
+	// Each extra change output adds its weight.
+	// One P2TROutputSize 43 bytes
+	// Total weight = 487 + 43 * 4 = 659
+	weight, err = calcSweepTxWeight(
+		[]input.Input{&inp}, ChangePolicy{},
+		[][]byte{changePkScript, changePkScript},
+	)
+	require.NoError(t, err)
+	require.EqualValuesf(t, 659, weight, "unexpected weight %v", weight)
 }
 
 // TestBumpRequestMaxFeeRateAllowed tests the max fee rate allowed for a bump
@@ -105,7 +124,9 @@ func TestBumpRequestMaxFeeRateAllowed(t *testing.T) {
 	inp := createTestInput(100, input.WitnessKeyHash)
 
 	// The weight is 487.
-	weight, err := calcSweepTxWeight([]input.Input{&inp}, changePkScript)
+	weight, err := calcSweepTxWeight(
+		[]input.Input{&inp}, ChangePolicy{}, [][]byte{changePkScript},
+	)
 	require.NoError(t, err)
 
 	// Define a test budget and calculates its fee rate.
@@ -247,6 +268,25 @@ func TestInitializeFeeFunction(t *testing.T) {
 	f, err = tp.initializeFeeFunction(req)
 	require.NoError(t, err)
 	require.Equal(t, feerate, f.FeeRate())
+
+	// When the request has a fee rate bumper, it drives the fee rate
+	// instead of the fee estimator.
+	set := &BudgetInputSet{
+		deadlineHeight: req.DeadlineHeight,
+		cfg: BudgetInputSetConfig{
+			FeeBumpPolicy: &exponentialFeeBumpPolicy{
+				maxFeeRate: feerate * 5,
+			},
+		},
+	}
+	set.SetFeeRate(feerate)
+	req.FeeRateBumper = set
+	req.Budget = btcutil.Amount(100_000)
+
+	f, err = tp.initializeFeeFunction(req)
+	require.NoError(t, err)
+	require.IsType(t, &bumperFeeFunction{}, f)
+	require.Equal(t, feerate*2, f.FeeRate())
 }
 
 // TestStoreRecord correctly increases the request counter and saves the
@@ -427,6 +467,705 @@ func TestCreateAndCheckTx(t *testing.T) {
 	}
 }
 
+// TestCreateSweepTxPreserveInputOrder checks that `createSweepTx` keeps the
+// given input order when asked to.
+func TestCreateSweepTxPreserveInputOrder(t *testing.T) {
+	t.Parallel()
+
+	// Create a regular input and one with a required output.
+	regular := createTestInput(100_000, input.WitnessKeyHash)
+	reqTxOut := &wire.TxOut{
+		Value:    10_000,
+		PkScript: changePkScript,
+	}
+	required := &reqInput{
+		Input: createP2WKHInput(10_000),
+		txOut: reqTxOut,
+	}
+	inputs := []input.Input{&regular, required}
+
+	// Create a publisher using the mocks.
+	tp, m := createTestPublisher(t)
+
+	// Mock the signer to always return a valid script.
+	script := &input.Script{}
+	m.signer.On("ComputeInputScript", mock.Anything,
+		mock.Anything).Return(script, nil)
+
+	feeRate := chainfee.SatPerKWeight(1000)
+
+	// By default, the input with the required output is placed first.
+	tx, _, err := tp.createSweepTx(
+		inputs, changePkScript, feeRate, sweepTxOptions{
+			version:     defaultTxVersion,
+			replaceable: true,
+		},
+	)
+	require.NoError(t, err)
+	require.Len(t, tx.TxIn, 2)
+	require.Equal(t, required.OutPoint(), tx.TxIn[0].PreviousOutPoint)
+	require.Equal(t, regular.OutPoint(), tx.TxIn[1].PreviousOutPoint)
+	require.Equal(t, reqTxOut, tx.TxOut[0])
+
+	// When the order is preserved, the inputs are added as given.
+	tx, _, err = tp.createSweepTx(
+		inputs, changePkScript, feeRate, sweepTxOptions{
+			version:       defaultTxVersion,
+			replaceable:   true,
+			preserveOrder: true,
+		},
+	)
+	require.NoError(t, err)
+	require.Len(t, tx.TxIn, 2)
+	require.Equal(t, regular.OutPoint(), tx.TxIn[0].PreviousOutPoint)
+	require.Equal(t, required.OutPoint(), tx.TxIn[1].PreviousOutPoint)
+	require.Equal(t, reqTxOut, tx.TxOut[0])
+	require.Equal(t, defaultTxVersion, tx.Version)
+
+	// The tx is created with the given version.
+	tx, _, err = tp.createSweepTx(
+		inputs, changePkScript, feeRate, sweepTxOptions{
+			version:       trucTxVersion,
+			replaceable:   true,
+			preserveOrder: true,
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(t, trucTxVersion, tx.Version)
+
+	// The tx is created with the given lock time.
+	tx, _, err = tp.createSweepTx(
+		inputs, changePkScript, feeRate, sweepTxOptions{
+			version:       defaultTxVersion,
+			lockTime:      fn.Some(uint32(123)),
+			replaceable:   true,
+			preserveOrder: true,
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(t, uint32(123), tx.LockTime)
+}
+
+// TestCreateSweepTxSortOutputs checks that `createSweepTx` sorts the outputs
+// per BIP69 when asked to, unless an input commits to the position of its
+// required output.
+func TestCreateSweepTxSortOutputs(t *testing.T) {
+	t.Parallel()
+
+	// Create a regular input and one with a required output larger than
+	// the change.
+	regular := createTestInput(100_000, input.WitnessKeyHash)
+	reqTxOut := &wire.TxOut{
+		Value:    200_000,
+		PkScript: changePkScript,
+	}
+	required := &reqInput{
+		Input: createP2WKHInput(200_000),
+		txOut: reqTxOut,
+	}
+	inputs := []input.Input{&regular, required}
+
+	// Create a publisher using the mocks.
+	tp, m := createTestPublisher(t)
+
+	// Mock the signer to always return a valid script.
+	script := &input.Script{}
+	m.signer.On("ComputeInputScript", mock.Anything,
+		mock.Anything).Return(script, nil)
+
+	feeRate := chainfee.SatPerKWeight(1000)
+
+	// By default, the required output is placed first, followed by the
+	// change output.
+	tx, _, err := tp.createSweepTx(
+		inputs, changePkScript, feeRate, sweepTxOptions{
+			version:     defaultTxVersion,
+			replaceable: true,
+		},
+	)
+	require.NoError(t, err)
+	require.Len(t, tx.TxOut, 2)
+	require.Equal(t, reqTxOut, tx.TxOut[0])
+
+	// When sorted, the smaller change output is placed first.
+	tx, _, err = tp.createSweepTx(
+		inputs, changePkScript, feeRate, sweepTxOptions{
+			version:     defaultTxVersion,
+			replaceable: true,
+			sortOutputs: true,
+		},
+	)
+	require.NoError(t, err)
+	require.Len(t, tx.TxOut, 2)
+	require.Less(t, tx.TxOut[0].Value, tx.TxOut[1].Value)
+	require.Equal(t, reqTxOut, tx.TxOut[1])
+
+	// When the required output is committed to via SIGHASH_SINGLE, the
+	// outputs are not sorted.
+	required.SignDesc().HashType = txscript.SigHashSingle |
+		txscript.SigHashAnyOneCanPay
+	tx, _, err = tp.createSweepTx(
+		inputs, changePkScript, feeRate, sweepTxOptions{
+			version:     defaultTxVersion,
+			replaceable: true,
+			sortOutputs: true,
+		},
+	)
+	require.NoError(t, err)
+	require.Len(t, tx.TxOut, 2)
+	require.Equal(t, reqTxOut, tx.TxOut[0])
+}
+
+// TestCreateSweepTxChangeDistribution checks that `createSweepTx` splits the
+// change across the accounts of the change policy.
+func TestCreateSweepTxChangeDistribution(t *testing.T) {
+	t.Parallel()
+
+	inp := createTestInput(100_000, input.WitnessKeyHash)
+	inputs := []input.Input{&inp}
+
+	// Create a publisher using the mocks.
+	tp, m := createTestPublisher(t)
+
+	// Mock the signer to always return a valid script.
+	script := &input.Script{}
+	m.signer.On("ComputeInputScript", mock.Anything,
+		mock.Anything).Return(script, nil)
+
+	// Create a second taproot change script for the other account.
+	otherPkScript := make([]byte, len(changePkScript))
+	copy(otherPkScript, changePkScript)
+	otherPkScript[2] = 1
+
+	feeRate := chainfee.SatPerKWeight(1000)
+	opts := sweepTxOptions{
+		version:     defaultTxVersion,
+		replaceable: true,
+		changePolicy: ChangePolicy{
+			Distribution: map[string]float64{
+				"a": 0.25,
+				"b": 0.75,
+			},
+		},
+		changeScripts: [][]byte{changePkScript, otherPkScript},
+	}
+
+	// The change is split across the accounts sorted by name, with the
+	// rounding remainder going to the last one.
+	tx, fee, err := tp.createSweepTx(inputs, nil, feeRate, opts)
+	require.NoError(t, err)
+	require.Len(t, tx.TxOut, 2)
+
+	change := 100_000 - fee
+	share := btcutil.Amount(float64(change) * 0.25)
+	require.EqualValues(t, share, tx.TxOut[0].Value)
+	require.Equal(t, changePkScript, tx.TxOut[0].PkScript)
+	require.EqualValues(t, change-share, tx.TxOut[1].Value)
+	require.Equal(t, otherPkScript, tx.TxOut[1].PkScript)
+
+	// The fee pays for both change outputs.
+	weight, err := calcSweepTxWeight(
+		inputs, opts.changePolicy, opts.changeScripts,
+	)
+	require.NoError(t, err)
+	require.Equal(t, feeRate.FeeForWeight(int64(weight)), fee)
+
+	// A script must be given for each change output.
+	opts.changeScripts = [][]byte{changePkScript}
+	_, _, err = tp.createSweepTx(inputs, nil, feeRate, opts)
+	require.ErrorContains(t, err, "change scripts")
+
+	// When the share of an account is dust, the change is moved into the
+	// fees, which leaves the tx without outputs.
+	opts.changeScripts = [][]byte{changePkScript, otherPkScript}
+	opts.changePolicy.Distribution = map[string]float64{
+		"a": 0.001,
+		"b": 0.999,
+	}
+	_, _, err = tp.createSweepTx(inputs, nil, feeRate, opts)
+	require.ErrorIs(t, err, ErrTxNoOutput)
+}
+
+// TestCreateSweepTxMaxChangeValue checks that `createSweepTx` splits the change
+// into outputs no larger than the max change value of the change policy.
+func TestCreateSweepTxMaxChangeValue(t *testing.T) {
+	t.Parallel()
+
+	inp := createTestInput(100_000, input.WitnessKeyHash)
+	inputs := []input.Input{&inp}
+
+	// Create a publisher using the mocks.
+	tp, m := createTestPublisher(t)
+
+	// Mock the signer to always return a valid script.
+	script := &input.Script{}
+	m.signer.On("ComputeInputScript", mock.Anything,
+		mock.Anything).Return(script, nil)
+
+	// The 100k sats available are split into three outputs.
+	policy := ChangePolicy{MaxChangeValue: 40_000}
+	require.Equal(t, []string{"", "", ""}, policy.outputAccounts(100_000))
+
+	feeRate := chainfee.SatPerKWeight(1000)
+	opts := sweepTxOptions{
+		version:      defaultTxVersion,
+		replaceable:  true,
+		changePolicy: policy,
+		changeScripts: [][]byte{
+			changePkScript, changePkScript, changePkScript,
+		},
+	}
+
+	tx, fee, err := tp.createSweepTx(inputs, nil, feeRate, opts)
+	require.NoError(t, err)
+	require.Len(t, tx.TxOut, 3)
+
+	// The change is split evenly and none of the outputs exceeds the max.
+	var total int64
+	for _, txOut := range tx.TxOut {
+		require.LessOrEqual(t, txOut.Value, int64(40_000))
+		require.InDelta(t, tx.TxOut[0].Value, txOut.Value, 1)
+		total += txOut.Value
+	}
+	require.EqualValues(t, 100_000-fee, total)
+
+	// The fee pays for all the change outputs.
+	weight, err := calcSweepTxWeight(
+		inputs, opts.changePolicy, opts.changeScripts,
+	)
+	require.NoError(t, err)
+	require.Equal(t, feeRate.FeeForWeight(int64(weight)), fee)
+}
+
+// TestCreateSweepTxCpfpAnchor checks that `createSweepTx` keeps the change as a
+// CPFP anchor of at least the min change value of the change policy.
+func TestCreateSweepTxCpfpAnchor(t *testing.T) {
+	t.Parallel()
+
+	// Create a regular input and one with a required output, so the tx
+	// would stay valid without its change.
+	regular := createTestInput(100_000, input.WitnessKeyHash)
+	reqTxOut := &wire.TxOut{
+		Value:    10_000,
+		PkScript: changePkScript,
+	}
+	required := &reqInput{
+		Input: createP2WKHInput(10_000),
+		txOut: reqTxOut,
+	}
+	inputs := []input.Input{&regular, required}
+
+	// Create a publisher using the mocks.
+	tp, m := createTestPublisher(t)
+
+	// Mock the signer to always return a valid script.
+	script := &input.Script{}
+	m.signer.On("ComputeInputScript", mock.Anything,
+		mock.Anything).Return(script, nil)
+
+	feeRate := chainfee.SatPerKWeight(1000)
+	opts := sweepTxOptions{
+		version:      defaultTxVersion,
+		replaceable:  true,
+		changePolicy: ChangePolicy{MinChange: 50_000},
+	}
+
+	// The change output is above the min value.
+	tx, fee, err := tp.createSweepTx(inputs, changePkScript, feeRate, opts)
+	require.NoError(t, err)
+	require.Len(t, tx.TxOut, 2)
+	require.Equal(t, reqTxOut, tx.TxOut[0])
+	require.EqualValues(t, 100_000-fee, tx.TxOut[1].Value)
+	require.GreaterOrEqual(t, tx.TxOut[1].Value, int64(50_000))
+
+	// When the change is below the min value, it's not given up to the
+	// fees as the anchor is mandatory.
+	opts.changePolicy.MinChange = 100_000
+	_, _, err = tp.createSweepTx(inputs, changePkScript, feeRate, opts)
+	require.ErrorIs(t, err, ErrChangeBelowMin)
+}
+
+// TestCreateSweepTxDropChange checks that `createSweepTx` gives the change up to
+// the fees when the change policy drops it.
+func TestCreateSweepTxDropChange(t *testing.T) {
+	t.Parallel()
+
+	// Create a regular input and one with a required output, so the tx
+	// stays valid without its change.
+	regular := createTestInput(100_000, input.WitnessKeyHash)
+	reqTxOut := &wire.TxOut{
+		Value:    10_000,
+		PkScript: changePkScript,
+	}
+	required := &reqInput{
+		Input: createP2WKHInput(10_000),
+		txOut: reqTxOut,
+	}
+	inputs := []input.Input{&regular, required}
+
+	// Create a publisher using the mocks.
+	tp, m := createTestPublisher(t)
+
+	// Mock the signer to always return a valid script.
+	script := &input.Script{}
+	m.signer.On("ComputeInputScript", mock.Anything,
+		mock.Anything).Return(script, nil)
+
+	feeRate := chainfee.SatPerKWeight(1000)
+	opts := sweepTxOptions{
+		version:      defaultTxVersion,
+		replaceable:  true,
+		changePolicy: ChangePolicy{Drop: true},
+	}
+
+	// The tx only has the required output and the change is paid as fee.
+	tx, fee, err := tp.createSweepTx(inputs, changePkScript, feeRate, opts)
+	require.NoError(t, err)
+	require.Len(t, tx.TxOut, 1)
+	require.Equal(t, reqTxOut, tx.TxOut[0])
+	require.EqualValues(t, 100_000, fee)
+
+	// The weight used to cap the fee rate doesn't count a change output.
+	weight, err := calcSweepTxWeight(inputs, opts.changePolicy, nil)
+	require.NoError(t, err)
+
+	withChange, err := calcSweepTxWeight(
+		inputs, ChangePolicy{}, [][]byte{changePkScript},
+	)
+	require.NoError(t, err)
+	require.Less(t, weight, withChange)
+
+	req := &BumpRequest{
+		Inputs:          inputs,
+		Budget:          1000,
+		DeliveryAddress: changePkScript,
+		MaxFeeRate:      chainfee.SatPerKWeight(1_000_000),
+		ChangePolicy:    ChangePolicy{Drop: true},
+	}
+	maxFeeRate, err := req.MaxFeeRateAllowed()
+	require.NoError(t, err)
+	require.Equal(t, chainfee.NewSatPerKWeight(1000, weight), maxFeeRate)
+
+	// Without a required output, dropping the change leaves the tx
+	// without any outputs.
+	_, _, err = tp.createSweepTx(
+		[]input.Input{&regular}, changePkScript, feeRate, opts,
+	)
+	require.ErrorIs(t, err, ErrTxNoOutput)
+}
+
+// TestCreateSweepTxEphemeralAnchor checks that `createSweepTx` adds the
+// ephemeral anchor in place of the change output when the change policy asks
+// for it, and that the tx is a v3 tx paying no fee.
+func TestCreateSweepTxEphemeralAnchor(t *testing.T) {
+	t.Parallel()
+
+	// Create an input whose value is fully paid out to its required
+	// output, so the tx has a value carrying output next to the anchor.
+	reqTxOut := &wire.TxOut{
+		Value:    10_000,
+		PkScript: changePkScript,
+	}
+	required := &reqInput{
+		Input: createP2WKHInput(10_000),
+		txOut: reqTxOut,
+	}
+	inputs := []input.Input{required}
+
+	// Create a publisher using the mocks.
+	tp, m := createTestPublisher(t)
+
+	// Mock the signer to always return a valid script.
+	script := &input.Script{}
+	m.signer.On("ComputeInputScript", mock.Anything,
+		mock.Anything).Return(script, nil)
+
+	feeRate := chainfee.SatPerKWeight(1000)
+	opts := sweepTxOptions{
+		version:      trucTxVersion,
+		replaceable:  true,
+		changePolicy: ChangePolicy{EphemeralAnchor: true},
+	}
+
+	// The anchor takes the place of the change, and the tx pays no fee.
+	tx, fee, err := tp.createSweepTx(inputs, changePkScript, feeRate, opts)
+	require.NoError(t, err)
+	require.Equal(t, trucTxVersion, tx.Version)
+	require.Len(t, tx.TxOut, 2)
+	require.Equal(t, reqTxOut, tx.TxOut[0])
+	require.Equal(t, ephemeralAnchorOutput(), tx.TxOut[1])
+	require.Zero(t, fee)
+
+	// The weight of the anchor is accounted for instead of the change.
+	weight, err := calcSweepTxWeight(inputs, opts.changePolicy, nil)
+	require.NoError(t, err)
+
+	noChange, err := calcSweepTxWeight(
+		inputs, ChangePolicy{Drop: true}, nil,
+	)
+	require.NoError(t, err)
+
+	anchorWeight := (8 + 1 + len(ephemeralAnchorScript)) *
+		blockchain.WitnessScaleFactor
+	require.EqualValues(t, noChange+uint64(anchorWeight), weight)
+
+	// An input whose value isn't fully paid out to a required output
+	// would make the tx pay a fee.
+	regular := createTestInput(100_000, input.WitnessKeyHash)
+	_, _, err = tp.createSweepTx(
+		[]input.Input{&regular, required}, changePkScript, feeRate,
+		opts,
+	)
+	require.ErrorIs(t, err, ErrInvalidEphemeralAnchor)
+
+	// The anchor is only relayed in a v3 tx.
+	opts.version = defaultTxVersion
+	_, _, err = tp.createSweepTx(inputs, changePkScript, feeRate, opts)
+	require.ErrorIs(t, err, ErrInvalidEphemeralAnchor)
+}
+
+// TestCreateAndCheckTxEphemeralAnchor checks that the tx with an ephemeral
+// anchor handed to the mempool acceptance check is a zero-fee v3 tx, as the
+// anchor is dust.
+func TestCreateAndCheckTxEphemeralAnchor(t *testing.T) {
+	t.Parallel()
+
+	required := &reqInput{
+		Input: createP2WKHInput(10_000),
+		txOut: &wire.TxOut{
+			Value:    10_000,
+			PkScript: changePkScript,
+		},
+	}
+
+	// Create a publisher using the mocks.
+	tp, m := createTestPublisher(t)
+
+	feeRate := chainfee.SatPerKWeight(1000)
+	m.feeFunc.On("FeeRate").Return(feeRate)
+
+	// Mock the signer to always return a valid script.
+	script := &input.Script{}
+	m.signer.On("ComputeInputScript", mock.Anything,
+		mock.Anything).Return(script, nil)
+
+	// The tx checked must be a v3 tx whose outputs are worth as much as
+	// its inputs, with the zero-value anchor as its last output.
+	isZeroFeeTruc := func(tx *wire.MsgTx) bool {
+		var outputSum int64
+		for _, txOut := range tx.TxOut {
+			outputSum += txOut.Value
+		}
+
+		anchor := tx.TxOut[len(tx.TxOut)-1]
+
+		return tx.Version == trucTxVersion &&
+			outputSum == required.SignDesc().Output.Value &&
+			anchor.Value == 0 &&
+			bytes.Equal(anchor.PkScript, ephemeralAnchorScript)
+	}
+	m.wallet.On("CheckMempoolAcceptance",
+		mock.MatchedBy(isZeroFeeTruc)).Return(nil).Once()
+
+	req := &BumpRequest{
+		DeliveryAddress: changePkScript,
+		Inputs:          []input.Input{required},
+		Budget:          1_000,
+		TxVersion:       trucTxVersion,
+		ChangePolicy:    ChangePolicy{EphemeralAnchor: true},
+	}
+
+	tx, fee, err := tp.createAndCheckTx(req, m.feeFunc)
+	require.NoError(t, err)
+	require.Zero(t, fee)
+	require.True(t, isZeroFeeTruc(tx))
+
+	// A v2 request never reaches the mempool acceptance check.
+	req.TxVersion = defaultTxVersion
+	_, _, err = tp.createAndCheckTx(req, m.feeFunc)
+	require.ErrorIs(t, err, ErrInvalidEphemeralAnchor)
+}
+
+// TestCreateSweepTxTargetOutputCount checks that `createSweepTx` splits the
+// change evenly into the target output count of the change policy.
+func TestCreateSweepTxTargetOutputCount(t *testing.T) {
+	t.Parallel()
+
+	inp := createTestInput(100_000, input.WitnessKeyHash)
+	inputs := []input.Input{&inp}
+
+	// Create a publisher using the mocks.
+	tp, m := createTestPublisher(t)
+
+	// Mock the signer to always return a valid script.
+	script := &input.Script{}
+	m.signer.On("ComputeInputScript", mock.Anything,
+		mock.Anything).Return(script, nil)
+
+	// The change goes to four outputs of the default account regardless of
+	// its value.
+	policy := ChangePolicy{TargetOutputCount: 4}
+	require.Len(t, policy.outputAccounts(100_000), 4)
+	require.Len(t, policy.outputAccounts(1_000_000), 4)
+
+	feeRate := chainfee.SatPerKWeight(1000)
+	opts := sweepTxOptions{
+		version:      defaultTxVersion,
+		replaceable:  true,
+		changePolicy: policy,
+		changeScripts: [][]byte{
+			changePkScript, changePkScript, changePkScript,
+			changePkScript,
+		},
+	}
+
+	tx, fee, err := tp.createSweepTx(inputs, nil, feeRate, opts)
+	require.NoError(t, err)
+	require.Len(t, tx.TxOut, 4)
+
+	var total int64
+	for _, txOut := range tx.TxOut {
+		require.InDelta(t, tx.TxOut[0].Value, txOut.Value, 1)
+		total += txOut.Value
+	}
+	require.EqualValues(t, 100_000-fee, total)
+
+	// When the outputs would be dust, the change is mandatory so it's not
+	// given up to the fees.
+	opts.changePolicy.TargetOutputCount = 400
+	opts.changeScripts = make([][]byte, 400)
+	for i := range opts.changeScripts {
+		opts.changeScripts[i] = changePkScript
+	}
+	_, _, err = tp.createSweepTx(inputs, nil, feeRate, opts)
+	require.ErrorIs(t, err, ErrDustOutput)
+}
+
+// TestCreateSweepTxDropUneconomicalChange checks that `createSweepTx` gives the
+// change up to the fees when it's worth less than its spend cost and the
+// change policy asks for it.
+func TestCreateSweepTxDropUneconomicalChange(t *testing.T) {
+	t.Parallel()
+
+	// Create an input with a required output, so the tx stays valid
+	// without its change.
+	reqTxOut := &wire.TxOut{
+		Value:    10_000,
+		PkScript: changePkScript,
+	}
+	required := &reqInput{
+		Input: createP2WKHInput(10_000),
+		txOut: reqTxOut,
+	}
+
+	// Create a publisher using the mocks.
+	tp, m := createTestPublisher(t)
+
+	// Mock the signer to always return a valid script.
+	script := &input.Script{}
+	m.signer.On("ComputeInputScript", mock.Anything,
+		mock.Anything).Return(script, nil)
+
+	feeRate := chainfee.SatPerKWeight(10_000)
+	spendCost, err := changeSpendCost(lnwallet.TaprootPubkey, feeRate)
+	require.NoError(t, err)
+
+	opts := sweepTxOptions{
+		version:     defaultTxVersion,
+		replaceable: true,
+		changePolicy: ChangePolicy{
+			DropUneconomical: true,
+		},
+	}
+
+	// A change worth more than its spend cost is kept.
+	regular := createTestInput(100_000, input.WitnessKeyHash)
+	inputs := []input.Input{&regular, required}
+	tx, fee, err := tp.createSweepTx(inputs, changePkScript, feeRate, opts)
+	require.NoError(t, err)
+	require.Len(t, tx.TxOut, 2)
+	require.Greater(t, btcutil.Amount(tx.TxOut[1].Value), spendCost)
+
+	// Leave a change above dust but below its spend cost.
+	change := spendCost / 2
+	require.Greater(t, change, lnwallet.DustLimitForSize(len(changePkScript)))
+
+	small := createTestInput(int64(fee+change), input.WitnessKeyHash)
+	inputs = []input.Input{&small, required}
+
+	// Without the option, the change is kept.
+	opts.changePolicy.DropUneconomical = false
+	tx, _, err = tp.createSweepTx(inputs, changePkScript, feeRate, opts)
+	require.NoError(t, err)
+	require.Len(t, tx.TxOut, 2)
+	require.EqualValues(t, change, tx.TxOut[1].Value)
+
+	// With the option, the change is given up to the fees.
+	opts.changePolicy.DropUneconomical = true
+	tx, dropFee, err := tp.createSweepTx(
+		inputs, changePkScript, feeRate, opts,
+	)
+	require.NoError(t, err)
+	require.Len(t, tx.TxOut, 1)
+	require.Equal(t, reqTxOut, tx.TxOut[0])
+	require.Equal(t, fee+change, dropFee)
+
+	// The change is kept when the tx would have no output without it.
+	tx, _, err = tp.createSweepTx(
+		[]input.Input{&small}, changePkScript, feeRate, opts,
+	)
+	require.NoError(t, err)
+	require.Len(t, tx.TxOut, 1)
+}
+
+// TestCreateSweepTxChangeType checks that `createSweepTx` only pays the change
+// to scripts of the change type of the change policy.
+func TestCreateSweepTxChangeType(t *testing.T) {
+	t.Parallel()
+
+	inp := createTestInput(100_000, input.WitnessKeyHash)
+	inputs := []input.Input{&inp}
+
+	// Create a publisher using the mocks.
+	tp, m := createTestPublisher(t)
+
+	// Mock the signer to always return a valid script.
+	script := &input.Script{}
+	m.signer.On("ComputeInputScript", mock.Anything,
+		mock.Anything).Return(script, nil)
+
+	// Create a p2wkh change script.
+	p2wkhScript := make([]byte, input.P2WPKHSize)
+	p2wkhScript[0], p2wkhScript[1] = txscript.OP_0, txscript.OP_DATA_20
+
+	feeRate := chainfee.SatPerKWeight(1000)
+	opts := sweepTxOptions{
+		version:     defaultTxVersion,
+		replaceable: true,
+		changePolicy: ChangePolicy{
+			ChangeType: lnwallet.WitnessPubKey,
+		},
+		changeScripts: [][]byte{p2wkhScript},
+	}
+
+	// The change pays to the p2wkh script, whose weight is accounted for.
+	tx, fee, err := tp.createSweepTx(inputs, nil, feeRate, opts)
+	require.NoError(t, err)
+	require.Len(t, tx.TxOut, 1)
+	require.Equal(t, p2wkhScript, tx.TxOut[0].PkScript)
+
+	weight, err := calcSweepTxWeight(
+		inputs, opts.changePolicy, opts.changeScripts,
+	)
+	require.NoError(t, err)
+	require.Equal(t, feeRate.FeeForWeight(int64(weight)), fee)
+
+	// A change script of another type is rejected.
+	opts.changeScripts = [][]byte{changePkScript}
+	_, _, err = tp.createSweepTx(inputs, nil, feeRate, opts)
+	require.ErrorIs(t, err, ErrUnsupportedChangeType)
+}
+
 // createTestBumpRequest creates a new bump request.
 func createTestBumpRequest() *BumpRequest {
 	// Create a test input.
@@ -600,6 +1339,55 @@ func TestCreateRBFCompliantTx(t *testing.T) {
 	}
 }
 
+// TestCreateRBFCompliantTxReserveChange checks that the change output of the
+// created tx is leased when the request carries a change reserver.
+func TestCreateRBFCompliantTxReserveChange(t *testing.T) {
+	t.Parallel()
+
+	// Create a publisher using the mocks.
+	tp, m := createTestPublisher(t)
+
+	// Create a bump request whose set reserves the change.
+	inp := createTestInput(100_000, input.WitnessKeyHash)
+	set := &BudgetInputSet{
+		inputs: []*SweeperInput{{
+			Input:  &inp,
+			params: Params{Budget: 10_000},
+		}},
+	}
+	leaser := newMockOutputLeaser()
+	set.cfg.ChangeReservation = fn.Some(ChangeReservation{
+		ID:       wtxmgr.LockID{1},
+		Duration: time.Hour,
+		Leaser:   leaser,
+	})
+
+	req := &BumpRequest{
+		DeliveryAddress: changePkScript,
+		Inputs:          []input.Input{&inp},
+		Budget:          btcutil.Amount(10_000),
+		ChangeReserver:  set,
+	}
+
+	feerate := chainfee.SatPerKWeight(1000)
+	m.feeFunc.On("FeeRate").Return(feerate)
+	m.signer.On("ComputeInputScript", mock.Anything,
+		mock.Anything).Return(&input.Script{}, nil)
+	m.wallet.On("CheckMempoolAcceptance", mock.Anything).Return(nil).Once()
+
+	id, err := tp.createRBFCompliantTx(req, m.feeFunc)
+	require.NoError(t, err)
+
+	// The change output of the stored tx is leased.
+	record, ok := tp.records.Load(id)
+	require.True(t, ok)
+	require.Len(t, record.tx.TxOut, 1)
+
+	changeOp := wire.OutPoint{Hash: record.tx.TxHash()}
+	require.Equal(t, map[wire.OutPoint]struct{}{changeOp: {}},
+		leaser.leasedOutputs)
+}
+
 // TestTxPublisherBroadcast checks the internal `broadcast` method behaves as
 // expected.
 func TestTxPublisherBroadcast(t *testing.T) {
@@ -1140,6 +1928,58 @@ func TestCreateAnPublishSuccess(t *testing.T) {
 	require.True(t, found)
 }
 
+// TestCreateAnPublishMinRBFFee checks that a replacement paying less than the
+// min RBF fee given by the set of the request isn't published.
+func TestCreateAnPublishMinRBFFee(t *testing.T) {
+	t.Parallel()
+
+	// Create a publisher using the mocks.
+	tp, m := createTestPublisher(t)
+
+	// Create a test requestID.
+	requestID := uint64(1)
+
+	// Create a test feerate and return it from the mock fee function.
+	feerate := chainfee.SatPerKWeight(1000)
+	m.feeFunc.On("FeeRate").Return(feerate)
+
+	// Create a testing monitor record whose set gives the min RBF fee.
+	req := createTestBumpRequest()
+	req.RBFFeeCalculator = &BudgetInputSet{
+		inputs: []*SweeperInput{{Input: req.Inputs[0]}},
+	}
+
+	// The old tx pays the whole budget, which the replacement can't
+	// exceed.
+	record := &monitorRecord{
+		req:         req,
+		feeFunction: m.feeFunc,
+		tx:          &wire.MsgTx{},
+		fee:         req.Budget,
+	}
+
+	script := &input.Script{}
+	m.signer.On("ComputeInputScript", mock.Anything,
+		mock.Anything).Return(script, nil)
+	m.wallet.On("CheckMempoolAcceptance", mock.Anything).Return(nil)
+
+	// The replacement is skipped without being published.
+	resultOpt := tp.createAndPublishTx(requestID, record)
+	require.True(t, resultOpt.IsNone())
+	m.wallet.AssertNotCalled(t, "PublishTransaction", mock.Anything,
+		mock.Anything)
+
+	// Once the old fee leaves room for the increment, it's published.
+	record.fee = 0
+	m.wallet.On("PublishTransaction",
+		mock.Anything, mock.Anything).Return(nil).Once()
+
+	resultOpt = tp.createAndPublishTx(requestID, record)
+	result := resultOpt.UnwrapOrFail(t)
+	require.Equal(t, TxReplaced, result.Event)
+	require.GreaterOrEqual(t, result.Fee, req.RBFFeeCalculator.NextRBFFee(0))
+}
+
 // TestHandleTxConfirmed checks the expected result is returned from the method
 // handleTxConfirmed.
 func TestHandleTxConfirmed(t *testing.T) {
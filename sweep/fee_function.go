@@ -306,3 +306,125 @@ func (l *LinearFeeFunction) estimateFeeRate(
 
 	return estimatedFeeRate, nil
 }
+
+// FeeRateBumper escalates the fee rate of a sweep as its deadline approaches.
+// It's implemented by BudgetInputSet, so the fee bump policy of a set can
+// drive the fee rate of its sweeping tx.
+type FeeRateBumper interface {
+	// FeeRate returns the current fee rate, which is zero if it hasn't
+	// been bumped yet.
+	FeeRate() chainfee.SatPerKWeight
+
+	// BumpFeeRate raises the fee rate to the next one at the given
+	// current height, and returns it.
+	BumpFeeRate(currentHeight int32) (chainfee.SatPerKWeight, error)
+}
+
+// bumperFeeFunction implements the FeeFunction interface using a
+// FeeRateBumper, with the fee rate capped at the max fee rate.
+type bumperFeeFunction struct {
+	// bumper decides the fee rate at each step.
+	bumper FeeRateBumper
+
+	// maxFeeRate is the max fee rate allowed.
+	maxFeeRate chainfee.SatPerKWeight
+
+	// deadlineHeight is the height at which the tx should be confirmed,
+	// used to map a conf target to a height.
+	deadlineHeight int32
+
+	// height is the height of the last bump.
+	height int32
+
+	// currentFeeRate is the current fee rate, capped at the max fee rate.
+	currentFeeRate chainfee.SatPerKWeight
+}
+
+// Compile-time check to ensure bumperFeeFunction satisfies the FeeFunction.
+var _ FeeFunction = (*bumperFeeFunction)(nil)
+
+// newBumperFeeFunction creates a fee function driven by the given bumper,
+// starting at the fee rate it gives at the current height.
+func newBumperFeeFunction(bumper FeeRateBumper,
+	maxFeeRate chainfee.SatPerKWeight, deadlineHeight,
+	currentHeight int32) (*bumperFeeFunction, error) {
+
+	b := &bumperFeeFunction{
+		bumper:         bumper,
+		maxFeeRate:     maxFeeRate,
+		deadlineHeight: deadlineHeight,
+		height:         currentHeight,
+	}
+
+	if _, err := b.bump(); err != nil {
+		return nil, fmt.Errorf("init fee rate: %w", err)
+	}
+
+	return b, nil
+}
+
+// FeeRate returns the current fee rate.
+//
+// NOTE: part of the FeeFunction interface.
+func (b *bumperFeeFunction) FeeRate() chainfee.SatPerKWeight {
+	return b.currentFeeRate
+}
+
+// Increment asks the bumper for the next fee rate at the height of the last
+// bump. As the caller retries until the fee rate is increased, ErrMaxPosition
+// is returned if the bumper doesn't raise it.
+//
+// NOTE: part of the FeeFunction interface.
+func (b *bumperFeeFunction) Increment() (bool, error) {
+	increased, err := b.bump()
+	if err != nil {
+		return false, err
+	}
+
+	if !increased {
+		return false, ErrMaxPosition
+	}
+
+	return true, nil
+}
+
+// IncreaseFeeRate asks the bumper for the next fee rate at the height given by
+// the conf target. The fee rate is not increased if the height hasn't moved
+// past the one of the last bump.
+//
+// NOTE: part of the FeeFunction interface.
+func (b *bumperFeeFunction) IncreaseFeeRate(confTarget uint32) (bool, error) {
+	height := b.deadlineHeight - int32(confTarget)
+	if height <= b.height {
+		log.Tracef("Skipped increase feerate: height=%v, "+
+			"newHeight=%v", b.height, height)
+
+		return false, nil
+	}
+
+	b.height = height
+
+	return b.bump()
+}
+
+// bump raises the fee rate to the next one given by the bumper at the height
+// of the last bump, capped at the max fee rate. ErrMaxPosition is returned if
+// the max fee rate has already been reached.
+func (b *bumperFeeFunction) bump() (bool, error) {
+	if b.currentFeeRate >= b.maxFeeRate {
+		return false, ErrMaxPosition
+	}
+
+	feeRate, err := b.bumper.BumpFeeRate(b.height)
+	if err != nil {
+		return false, err
+	}
+
+	oldFeeRate := b.currentFeeRate
+	b.currentFeeRate = min(feeRate, b.maxFeeRate)
+
+	log.Tracef("Fee rate increased from %v to %v at height %v",
+		oldFeeRate, b.currentFeeRate, b.height)
+
+	return b.currentFeeRate > oldFeeRate, nil
+}
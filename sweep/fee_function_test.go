@@ -307,3 +307,58 @@ func TestLinearFeeFunctionIncreaseFeeRate(t *testing.T) {
 	rt.Equal(confTarget, f.position)
 	rt.Equal(maxFeeRate, f.currentFeeRate)
 }
+
+// TestBumperFeeFunction checks that the fee function driven by a bumper uses
+// the fee rates given by the bumper, capped at the max fee rate.
+func TestBumperFeeFunction(t *testing.T) {
+	t.Parallel()
+
+	rt := require.New(t)
+
+	const maxFeeRate = chainfee.SatPerKWeight(6_000)
+	deadline := testHeight + 10
+
+	// Use a set doubling its fee rate on each bump.
+	set := &BudgetInputSet{deadlineHeight: deadline}
+	set.SetFeeRate(1_000)
+	set.cfg.FeeBumpPolicy = &exponentialFeeBumpPolicy{maxFeeRate: 100_000}
+
+	// The fee function starts at the first bumped fee rate.
+	f, err := newBumperFeeFunction(set, maxFeeRate, deadline, testHeight)
+	rt.NoError(err)
+	rt.Equal(chainfee.SatPerKWeight(2_000), f.FeeRate())
+
+	// Incrementing asks the bumper for the next fee rate.
+	increased, err := f.Increment()
+	rt.NoError(err)
+	rt.True(increased)
+	rt.Equal(chainfee.SatPerKWeight(4_000), f.FeeRate())
+
+	// A conf target not moving past the height of the last bump doesn't
+	// increase the fee rate.
+	increased, err = f.IncreaseFeeRate(10)
+	rt.NoError(err)
+	rt.False(increased)
+	rt.Equal(chainfee.SatPerKWeight(4_000), f.FeeRate())
+
+	// A new block bumps the fee rate, which is capped at the max.
+	increased, err = f.IncreaseFeeRate(9)
+	rt.NoError(err)
+	rt.True(increased)
+	rt.Equal(maxFeeRate, f.FeeRate())
+
+	// Once the max fee rate is reached, the fee rate can't be increased.
+	_, err = f.Increment()
+	rt.ErrorIs(err, ErrMaxPosition)
+
+	// A bumper not raising the fee rate is treated as maxed out.
+	set = &BudgetInputSet{deadlineHeight: deadline}
+	set.SetFeeRate(1_000)
+	set.cfg.FeeBumpPolicy = &exponentialFeeBumpPolicy{maxFeeRate: 1_000}
+	f, err = newBumperFeeFunction(set, maxFeeRate, deadline, testHeight)
+	rt.NoError(err)
+	rt.Equal(chainfee.SatPerKWeight(1_000), f.FeeRate())
+
+	_, err = f.Increment()
+	rt.ErrorIs(err, ErrMaxPosition)
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
 )
 
 // Wallet contains all wallet related functionality required by sweeper.
@@ -57,3 +58,50 @@ type Wallet interface {
 	// service.
 	BackEnd() string
 }
+
+// UtxoPager is an optional interface a Wallet can implement to list its utxos
+// page by page, so the sweeper doesn't need to load all the utxos of a large
+// wallet at once. When implemented, it's used instead of
+// ListUnspentWitnessFromDefaultAccount when adding wallet inputs.
+type UtxoPager interface {
+	// ListUnspentWitnessPage returns the page with the given index, which
+	// starts at zero, of the unspent witness outputs of the default
+	// account with a confirmation count in the given range. Each page
+	// holds at most pageSize utxos, and a page with fewer utxos is the
+	// last one. Pages should be ordered ascending by value, so the
+	// smallest utxos are considered first.
+	ListUnspentWitnessPage(minConfs, maxConfs int32, page,
+		pageSize uint32) ([]*lnwallet.Utxo, error)
+}
+
+// AccountUtxoLister is an optional interface a Wallet can implement to list
+// the utxos of an account other than the default one. When implemented, the
+// wallet inputs of a set with a sweep account are taken from that account
+// first, and the default account is only used as a fallback.
+type AccountUtxoLister interface {
+	// ListUnspentWitnessFromAccount returns all unspent outputs which are
+	// version 0 witness programs from the given wallet account, with a
+	// confirmation count in the given range.
+	ListUnspentWitnessFromAccount(account string, minConfs,
+		maxConfs int32) ([]*lnwallet.Utxo, error)
+}
+
+// AncestorCounter is an optional interface a Wallet can implement to report
+// the unconfirmed ancestors of its utxos. When implemented, the wallet utxos
+// with more unconfirmed ancestors than the max of a set are never used as
+// wallet inputs, as the sweep could hit the mempool ancestor limit and fail
+// to relay.
+type AncestorCounter interface {
+	// UnconfirmedAncestors returns the number of unconfirmed txns in the
+	// ancestry of the given utxo, including the tx creating it if it's
+	// unconfirmed.
+	UnconfirmedAncestors(op wire.OutPoint) (uint32, error)
+}
+
+// RelayFeeProvider provides the min relay fee rate of the mempool policy. It's
+// satisfied by chainfee.Estimator.
+type RelayFeeProvider interface {
+	// RelayFeePerKW returns the minimum fee rate required for transactions
+	// to be relayed.
+	RelayFeePerKW() chainfee.SatPerKWeight
+}
@@ -311,6 +311,46 @@ func (s *MockSweeperStore) DeleteTx(txid chainhash.Hash) error {
 	return args.Error(0)
 }
 
+// StoreWalletInputs checkpoints the wallet utxos of an input set.
+func (s *MockSweeperStore) StoreWalletInputs(setID chainhash.Hash,
+	setInputs []wire.OutPoint, utxos []*lnwallet.Utxo) error {
+
+	args := s.Called(setID, setInputs, utxos)
+
+	return args.Error(0)
+}
+
+// FetchWalletInputs returns the wallet utxos checkpointed for an input set.
+func (s *MockSweeperStore) FetchWalletInputs(
+	setID chainhash.Hash) ([]*lnwallet.Utxo, error) {
+
+	args := s.Called(setID)
+
+	utxos := args.Get(0)
+	if utxos != nil {
+		return utxos.([]*lnwallet.Utxo), args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
+
+// DeleteWalletInputs removes the checkpoint of an input set.
+func (s *MockSweeperStore) DeleteWalletInputs(setID chainhash.Hash) error {
+	args := s.Called(setID)
+
+	return args.Error(0)
+}
+
+// DeleteWalletInputsSpending removes the checkpoints of the input sets having
+// any of the given outpoints.
+func (s *MockSweeperStore) DeleteWalletInputsSpending(
+	ops []wire.OutPoint) error {
+
+	args := s.Called(ops)
+
+	return args.Error(0)
+}
+
 // Compile-time constraint to ensure MockSweeperStore implements SweeperStore.
 var _ SweeperStore = (*MockSweeperStore)(nil)
 
@@ -346,8 +386,10 @@ type mockUtxoAggregator struct {
 var _ UtxoAggregator = (*mockUtxoAggregator)(nil)
 
 // ClusterInputs takes a list of inputs and groups them into clusters.
-func (m *mockUtxoAggregator) ClusterInputs(inputs InputsMap) []InputSet {
-	args := m.Called(inputs)
+func (m *mockUtxoAggregator) ClusterInputs(inputs InputsMap,
+	currentHeight int32) []InputSet {
+
+	args := m.Called(inputs, currentHeight)
 
 	return args.Get(0).([]InputSet)
 }
@@ -516,6 +558,27 @@ func (m *MockInputSet) StartingFeeRate() fn.Option[chainfee.SatPerKWeight] {
 	return args.Get(0).(fn.Option[chainfee.SatPerKWeight])
 }
 
+// IsReplaceable returns true if the set signals replaceability.
+func (m *MockInputSet) IsReplaceable() bool {
+	args := m.Called()
+
+	return args.Bool(0)
+}
+
+// TxVersion returns the version of the tx created from the set.
+func (m *MockInputSet) TxVersion() int32 {
+	args := m.Called()
+
+	return args.Get(0).(int32)
+}
+
+// LockTime returns the lock time of the tx created from the set.
+func (m *MockInputSet) LockTime() fn.Option[uint32] {
+	args := m.Called()
+
+	return args.Get(0).(fn.Option[uint32])
+}
+
 // MockBumper is a mock implementation of the interface Bumper.
 type MockBumper struct {
 	mock.Mock
@@ -6,9 +6,12 @@ import (
 	"errors"
 	"io"
 
+	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/lightningnetwork/lnd/kvdb"
+	"github.com/lightningnetwork/lnd/lnwallet"
 	"github.com/lightningnetwork/lnd/tlv"
 )
 
@@ -19,6 +22,16 @@ var (
 	// maps: txHash -> TxRecord
 	txHashesBucketKey = []byte("sweeper-tx-hashes")
 
+	// walletInputsBucketKey is the key that points to a bucket containing
+	// the checkpointed wallet inputs of the budget input sets, so their
+	// exact selection can be restored after a restart. A set is identified
+	// by the hash of the sorted outpoints of its non-wallet inputs, which
+	// are stored along with the wallet utxos, so the checkpoint can be
+	// found from any of the inputs of the set once it's spent.
+	//
+	// maps: checkpointID -> ([]wire.OutPoint, []lnwallet.Utxo)
+	walletInputsBucketKey = []byte("sweeper-wallet-inputs")
+
 	// utxnChainPrefix is the bucket prefix for nursery buckets.
 	utxnChainPrefix = []byte("utxn")
 
@@ -37,6 +50,10 @@ var (
 	// ErrTxNotFound is returned when querying using a txid that's not
 	// found in our db.
 	ErrTxNotFound = errors.New("tx not found")
+
+	// ErrWalletInputsNotFound is returned when no wallet inputs are
+	// checkpointed for an input set.
+	ErrWalletInputsNotFound = errors.New("wallet inputs not found")
 )
 
 // TxRecord specifies a record of a tx that's stored in the database.
@@ -117,6 +134,127 @@ func deserializeTxRecord(r io.Reader) (*TxRecord, error) {
 	return &tx, nil
 }
 
+// serializeWalletInputs serializes the outpoints of the non-wallet inputs of a
+// set, followed by the outpoint, value, address type and script of its wallet
+// utxos, which is all that's needed to spend them again as wallet inputs.
+func serializeWalletInputs(w io.Writer, setInputs []wire.OutPoint,
+	utxos []*lnwallet.Utxo) error {
+
+	err := binary.Write(w, byteOrder, uint32(len(setInputs)))
+	if err != nil {
+		return err
+	}
+
+	for _, op := range setInputs {
+		if _, err := w.Write(op.Hash[:]); err != nil {
+			return err
+		}
+
+		if err := binary.Write(w, byteOrder, op.Index); err != nil {
+			return err
+		}
+	}
+
+	err = binary.Write(w, byteOrder, uint32(len(utxos)))
+	if err != nil {
+		return err
+	}
+
+	for _, utxo := range utxos {
+		if _, err := w.Write(utxo.OutPoint.Hash[:]); err != nil {
+			return err
+		}
+
+		err := binary.Write(w, byteOrder, utxo.OutPoint.Index)
+		if err != nil {
+			return err
+		}
+
+		err = binary.Write(w, byteOrder, int64(utxo.Value))
+		if err != nil {
+			return err
+		}
+
+		err = binary.Write(w, byteOrder, uint8(utxo.AddressType))
+		if err != nil {
+			return err
+		}
+
+		if err := wire.WriteVarBytes(w, 0, utxo.PkScript); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deserializeWalletInputs deserializes the outpoints of the non-wallet inputs
+// and the wallet utxos serialized by serializeWalletInputs.
+func deserializeWalletInputs(r io.Reader) ([]wire.OutPoint,
+	[]*lnwallet.Utxo, error) {
+
+	var numInputs uint32
+	if err := binary.Read(r, byteOrder, &numInputs); err != nil {
+		return nil, nil, err
+	}
+
+	setInputs := make([]wire.OutPoint, numInputs)
+	for i := range setInputs {
+		op := &setInputs[i]
+		if _, err := io.ReadFull(r, op.Hash[:]); err != nil {
+			return nil, nil, err
+		}
+
+		if err := binary.Read(r, byteOrder, &op.Index); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var numUtxos uint32
+	if err := binary.Read(r, byteOrder, &numUtxos); err != nil {
+		return nil, nil, err
+	}
+
+	utxos := make([]*lnwallet.Utxo, 0, numUtxos)
+	for i := uint32(0); i < numUtxos; i++ {
+		var (
+			utxo        lnwallet.Utxo
+			value       int64
+			addressType uint8
+		)
+
+		if _, err := io.ReadFull(r, utxo.OutPoint.Hash[:]); err != nil {
+			return nil, nil, err
+		}
+
+		err := binary.Read(r, byteOrder, &utxo.OutPoint.Index)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := binary.Read(r, byteOrder, &value); err != nil {
+			return nil, nil, err
+		}
+		utxo.Value = btcutil.Amount(value)
+
+		if err := binary.Read(r, byteOrder, &addressType); err != nil {
+			return nil, nil, err
+		}
+		utxo.AddressType = lnwallet.AddressType(addressType)
+
+		utxo.PkScript, err = wire.ReadVarBytes(
+			r, 0, txscript.MaxScriptSize, "pkScript",
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		utxos = append(utxos, &utxo)
+	}
+
+	return setInputs, utxos, nil
+}
+
 // SweeperStore stores published txes.
 type SweeperStore interface {
 	// IsOurTx determines whether a tx is published by us, based on its
@@ -135,6 +273,27 @@ type SweeperStore interface {
 
 	// DeleteTx removes a tx specified by the hash from the store.
 	DeleteTx(hash chainhash.Hash) error
+
+	// StoreWalletInputs checkpoints the wallet utxos selected by the
+	// input set with the given id and non-wallet inputs. Any previous
+	// checkpoint sharing an input with it is replaced, as it belongs to
+	// the same set or to a set its inputs were re-clustered from.
+	StoreWalletInputs(setID chainhash.Hash, setInputs []wire.OutPoint,
+		utxos []*lnwallet.Utxo) error
+
+	// FetchWalletInputs returns the wallet utxos checkpointed for the
+	// input set with the given id. Returns ErrWalletInputsNotFound if
+	// there's no checkpoint.
+	FetchWalletInputs(setID chainhash.Hash) ([]*lnwallet.Utxo, error)
+
+	// DeleteWalletInputs removes the checkpoint of the input set with the
+	// given id.
+	DeleteWalletInputs(setID chainhash.Hash) error
+
+	// DeleteWalletInputsSpending removes the checkpoints of the input
+	// sets having any of the given outpoints as a non-wallet input or a
+	// wallet utxo.
+	DeleteWalletInputsSpending(ops []wire.OutPoint) error
 }
 
 type sweeperStore struct {
@@ -390,5 +549,148 @@ func (s *sweeperStore) DeleteTx(txid chainhash.Hash) error {
 	}, func() {})
 }
 
+// StoreWalletInputs checkpoints the wallet utxos selected by the input set with
+// the given id and non-wallet inputs. Any previous checkpoint sharing an input
+// with it is replaced, as it belongs to the same set or to a set its inputs
+// were re-clustered from.
+func (s *sweeperStore) StoreWalletInputs(setID chainhash.Hash,
+	setInputs []wire.OutPoint, utxos []*lnwallet.Utxo) error {
+
+	var b bytes.Buffer
+	if err := serializeWalletInputs(&b, setInputs, utxos); err != nil {
+		return err
+	}
+
+	ops := make([]wire.OutPoint, 0, len(setInputs)+len(utxos))
+	ops = append(ops, setInputs...)
+	for _, utxo := range utxos {
+		ops = append(ops, utxo.OutPoint)
+	}
+
+	return kvdb.Update(s.db, func(tx kvdb.RwTx) error {
+		bucket, err := tx.CreateTopLevelBucket(walletInputsBucketKey)
+		if err != nil {
+			return err
+		}
+
+		if err := deleteWalletInputsSpending(bucket, ops); err != nil {
+			return err
+		}
+
+		return bucket.Put(setID[:], b.Bytes())
+	}, func() {})
+}
+
+// FetchWalletInputs returns the wallet utxos checkpointed for the input set
+// with the given id. Returns ErrWalletInputsNotFound if there's no checkpoint.
+func (s *sweeperStore) FetchWalletInputs(
+	setID chainhash.Hash) ([]*lnwallet.Utxo, error) {
+
+	var utxos []*lnwallet.Utxo
+	err := kvdb.View(s.db, func(tx kvdb.RTx) error {
+		bucket := tx.ReadBucket(walletInputsBucketKey)
+		if bucket == nil {
+			return ErrWalletInputsNotFound
+		}
+
+		v := bucket.Get(setID[:])
+		if v == nil {
+			return ErrWalletInputsNotFound
+		}
+
+		var err error
+		_, utxos, err = deserializeWalletInputs(bytes.NewReader(v))
+
+		return err
+	}, func() {
+		utxos = nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return utxos, nil
+}
+
+// DeleteWalletInputs removes the checkpoint of the input set with the given id.
+func (s *sweeperStore) DeleteWalletInputs(setID chainhash.Hash) error {
+	return kvdb.Update(s.db, func(tx kvdb.RwTx) error {
+		bucket := tx.ReadWriteBucket(walletInputsBucketKey)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.Delete(setID[:])
+	}, func() {})
+}
+
+// DeleteWalletInputsSpending removes the checkpoints of the input sets having
+// any of the given outpoints as a non-wallet input or a wallet utxo.
+func (s *sweeperStore) DeleteWalletInputsSpending(ops []wire.OutPoint) error {
+	return kvdb.Update(s.db, func(tx kvdb.RwTx) error {
+		bucket := tx.ReadWriteBucket(walletInputsBucketKey)
+		if bucket == nil {
+			return nil
+		}
+
+		return deleteWalletInputsSpending(bucket, ops)
+	}, func() {})
+}
+
+// deleteWalletInputsSpending removes the checkpoints in the given bucket
+// having any of the given outpoints as a non-wallet input or a wallet utxo.
+func deleteWalletInputsSpending(bucket kvdb.RwBucket,
+	ops []wire.OutPoint) error {
+
+	spent := make(map[wire.OutPoint]struct{}, len(ops))
+	for _, op := range ops {
+		spent[op] = struct{}{}
+	}
+
+	isSpent := func(op wire.OutPoint) bool {
+		_, ok := spent[op]
+		return ok
+	}
+
+	// Collect copies of the keys first, as the bucket can't be modified
+	// while it's iterated.
+	var stale [][]byte
+	err := bucket.ForEach(func(k, v []byte) error {
+		setInputs, utxos, err := deserializeWalletInputs(
+			bytes.NewReader(v),
+		)
+		if err != nil {
+			return err
+		}
+
+		for _, op := range setInputs {
+			if isSpent(op) {
+				stale = append(stale, bytes.Clone(k))
+				return nil
+			}
+		}
+
+		for _, utxo := range utxos {
+			if isSpent(utxo.OutPoint) {
+				stale = append(stale, bytes.Clone(k))
+				return nil
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range stale {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Compile-time constraint to ensure sweeperStore implements SweeperStore.
 var _ SweeperStore = (*sweeperStore)(nil)
@@ -8,6 +8,7 @@ import (
 	"github.com/btcsuite/btcd/wire"
 	"github.com/lightningnetwork/lnd/channeldb"
 	"github.com/lightningnetwork/lnd/kvdb"
+	"github.com/lightningnetwork/lnd/lnwallet"
 	"github.com/stretchr/testify/require"
 )
 
@@ -220,3 +221,96 @@ func TestDeleteTx(t *testing.T) {
 	err = store.DeleteTx(chainhash.Hash{4, 5, 6})
 	require.NoError(t, err)
 }
+
+// TestWalletInputs asserts that the checkpointed wallet inputs can be fetched
+// and deleted, either by the id of their set or by the inputs they spend.
+func TestWalletInputs(t *testing.T) {
+	t.Parallel()
+
+	cdb, err := channeldb.MakeTestDB(t)
+	require.NoError(t, err)
+
+	// Create a testing store.
+	chain := chainhash.Hash{}
+	store, err := NewSweeperStore(cdb, &chain)
+	require.NoError(t, err)
+
+	setID := chainhash.Hash{1}
+
+	// Fetching a missing checkpoint gives us an error.
+	_, err = store.FetchWalletInputs(setID)
+	require.ErrorIs(t, err, ErrWalletInputsNotFound)
+
+	utxos := []*lnwallet.Utxo{{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       10_000,
+		PkScript:    []byte{0, 20, 1, 2, 3},
+		OutPoint:    wire.OutPoint{Hash: chainhash.Hash{1}, Index: 1},
+	}, {
+		AddressType: lnwallet.TaprootPubkey,
+		Value:       20_000,
+		PkScript:    []byte{1, 32, 4, 5, 6},
+		OutPoint:    wire.OutPoint{Hash: chainhash.Hash{2}, Index: 2},
+	}}
+
+	setInputs := []wire.OutPoint{
+		{Hash: chainhash.Hash{3}, Index: 3},
+		{Hash: chainhash.Hash{4}, Index: 4},
+	}
+
+	// Assert we can store and fetch the wallet inputs.
+	require.NoError(t, store.StoreWalletInputs(setID, setInputs, utxos))
+	fetched, err := store.FetchWalletInputs(setID)
+	require.NoError(t, err)
+	require.Equal(t, utxos, fetched)
+
+	// Other sets are not affected.
+	_, err = store.FetchWalletInputs(chainhash.Hash{2})
+	require.ErrorIs(t, err, ErrWalletInputsNotFound)
+
+	// A new checkpoint replaces the previous one.
+	require.NoError(t, store.StoreWalletInputs(
+		setID, setInputs, utxos[1:],
+	))
+	fetched, err = store.FetchWalletInputs(setID)
+	require.NoError(t, err)
+	require.Equal(t, utxos[1:], fetched)
+
+	// So does the checkpoint of a set re-clustered with one of the inputs
+	// of the previous set, while a disjoint set is not affected.
+	reclusteredID, otherID := chainhash.Hash{5}, chainhash.Hash{6}
+	require.NoError(t, store.StoreWalletInputs(
+		otherID, []wire.OutPoint{{Index: 7}}, utxos[:1],
+	))
+	require.NoError(t, store.StoreWalletInputs(
+		reclusteredID, setInputs[1:], utxos[1:],
+	))
+	_, err = store.FetchWalletInputs(setID)
+	require.ErrorIs(t, err, ErrWalletInputsNotFound)
+	_, err = store.FetchWalletInputs(otherID)
+	require.NoError(t, err)
+
+	// The checkpoints are deleted once any of their inputs is spent,
+	// either a non-wallet input or a wallet utxo.
+	require.NoError(t, store.DeleteWalletInputsSpending(
+		[]wire.OutPoint{{Index: 8}, setInputs[1]},
+	))
+	_, err = store.FetchWalletInputs(reclusteredID)
+	require.ErrorIs(t, err, ErrWalletInputsNotFound)
+	_, err = store.FetchWalletInputs(otherID)
+	require.NoError(t, err)
+
+	require.NoError(t, store.DeleteWalletInputsSpending(
+		[]wire.OutPoint{utxos[0].OutPoint},
+	))
+	_, err = store.FetchWalletInputs(otherID)
+	require.ErrorIs(t, err, ErrWalletInputsNotFound)
+
+	// Store the checkpoint again to delete it by its id.
+	require.NoError(t, store.StoreWalletInputs(setID, setInputs, utxos))
+
+	// Assert we can delete the checkpoint.
+	require.NoError(t, store.DeleteWalletInputs(setID))
+	_, err = store.FetchWalletInputs(setID)
+	require.ErrorIs(t, err, ErrWalletInputsNotFound)
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
+	base "github.com/btcsuite/btcwallet/wallet"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/lightningnetwork/lnd/chainntnfs"
 	"github.com/lightningnetwork/lnd/fn"
@@ -69,6 +70,17 @@ type Params struct {
 	// StartingFeeRate is an optional parameter that can be used to specify
 	// the initial fee rate to use for the fee function.
 	StartingFeeRate fn.Option[chainfee.SatPerKWeight]
+
+	// CoinSelectionStrategy is an optional strategy used to select the
+	// wallet utxos when the input needs wallet inputs, e.g., an anchor
+	// CPFP may prefer the largest utxos first. If not set, the default of
+	// the input set is used.
+	CoinSelectionStrategy fn.Option[base.CoinSelectionStrategy]
+
+	// Pinned indicates the input must never be dropped from an input set
+	// when the number of inputs exceeds the max allowed. Only unpinned
+	// inputs are trimmed.
+	Pinned bool
 }
 
 // String returns a human readable interpretation of the sweep parameters.
@@ -204,6 +216,12 @@ type SweeperInput struct {
 	// different from the DeadlineHeight in its params as it's an actual
 	// value than an option.
 	DeadlineHeight int32
+
+	// CoGroup optionally identifies a group of inputs that must be
+	// confirmed together, e.g., both legs of an HTLC resolution. If any
+	// input of the group cannot be added to an input set, the whole group
+	// is rejected.
+	CoGroup fn.Option[uint64]
 }
 
 // String returns a human readable interpretation of the pending input.
@@ -331,6 +349,14 @@ type UtxoSweeperConfig struct {
 	// funds can be swept.
 	GenSweepScript func() ([]byte, error)
 
+	// GenChangeScript optionally generates a script of the given account
+	// and address type of the wallet, which is used when the change policy
+	// of a set pays the change to accounts other than the default one, to
+	// multiple outputs, or to an address type other than p2tr. The default
+	// account is the empty string.
+	GenChangeScript func(account string,
+		addrType lnwallet.AddressType) ([]byte, error)
+
 	// FeeEstimator is used when crafting sweep transactions to estimate
 	// the necessary fee relative to the expected size of the sweep
 	// transaction.
@@ -827,6 +853,15 @@ func (s *UtxoSweeper) sweep(set InputSet) error {
 		s.currentOutputScript = pkScript
 	}
 
+	// Run the sanity checks of the set, if it has any, so we don't ask
+	// the publisher to create a tx that would be invalid.
+	if validator, ok := set.(setValidator); ok {
+		err := validator.Validate(s.currentHeight)
+		if err != nil {
+			return fmt.Errorf("validate set: %w", err)
+		}
+	}
+
 	// Create a fee bump request and ask the publisher to broadcast it. The
 	// publisher will then take over and start monitoring the tx for
 	// potential fee bump.
@@ -837,9 +872,55 @@ func (s *UtxoSweeper) sweep(set InputSet) error {
 		DeliveryAddress: s.currentOutputScript,
 		MaxFeeRate:      s.cfg.MaxFeeRate.FeePerKWeight(),
 		StartingFeeRate: set.StartingFeeRate(),
+		NonReplaceable:  !set.IsReplaceable(),
+		TxVersion:       set.TxVersion(),
+		LockTime:        set.LockTime(),
 		// TODO(yy): pass the strategy here.
 	}
 
+	// Label the sweeping tx with the composition of the set if known, keep
+	// the input order of the set if it must be preserved, sort the outputs
+	// if asked to, and cap the max fee rate at what the budget affords if
+	// the set asks for it. A set with a fee bump policy drives the fee
+	// rate of its sweeping tx, and one with a change reservation leases
+	// its change.
+	if budgetSet, ok := set.(*BudgetInputSet); ok {
+		req.Label = budgetSet.Label()
+		req.PreserveInputOrder = budgetSet.PreservesInputOrder()
+		req.SortOutputs = budgetSet.SortsOutputs()
+		req.MaxFeeRate = budgetSet.EffectiveMaxFeeRate(req.MaxFeeRate)
+
+		if budgetSet.cfg.FeeBumpPolicy != nil {
+			req.FeeRateBumper = budgetSet
+		}
+
+		if budgetSet.cfg.ChangeReservation.IsSome() {
+			req.ChangeReserver = budgetSet
+		}
+	}
+
+	// Let the set decide the min fee of a valid replacement of its
+	// sweeping tx.
+	if calculator, ok := set.(RBFFeeCalculator); ok {
+		req.RBFFeeCalculator = calculator
+	}
+
+	// Pay the change out as the set asks for, with a script for each of
+	// its change outputs.
+	if policySet, ok := set.(changePolicySet); ok {
+		policy := policySet.ChangePolicy()
+
+		scripts, err := s.changeScripts(
+			policy, availableChange(set.Inputs()),
+		)
+		if err != nil {
+			return fmt.Errorf("gen change scripts: %w", err)
+		}
+
+		req.ChangePolicy = policy
+		req.ChangeScripts = scripts
+	}
+
 	// Reschedule the inputs that we just tried to sweep. This is done in
 	// case the following publish fails, we'd like to update the inputs'
 	// publish attempts and rescue them in the next sweep.
@@ -1142,16 +1223,15 @@ func (s *UtxoSweeper) handleUpdateReq(req *updateReq) (
 		return nil, lnwallet.ErrNotMine
 	}
 
-	// Create the updated parameters struct. Leave the exclusive group
-	// unchanged.
-	newParams := Params{
-		Fee:             req.params.Fee,
-		StartingFeeRate: req.params.StartingFeeRate,
-		Immediate:       req.params.Immediate,
-		Budget:          req.params.Budget,
-		DeadlineHeight:  req.params.DeadlineHeight,
-		ExclusiveGroup:  sweeperInput.params.ExclusiveGroup,
-	}
+	// Create the updated parameters struct by overwriting the updatable
+	// fields of the current params, so the others, such as the exclusive
+	// group, are left unchanged.
+	newParams := sweeperInput.params
+	newParams.Fee = req.params.Fee
+	newParams.StartingFeeRate = req.params.StartingFeeRate
+	newParams.Immediate = req.params.Immediate
+	newParams.Budget = req.params.Budget
+	newParams.DeadlineHeight = req.params.DeadlineHeight
 
 	log.Debugf("Updating parameters for %v(state=%v) from (%v) to (%v)",
 		req.input, sweeperInput.state, sweeperInput.params, newParams)
@@ -1404,10 +1484,54 @@ func (s *UtxoSweeper) handleInputSpent(spend *chainntnfs.SpendDetail) {
 		)
 	}
 
+	// Whether our sweeping tx confirmed or a third party spent the
+	// inputs, the wallet inputs checkpointed for the sets spending them
+	// are no longer needed.
+	s.deleteCheckpoints(spend.SpendingTx)
+
 	// We now use the spending tx to update the state of the inputs.
 	s.markInputsSwept(spend.SpendingTx, isOurTx)
 }
 
+// deleteCheckpoints removes the checkpoints of the wallet inputs of the sets
+// spending any of the inputs of the given tx once their sweep is over, so the
+// checkpoints don't pile up.
+func (s *UtxoSweeper) deleteCheckpoints(tx *wire.MsgTx) {
+	ops := make([]wire.OutPoint, 0, len(tx.TxIn))
+	for _, txIn := range tx.TxIn {
+		ops = append(ops, txIn.PreviousOutPoint)
+	}
+
+	if err := s.cfg.Store.DeleteWalletInputsSpending(ops); err != nil {
+		log.Errorf("Unable to delete checkpoints spending tx %v "+
+			"inputs: %v", tx.TxHash(), err)
+	}
+}
+
+// isSweeping returns true if the given outpoint is spent by one of our
+// sweeping txns in the mempool.
+func (s *UtxoSweeper) isSweeping(op wire.OutPoint) bool {
+	// Extract the spending tx from the mempool, if any.
+	var tx *wire.MsgTx
+	s.mempoolLookup(op).WhenSome(func(t wire.MsgTx) {
+		tx = &t
+	})
+
+	if tx == nil {
+		return false
+	}
+
+	isOurTx, err := s.cfg.Store.IsOurTx(tx.TxHash())
+	if err != nil {
+		log.Errorf("Cannot determine if tx %v spending %v is ours: %v",
+			tx.TxHash(), op, err)
+
+		return false
+	}
+
+	return isOurTx
+}
+
 // markInputsSwept marks all inputs swept by the spending transaction as swept.
 // It will also notify all the subscribers of this input.
 func (s *UtxoSweeper) markInputsSwept(tx *wire.MsgTx, isOurTx bool) {
@@ -1551,7 +1675,35 @@ func (s *UtxoSweeper) updateSweeperInputs() InputsMap {
 // and attempt to create and publish the sweeping transactions.
 func (s *UtxoSweeper) sweepPendingInputs(inputs InputsMap) {
 	// Cluster all of our inputs based on the specific Aggregator.
-	sets := s.cfg.Aggregator.ClusterInputs(inputs)
+	sets := s.cfg.Aggregator.ClusterInputs(inputs, s.currentHeight)
+
+	// Sweep the sets protecting channel funds before the ones only
+	// consolidating wallet utxos, so the former get the first pick of the
+	// wallet utxos.
+	sets = prioritizeSets(sets)
+
+	// sweepSet sweeps the given set unless it spends an outpoint already
+	// spent by a set swept before it, as their txns would double spend
+	// each other. This is checked once the wallet inputs are added, as
+	// two sets may select the same wallet utxo.
+	var swept []InputSet
+	sweepSet := func(set InputSet) error {
+		if conflictsWithSets(set, swept) {
+			log.Warnf("Skipped sweeping %v as it conflicts with "+
+				"another set", set)
+
+			return nil
+		}
+
+		err := s.sweep(set)
+		if err != nil {
+			return err
+		}
+
+		swept = append(swept, set)
+
+		return nil
+	}
 
 	// sweepWithLock is a helper closure that executes the sweep within a
 	// coin select lock to prevent the coins being selected for other
@@ -1559,18 +1711,13 @@ func (s *UtxoSweeper) sweepPendingInputs(inputs InputsMap) {
 	sweepWithLock := func(set InputSet) error {
 		return s.cfg.Wallet.WithCoinSelectLock(func() error {
 			// Try to add inputs from our wallet.
-			err := set.AddWalletInputs(s.cfg.Wallet)
+			err := s.addWalletInputs(set)
 			if err != nil {
 				return err
 			}
 
 			// Create sweeping transaction for each set.
-			err = s.sweep(set)
-			if err != nil {
-				return err
-			}
-
-			return nil
+			return sweepSet(set)
 		})
 	}
 
@@ -1582,7 +1729,7 @@ func (s *UtxoSweeper) sweepPendingInputs(inputs InputsMap) {
 		} else {
 			// Sweep the set of inputs that don't need the wallet
 			// inputs.
-			err = s.sweep(set)
+			err = sweepSet(set)
 		}
 
 		if err != nil {
@@ -1591,6 +1738,81 @@ func (s *UtxoSweeper) sweepPendingInputs(inputs InputsMap) {
 	}
 }
 
+// walletOnlySet is implemented by the sets that can tell whether they only
+// consolidate wallet utxos.
+type walletOnlySet interface {
+	// IsWalletOnly returns true if the set only consolidates wallet utxos.
+	IsWalletOnly() bool
+}
+
+// prioritizeSets returns the given sets with the wallet only sets moved to the
+// end. The order of the sets is otherwise preserved.
+func prioritizeSets(sets []InputSet) []InputSet {
+	isWalletOnly := func(set InputSet) bool {
+		s, ok := set.(walletOnlySet)
+		return ok && s.IsWalletOnly()
+	}
+
+	sorted := make([]InputSet, 0, len(sets))
+	var walletOnly []InputSet
+	for _, set := range sets {
+		if isWalletOnly(set) {
+			walletOnly = append(walletOnly, set)
+			continue
+		}
+
+		sorted = append(sorted, set)
+	}
+
+	return append(sorted, walletOnly...)
+}
+
+// conflictsWithSets returns true if the given set spends an outpoint also
+// spent by one of the other sets.
+func conflictsWithSets(set InputSet, others []InputSet) bool {
+	sets := make([]InputSet, 0, len(others)+1)
+	sets = append(sets, others...)
+	sets = append(sets, set)
+
+	for _, pair := range ConflictingSets(sets) {
+		if pair[1] == len(others) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addWalletInputs adds the wallet inputs needed by the given set. For a budget
+// set, the wallet inputs checkpointed before a restart are restored first, so
+// the same utxos are spent again, and the final selection is checkpointed.
+//
+// NOTE: must be called with the wallet lock held via `WithCoinSelectLock`.
+func (s *UtxoSweeper) addWalletInputs(set InputSet) error {
+	budgetSet, ok := set.(*BudgetInputSet)
+	if !ok {
+		return set.AddWalletInputs(s.cfg.Wallet)
+	}
+
+	_, err := budgetSet.RestoreWalletInputs(
+		s.cfg.Store, s.cfg.Wallet, s.isSweeping,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Only select more wallet inputs if the restored ones don't cover the
+	// budget.
+	if budgetSet.NeedWalletInput() {
+		err := budgetSet.AddWalletInputs(s.cfg.Wallet)
+		if err != nil {
+			return err
+		}
+	}
+
+	return budgetSet.Checkpoint(s.cfg.Store)
+}
+
 // monitorFeeBumpResult subscribes to the passed result chan to listen for
 // future updates about the sweeping tx.
 //
@@ -1655,6 +1877,9 @@ func (s *UtxoSweeper) handleBumpEventTxFailed(r *BumpResult) error {
 		outpoints = append(outpoints, inp.PreviousOutPoint)
 	}
 
+	// The wallet inputs of the set are selected again on the next sweep.
+	s.deleteCheckpoints(tx)
+
 	// TODO(yy): should we also remove the failed tx from db?
 	s.markInputsPublishFailed(outpoints)
 
@@ -1734,6 +1959,45 @@ func (s *UtxoSweeper) handleBumpEventTxPublished(r *BumpResult) error {
 	return nil
 }
 
+// changeScripts generates a script of the change type for each change output
+// of the given policy, given the value available for the change and the fees.
+// If the change is dropped, or all of it goes to a single p2tr output of the
+// default account, no script is generated as the latter pays to the current
+// output script.
+func (s *UtxoSweeper) changeScripts(policy ChangePolicy,
+	available btcutil.Amount) ([][]byte, error) {
+
+	accounts := policy.outputAccounts(available)
+	addrType := effectiveChangeType(policy.ChangeType)
+
+	switch {
+	case len(accounts) == 0:
+		return nil, nil
+
+	case len(accounts) == 1 && accounts[0] == "" &&
+		addrType == lnwallet.TaprootPubkey:
+
+		return nil, nil
+	}
+
+	if s.cfg.GenChangeScript == nil {
+		return nil, fmt.Errorf("no change script generator for "+
+			"accounts=%v, type=%v", accounts, addrType)
+	}
+
+	scripts := make([][]byte, 0, len(accounts))
+	for _, account := range accounts {
+		pkScript, err := s.cfg.GenChangeScript(account, addrType)
+		if err != nil {
+			return nil, fmt.Errorf("account=%v: %w", account, err)
+		}
+
+		scripts = append(scripts, pkScript)
+	}
+
+	return scripts, nil
+}
+
 // handleBumpEvent handles the result sent from the bumper based on its event
 // type.
 //
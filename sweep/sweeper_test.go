@@ -14,6 +14,8 @@ import (
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
+	base "github.com/btcsuite/btcwallet/wallet"
+	"github.com/btcsuite/btcwallet/wtxmgr"
 	"github.com/lightningnetwork/lnd/build"
 	"github.com/lightningnetwork/lnd/chainntnfs"
 	"github.com/lightningnetwork/lnd/channeldb"
@@ -71,9 +73,10 @@ var (
 )
 
 func createTestInput(value int64, witnessType input.WitnessType) input.BaseInput {
+	count := testInputCount.Add(1)
 	hash := chainhash.Hash{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-		byte(testInputCount.Add(1))}
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		byte(count >> 8), byte(count)}
 
 	input := input.MakeBaseInput(
 		&wire.OutPoint{
@@ -2683,6 +2686,55 @@ func TestMarkInputFailed(t *testing.T) {
 	require.Equal(t, Failed, pi.state)
 }
 
+// TestHandleUpdateReq checks that updating the params of an input only
+// overwrites the updatable fields, and leaves the others unchanged.
+func TestHandleUpdateReq(t *testing.T) {
+	t.Parallel()
+
+	s := New(&UtxoSweeperConfig{})
+
+	inp := createP2WKHInput(100_000)
+	group := uint64(1)
+	strategy := fn.Some[base.CoinSelectionStrategy](
+		base.CoinSelectionLargest,
+	)
+	s.inputs[inp.OutPoint()] = &SweeperInput{
+		Input: inp,
+		state: Published,
+		params: Params{
+			Budget:                1_000,
+			ExclusiveGroup:        &group,
+			CoinSelectionStrategy: strategy,
+			Pinned:                true,
+		},
+	}
+
+	newParams := Params{
+		Budget:         2_000,
+		Immediate:      true,
+		DeadlineHeight: fn.Some(int32(testHeight)),
+	}
+	_, err := s.handleUpdateReq(&updateReq{
+		input:  inp.OutPoint(),
+		params: newParams,
+	})
+	require.NoError(t, err)
+
+	// The updatable fields are overwritten.
+	pi := s.inputs[inp.OutPoint()]
+	require.Equal(t, Init, pi.state)
+	require.Equal(t, btcutil.Amount(2_000), pi.params.Budget)
+	require.True(t, pi.params.Immediate)
+	require.Equal(t, int32(testHeight), pi.DeadlineHeight)
+
+	// The others are left unchanged.
+	require.Equal(t, &group, pi.params.ExclusiveGroup)
+	require.Equal(t, strategy, pi.params.CoinSelectionStrategy)
+
+	// A pinned input stays pinned after its fee is bumped.
+	require.True(t, pi.params.Pinned)
+}
+
 // TestSweepPendingInputs checks that `sweepPendingInputs` correctly executes
 // its workflow based on the returned values from the interfaces.
 func TestSweepPendingInputs(t *testing.T) {
@@ -2731,23 +2783,29 @@ func TestSweepPendingInputs(t *testing.T) {
 
 	// Mock the methods used in `sweep`. This is not important for this
 	// unit test.
-	setNeedWallet.On("Inputs").Return(nil).Times(4)
+	setNeedWallet.On("Inputs").Return(nil).Times(5)
 	setNeedWallet.On("DeadlineHeight").Return(testHeight).Once()
 	setNeedWallet.On("Budget").Return(btcutil.Amount(1)).Once()
 	setNeedWallet.On("StartingFeeRate").Return(
 		fn.None[chainfee.SatPerKWeight]()).Once()
-	normalSet.On("Inputs").Return(nil).Times(4)
+	setNeedWallet.On("IsReplaceable").Return(true).Once()
+	setNeedWallet.On("TxVersion").Return(int32(2)).Once()
+	setNeedWallet.On("LockTime").Return(fn.None[uint32]()).Once()
+	normalSet.On("Inputs").Return(nil).Times(5)
 	normalSet.On("DeadlineHeight").Return(testHeight).Once()
 	normalSet.On("Budget").Return(btcutil.Amount(1)).Once()
 	normalSet.On("StartingFeeRate").Return(
 		fn.None[chainfee.SatPerKWeight]()).Once()
+	normalSet.On("IsReplaceable").Return(true).Once()
+	normalSet.On("TxVersion").Return(int32(2)).Once()
+	normalSet.On("LockTime").Return(fn.None[uint32]()).Once()
 
 	// Make pending inputs for testing. We don't need real values here as
 	// the returned clusters are mocked.
 	pis := make(InputsMap)
 
 	// Mock the aggregator to return the mocked input sets.
-	aggregator.On("ClusterInputs", pis).Return([]InputSet{
+	aggregator.On("ClusterInputs", pis, mock.Anything).Return([]InputSet{
 		setNeedWallet, normalSet,
 	})
 
@@ -2763,13 +2821,370 @@ func TestSweepPendingInputs(t *testing.T) {
 	s.sweepPendingInputs(pis)
 }
 
+// TestSweepInvalidSet checks that a set failing its sanity checks isn't handed
+// to the publisher.
+func TestSweepInvalidSet(t *testing.T) {
+	t.Parallel()
+
+	publisher := &MockBumper{}
+	defer publisher.AssertExpectations(t)
+
+	s := New(&UtxoSweeperConfig{
+		Publisher: publisher,
+		GenSweepScript: func() ([]byte, error) {
+			return testPubKey.SerializeCompressed(), nil
+		},
+	})
+	s.currentHeight = testHeight
+
+	// Create a set that must not be broadcast before the next block.
+	inp := createP2WKHInput(100_000)
+	set, err := NewBudgetInputSet([]SweeperInput{{
+		Input:  inp,
+		params: Params{Budget: 1_000},
+	}}, testHeight+10, testHeight, BudgetInputSetConfig{
+		MaxInputs: DefaultMaxInputsPerTx,
+		NotBefore: fn.Some(testHeight + 1),
+	})
+	require.NoError(t, err)
+
+	// The set isn't ready yet, so the publisher is not called.
+	require.ErrorIs(t, s.sweep(set), ErrNotReady)
+}
+
+// TestSweepFeeBumpPolicy checks that a budget set with a fee bump policy is
+// asked to drive the fee rate of its sweeping tx, and that a set without one
+// isn't.
+func TestSweepFeeBumpPolicy(t *testing.T) {
+	t.Parallel()
+
+	publisher := &MockBumper{}
+	defer publisher.AssertExpectations(t)
+
+	s := New(&UtxoSweeperConfig{
+		Publisher: publisher,
+		GenSweepScript: func() ([]byte, error) {
+			return testPubKey.SerializeCompressed(), nil
+		},
+	})
+	s.currentHeight = testHeight
+
+	newSet := func(cfg BudgetInputSetConfig) *BudgetInputSet {
+		cfg.MaxInputs = DefaultMaxInputsPerTx
+		set, err := NewBudgetInputSet([]SweeperInput{{
+			Input:  createP2WKHInput(100_000),
+			params: Params{Budget: 1_000},
+		}}, testHeight+10, testHeight, cfg)
+		require.NoError(t, err)
+
+		return set
+	}
+
+	// Fail the broadcast so the result isn't monitored.
+	dummyErr := errors.New("dummy error")
+
+	set := newSet(BudgetInputSetConfig{})
+	publisher.On("Broadcast", mock.MatchedBy(func(req *BumpRequest) bool {
+		return req.FeeRateBumper == nil
+	})).Return(nil, dummyErr).Once()
+	require.ErrorIs(t, s.sweep(set), dummyErr)
+
+	set = newSet(BudgetInputSetConfig{
+		FeeBumpPolicy: &LinearFeeBumpPolicy{MaxFeeRate: 10_000},
+	})
+	publisher.On("Broadcast", mock.MatchedBy(func(req *BumpRequest) bool {
+		return req.FeeRateBumper == set
+	})).Return(nil, dummyErr).Once()
+	require.ErrorIs(t, s.sweep(set), dummyErr)
+}
+
+// TestSweepChangeReservation checks that a budget set with a change
+// reservation is asked to lease the change of its sweeping tx, and that a set
+// without one isn't.
+func TestSweepChangeReservation(t *testing.T) {
+	t.Parallel()
+
+	publisher := &MockBumper{}
+	defer publisher.AssertExpectations(t)
+
+	s := New(&UtxoSweeperConfig{
+		Publisher: publisher,
+		GenSweepScript: func() ([]byte, error) {
+			return testPubKey.SerializeCompressed(), nil
+		},
+	})
+	s.currentHeight = testHeight
+
+	newSet := func(cfg BudgetInputSetConfig) *BudgetInputSet {
+		cfg.MaxInputs = DefaultMaxInputsPerTx
+		set, err := NewBudgetInputSet([]SweeperInput{{
+			Input:  createP2WKHInput(100_000),
+			params: Params{Budget: 1_000},
+		}}, testHeight+10, testHeight, cfg)
+		require.NoError(t, err)
+
+		return set
+	}
+
+	// Fail the broadcast so the result isn't monitored.
+	dummyErr := errors.New("dummy error")
+
+	set := newSet(BudgetInputSetConfig{})
+	publisher.On("Broadcast", mock.MatchedBy(func(req *BumpRequest) bool {
+		return req.ChangeReserver == nil
+	})).Return(nil, dummyErr).Once()
+	require.ErrorIs(t, s.sweep(set), dummyErr)
+
+	set = newSet(BudgetInputSetConfig{
+		ChangeReservation: fn.Some(ChangeReservation{
+			ID:       wtxmgr.LockID{1},
+			Duration: time.Hour,
+			Leaser:   newMockOutputLeaser(),
+		}),
+	})
+	publisher.On("Broadcast", mock.MatchedBy(func(req *BumpRequest) bool {
+		return req.ChangeReserver == set
+	})).Return(nil, dummyErr).Once()
+	require.ErrorIs(t, s.sweep(set), dummyErr)
+}
+
+// TestSweepRBFFeeCalculator checks that the set is asked for the min fee of a
+// valid replacement of its sweeping tx.
+func TestSweepRBFFeeCalculator(t *testing.T) {
+	t.Parallel()
+
+	publisher := &MockBumper{}
+	defer publisher.AssertExpectations(t)
+
+	s := New(&UtxoSweeperConfig{
+		Publisher: publisher,
+		GenSweepScript: func() ([]byte, error) {
+			return testPubKey.SerializeCompressed(), nil
+		},
+	})
+	s.currentHeight = testHeight
+
+	set, err := NewBudgetInputSet([]SweeperInput{{
+		Input:  createP2WKHInput(100_000),
+		params: Params{Budget: 1_000},
+	}}, testHeight+10, testHeight, BudgetInputSetConfig{
+		MaxInputs: DefaultMaxInputsPerTx,
+	})
+	require.NoError(t, err)
+
+	// Fail the broadcast so the result isn't monitored.
+	dummyErr := errors.New("dummy error")
+	publisher.On("Broadcast", mock.MatchedBy(func(req *BumpRequest) bool {
+		return req.RBFFeeCalculator == set
+	})).Return(nil, dummyErr).Once()
+	require.ErrorIs(t, s.sweep(set), dummyErr)
+}
+
+// TestPrioritizeSets checks that the wallet only sets are swept after the sets
+// protecting channel funds, and that the order is otherwise preserved.
+func TestPrioritizeSets(t *testing.T) {
+	t.Parallel()
+
+	newSet := func(walletOnly bool) *BudgetInputSet {
+		timeLock := createTestInput(10_000, input.CommitmentTimeLock)
+		inp := &SweeperInput{Input: &timeLock}
+		set := &BudgetInputSet{
+			inputs:         []*SweeperInput{inp},
+			deadlineHeight: testHeight,
+		}
+		if walletOnly {
+			set.recordWalletInputs([]*lnwallet.Utxo{{
+				OutPoint: inp.OutPoint(),
+				Value:    10_000,
+			}})
+		}
+
+		return set
+	}
+
+	wallet1, regular1, regular2 := newSet(true), newSet(false),
+		newSet(false)
+	require.True(t, wallet1.IsWalletOnly())
+	require.False(t, regular1.IsWalletOnly())
+
+	// A set sweeping a wallet utxo offered to the sweeper, e.g., to bump
+	// the fee of a wallet tx via CPFP, is wallet only too.
+	wallet2 := &BudgetInputSet{
+		inputs: []*SweeperInput{{
+			Input: createP2WKHInput(10_000),
+		}},
+		deadlineHeight: testHeight,
+	}
+	require.True(t, wallet2.IsWalletOnly())
+
+	// A set not telling whether it's wallet only keeps its place.
+	mockSet := &MockInputSet{}
+
+	sets := prioritizeSets([]InputSet{
+		wallet1, regular1, mockSet, wallet2, regular2,
+	})
+	require.Equal(t, []InputSet{
+		regular1, mockSet, regular2, wallet1, wallet2,
+	}, sets)
+}
+
+// TestConflictsWithSets checks that a set spending an outpoint, such as a
+// wallet utxo, already spent by another set is detected.
+func TestConflictsWithSets(t *testing.T) {
+	t.Parallel()
+
+	inp1, inp2, inp3 := createP2WKHInput(10_000), createP2WKHInput(10_000),
+		createP2WKHInput(10_000)
+
+	newSet := func(inputs ...input.Input) *BudgetInputSet {
+		set := &BudgetInputSet{deadlineHeight: testHeight}
+		for _, inp := range inputs {
+			set.inputs = append(
+				set.inputs, &SweeperInput{Input: inp},
+			)
+		}
+
+		return set
+	}
+
+	set1 := newSet(inp1)
+	set2 := newSet(inp2)
+	set3 := newSet(inp3)
+
+	// Without other sets, there's no conflict.
+	require.False(t, conflictsWithSets(set1, nil))
+
+	// Disjoint sets don't conflict.
+	require.False(t, conflictsWithSets(set3, []InputSet{set1, set2}))
+
+	// A wallet utxo selected by two sets makes them conflict, whichever
+	// of the other sets spends it.
+	utxo := createP2WKHInput(20_000)
+	for _, set := range []*BudgetInputSet{set1, set3} {
+		set.inputs = append(set.inputs, &SweeperInput{Input: utxo})
+	}
+	require.True(t, conflictsWithSets(set3, []InputSet{set1, set2}))
+	require.True(t, conflictsWithSets(set3, []InputSet{set2, set1}))
+}
+
+// TestAddWalletInputsCheckpoint checks that the wallet inputs selected for a
+// budget set are checkpointed, and restored for the same set after a restart
+// instead of selecting other utxos, until any of the inputs is spent.
+func TestAddWalletInputsCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	cdb, err := channeldb.MakeTestDB(t)
+	require.NoError(t, err)
+	store, err := NewSweeperStore(cdb, &chainhash.Hash{})
+	require.NoError(t, err)
+
+	wallet := &mockUtxoWallet{utxos: []*lnwallet.Utxo{{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       20_000,
+		OutPoint:    wire.OutPoint{Index: 1},
+	}}}
+
+	s := New(&UtxoSweeperConfig{
+		Wallet: wallet,
+		Store:  store,
+	})
+
+	// newSet returns a set whose required output borrows 10k sats of
+	// budget.
+	reqInp := &reqInput{
+		Input: createP2WKHInput(100_000),
+		txOut: &wire.TxOut{Value: 100_000},
+	}
+	newSet := func() *BudgetInputSet {
+		return &BudgetInputSet{
+			inputs: []*SweeperInput{{
+				Input:  reqInp,
+				params: Params{Budget: 10_000},
+			}},
+			deadlineHeight: testHeight,
+		}
+	}
+
+	set := newSet()
+	require.NoError(t, s.addWalletInputs(set))
+	require.Len(t, set.inputs, 2)
+
+	// After a restart, the wallet lists a smaller utxo first, but the
+	// checkpointed utxo is spent again.
+	wallet.utxos = append([]*lnwallet.Utxo{{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       15_000,
+		OutPoint:    wire.OutPoint{Index: 2},
+	}}, wallet.utxos...)
+
+	restoredSet := newSet()
+	require.NoError(t, s.addWalletInputs(restoredSet))
+	require.Len(t, restoredSet.inputs, 2)
+	require.Equal(t, wire.OutPoint{Index: 1},
+		restoredSet.inputs[1].OutPoint())
+
+	setID, err := restoredSet.checkpointID()
+	require.NoError(t, err)
+
+	// A third party spending the input deletes the checkpoint too.
+	s.inputs = InputsMap{
+		reqInp.OutPoint(): &SweeperInput{
+			Input: reqInp,
+			state: Published,
+		},
+	}
+	thirdPartyTx := &wire.MsgTx{TxIn: []*wire.TxIn{
+		{PreviousOutPoint: reqInp.OutPoint()},
+	}}
+	thirdPartyTxid := thirdPartyTx.TxHash()
+	s.handleInputSpent(&chainntnfs.SpendDetail{
+		SpenderTxHash: &thirdPartyTxid,
+		SpendingTx:    thirdPartyTx,
+	})
+	_, err = store.FetchWalletInputs(setID)
+	require.ErrorIs(t, err, ErrWalletInputsNotFound)
+
+	// Once our sweeping tx confirms, the checkpoint is deleted.
+	require.NoError(t, s.addWalletInputs(newSet()))
+	_, err = store.FetchWalletInputs(setID)
+	require.NoError(t, err)
+
+	s.inputs = InputsMap{
+		reqInp.OutPoint(): &SweeperInput{
+			Input: reqInp,
+			state: Published,
+		},
+	}
+	sweepTx := &wire.MsgTx{TxIn: []*wire.TxIn{
+		{PreviousOutPoint: reqInp.OutPoint()},
+		{PreviousOutPoint: wire.OutPoint{Index: 1}},
+	}}
+	txid := sweepTx.TxHash()
+	require.NoError(t, store.StoreTx(&TxRecord{Txid: txid}))
+
+	s.handleInputSpent(&chainntnfs.SpendDetail{
+		SpenderTxHash: &txid,
+		SpendingTx:    sweepTx,
+	})
+	require.Equal(t, Swept, s.inputs[reqInp.OutPoint()].state)
+
+	_, err = store.FetchWalletInputs(setID)
+	require.ErrorIs(t, err, ErrWalletInputsNotFound)
+}
+
 // TestHandleBumpEventTxFailed checks that the sweeper correctly handles the
 // case where the bump event tx fails to be published.
 func TestHandleBumpEventTxFailed(t *testing.T) {
 	t.Parallel()
 
+	// Create a mock store.
+	store := &MockSweeperStore{}
+	defer store.AssertExpectations(t)
+
 	// Create a test sweeper.
-	s := New(&UtxoSweeperConfig{})
+	s := New(&UtxoSweeperConfig{
+		Store: store,
+	})
 
 	var (
 		// Create four testing outpoints.
@@ -2779,6 +3194,12 @@ func TestHandleBumpEventTxFailed(t *testing.T) {
 		opNotExist = wire.OutPoint{Hash: chainhash.Hash{4}}
 	)
 
+	// The checkpoints of the sets spending the inputs of the failed tx
+	// are deleted.
+	store.On("DeleteWalletInputsSpending", []wire.OutPoint{
+		op1, op2, opNotExist,
+	}).Return(nil).Once()
+
 	// Create three mock inputs.
 	input1 := &input.MockInput{}
 	defer input1.AssertExpectations(t)
@@ -3143,3 +3564,57 @@ func TestMonitorFeeBumpResult(t *testing.T) {
 		})
 	}
 }
+
+// TestChangeScripts checks that the sweeper generates a script for each change
+// output of a change policy.
+func TestChangeScripts(t *testing.T) {
+	t.Parallel()
+
+	s := New(&UtxoSweeperConfig{})
+
+	// A single change output of the default account pays to the current
+	// output script, so no script is generated.
+	scripts, err := s.changeScripts(ChangePolicy{}, 100_000)
+	require.NoError(t, err)
+	require.Nil(t, scripts)
+
+	// Distributing the change needs a script generator.
+	policy := ChangePolicy{
+		Distribution: map[string]float64{
+			"b": 0.5,
+			"a": 0.5,
+		},
+	}
+	_, err = s.changeScripts(policy, 100_000)
+	require.ErrorContains(t, err, "no change script generator")
+
+	// A script is generated for each account, sorted by name.
+	s.cfg.GenChangeScript = func(account string,
+		addrType lnwallet.AddressType) ([]byte, error) {
+
+		return []byte(fmt.Sprintf("%v:%v", account, addrType)), nil
+	}
+	scripts, err = s.changeScripts(policy, 100_000)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{
+		[]byte(fmt.Sprintf("a:%v", lnwallet.TaprootPubkey)),
+		[]byte(fmt.Sprintf("b:%v", lnwallet.TaprootPubkey)),
+	}, scripts)
+
+	// A script is generated for each output of an account when its share
+	// exceeds the max change value.
+	policy.MaxChangeValue = 30_000
+	scripts, err = s.changeScripts(policy, 100_000)
+	require.NoError(t, err)
+	require.Len(t, scripts, 4)
+
+	// A script of the change type is generated even for a single output
+	// of the default account, as the current output script is p2tr.
+	scripts, err = s.changeScripts(
+		ChangePolicy{ChangeType: lnwallet.WitnessPubKey}, 100_000,
+	)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{
+		[]byte(fmt.Sprintf(":%v", lnwallet.WitnessPubKey)),
+	}, scripts)
+}
@@ -3,6 +3,7 @@ package sweep
 import (
 	"fmt"
 	"math"
+	"math/rand"
 	"sort"
 
 	"github.com/btcsuite/btcd/btcutil"
@@ -10,6 +11,7 @@ import (
 	"github.com/btcsuite/btcd/wire"
 	"github.com/lightningnetwork/lnd/fn"
 	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/lntypes"
 	"github.com/lightningnetwork/lnd/lnwallet"
 	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
 )
@@ -31,18 +33,177 @@ const (
 	constraintsForce
 )
 
-var (
-	// ErrNotEnoughInputs is returned when there are not enough wallet
-	// inputs to construct a non-dust change output for an input set.
-	ErrNotEnoughInputs = fmt.Errorf("not enough inputs")
+// inputSufficiency describes whether the inputs of a set are enough to create
+// a valid tx.
+type inputSufficiency uint8
 
-	// ErrDeadlinesMismatch is returned when the deadlines of the input
-	// sets do not match.
-	ErrDeadlinesMismatch = fmt.Errorf("deadlines mismatch")
+const (
+	// inputsEnough means the inputs pay the fees and create at least one
+	// output above the dust limit.
+	inputsEnough inputSufficiency = iota
+
+	// inputsNegativeChange means the inputs don't even cover the required
+	// outputs and the fees, i.e., the change is negative. The set can't
+	// be swept on its own, but wallet inputs can make up for the
+	// difference.
+	inputsNegativeChange
+
+	// inputsInsufficient means the set has no inputs, or its inputs cover
+	// the required outputs and the fees but leave no output above the
+	// dust limit.
+	inputsInsufficient
+)
 
-	// ErrDustOutput is returned when the output value is below the dust
-	// limit.
-	ErrDustOutput = fmt.Errorf("dust output")
+// String returns a human readable description of the input sufficiency.
+func (s inputSufficiency) String() string {
+	switch s {
+	case inputsEnough:
+		return "Enough"
+
+	case inputsNegativeChange:
+		return "NegativeChange"
+
+	case inputsInsufficient:
+		return "Insufficient"
+
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(s))
+	}
+}
+
+// addRejectReason describes why an input was rejected when adding it to the
+// set.
+type addRejectReason uint8
+
+const (
+	// rejectNone means the input was not rejected.
+	rejectNone addRejectReason = iota
+
+	// rejectMaxInputs means the set already holds the max number of
+	// inputs.
+	rejectMaxInputs
+
+	// rejectDuplicate means the input is already in the set.
+	rejectDuplicate
+
+	// rejectDustOutput means the required output of the input is dust.
+	rejectDustOutput
+
+	// rejectUnknownWeight means the weight of the input can't be
+	// estimated.
+	rejectUnknownWeight
+
+	// rejectNegativeYield means adding the input doesn't increase the
+	// output value of the tx.
+	rejectNegativeYield
+
+	// rejectBelowMinYield means the yield of the input is below the
+	// configured min yield.
+	rejectBelowMinYield
+
+	// rejectWalletLoss means adding the wallet input would make us spend
+	// more from the wallet than we get out of the tx.
+	rejectWalletLoss
+
+	// rejectMalformed means the input is missing its sign descriptor or
+	// the output it spends.
+	rejectMalformed
+
+	// rejectFeeCapExceeded means the fee added by the input exceeds the
+	// configured max fee per input.
+	rejectFeeCapExceeded
+
+	// rejectImmature means the time locks of the input haven't expired
+	// yet at the current height.
+	rejectImmature
+
+	// rejectUnexpectedScript means the required output of the input pays
+	// to a script that doesn't match any of the expected script classes.
+	rejectUnexpectedScript
+
+	// rejectAnchorFee means the value of the input isn't fully paid out to
+	// its required output, so the tx with an ephemeral anchor would pay a
+	// fee.
+	rejectAnchorFee
+)
+
+// String returns a human readable description of the reject reason.
+func (r addRejectReason) String() string {
+	switch r {
+	case rejectNone:
+		return "None"
+
+	case rejectMaxInputs:
+		return "MaxInputs"
+
+	case rejectDuplicate:
+		return "Duplicate"
+
+	case rejectDustOutput:
+		return "DustOutput"
+
+	case rejectUnknownWeight:
+		return "UnknownWeight"
+
+	case rejectNegativeYield:
+		return "NegativeYield"
+
+	case rejectBelowMinYield:
+		return "BelowMinYield"
+
+	case rejectWalletLoss:
+		return "WalletLoss"
+
+	case rejectMalformed:
+		return "Malformed"
+
+	case rejectFeeCapExceeded:
+		return "FeeCapExceeded"
+
+	case rejectImmature:
+		return "Immature"
+
+	case rejectUnexpectedScript:
+		return "UnexpectedScript"
+
+	case rejectAnchorFee:
+		return "AnchorFee"
+
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(r))
+	}
+}
+
+const (
+	// maxTrucTxWeight is the max weight allowed for a v3 (TRUC) tx to be
+	// considered standard, which is 10,000 vbytes.
+	maxTrucTxWeight = 40_000
+
+	// defaultTxVersion is the version of the tx created from a set unless
+	// specified otherwise. Version 2 is required for CSV.
+	defaultTxVersion = int32(2)
+
+	// trucTxVersion is the version that opts the tx in to the TRUC
+	// policy, which imposes additional standardness constraints.
+	trucTxVersion = int32(3)
+
+	// dominantWalletInputPercent is the percentage of the total wallet
+	// input value above which a single wallet input is considered to
+	// dominate the sweep.
+	dominantWalletInputPercent = 80
+
+	// incrementalRelayFeeRate is bitcoind's default incremental relay fee
+	// rate of 1 sat/vb. A replacement tx must pay at least this fee rate
+	// for its own weight on top of the fee paid by the replaced tx.
+	incrementalRelayFeeRate = chainfee.SatPerKWeight(250)
+
+	// defaultRelayFeePerKvB is the min relay fee, in sat/kvB, assumed by
+	// the static dust limit.
+	defaultRelayFeePerKvB = chainfee.SatPerKVByte(1000)
+
+	// defaultWalletMinConfs is the default number of confirmations a
+	// wallet utxo needs to be used as a wallet input.
+	defaultWalletMinConfs = int32(1)
 )
 
 // InputSet defines an interface that's responsible for filtering a set of
@@ -54,6 +215,9 @@ type InputSet interface {
 	// AddWalletInputs adds wallet inputs to the set until a non-dust
 	// change output can be made. Return an error if there are not enough
 	// wallet inputs.
+	//
+	// NOTE: must be called with the wallet lock held via
+	// `WithCoinSelectLock`.
 	AddWalletInputs(wallet Wallet) error
 
 	// NeedWalletInput returns true if the input set needs more wallet
@@ -81,6 +245,46 @@ type InputSet interface {
 	// StartingFeeRate returns the max starting fee rate found in the
 	// inputs.
 	StartingFeeRate() fn.Option[chainfee.SatPerKWeight]
+
+	// IsReplaceable returns true if the tx created from the set should
+	// signal replaceability via the nSequence of its inputs.
+	IsReplaceable() bool
+
+	// TxVersion returns the version of the tx created from the set.
+	TxVersion() int32
+
+	// LockTime returns the lock time of the tx created from the set, if
+	// one is set. Otherwise, the tx builder picks the lock time.
+	LockTime() fn.Option[uint32]
+}
+
+// setValidator is implemented by the input sets that run sanity checks before
+// their tx is created. The sweeper skips a set failing them.
+type setValidator interface {
+	// Validate runs all the sanity checks relevant to the set at the
+	// given block height and returns the first failure found.
+	Validate(currentHeight int32) error
+}
+
+// estimateFeeAt estimates the fee and weight of a tx spending the given inputs
+// at the given fee rate. A p2tr change output is always assumed.
+func estimateFeeAt(inputs []input.Input,
+	feeRate chainfee.SatPerKWeight) (btcutil.Amount, int, error) {
+
+	estimator := newWeightEstimator(feeRate, 0)
+	for _, inp := range inputs {
+		if err := estimator.add(inp); err != nil {
+			return 0, 0, fmt.Errorf("estimate input=%v: %w",
+				inp.OutPoint(), err)
+		}
+
+		if r := inp.RequiredTxOut(); r != nil {
+			estimator.addOutput(r)
+		}
+	}
+	estimator.addP2TROutput()
+
+	return estimator.fee(), estimator.weight(), nil
 }
 
 type txInputSetState struct {
@@ -107,22 +311,167 @@ type txInputSetState struct {
 	// inputs is the set of tx inputs.
 	inputs []input.Input
 
+	// inputWeights caches the weight estimation parameters of the inputs.
+	// It's index-aligned with inputs.
+	inputWeights []inputWeight
+
+	// inputConstraints records the constraints each input was added with.
+	// It's index-aligned with inputs.
+	inputConstraints []addConstraints
+
+	// inputsEstimate is the running weight estimate of the inputs and
+	// their required outputs, excluding the change output. It's updated
+	// incrementally when an input is added so the tx fee can be
+	// recomputed without re-estimating the weight of all the inputs.
+	inputsEstimate *weightEstimator
+
 	// walletInputTotal is the total value of inputs coming from the wallet.
 	walletInputTotal btcutil.Amount
 
 	// force indicates that this set must be swept even if the total yield
 	// is negative.
 	force bool
+
+	// changePolicy describes how the change is paid out. It's handed to
+	// the tx builder along with the inputs.
+	changePolicy ChangePolicy
+
+	// relayFeeProvider is an optional provider of the min relay fee, used
+	// to derive the dust limit from the current mempool policy. When nil,
+	// the static dust limit is used.
+	relayFeeProvider RelayFeeProvider
 }
 
 // weightEstimate is the (worst case) tx weight with the current set of
-// inputs. It takes a parameter whether to add a change output or not.
+// inputs. It takes a parameter whether to add a change output or not. If the
+// set has no inputs, an empty estimate is returned and no change output is
+// added, as there's nothing to create a change output from.
 func (t *txInputSetState) weightEstimate(change bool) *weightEstimator {
+	if len(t.inputs) == 0 {
+		return newWeightEstimator(t.feeRate, t.maxFeeRate)
+	}
+
+	// Start from the running estimate of the inputs if we have one,
+	// otherwise build it from scratch.
+	var weightEstimate *weightEstimator
+	if t.inputsEstimate != nil {
+		weightEstimate = t.inputsEstimate.clone()
+	} else {
+		weightEstimate = t.fullWeightEstimate()
+	}
+
+	// Make sure the current fee rates are used.
+	weightEstimate.feeRate = t.feeRate
+	weightEstimate.maxFeeRate = t.maxFeeRate
+
+	// Add the change outputs to the weight estimate if requested. An
+	// ephemeral anchor replaces them.
+	switch {
+	case change && t.changePolicy.EphemeralAnchor:
+		weightEstimate.addOutput(ephemeralAnchorOutput())
+
+	case change:
+		for i := 0; i < t.numChangeOutputs(); i++ {
+			weightEstimate.addChangeOutput(t.changePolicy.ChangeType)
+		}
+	}
+
+	return weightEstimate
+}
+
+// changeOutputCounts returns the number of change outputs of each account,
+// which are sorted by name. They're derived from the change before fees, as
+// the change is only known once the fee is.
+func (t *txInputSetState) changeOutputCounts() []int {
+	return t.changePolicy.outputCounts(t.inputTotal - t.requiredOutput)
+}
+
+// numChangeOutputs returns the number of change outputs of the tx, which is
+// one per account when the change is distributed across accounts, unless the
+// change of an account is split to respect the max change value.
+func (t *txInputSetState) numChangeOutputs() int {
+	total := 0
+	for _, count := range t.changeOutputCounts() {
+		total += count
+	}
+
+	return total
+}
+
+// dustLimitForRelayFee returns the dust limit of an output with the given
+// script size. The static dust limit assumes the default min relay fee of
+// 1000 sat/kvB. If a relay fee provider is given and its fee rate is higher,
+// the limit is scaled up accordingly, matching how the mempool policy derives
+// the dust threshold from the min relay fee. The static limit is never
+// lowered.
+func dustLimitForRelayFee(scriptSize int,
+	provider RelayFeeProvider) btcutil.Amount {
+
+	dustLimit := lnwallet.DustLimitForSize(scriptSize)
+	if provider == nil {
+		return dustLimit
+	}
+
+	// The fee rate floor is the default relay fee rounded up to sat/kw, so
+	// we treat it as the default to avoid inflating the limit.
+	relayFee := provider.RelayFeePerKW().FeePerKVByte()
+	if relayFee <= chainfee.FeePerKwFloor.FeePerKVByte() {
+		return dustLimit
+	}
+
+	scaled := math.Ceil(
+		float64(dustLimit) * float64(relayFee) /
+			float64(defaultRelayFeePerKvB),
+	)
+
+	return max(dustLimit, btcutil.Amount(scaled))
+}
+
+// dustLimit returns the dust limit of an output with the given script size,
+// taking the relay fee into account if a provider is set.
+func (t *txInputSetState) dustLimit(scriptSize int) btcutil.Amount {
+	return dustLimitForRelayFee(scriptSize, t.relayFeeProvider)
+}
+
+// changeDustLimit returns the min change value needed so that every change
+// output is above the dust limit. When the change is distributed across
+// accounts or split into multiple outputs, the smallest output must still be
+// above dust.
+func (t *txInputSetState) changeDustLimit() btcutil.Amount {
+	// The ephemeral anchor has no value, so it's not subject to the dust
+	// limit.
+	if t.changePolicy.EphemeralAnchor {
+		return 0
+	}
+
+	dustLimit := t.dustLimit(changeScriptSize(t.changePolicy.ChangeType))
+
+	accounts, dist := t.changePolicy.accounts()
+	counts := t.changeOutputCounts()
+
+	var limit btcutil.Amount
+	for i, account := range accounts {
+		// The share of the account is split into counts[i] outputs,
+		// each of which must be above dust.
+		needed := math.Ceil(
+			float64(dustLimit) * float64(counts[i]) / dist[account],
+		)
+		limit = max(limit, btcutil.Amount(needed))
+	}
+
+	// The change must also be large enough to be used as a CPFP anchor
+	// if requested.
+	return max(limit, t.changePolicy.MinChange)
+}
+
+// fullWeightEstimate builds the weight estimate of the inputs and their
+// required outputs from scratch, excluding the change output.
+func (t *txInputSetState) fullWeightEstimate() *weightEstimator {
 	weightEstimate := newWeightEstimator(t.feeRate, t.maxFeeRate)
-	for _, i := range t.inputs {
-		// Can ignore error, because it has already been checked when
-		// calculating the yields.
-		_ = weightEstimate.add(i)
+	for idx, i := range t.inputs {
+		// Use the cached weight of the input, which has been computed
+		// when the input was added to the set.
+		weightEstimate.addWithWeight(i, t.inputWeights[idx])
 
 		r := i.RequiredTxOut()
 		if r != nil {
@@ -130,11 +479,6 @@ func (t *txInputSetState) weightEstimate(change bool) *weightEstimator {
 		}
 	}
 
-	// Add a change output to the weight estimate if requested.
-	if change {
-		weightEstimate.addP2TROutput()
-	}
-
 	return weightEstimate
 }
 
@@ -148,46 +492,430 @@ func (t *txInputSetState) totalOutput() btcutil.Amount {
 func (t *txInputSetState) clone() txInputSetState {
 	s := txInputSetState{
 		feeRate:          t.feeRate,
+		maxFeeRate:       t.maxFeeRate,
 		inputTotal:       t.inputTotal,
 		changeOutput:     t.changeOutput,
 		requiredOutput:   t.requiredOutput,
 		walletInputTotal: t.walletInputTotal,
 		force:            t.force,
 		inputs:           make([]input.Input, len(t.inputs)),
+		inputWeights:     make([]inputWeight, len(t.inputWeights)),
+		inputConstraints: make(
+			[]addConstraints, len(t.inputConstraints),
+		),
+
+		// The distribution is never modified so the policy can be
+		// shared.
+		changePolicy:     t.changePolicy,
+		relayFeeProvider: t.relayFeeProvider,
 	}
 	copy(s.inputs, t.inputs)
+	copy(s.inputWeights, t.inputWeights)
+	copy(s.inputConstraints, t.inputConstraints)
+
+	if t.inputsEstimate != nil {
+		s.inputsEstimate = t.inputsEstimate.clone()
+	}
 
 	return s
 }
 
+// txInputSetConfig houses the settings of a txInputSet, which are fixed once
+// the set is created. The zero value gives the defaults.
+type txInputSetConfig struct {
+	// maxInputs is the maximum number of inputs that will be accepted in
+	// the set.
+	maxInputs uint32
+
+	// minYield is the minimum yield a regular input must provide to be
+	// accepted in the set. Inputs whose yield is positive but below this
+	// threshold are rejected to avoid wasting an input slot on negligible
+	// gains.
+	minYield btcutil.Amount
+
+	// isLeased is an optional checker used to skip wallet utxos that are
+	// leased by other subsystems. When nil, all wallet utxos are
+	// considered.
+	isLeased LeaseChecker
+
+	// maxForceSubsidy is the max wallet value that can be spent to cover
+	// the negative change caused by force sweeps. A zero value means no
+	// limit.
+	maxForceSubsidy btcutil.Amount
+
+	// maxFeePerInput is the max fee a regular input without a required
+	// output may add to the tx. A zero value means no limit.
+	maxFeePerInput btcutil.Amount
+
+	// maxFeeToChangeRatio is the max ratio of the fee to the change of
+	// the tx. Above it, more wallet inputs are added to raise the change,
+	// or the change is dropped to the fees. Zero means no max.
+	maxFeeToChangeRatio float64
+
+	// minConfs is the min number of confirmations of the wallet utxos
+	// used as wallet inputs. Zero means defaultWalletMinConfs.
+	minConfs int32
+
+	// confBucket is the optional range of confirmation depths the wallet
+	// utxos used as wallet inputs must be within.
+	confBucket fn.Option[ConfirmationBucket]
+
+	// minWalletInputValue is the min value of the wallet utxos used as
+	// wallet inputs. Smaller utxos are never considered. Zero means no
+	// min.
+	minWalletInputValue btcutil.Amount
+
+	// utxoScorer is an optional scorer used to spend the wallet utxos
+	// with lower scores first. When nil, the utxos are ordered by value.
+	utxoScorer UtxoScorer
+
+	// sweepAccount is the optional wallet account the wallet inputs are
+	// taken from first. The default account is used as a fallback.
+	sweepAccount string
+
+	// maxAncestors is the max number of unconfirmed ancestors of the
+	// wallet utxos used as wallet inputs. Zero means no max.
+	maxAncestors uint32
+
+	// maxOutputs is the max number of outputs of the tx created from the
+	// set. Zero means no max.
+	maxOutputs int
+
+	// maxTxVSize is the max virtual size in vbytes of the tx created from
+	// the set. Zero means no max.
+	maxTxVSize int
+
+	// ownChangeHint holds the change outputs of prior sweeps, which are
+	// selected ahead of the other wallet utxos when adding wallet inputs,
+	// so the sweep change is recycled.
+	ownChangeHint []wire.OutPoint
+
+	// requiredScriptClasses are the script classes the required outputs
+	// of the inputs must match. Empty means any script.
+	requiredScriptClasses []txscript.ScriptClass
+
+	// recorder is an optional recorder for the warnings emitted when
+	// adding wallet inputs.
+	recorder WarningRecorder
+
+	// futureFeeRate is an optional fee rate used to prioritize the wallet
+	// utxos that would be uneconomical to spend at that rate, so they are
+	// consolidated while fees are low. Zero disables the prioritization.
+	futureFeeRate chainfee.SatPerKWeight
+
+	// nonReplaceable indicates the tx created from the set should opt out
+	// of replaceability. Defaults to false, i.e., replaceable.
+	nonReplaceable bool
+
+	// txVersion is the version of the tx created from the set. Zero means
+	// the default version 2 is used. As for BudgetInputSetConfig, the
+	// wallet inputs of a v3 set are always confirmed.
+	txVersion int32
+
+	// weightReserve is the extra weight, on top of the estimated tx
+	// weight, whose fee must be covered by the set. This holds back
+	// enough value so that a later RBF replacement with an extra input
+	// can still relay. Defaults to zero.
+	weightReserve int
+
+	// displayUnit is the unit used to render the amounts in the
+	// description of the set.
+	displayUnit DisplayUnit
+
+	// rankByYieldPerWeight indicates the regular inputs should be added
+	// in descending order of their yield per weight unit instead of the
+	// order they are given in.
+	rankByYieldPerWeight bool
+
+	// safeMode indicates Validate should refuse a set whose fees exceed
+	// the value it recovers in its change output, unless a force sweep
+	// or a required output justifies it.
+	safeMode bool
+
+	// currentHeight is the current block height used to reject the
+	// inputs whose time locks haven't expired yet. Zero disables the
+	// maturity check.
+	currentHeight int32
+
+	// changePolicy is the policy the tx builder follows to pay out the
+	// change of the set, which seeds the state of the set. Only one of a
+	// change distribution or a max change value, a CPFP anchor, an
+	// ephemeral anchor and a target output count can be used.
+	changePolicy ChangePolicy
+
+	// relayFeeProvider is an optional provider of the min relay fee, used
+	// to derive the dust limit of the outputs from the current mempool
+	// policy, which seeds the state of the set.
+	relayFeeProvider RelayFeeProvider
+}
+
 // txInputSet is an object that accumulates tx inputs and keeps running counters
 // on various properties of the tx.
 type txInputSet struct {
 	txInputSetState
 
-	// maxInputs is the maximum number of inputs that will be accepted in
-	// the set.
-	maxInputs uint32
+	// cfg holds the settings of the set.
+	cfg txInputSetConfig
+
+	// ownChange holds the change outputs of prior sweeps, which are
+	// preferred as wallet inputs.
+	ownChange fn.Set[wire.OutPoint]
+
+	// lockTime is the optional lock time of the tx created from the set.
+	// When none, the tx builder picks the lock time.
+	lockTime fn.Option[uint32]
 }
 
 // Compile-time constraint to ensure txInputSet implements InputSet.
 var _ InputSet = (*txInputSet)(nil)
 
-// newTxInputSet constructs a new, empty input set.
+// Compile-time constraint to ensure txInputSet implements changePolicySet.
+var _ changePolicySet = (*txInputSet)(nil)
+
+// validate checks the settings of the config, using the given dust limit of
+// the change outputs.
+func (c *txInputSetConfig) validate(changeDustLimit btcutil.Amount) error {
+	ratio := c.maxFeeToChangeRatio
+	if ratio < 0 || math.IsNaN(ratio) || math.IsInf(ratio, 0) {
+		return fmt.Errorf("invalid fee to change ratio: %v", ratio)
+	}
+
+	if c.txVersion != 0 {
+		if err := validateTxVersion(c.txVersion); err != nil {
+			return err
+		}
+	}
+
+	if c.confBucket.IsSome() {
+		if err := c.confBucket.UnsafeFromSome().validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.changePolicy.EphemeralAnchor && c.txVersion != trucTxVersion {
+		return fmt.Errorf("%w: tx version=%v",
+			ErrInvalidEphemeralAnchor, c.txVersion)
+	}
+
+	return c.changePolicy.validate(changeDustLimit)
+}
+
+// newTxInputSet constructs a new, empty input set with the given settings.
 func newTxInputSet(feePerKW, maxFeeRate chainfee.SatPerKWeight,
-	maxInputs uint32) *txInputSet {
+	cfg txInputSetConfig) (*txInputSet, error) {
+
+	// The distribution is shared by the clones of the state, so copy it
+	// to make sure it's never modified.
+	if cfg.changePolicy.Distribution != nil {
+		dist := make(
+			map[string]float64, len(cfg.changePolicy.Distribution),
+		)
+		for account, fraction := range cfg.changePolicy.Distribution {
+			dist[account] = fraction
+		}
+		cfg.changePolicy.Distribution = dist
+	}
 
 	state := txInputSetState{
-		feeRate:    feePerKW,
-		maxFeeRate: maxFeeRate,
+		feeRate:          feePerKW,
+		maxFeeRate:       maxFeeRate,
+		inputsEstimate:   newWeightEstimator(feePerKW, maxFeeRate),
+		changePolicy:     cfg.changePolicy,
+		relayFeeProvider: cfg.relayFeeProvider,
+	}
+
+	// The dust limit of the change outputs depends on their type and the
+	// relay fee, so it's derived from the state.
+	err := cfg.validate(
+		state.dustLimit(changeScriptSize(cfg.changePolicy.ChangeType)),
+	)
+	if err != nil {
+		return nil, err
 	}
 
 	b := txInputSet{
-		maxInputs:       maxInputs,
 		txInputSetState: state,
+		cfg:             cfg,
+		ownChange:       newOwnChangeHint(cfg.ownChangeHint),
 	}
 
-	return &b
+	return &b, nil
+}
+
+// newJitteredTxInputSet constructs a new, empty input set with the given
+// settings, whose fee rate is randomly perturbed by up to the given fraction
+// of it, e.g., 0.05 for 5%, in either direction. The perturbation is drawn
+// once per set from the given source, so sweeps can't be linked by their
+// identical fee rates while the fee rate of a set stays stable. A zero jitter
+// disables it, in which case the source may be nil.
+func newJitteredTxInputSet(feePerKW, maxFeeRate chainfee.SatPerKWeight,
+	jitter float64, source *rand.Rand,
+	cfg txInputSetConfig) (*txInputSet, error) {
+
+	if jitter > 0 {
+		feePerKW = jitterFeeRate(
+			feePerKW, maxFeeRate, jitter, source.Float64(),
+		)
+	}
+
+	return newTxInputSet(feePerKW, maxFeeRate, cfg)
+}
+
+// jitterFeeRate scales the fee rate by 1 + jitter * (2r - 1), where r is a
+// random value in [0, 1). The result is kept within the min relay fee rate and
+// the max fee rate, if any.
+func jitterFeeRate(feeRate, maxFeeRate chainfee.SatPerKWeight, jitter,
+	r float64) chainfee.SatPerKWeight {
+
+	factor := 1 + jitter*(2*r-1)
+	jittered := chainfee.SatPerKWeight(
+		math.Round(float64(feeRate) * factor),
+	)
+
+	jittered = max(jittered, chainfee.FeePerKwFloor)
+	if maxFeeRate > 0 {
+		jittered = min(jittered, maxFeeRate)
+	}
+
+	return jittered
+}
+
+// String returns a human-readable description of the input set. The amounts
+// are rendered in the display unit of the set.
+func (t *txInputSet) String() string {
+	unit := t.cfg.displayUnit
+
+	return fmt.Sprintf("txInputSet(fee_rate=%v, num_inputs=%v, "+
+		"input_total=%v, required_output=%v, change_output=%v, "+
+		"force=%v, inputs=[%v])", t.feeRate, len(t.inputs),
+		unit.Format(t.inputTotal), unit.Format(t.requiredOutput),
+		unit.Format(t.changeOutput), t.force,
+		inputTypeSummary(t.inputs))
+}
+
+// feeToChangeRatioExceeded returns true if the fee of the tx exceeds the max
+// ratio to its change. A change output below the dust limit, or one already
+// dropped, is never checked.
+func (t *txInputSet) feeToChangeRatioExceeded() bool {
+	if t.cfg.maxFeeToChangeRatio == 0 || t.changePolicy.Drop ||
+		t.changePolicy.EphemeralAnchor {
+
+		return false
+	}
+
+	if t.changeOutput < t.changeDustLimit() {
+		return false
+	}
+
+	fee := t.inputTotal - t.requiredOutput - t.changeOutput
+
+	return float64(fee) > t.cfg.maxFeeToChangeRatio*float64(t.changeOutput)
+}
+
+// ChangePolicy returns the policy the tx builder follows to pay out the change
+// of the set.
+func (t *txInputSet) ChangePolicy() ChangePolicy {
+	return t.changePolicy
+}
+
+// ChangeOutputs returns the change outputs of the set, paying to a fresh
+// script generated for each output by genScript. Each account of the change
+// distribution gets its share of the change, and the rounding remainder goes
+// to the last account. If no distribution is set, all the change goes to the
+// default account, which is the empty string. The share of an account is
+// split evenly into multiple outputs if it exceeds the max change value. The
+// outputs are sorted by account name. Return an error if any of the outputs is
+// dust.
+func (t *txInputSet) ChangeOutputs(
+	genScript func(account string) ([]byte, error)) ([]*wire.TxOut,
+	error) {
+
+	// The change has been given up to the fees.
+	if t.changePolicy.Drop {
+		return nil, nil
+	}
+
+	// The change is given up to the fees as well, but the anchor output
+	// takes its place.
+	if t.changePolicy.EphemeralAnchor {
+		return []*wire.TxOut{ephemeralAnchorOutput()}, nil
+	}
+
+	accounts, _ := t.changePolicy.accounts()
+	shares := t.changePolicy.split(t.changeOutput)
+	counts := t.changeOutputCounts()
+
+	dustLimit := t.dustLimit(changeScriptSize(t.changePolicy.ChangeType))
+
+	txOuts := make([]*wire.TxOut, 0, t.numChangeOutputs())
+	for i, account := range accounts {
+		// Split the share evenly, giving one extra satoshi of the
+		// rounding remainder to each of the first outputs so none of
+		// them exceeds the max.
+		count := btcutil.Amount(counts[i])
+		base, remainder := shares[i]/count, shares[i]%count
+
+		for j := btcutil.Amount(0); j < count; j++ {
+			value := base
+			if j < remainder {
+				value++
+			}
+
+			if value < dustLimit {
+				return nil, fmt.Errorf("%w: account=%v has "+
+					"change=%v, dust limit=%v",
+					ErrDustOutput, account, value,
+					dustLimit)
+			}
+
+			pkScript, err := genScript(account)
+			if err != nil {
+				return nil, fmt.Errorf("gen script for "+
+					"account=%v: %w", account, err)
+			}
+
+			txOuts = append(txOuts, &wire.TxOut{
+				Value:    int64(value),
+				PkScript: pkScript,
+			})
+		}
+	}
+
+	return txOuts, nil
+}
+
+// ChangeSpendCost returns the estimated fee needed to spend the change outputs
+// of the set later at the given fee rate. Comparing it to the change tells
+// whether creating change is worth it, or whether it's better to leave the
+// change to the fees now.
+func (t *txInputSet) ChangeSpendCost(
+	feeRate chainfee.SatPerKWeight) btcutil.Amount {
+
+	cost, err := changeSpendCost(
+		effectiveChangeType(t.changePolicy.ChangeType), feeRate,
+	)
+	if err != nil {
+		return 0
+	}
+
+	return cost * btcutil.Amount(t.numChangeOutputs())
+}
+
+// ChangeDecision reports both alternatives for the change of the set: keeping
+// it, which gives its value minus the cost of spending it later at the given
+// fee rate, or dropping it to the fees, which pays its value as extra fee but
+// leaves no utxo behind. Keeping is recommended if it's possible and the
+// change is worth more than its spend cost, or if dropping isn't possible.
+// Dropping is recommended otherwise.
+func (t *txInputSet) ChangeDecision(
+	feeRate chainfee.SatPerKWeight) ChangeDecisionResult {
+
+	return decideChange(
+		t.changeOutput, t.ChangeSpendCost(feeRate),
+		t.changeOutput >= t.changeDustLimit(),
+		t.requiredOutput > 0 && !t.changePolicy.changeMandatory() &&
+			!t.changePolicy.EphemeralAnchor,
+	)
 }
 
 // Inputs returns the inputs that should be used to create a tx.
@@ -209,6 +937,38 @@ func (t *txInputSet) DeadlineHeight() int32 {
 	return 0
 }
 
+// OutputCount returns the number of outputs of the tx created from the set.
+// On top of the required outputs, the change is counted as the number of
+// outputs it's split into, or as the ephemeral anchor taking its place. No
+// change output is counted if it's dropped to the fees or below dust.
+func (t *txInputSet) OutputCount() int {
+	count := 0
+	for _, inp := range t.inputs {
+		if inp.RequiredTxOut() != nil {
+			count++
+		}
+	}
+
+	switch {
+	// The change is given up to the fees.
+	case t.changePolicy.Drop:
+
+	case t.changePolicy.EphemeralAnchor:
+		count++
+
+	case t.changeOutput >= t.changeDustLimit():
+		count += t.numChangeOutputs()
+	}
+
+	return count
+}
+
+// VirtualSize returns the projected virtual size in vbytes of the tx created
+// from the set, including the change output.
+func (t *txInputSet) VirtualSize() int {
+	return virtualSize(t.weightEstimate(true).weight())
+}
+
 // StartingFeeRate returns the max starting fee rate found in the inputs.
 //
 // NOTE: this field is only used for `BudgetInputSet`.
@@ -221,47 +981,360 @@ func (t *txInputSet) NeedWalletInput() bool {
 	return !t.enoughInput()
 }
 
+// IsReplaceable returns true if the tx created from the set signals
+// replaceability, which is the default.
+//
+// NOTE: part of the InputSet interface.
+func (t *txInputSet) IsReplaceable() bool {
+	return !t.cfg.nonReplaceable
+}
+
+// TxVersion returns the version of the tx created from the set, which
+// defaults to 2.
+//
+// NOTE: part of the InputSet interface.
+func (t *txInputSet) TxVersion() int32 {
+	if t.cfg.txVersion == 0 {
+		return defaultTxVersion
+	}
+
+	return t.cfg.txVersion
+}
+
+// SetLockTime sets the lock time of the tx created from the set, e.g., to
+// discourage fee sniping or as required by a protocol. It must be a block
+// height matching the lock time required by the CLTV-encumbered inputs of the
+// set.
+func (t *txInputSet) SetLockTime(lockTime uint32) error {
+	if err := validateLockTime(lockTime, t.inputs); err != nil {
+		return err
+	}
+
+	t.lockTime = fn.Some(lockTime)
+
+	return nil
+}
+
+// SetAntiFeeSnipingLockTime sets the lock time of the tx created from the set
+// to the current height given by the source, occasionally randomized below
+// it, which discourages fee sniping. If an input of the set is
+// CLTV-encumbered, its required lock time is used instead.
+func (t *txInputSet) SetAntiFeeSnipingLockTime(source HeightSource) error {
+	lockTime, err := deriveAntiFeeSnipingLockTime(source, t.inputs)
+	if err != nil {
+		return err
+	}
+
+	return t.SetLockTime(lockTime)
+}
+
+// LockTime returns the lock time of the tx created from the set, if one is
+// set.
+//
+// NOTE: part of the InputSet interface.
+func (t *txInputSet) LockTime() fn.Option[uint32] {
+	return t.lockTime
+}
+
+// IsWalletOnly returns true if the set only consolidates wallet utxos, i.e.,
+// every input was either added as a wallet input, or is a regular input
+// spending a wallet utxo, which has no required output and is not a force
+// sweep. An empty set is not wallet only.
+func (t *txInputSet) IsWalletOnly() bool {
+	if len(t.inputs) == 0 {
+		return false
+	}
+
+	for i, constraints := range t.inputConstraints {
+		switch {
+		case constraints == constraintsWallet:
+			continue
+
+		case constraints == constraintsRegular &&
+			t.inputs[i].RequiredTxOut() == nil &&
+			isWalletUtxoSpend(t.inputs[i].WitnessType()):
+
+			continue
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// Validate checks that the set has accumulated enough inputs to pay the fees
+// and create at least one non-dust output, and that the tx is within the size
+// limits of its version. A set opting out of replaceability must not spend
+// inputs with a CSV delay. In safe mode, it also checks the fees don't exceed
+// the value recovered by the set. The current height is not used.
+func (t *txInputSet) Validate(_ int32) error {
+	if sufficiency := t.inputSufficiency(); sufficiency != inputsEnough {
+		return fmt.Errorf("%w: %v", ErrNotEnoughInputs, sufficiency)
+	}
+
+	weight := t.weightEstimate(true).weight()
+	if err := checkTxVersionWeight(t.TxVersion(), weight); err != nil {
+		return err
+	}
+
+	if err := checkTxVSize(t.VirtualSize(), t.cfg.maxTxVSize); err != nil {
+		return err
+	}
+
+	// Make sure the inputs added since the lock time was set agree with
+	// it.
+	if t.lockTime.IsSome() {
+		lockTime := t.lockTime.UnsafeFromSome()
+		if err := validateLockTime(lockTime, t.inputs); err != nil {
+			return err
+		}
+	}
+
+	err := validateNonReplaceable(t.cfg.nonReplaceable, t.inputs)
+	if err != nil {
+		return err
+	}
+
+	err = checkOutputCount(t.OutputCount(), t.cfg.maxOutputs)
+	if err != nil {
+		return err
+	}
+
+	// In safe mode, make sure we don't pay more in fees than we recover.
+	// Force sweeps and required outputs are exempted, as they are swept
+	// to protect funds rather than to recover value.
+	if !t.cfg.safeMode || t.force || t.requiredOutput > 0 {
+		return nil
+	}
+
+	fee := t.inputTotal - t.requiredOutput - t.changeOutput
+	if fee > t.changeOutput {
+		return fmt.Errorf("%w: fee=%v, change=%v", ErrFeeExceedsValue,
+			fee, t.changeOutput)
+	}
+
+	return nil
+}
+
+// Shortfall returns how much more input value is needed for the set to pay
+// its fees and have at least one non-dust output. Zero is returned if the set
+// already has enough input.
+//
+// NOTE: the returned value doesn't include the fees needed to spend the
+// additional inputs themselves.
+func (t *txInputSet) Shortfall() btcutil.Amount {
+	if t.enoughInput() {
+		return 0
+	}
+
+	// The amount needed to bring the change outputs above the dust limit.
+	dustLimit := t.changeDustLimit()
+	reserveFee := t.reserveFee()
+	shortfall := dustLimit - t.changeOutput + reserveFee
+
+	// The inputs of the tx with an ephemeral anchor are fully paid out to
+	// their required outputs, so no wallet value can make up for them.
+	if t.changePolicy.EphemeralAnchor {
+		return 0
+	}
+
+	// A change output is mandatory if it's used as a CPFP anchor, or a
+	// target output count is set.
+	if t.changePolicy.changeMandatory() {
+		return shortfall
+	}
+
+	// If the set has a required output, we may instead only need enough
+	// to pay the fees for a transaction with no change output.
+	for _, inp := range t.inputs {
+		if inp.RequiredTxOut() == nil {
+			continue
+		}
+
+		fee := t.weightEstimate(false).feeWithParent() + reserveFee
+		noChange := t.requiredOutput + fee - t.inputTotal
+		shortfall = min(shortfall, noChange)
+
+		break
+	}
+
+	return shortfall
+}
+
+// reserveFee returns the fee for the reserved weight at the set's fee rate.
+func (t *txInputSet) reserveFee() btcutil.Amount {
+	return t.feeRate.FeeForWeight(int64(t.cfg.weightReserve))
+}
+
 // enoughInput returns true if we've accumulated enough inputs to pay the fees
-// and have at least one output that meets the dust limit.
+// and have at least one output that meets the dust limit. If a weight reserve
+// is configured, the fee for it must be covered as well. An empty set never
+// has enough inputs.
 func (t *txInputSet) enoughInput() bool {
-	// If we have a change output above dust, then we certainly have enough
+	return t.inputSufficiency() == inputsEnough
+}
+
+// inputSufficiency returns whether the inputs of the set are enough, as
+// described by enoughInput. If not, it tells apart a negative change, which
+// only wallet inputs can make up for, from inputs that cover the required
+// outputs and the fees but leave no output above the dust limit.
+func (t *txInputSet) inputSufficiency() inputSufficiency {
+	// Exit early if the set has no inputs, so we don't evaluate the fees
+	// of a change-only tx.
+	if len(t.inputs) == 0 {
+		log.Tracef("Input set has no inputs")
+
+		return inputsInsufficient
+	}
+
+	// With an ephemeral anchor, the tx pays no fee, so the inputs must be
+	// fully paid out to the required outputs.
+	if t.changePolicy.EphemeralAnchor {
+		if t.changeOutput != 0 || t.requiredOutput == 0 {
+			return inputsInsufficient
+		}
+
+		return inputsEnough
+	}
+
+	// The fee for the reserved weight must be held back in addition to
+	// the fees of the tx.
+	reserveFee := t.reserveFee()
+
+	// If we have change outputs above dust, then we certainly have enough
 	// inputs to the transaction.
-	if t.changeOutput >= lnwallet.DustLimitForSize(input.P2TRSize) {
-		return true
+	dustLimit := t.changeDustLimit()
+	if t.changeOutput-reserveFee >= dustLimit {
+		return inputsEnough
 	}
 
 	// We did not have enough input for a change output. Check if we have
 	// enough input to pay the fees for a transaction with no change
-	// output.
-	fee := t.weightEstimate(false).feeWithParent()
+	// output. If not, the change is negative, e.g., because force sweeps
+	// or required outputs dominate, and wallet inputs are required.
+	fee := t.weightEstimate(false).feeWithParent() + reserveFee
 	if t.inputTotal < t.requiredOutput+fee {
+		return inputsNegativeChange
+	}
+
+	if t.changePolicy.changeMandatory() {
+		return inputsInsufficient
+	}
+
+	// We could pay the fees, but we still need at least one output to be
+	// above the dust limit for the tx to be valid (we assume that these
+	// required outputs only get added if they are above dust)
+	for _, inp := range t.inputs {
+		if inp.RequiredTxOut() != nil {
+			return inputsEnough
+		}
+	}
+
+	return inputsInsufficient
+}
+
+// checkRequiredScript returns an error if the input has a required output
+// whose script doesn't match any of the given script classes. Any script is
+// accepted if no class is given.
+func checkRequiredScript(inp input.Input,
+	classes []txscript.ScriptClass) error {
+
+	reqOut := inp.RequiredTxOut()
+	if reqOut == nil || len(classes) == 0 {
+		return nil
+	}
+
+	class := txscript.GetScriptClass(reqOut.PkScript)
+	for _, expected := range classes {
+		if class == expected {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: input=%v has required output of class %v, "+
+		"expected one of %v", ErrUnexpectedScript, inp.OutPoint(),
+		class, classes)
+}
+
+// isMature returns true if the time locks of the input have expired, so it
+// can be spent by a tx included in the block after the given height. The
+// absolute lock time is checked against the height, as the tx uses it as its
+// lock time, and the relative one against the height hint of the input.
+func isMature(inp input.Input, height int32) bool {
+	lockTime, ok := inp.RequiredLockTime()
+	if ok && lockTime > uint32(height) {
 		return false
 	}
 
-	// We could pay the fees, but we still need at least one output to be
-	// above the dust limit for the tx to be valid (we assume that these
-	// required outputs only get added if they are above dust)
-	for _, inp := range t.inputs {
-		if inp.RequiredTxOut() != nil {
-			return true
-		}
+	csv := inp.BlocksToMaturity()
+	if csv == 0 {
+		return true
 	}
 
-	return false
+	return int64(inp.HeightHint())+int64(csv) <= int64(height)+1
 }
 
-// add adds a new input to the set. It returns a bool indicating whether the
-// input was added to the set. An input is rejected if it decreases the tx
-// output value after paying fees.
+// addToState returns the state that would result from adding the input to the
+// set. If the input is rejected, nil is returned along with the reason. An
+// input is rejected if it decreases the tx output value after paying fees.
 func (t *txInputSet) addToState(inp input.Input,
-	constraints addConstraints) *txInputSetState {
+	constraints addConstraints) (*txInputSetState, addRejectReason) {
+
+	// Reject malformed inputs, as we need the value of the spent output.
+	if err := checkSignDesc(inp); err != nil {
+		log.Errorf("Rejected malformed input=%v: %v", inp.OutPoint(),
+			err)
+
+		return nil, rejectMalformed
+	}
+
+	// Reject the inputs whose witness can't be created as they lack
+	// their preimage.
+	if err := checkPreimage(inp); err != nil {
+		log.Errorf("Rejected malformed input=%v: %v", inp.OutPoint(),
+			err)
+
+		return nil, rejectMalformed
+	}
+
+	// Reject the inputs that can't be spent in the next block yet, so we
+	// don't create a tx with non-final inputs. They can be retried once
+	// they mature.
+	if t.cfg.currentHeight > 0 && !isMature(inp, t.cfg.currentHeight) {
+		log.Debugf("Rejected immature input=%v at height=%v, will "+
+			"retry later", inp.OutPoint(), t.cfg.currentHeight)
+
+		return nil, rejectImmature
+	}
 
 	// Stop if max inputs is reached. Do not count additional wallet inputs,
 	// because we don't know in advance how many we may need.
 	if constraints != constraintsWallet &&
-		uint32(len(t.inputs)) >= t.maxInputs {
+		uint32(len(t.inputs)) >= t.cfg.maxInputs {
 
-		return nil
+		return nil, rejectMaxInputs
+	}
+
+	// Reject the input if it's already in the set, as spending the same
+	// outpoint twice would create an invalid tx.
+	for _, existing := range t.inputs {
+		if existing.OutPoint() != inp.OutPoint() {
+			continue
+		}
+
+		log.Warnf("Rejected duplicate input=%v", inp.OutPoint())
+
+		return nil, rejectDuplicate
+	}
+
+	// Reject the inputs whose required output pays to an unexpected
+	// script, so the swept funds don't land in an unintended script.
+	err := checkRequiredScript(inp, t.cfg.requiredScriptClasses)
+	if err != nil {
+		log.Errorf("Rejected input=%v: %v", inp.OutPoint(), err)
+
+		return nil, rejectUnexpectedScript
 	}
 
 	// If the input comes with a required tx out that is below dust, we
@@ -271,7 +1344,7 @@ func (t *txInputSet) addToState(inp input.Input,
 	reqOut := inp.RequiredTxOut()
 	if reqOut != nil {
 		// Fetch the dust limit for this output.
-		dustLimit := lnwallet.DustLimitForSize(len(reqOut.PkScript))
+		dustLimit := t.dustLimit(len(reqOut.PkScript))
 		if btcutil.Amount(reqOut.Value) < dustLimit {
 			log.Errorf("Rejected input=%v due to dust required "+
 				"output=%v, limit=%v", inp, reqOut.Value,
@@ -285,28 +1358,61 @@ func (t *txInputSet) addToState(inp input.Input,
 			// the request, what's considered non-dust at the
 			// caller side will be dust here, causing a force sweep
 			// to fail.
-			return nil
+			return nil, rejectDustOutput
 		}
 	}
 
+	// Compute the weight estimation parameters of the input once, so they
+	// can be reused every time the tx weight is estimated.
+	iw, err := newInputWeight(inp)
+	if err != nil {
+		log.Errorf("Rejected input=%v due to unknown weight: %v", inp,
+			err)
+
+		return nil, rejectUnknownWeight
+	}
+
 	// Clone the current set state.
 	newSet := t.clone()
 
 	// Add the new input.
 	newSet.inputs = append(newSet.inputs, inp)
+	newSet.inputWeights = append(newSet.inputWeights, iw)
+	newSet.inputConstraints = append(newSet.inputConstraints, constraints)
+
+	// Update the running weight estimate with the new input and its
+	// required output.
+	if newSet.inputsEstimate == nil {
+		newSet.inputsEstimate = newSet.fullWeightEstimate()
+	} else {
+		newSet.inputsEstimate.addWithWeight(inp, iw)
+		if reqOut != nil {
+			newSet.inputsEstimate.addOutput(reqOut)
+		}
+	}
 
 	// Add the value of the new input.
 	value := btcutil.Amount(inp.SignDesc().Output.Value)
 	newSet.inputTotal += value
 
-	// Recalculate the tx fee.
-	fee := newSet.weightEstimate(true).feeWithParent()
-
-	// Calculate the new output value.
+	// Calculate the new output value. This must be done before the fee is
+	// calculated, as the number of change outputs depends on it.
+	//
+	// NOTE: requiredOutput is the sum of all the required outputs, while
+	// each of them is still created as a distinct output of the tx, even
+	// if it shares its pkScript with another one, as it's committed to by
+	// the signature of its own input.
 	if reqOut != nil {
 		newSet.requiredOutput += btcutil.Amount(reqOut.Value)
 	}
 
+	// Recalculate the tx fee. The tx with an ephemeral anchor pays no fee,
+	// as the child spending the anchor pays for both.
+	var fee btcutil.Amount
+	if !newSet.changePolicy.EphemeralAnchor {
+		fee = newSet.weightEstimate(true).feeWithParent()
+	}
+
 	// NOTE: `changeOutput` could be negative here if this input is using
 	// constraintsForce.
 	newSet.changeOutput = newSet.inputTotal - newSet.requiredOutput - fee
@@ -315,6 +1421,17 @@ func (t *txInputSet) addToState(inp input.Input,
 	// value.
 	inputYield := newSet.totalOutput() - t.totalOutput()
 
+	// The value of every input must be fully paid out to its required
+	// output, otherwise the tx with an ephemeral anchor would pay a fee.
+	if t.changePolicy.EphemeralAnchor &&
+		(reqOut == nil || btcutil.Amount(reqOut.Value) != value) {
+
+		log.Debugf("Rejected input=%v not fully paid out to a "+
+			"required output next to an ephemeral anchor", value)
+
+		return nil, rejectAnchorFee
+	}
+
 	switch constraints {
 	// Don't sweep inputs that cost us more to sweep than they give us.
 	case constraintsRegular:
@@ -322,7 +1439,32 @@ func (t *txInputSet) addToState(inp input.Input,
 			log.Debugf("Rejected regular input=%v due to negative "+
 				"yield=%v", value, inputYield)
 
-			return nil
+			return nil, rejectNegativeYield
+		}
+
+		// Don't sweep inputs whose yield is below the configured
+		// threshold.
+		if inputYield < t.cfg.minYield {
+			log.Debugf("Rejected regular input=%v due to yield=%v "+
+				"below min yield=%v", value, inputYield,
+				t.cfg.minYield)
+
+			return nil, rejectBelowMinYield
+		}
+
+		// Don't sweep inputs that add more fees than allowed. The fee
+		// added by the input is the fee for its own weight, excluding
+		// the tx overhead shared by all inputs. Inputs with required
+		// outputs are exempt.
+		inputFee := t.feeRate.FeeForWeight(iw.weight())
+		if t.cfg.maxFeePerInput > 0 && reqOut == nil &&
+			inputFee > t.cfg.maxFeePerInput {
+
+			log.Debugf("Rejected regular input=%v due to fee=%v "+
+				"above max fee per input=%v", value, inputFee,
+				t.cfg.maxFeePerInput)
+
+			return nil, rejectFeeCapExceeded
 		}
 
 	// For force adds, no further constraints apply.
@@ -348,7 +1490,7 @@ func (t *txInputSet) addToState(inp input.Input,
 			log.Debugf("Rejected wallet input=%v due to negative "+
 				"yield=%v", value, inputYield)
 
-			return nil
+			return nil, rejectNegativeYield
 		}
 
 		// Calculate the total value that we spend in this tx from the
@@ -375,63 +1517,244 @@ func (t *txInputSet) addToState(inp input.Input,
 		// TODO(yy): change from `>=` to `>` to allow non-negative
 		// sweeping - we won't gain more coins from this sweep, but
 		// aggregating small UTXOs.
+		//
+		// NOTE: this is reachable even though the yield of the wallet
+		// input is positive, as the totals of the set are compared
+		// rather than the values added by the input. With a single
+		// wallet input, it's hit whenever the output value left by the
+		// other inputs doesn't exceed the fee added by the wallet
+		// input, e.g., a regular input whose yield is small at the fee
+		// rate. Sweeping it would then cost us more from the wallet
+		// than we get out of the tx.
 		if newSet.walletInputTotal >= newSet.totalOutput() {
-			// TODO(yy): further check this case as it seems we can
-			// never reach here because it'd mean `inputYield` is
-			// already <= 0?
 			log.Debugf("Rejecting wallet input of %v, because it "+
 				"would make a negative yielding transaction "+
 				"(%v)", value,
 				newSet.totalOutput()-newSet.walletInputTotal)
 
-			return nil
+			return nil, rejectWalletLoss
 		}
 	}
 
-	return &newSet
+	return &newSet, rejectNone
+}
+
+// preimageInput is an input whose witness reveals a payment preimage, such as
+// an HTLC swept with its preimage from the commitment of the remote party.
+type preimageInput interface {
+	// Preimage returns the payment preimage revealed by the witness.
+	Preimage() []byte
+}
+
+// checkPreimage returns an error if the witness type of the input requires a
+// payment preimage, but the input doesn't carry a 32-byte one. The witness
+// size estimate of such an input accounts for the preimage, but its witness
+// can't be created without it.
+func checkPreimage(inp input.Input) error {
+	switch inp.WitnessType() {
+	case input.HtlcAcceptedRemoteSuccess,
+		input.TaprootHtlcAcceptedRemoteSuccess:
+
+	default:
+		return nil
+	}
+
+	pi, ok := inp.(preimageInput)
+	if !ok {
+		return fmt.Errorf("input %v doesn't provide the preimage "+
+			"required by witness type %v", inp.OutPoint(),
+			inp.WitnessType())
+	}
+
+	if len(pi.Preimage()) != lntypes.PreimageSize {
+		return fmt.Errorf("input %v has preimage of %d bytes, want %d",
+			inp.OutPoint(), len(pi.Preimage()),
+			lntypes.PreimageSize)
+	}
+
+	return nil
+}
+
+// checkSignDesc returns an error if the sign descriptor of the input, or the
+// output it spends, is missing.
+func checkSignDesc(inp input.Input) error {
+	signDesc := inp.SignDesc()
+	if signDesc == nil {
+		return fmt.Errorf("missing sign descriptor")
+	}
+
+	if signDesc.Output == nil {
+		return fmt.Errorf("missing output in sign descriptor")
+	}
+
+	return nil
 }
 
 // add adds a new input to the set. It returns a bool indicating whether the
-// input was added to the set. An input is rejected if it decreases the tx
-// output value after paying fees.
-func (t *txInputSet) add(input input.Input, constraints addConstraints) bool {
-	newState := t.addToState(input, constraints)
+// input was added to the set, and the reason if it was rejected. An input is
+// rejected if it decreases the tx output value after paying fees.
+func (t *txInputSet) add(input input.Input,
+	constraints addConstraints) (bool, addRejectReason) {
+
+	newState, reason := t.addToState(input, constraints)
 	if newState == nil {
-		return false
+		return false, reason
 	}
 
 	t.txInputSetState = *newState
 
-	return true
+	return true, rejectNone
+}
+
+// addGroupToState returns the state that would result from adding all the
+// given inputs to the set. If any of them cannot be added, nil is returned
+// along with the reason the member was rejected, and none of them is added, so
+// the inputs are either all included or not at all.
+func (t *txInputSet) addGroupToState(inputs []input.Input,
+	constraints addConstraints) (*txInputSetState, addRejectReason) {
+
+	// Work on a copy of the set so the intermediate states are discarded
+	// if a member is rejected.
+	tmp := *t
+	for _, inp := range inputs {
+		newState, reason := tmp.addToState(inp, constraints)
+		if newState == nil {
+			log.Debugf("Rejected co-group of %d inputs due to "+
+				"input=%v: %v", len(inputs), inp.OutPoint(),
+				reason)
+
+			return nil, reason
+		}
+
+		tmp.txInputSetState = *newState
+	}
+
+	return &tmp.txInputSetState, rejectNone
+}
+
+// addGroup atomically adds a group of inputs to the set. It returns a bool
+// indicating whether the inputs were added, and the reason if they were
+// rejected.
+func (t *txInputSet) addGroup(inputs []input.Input,
+	constraints addConstraints) (bool, addRejectReason) {
+
+	newState, reason := t.addGroupToState(inputs, constraints)
+	if newState == nil {
+		return false, reason
+	}
+
+	t.txInputSetState = *newState
+
+	return true, rejectNone
+}
+
+// coGroupMembers returns the inputs that belong to the given co-group, along
+// with the constraints to add them with. Force constraints are used if any of
+// the members is a force sweep.
+func coGroupMembers(inputs []*SweeperInput,
+	group uint64) ([]input.Input, addConstraints) {
+
+	var (
+		members     []input.Input
+		constraints = constraintsRegular
+	)
+	for _, inp := range inputs {
+		if inp.CoGroup != fn.Some(group) {
+			continue
+		}
+
+		members = append(members, inp)
+		if inp.parameters().Immediate {
+			constraints = constraintsForce
+		}
+	}
+
+	return members, constraints
 }
 
 // addPositiveYieldInputs adds sweepableInputs that have a positive yield to the
 // input set. This function assumes that the list of inputs is sorted descending
-// by yield.
+// by yield. Inputs of a co-group are added atomically when the first member of
+// the group is encountered, and the group is skipped if it can't be added.
 //
 // TODO(roasbeef): Consider including some negative yield inputs too to clean
 // up the utxo set even if it costs us some fees up front.  In the spirit of
 // minimizing any negative externalities we cause for the Bitcoin system as a
 // whole.
 func (t *txInputSet) addPositiveYieldInputs(sweepableInputs []*SweeperInput) {
+	// Rank the inputs by their yield per weight unit if configured.
+	if t.cfg.rankByYieldPerWeight {
+		sweepableInputs = rankByYieldPerWeight(
+			sweepableInputs, t.feeRate,
+		)
+	}
+
+	// seenGroups tracks the co-groups that have already been handled.
+	seenGroups := make(map[uint64]struct{})
+
 	for i, inp := range sweepableInputs {
+		// If the input belongs to a co-group, add all its members at
+		// once. As the members may have different yields, a rejected
+		// group doesn't stop us from trying the remaining inputs.
+		if inp.CoGroup.IsSome() {
+			group := inp.CoGroup.UnwrapOr(0)
+			if _, ok := seenGroups[group]; ok {
+				continue
+			}
+			seenGroups[group] = struct{}{}
+
+			members, constraints := coGroupMembers(
+				sweepableInputs[i:], group,
+			)
+			added, reason := t.addGroup(members, constraints)
+			if !added {
+				log.Debugf("Co-group %d of %d inputs not "+
+					"added to input set due to %v: %v",
+					group, len(members), reason,
+					inputTypeSummary(members))
+
+				continue
+			}
+
+			log.Debugf("Added co-group %d to input set: %v", group,
+				inputTypeSummary(members))
+
+			continue
+		}
+
 		// Apply relaxed constraints for force sweeps.
 		constraints := constraintsRegular
 		if inp.parameters().Immediate {
 			constraints = constraintsForce
 		}
 
-		// Try to add the input to the transaction. If that doesn't
-		// succeed because it wouldn't increase the output value,
-		// return. Assuming inputs are sorted by yield, any further
-		// inputs wouldn't increase the output value either.
-		if !t.add(inp, constraints) {
+		// Try to add the input to the transaction.
+		added, reason := t.add(inp, constraints)
+		if !added {
+			switch reason {
+			// The rejection is specific to this input, so the
+			// remaining inputs may still be added.
+			case rejectDuplicate, rejectDustOutput,
+				rejectUnknownWeight, rejectMalformed,
+				rejectFeeCapExceeded, rejectImmature,
+				rejectUnexpectedScript:
+
+				log.Debugf("Input %v not added to input set "+
+					"due to %v", inp.OutPoint(), reason)
+
+				continue
+			}
+
+			// Otherwise, the set is full or the input doesn't
+			// increase the output value enough. Assuming inputs
+			// are sorted by yield, any further inputs wouldn't
+			// increase the output value either, so we return.
 			var rem []input.Input
 			for j := i; j < len(sweepableInputs); j++ {
 				rem = append(rem, sweepableInputs[j])
 			}
-			log.Debugf("%d negative yield inputs not added to "+
-				"input set: %v", len(rem),
+			log.Debugf("%d inputs not added to input set due to "+
+				"%v: %v", len(rem), reason,
 				inputTypeSummary(rem))
 			return
 		}
@@ -443,389 +1766,310 @@ func (t *txInputSet) addPositiveYieldInputs(sweepableInputs []*SweeperInput) {
 	// We managed to add all inputs to the set.
 }
 
-// AddWalletInputs adds wallet inputs to the set until a non-dust output can be
-// made. This non-dust output is either a change output or a required output.
-// Return an error if there are not enough wallet inputs.
-func (t *txInputSet) AddWalletInputs(wallet Wallet) error {
-	// Check the current output value and add wallet utxos if needed to
-	// push the output value to the lower limit.
-	if err := t.tryAddWalletInputsIfNeeded(wallet); err != nil {
-		return err
+// YieldPerWeight returns the yield of the input per weight unit at the given
+// fee rate, i.e., its value minus the fee to spend it, divided by the weight
+// it adds to a tx. The weight includes the outpoint, sequence and script sig
+// of the input, and the upper limit of its witness. Zero is returned if the
+// weight of the input is unknown.
+func YieldPerWeight(inp input.Input, feeRate chainfee.SatPerKWeight) float64 {
+	if err := checkSignDesc(inp); err != nil {
+		log.Errorf("Malformed input=%v: %v", inp.OutPoint(), err)
+
+		return 0
 	}
 
-	// If the output value of this block of inputs does not reach the dust
-	// limit, stop sweeping. Because of the sorting, continuing with the
-	// remaining inputs will only lead to sets with an even lower output
-	// value.
-	if !t.enoughInput() {
-		// The change output is always a p2tr here.
-		dl := lnwallet.DustLimitForSize(input.P2TRSize)
-		log.Debugf("Input set value %v (required=%v, change=%v) "+
-			"below dust limit of %v", t.totalOutput(),
-			t.requiredOutput, t.changeOutput, dl)
+	iw, err := newInputWeight(inp)
+	if err != nil {
+		log.Errorf("Failed to get input weight: %v", err)
 
-		return ErrNotEnoughInputs
+		return 0
 	}
 
-	return nil
-}
+	weight := iw.weight()
+	yield := inp.SignDesc().Output.Value -
+		int64(feeRate.FeeForWeight(weight))
 
-// tryAddWalletInputsIfNeeded retrieves utxos from the wallet and tries adding
-// as many as required to bring the tx output value above the given minimum.
-func (t *txInputSet) tryAddWalletInputsIfNeeded(wallet Wallet) error {
-	// If we've already have enough to pay the transaction fees and have at
-	// least one output materialize, no action is needed.
-	if t.enoughInput() {
-		return nil
-	}
+	return float64(yield) / float64(weight)
+}
 
-	// Retrieve wallet utxos. Only consider confirmed utxos to prevent
-	// problems around RBF rules for unconfirmed inputs. This currently
-	// ignores the configured coin selection strategy.
-	utxos, err := wallet.ListUnspentWitnessFromDefaultAccount(
-		1, math.MaxInt32,
-	)
-	if err != nil {
-		return err
+// rankByYieldPerWeight returns a copy of the given inputs where the regular
+// inputs are sorted in descending order of their yield per weight unit at the
+// given fee rate. Force sweeps and pinned inputs keep their order at the start
+// of the list, so they are still added first.
+func rankByYieldPerWeight(inputs []*SweeperInput,
+	feeRate chainfee.SatPerKWeight) []*SweeperInput {
+
+	// prioritized returns true if the input must keep its place before
+	// the regular inputs.
+	prioritized := func(inp *SweeperInput) bool {
+		return inp.parameters().Immediate || inp.parameters().Pinned
 	}
 
-	// Sort the UTXOs by putting smaller values at the start of the slice
-	// to avoid locking large UTXO for sweeping.
-	//
-	// TODO(yy): add more choices to CoinSelectionStrategy and use the
-	// configured value here.
-	sort.Slice(utxos, func(i, j int) bool {
-		return utxos[i].Value < utxos[j].Value
-	})
-
-	for _, utxo := range utxos {
-		input, err := createWalletTxInput(utxo)
-		if err != nil {
-			return err
-		}
+	ranked := make([]*SweeperInput, len(inputs))
+	copy(ranked, inputs)
 
-		// If the wallet input isn't positively-yielding at this fee
-		// rate, skip it.
-		if !t.add(input, constraintsWallet) {
-			continue
+	sort.SliceStable(ranked, func(i, j int) bool {
+		prioI, prioJ := prioritized(ranked[i]), prioritized(ranked[j])
+		if prioI || prioJ {
+			return prioI && !prioJ
 		}
 
-		// Return if we've reached the minimum output amount.
-		if t.enoughInput() {
-			return nil
-		}
-	}
+		return YieldPerWeight(ranked[i], feeRate) >
+			YieldPerWeight(ranked[j], feeRate)
+	})
 
-	// We were not able to reach the minimum output amount.
-	return nil
+	return ranked
 }
 
-// createWalletTxInput converts a wallet utxo into an object that can be added
-// to the other inputs to sweep.
-func createWalletTxInput(utxo *lnwallet.Utxo) (input.Input, error) {
-	signDesc := &input.SignDescriptor{
-		Output: &wire.TxOut{
-			PkScript: utxo.PkScript,
-			Value:    int64(utxo.Value),
-		},
-		HashType: txscript.SigHashAll,
-	}
-
-	var witnessType input.WitnessType
-	switch utxo.AddressType {
-	case lnwallet.WitnessPubKey:
-		witnessType = input.WitnessKeyHash
-	case lnwallet.NestedWitnessPubKey:
-		witnessType = input.NestedWitnessKeyHash
-	case lnwallet.TaprootPubkey:
-		witnessType = input.TaprootPubKeySpend
-		signDesc.HashType = txscript.SigHashDefault
-	default:
-		return nil, fmt.Errorf("unknown address type %v",
-			utxo.AddressType)
-	}
-
-	// A height hint doesn't need to be set, because we don't monitor these
-	// inputs for spend.
-	heightHint := uint32(0)
-
-	return input.NewBaseInput(
-		&utxo.OutPoint, witnessType, signDesc, heightHint,
-	), nil
-}
-
-// BudgetInputSet implements the interface `InputSet`. It takes a list of
-// pending inputs which share the same deadline height and groups them into a
-// set conditionally based on their economical values.
-type BudgetInputSet struct {
-	// inputs is the set of inputs that have been added to the set after
-	// considering their economical contribution.
-	inputs []*SweeperInput
-
-	// deadlineHeight is the height which the inputs in this set must be
-	// confirmed by.
-	deadlineHeight int32
-}
-
-// Compile-time constraint to ensure budgetInputSet implements InputSet.
-var _ InputSet = (*BudgetInputSet)(nil)
-
-// validateInputs is used when creating new BudgetInputSet to ensure there are
-// no duplicate inputs and they all share the same deadline heights, if set.
-func validateInputs(inputs []SweeperInput, deadlineHeight int32) error {
-	// Sanity check the input slice to ensure it's non-empty.
-	if len(inputs) == 0 {
-		return fmt.Errorf("inputs slice is empty")
-	}
-
-	// inputDeadline tracks the input's deadline height. It will be updated
-	// if the input has a different deadline than the specified
-	// deadlineHeight.
-	inputDeadline := deadlineHeight
-
-	// dedupInputs is a set used to track unique outpoints of the inputs.
-	dedupInputs := fn.NewSet(
-		// Iterate all the inputs and map the function.
-		fn.Map(func(inp SweeperInput) wire.OutPoint {
-			// If the input has a deadline height, we'll check if
-			// it's the same as the specified.
-			inp.params.DeadlineHeight.WhenSome(func(h int32) {
-				// Exit early if the deadlines matched.
-				if h == deadlineHeight {
-					return
-				}
-
-				// Update the deadline height if it's
-				// different.
-				inputDeadline = h
-			})
+// trimInputs splits the given inputs into the ones kept in a set of at most
+// maxInputs inputs and the ones trimmed. Pinned inputs are always kept first,
+// and the remaining slots are filled with unpinned inputs in their given
+// order. If there are more pinned inputs than maxInputs, the excess pinned
+// inputs are trimmed as well, but never in favor of an unpinned input. The
+// order of the inputs is preserved in both slices.
+func trimInputs(inputs []SweeperInput,
+	maxInputs uint32) ([]SweeperInput, []SweeperInput) {
+
+	if uint32(len(inputs)) <= maxInputs {
+		return inputs, nil
+	}
 
-			return inp.OutPoint()
-		}, inputs)...,
-	)
+	// Decide which inputs to keep, starting with the pinned ones.
+	keep := make([]bool, len(inputs))
+	slots := maxInputs
+	for _, pinned := range []bool{true, false} {
+		for i, inp := range inputs {
+			if slots == 0 {
+				break
+			}
+
+			if inp.params.Pinned != pinned {
+				continue
+			}
 
-	// Make sure the inputs share the same deadline height when there is
-	// one.
-	if inputDeadline != deadlineHeight {
-		return fmt.Errorf("input deadline height not matched: want "+
-			"%d, got %d", deadlineHeight, inputDeadline)
+			keep[i] = true
+			slots--
+		}
 	}
 
-	// Provide a defensive check to ensure that we don't have any duplicate
-	// inputs within the set.
-	if len(dedupInputs) != len(inputs) {
-		return fmt.Errorf("duplicate inputs")
+	var kept, trimmed []SweeperInput
+	for i, inp := range inputs {
+		if keep[i] {
+			kept = append(kept, inp)
+		} else {
+			trimmed = append(trimmed, inp)
+		}
 	}
 
-	return nil
+	return kept, trimmed
 }
 
-// NewBudgetInputSet creates a new BudgetInputSet.
-func NewBudgetInputSet(inputs []SweeperInput,
-	deadlineHeight int32) (*BudgetInputSet, error) {
-
-	// Validate the supplied inputs.
-	if err := validateInputs(inputs, deadlineHeight); err != nil {
-		return nil, err
+// AddWalletInputs adds wallet inputs to the set until a non-dust output can be
+// made. This non-dust output is either a change output or a required output.
+// Return an error if there are not enough wallet inputs, or if the wallet value
+// spent to cover force sweeps exceeds the max subsidy. If wallet inputs are
+// needed but the wallet has no spendable utxos at all, ErrNoWalletUtxos is
+// returned.
+//
+// NOTE: must be called with the wallet lock held via `WithCoinSelectLock`.
+func (t *txInputSet) AddWalletInputs(wallet Wallet) error {
+	// Check the current output value and add wallet utxos if needed to
+	// push the output value to the lower limit.
+	numUtxos, err := t.tryAddWalletInputsIfNeeded(wallet)
+	if err != nil {
+		return err
 	}
 
-	bi := &BudgetInputSet{
-		deadlineHeight: deadlineHeight,
-		inputs:         make([]*SweeperInput, 0, len(inputs)),
+	// If the output value of this block of inputs does not reach the dust
+	// limit, stop sweeping. Because of the sorting, continuing with the
+	// remaining inputs will only lead to sets with an even lower output
+	// value.
+	if sufficiency := t.inputSufficiency(); sufficiency != inputsEnough {
+		dl := t.changeDustLimit()
+		log.Debugf("Input set value %v (required=%v, change=%v) "+
+			"below dust limit of %v: %v", t.totalOutput(),
+			t.requiredOutput, t.changeOutput, dl, sufficiency)
+
+		return &NotEnoughInputsError{
+			Shortfall:      t.Shortfall(),
+			DeadlineHeight: t.DeadlineHeight(),
+			NumWalletUtxos: numUtxos,
+		}
 	}
 
-	for _, input := range inputs {
-		bi.addInput(input)
+	// Make sure the fee isn't too high compared to the change.
+	if err := t.enforceFeeToChangeRatio(wallet); err != nil {
+		return err
 	}
 
-	log.Tracef("Created %v", bi.String())
+	// If the set contains force sweeps, make sure the wallet value spent
+	// on them doesn't exceed the configured max subsidy.
+	if t.force && t.cfg.maxForceSubsidy > 0 {
+		subsidy := t.walletInputTotal - t.totalOutput()
+		if subsidy > t.cfg.maxForceSubsidy {
+			return fmt.Errorf("%w: subsidy=%v, max=%v",
+				ErrForceSubsidyExceeded, subsidy,
+				t.cfg.maxForceSubsidy)
+		}
+	}
 
-	return bi, nil
+	return nil
 }
 
-// String returns a human-readable description of the input set.
-func (b *BudgetInputSet) String() string {
-	inputsDesc := ""
-	for _, input := range b.inputs {
-		inputsDesc += fmt.Sprintf("\n%v", input)
+// enforceFeeToChangeRatio makes sure the fee of the tx doesn't exceed the max
+// ratio to its change. More wallet inputs are added first to raise the change.
+// If the wallet can't bring the ratio down, those inputs are reverted and the
+// change is dropped to the fees instead, which is only possible if a required
+// output keeps the tx valid. ErrFeeToChangeRatioExceeded is returned
+// otherwise.
+func (t *txInputSet) enforceFeeToChangeRatio(wallet Wallet) error {
+	if !t.feeToChangeRatioExceeded() {
+		return nil
 	}
 
-	return fmt.Sprintf("BudgetInputSet(budget=%v, deadline=%v, "+
-		"inputs=[%v])", b.Budget(), b.DeadlineHeight(), inputsDesc)
-}
-
-// addInput adds an input to the input set.
-func (b *BudgetInputSet) addInput(input SweeperInput) {
-	b.inputs = append(b.inputs, &input)
-}
+	original := t.clone()
 
-// NeedWalletInput returns true if the input set needs more wallet inputs.
-//
-// A set may need wallet inputs when it has a required output or its total
-// value cannot cover its total budget.
-func (b *BudgetInputSet) NeedWalletInput() bool {
-	var (
-		// budgetNeeded is the amount that needs to be covered from
-		// other inputs.
-		budgetNeeded btcutil.Amount
+	addBatch := func(utxos []*lnwallet.Utxo) (bool, error) {
+		for _, utxo := range utxos {
+			input, err := createWalletTxInput(utxo)
+			if err != nil {
+				return false, err
+			}
 
-		// budgetBorrowable is the amount that can be borrowed from
-		// other inputs.
-		budgetBorrowable btcutil.Amount
-	)
+			// Utxos already in the set are rejected as
+			// duplicates.
+			if ok, _ := t.add(input, constraintsWallet); !ok {
+				continue
+			}
 
-	for _, inp := range b.inputs {
-		// If this input has a required output, we can assume it's a
-		// second-level htlc txns input. Although this input must have
-		// a value that can cover its budget, it cannot be used to pay
-		// fees. Instead, we need to borrow budget from other inputs to
-		// make the sweep happen. Once swept, the input value will be
-		// credited to the wallet.
-		if inp.RequiredTxOut() != nil {
-			budgetNeeded += inp.params.Budget
-			continue
+			if !t.feeToChangeRatioExceeded() {
+				return true, nil
+			}
 		}
 
-		// Get the amount left after covering the input's own budget.
-		// This amount can then be lent to the above input.
-		budget := inp.params.Budget
-		output := btcutil.Amount(inp.SignDesc().Output.Value)
-		budgetBorrowable += output - budget
+		return false, nil
+	}
 
-		// If the input's budget is not even covered by itself, we need
-		// to borrow outputs from other inputs.
-		if budgetBorrowable < 0 {
-			log.Debugf("Input %v specified a budget that exceeds "+
-				"its output value: %v > %v", inp, budget,
-				output)
-		}
+	_, err := forEachWalletUtxoBatch(wallet, t.utxoFilter(), addBatch)
+	if err != nil {
+		t.txInputSetState = original
+
+		return err
 	}
 
-	log.Tracef("NeedWalletInput: budgetNeeded=%v, budgetBorrowable=%v",
-		budgetNeeded, budgetBorrowable)
+	if !t.feeToChangeRatioExceeded() {
+		return nil
+	}
 
-	// If we don't have enough extra budget to borrow, we need wallet
-	// inputs.
-	return budgetBorrowable < budgetNeeded
-}
+	// The wallet couldn't fix the ratio, so revert the inputs added and
+	// try to drop the change instead.
+	t.txInputSetState = original
 
-// copyInputs returns a copy of the slice of the inputs in the set.
-func (b *BudgetInputSet) copyInputs() []*SweeperInput {
-	inputs := make([]*SweeperInput, len(b.inputs))
-	copy(inputs, b.inputs)
-	return inputs
-}
+	if t.changePolicy.changeMandatory() || t.requiredOutput == 0 {
+		fee := t.inputTotal - t.requiredOutput - t.changeOutput
 
-// AddWalletInputs adds wallet inputs to the set until the specified budget is
-// met. When sweeping inputs with required outputs, although there's budget
-// specified, it cannot be directly spent from these required outputs. Instead,
-// we need to borrow budget from other inputs to make the sweep happen.
-// There are two sources to borrow from: 1) other inputs, 2) wallet utxos. If
-// we are calling this method, it means other inputs cannot cover the specified
-// budget, so we need to borrow from wallet utxos.
-//
-// Return an error if there are not enough wallet inputs, and the budget set is
-// set to its initial state by removing any wallet inputs added.
-//
-// NOTE: must be called with the wallet lock held via `WithCoinSelectLock`.
-func (b *BudgetInputSet) AddWalletInputs(wallet Wallet) error {
-	// Retrieve wallet utxos. Only consider confirmed utxos to prevent
-	// problems around RBF rules for unconfirmed inputs. This currently
-	// ignores the configured coin selection strategy.
-	utxos, err := wallet.ListUnspentWitnessFromDefaultAccount(
-		1, math.MaxInt32,
-	)
-	if err != nil {
-		return fmt.Errorf("list unspent witness: %w", err)
+		return fmt.Errorf("%w: fee=%v, change=%v, max ratio=%v",
+			ErrFeeToChangeRatioExceeded, fee, t.changeOutput,
+			t.cfg.maxFeeToChangeRatio)
 	}
 
-	// Sort the UTXOs by putting smaller values at the start of the slice
-	// to avoid locking large UTXO for sweeping.
-	//
-	// TODO(yy): add more choices to CoinSelectionStrategy and use the
-	// configured value here.
-	sort.Slice(utxos, func(i, j int) bool {
-		return utxos[i].Value < utxos[j].Value
-	})
+	log.Debugf("Dropping change=%v of input set to fees, max fee to "+
+		"change ratio=%v", t.changeOutput, t.cfg.maxFeeToChangeRatio)
 
-	// Make a copy of the current inputs. If the wallet doesn't have enough
-	// utxos to cover the budget, we will revert the current set to its
-	// original state by removing the added wallet inputs.
-	originalInputs := b.copyInputs()
+	t.changePolicy.Drop = true
 
-	// Add wallet inputs to the set until the specified budget is covered.
-	for _, utxo := range utxos {
-		input, err := createWalletTxInput(utxo)
-		if err != nil {
-			return err
-		}
+	return nil
+}
 
-		pi := SweeperInput{
-			Input: input,
-			params: Params{
-				DeadlineHeight: fn.Some(b.deadlineHeight),
-			},
-		}
-		b.addInput(pi)
+// utxoFilter returns the filter of the wallet utxos used as wallet inputs.
+func (t *txInputSet) utxoFilter() walletUtxoFilter {
+	return walletUtxoFilter{
+		isLeased:     t.cfg.isLeased,
+		minConfs:     t.cfg.minConfs,
+		minValue:     t.cfg.minWalletInputValue,
+		scorer:       t.cfg.utxoScorer,
+		account:      t.cfg.sweepAccount,
+		maxAncestors: t.cfg.maxAncestors,
+		ownChange:    t.ownChange,
+	}.withBucket(t.cfg.confBucket)
+}
 
-		// Return if we've reached the minimum output amount.
-		if !b.NeedWalletInput() {
-			return nil
-		}
+// tryAddWalletInputsIfNeeded retrieves utxos from the wallet and tries adding
+// as many as required to bring the tx output value above the given minimum.
+// It returns the number of wallet utxos examined.
+func (t *txInputSet) tryAddWalletInputsIfNeeded(wallet Wallet) (int, error) {
+	// If we've already have enough to pay the transaction fees and have at
+	// least one output materialize, no action is needed. Wallet inputs
+	// can't be added to the zero-fee tx with an ephemeral anchor either,
+	// as their value would be paid as fee.
+	if t.enoughInput() || t.changePolicy.EphemeralAnchor {
+		return 0, nil
 	}
 
-	// The wallet doesn't have enough utxos to cover the budget. Revert the
-	// input set to its original state.
-	b.inputs = originalInputs
+	// added tracks the wallet utxos added to the set.
+	var added []*lnwallet.Utxo
+
+	// addBatch adds the given confirmed wallet utxos until the set has
+	// enough input, and returns true once it does.
+	addBatch := func(utxos []*lnwallet.Utxo) (bool, error) {
+		// Consolidate the utxos that would become uneconomical first
+		// if a future fee rate is configured.
+		if t.cfg.futureFeeRate > 0 {
+			var err error
+			utxos, err = prioritizeUneconomical(
+				utxos, t.cfg.futureFeeRate,
+			)
+			if err != nil {
+				return false, err
+			}
+		}
 
-	return ErrNotEnoughInputs
-}
+		for _, utxo := range utxos {
+			input, err := createWalletTxInput(utxo)
+			if err != nil {
+				return false, err
+			}
 
-// Budget returns the total budget of the set.
-//
-// NOTE: part of the InputSet interface.
-func (b *BudgetInputSet) Budget() btcutil.Amount {
-	budget := btcutil.Amount(0)
-	for _, input := range b.inputs {
-		budget += input.params.Budget
-	}
+			// If the wallet input isn't positively-yielding at
+			// this fee rate, skip it.
+			ok, _ := t.add(input, constraintsWallet)
+			if !ok {
+				continue
+			}
+			added = append(added, utxo)
 
-	return budget
-}
+			// Stop if we've reached the minimum output amount.
+			if t.enoughInput() {
+				checkDominantWalletInput(added, t.cfg.recorder)
 
-// DeadlineHeight returns the deadline height of the set.
-//
-// NOTE: part of the InputSet interface.
-func (b *BudgetInputSet) DeadlineHeight() int32 {
-	return b.deadlineHeight
-}
+				return true, nil
+			}
+		}
 
-// Inputs returns the inputs that should be used to create a tx.
-//
-// NOTE: part of the InputSet interface.
-func (b *BudgetInputSet) Inputs() []input.Input {
-	inputs := make([]input.Input, 0, len(b.inputs))
-	for _, inp := range b.inputs {
-		inputs = append(inputs, inp.Input)
+		return false, nil
 	}
 
-	return inputs
-}
+	numUtxos, err := forEachWalletUtxoBatch(
+		wallet, t.utxoFilter(), addBatch,
+	)
+	if err != nil {
+		return 0, err
+	}
 
-// StartingFeeRate returns the max starting fee rate found in the inputs.
-//
-// NOTE: part of the InputSet interface.
-func (b *BudgetInputSet) StartingFeeRate() fn.Option[chainfee.SatPerKWeight] {
-	maxFeeRate := chainfee.SatPerKWeight(0)
-	startingFeeRate := fn.None[chainfee.SatPerKWeight]()
+	// Exit with a distinct error if the wallet has nothing to add, rather
+	// than all its utxos being filtered out.
+	if numUtxos == 0 {
+		hasUtxos, err := walletHasUtxos(wallet, t.utxoFilter())
+		if err != nil {
+			return 0, err
+		}
 
-	for _, inp := range b.inputs {
-		feerate := inp.params.StartingFeeRate.UnwrapOr(0)
-		if feerate > maxFeeRate {
-			maxFeeRate = feerate
-			startingFeeRate = fn.Some(maxFeeRate)
+		if !hasUtxos {
+			return 0, ErrNoWalletUtxos
 		}
 	}
 
-	return startingFeeRate
+	// Otherwise, we may not have been able to reach the minimum output
+	// amount, which is checked by the caller.
+	return numUtxos, nil
 }
@@ -2,36 +2,64 @@ package sweep
 
 import (
 	"errors"
+	"fmt"
 	"math"
+	"math/rand"
+	"sort"
 	"testing"
+	"time"
 
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
+	base "github.com/btcsuite/btcwallet/wallet"
+	"github.com/btcsuite/btcwallet/wtxmgr"
+	"github.com/lightningnetwork/lnd/channeldb"
 	"github.com/lightningnetwork/lnd/fn"
 	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lntypes"
 	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
 	"github.com/stretchr/testify/require"
 )
 
+// newTestTxInputSet creates a txInputSet with the given settings, failing the
+// test if they are invalid.
+func newTestTxInputSet(t testing.TB, feeRate,
+	maxFeeRate chainfee.SatPerKWeight, cfg txInputSetConfig) *txInputSet {
+
+	t.Helper()
+
+	set, err := newTxInputSet(feeRate, maxFeeRate, cfg)
+	require.NoError(t, err)
+
+	return set
+}
+
 // TestTxInputSet tests adding various sized inputs to the set.
 func TestTxInputSet(t *testing.T) {
 	const (
 		feeRate   = 1000
 		maxInputs = 10
 	)
-	set := newTxInputSet(feeRate, 0, maxInputs)
+	set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+		maxInputs: maxInputs,
+	})
 
 	// Create a 300 sat input. The fee to sweep this input to a P2WKH output
 	// is 439 sats. That means that this input yields -139 sats and we
 	// expect it not to be added.
-	if set.add(createP2WKHInput(300), constraintsRegular) {
+	if tryAdd(set, createP2WKHInput(300), constraintsRegular) {
 		t.Fatal("expected add of negatively yielding input to fail")
 	}
 
 	// A 700 sat input should be accepted into the set, because it yields
 	// positively.
-	if !set.add(createP2WKHInput(700), constraintsRegular) {
+	if !tryAdd(set, createP2WKHInput(700), constraintsRegular) {
 		t.Fatal("expected add of positively yielding input to succeed")
 	}
 
@@ -49,7 +77,7 @@ func TestTxInputSet(t *testing.T) {
 
 	// Add a 1000 sat input. This increases the tx fee to 760 sats. The tx
 	// output should now be 1000+700 - 760 = 940 sats.
-	if !set.add(createP2WKHInput(1000), constraintsRegular) {
+	if !tryAdd(set, createP2WKHInput(1000), constraintsRegular) {
 		t.Fatal("expected add of positively yielding input to succeed")
 	}
 	if set.totalOutput() != 940 {
@@ -60,6 +88,34 @@ func TestTxInputSet(t *testing.T) {
 	}
 }
 
+// TestTxInputSetMinYield checks that regular inputs whose yield is positive
+// but below the configured min yield are rejected.
+func TestTxInputSetMinYield(t *testing.T) {
+	const (
+		feeRate   = 1000
+		maxInputs = 10
+		minYield  = 300
+	)
+	set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+		maxInputs: maxInputs,
+	})
+	set.cfg.minYield = minYield
+
+	// A 700 sat input yields 700-487 = 213 sats, which is positive but
+	// below the min yield, so it should be rejected.
+	require.False(t, tryAdd(set, createP2WKHInput(700), constraintsRegular))
+	require.Empty(t, set.inputs)
+
+	// A 1000 sat input yields 1000-487 = 513 sats, which is above the
+	// min yield and should be accepted.
+	require.True(t, tryAdd(set, createP2WKHInput(1000), constraintsRegular))
+	require.Len(t, set.inputs, 1)
+
+	// The min yield does not apply to force sweeps.
+	require.True(t, tryAdd(set, createP2WKHInput(300), constraintsForce))
+	require.Len(t, set.inputs, 2)
+}
+
 // TestTxInputSetFromWallet tests adding a wallet input to a TxInputSet to reach
 // the dust limit.
 func TestTxInputSetFromWallet(t *testing.T) {
@@ -69,11 +125,13 @@ func TestTxInputSetFromWallet(t *testing.T) {
 	)
 
 	wallet := &mockWallet{}
-	set := newTxInputSet(feeRate, 0, maxInputs)
+	set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+		maxInputs: maxInputs,
+	})
 
 	// Add a 500 sat input to the set. It yields positively, but doesn't
 	// reach the output dust limit.
-	if !set.add(createP2WKHInput(500), constraintsRegular) {
+	if !tryAdd(set, createP2WKHInput(500), constraintsRegular) {
 		t.Fatal("expected add of positively yielding input to succeed")
 	}
 	if set.enoughInput() {
@@ -81,12 +139,12 @@ func TestTxInputSetFromWallet(t *testing.T) {
 	}
 
 	// Expect that adding a negative yield input fails.
-	if set.add(createP2WKHInput(50), constraintsRegular) {
+	if tryAdd(set, createP2WKHInput(50), constraintsRegular) {
 		t.Fatal("expected negative yield input add to fail")
 	}
 
 	// Force add the negative yield input. It should succeed.
-	if !set.add(createP2WKHInput(50), constraintsForce) {
+	if !tryAdd(set, createP2WKHInput(50), constraintsForce) {
 		t.Fatal("expected forced add to succeed")
 	}
 
@@ -100,12 +158,76 @@ func TestTxInputSetFromWallet(t *testing.T) {
 	}
 }
 
+// TestTxInputSetMaxForceSubsidy checks that adding wallet inputs fails when
+// the wallet value spent on force sweeps exceeds the max subsidy.
+func TestTxInputSetMaxForceSubsidy(t *testing.T) {
+	const (
+		feeRate   = 500
+		maxInputs = 10
+	)
+
+	testCases := []struct {
+		name            string
+		maxForceSubsidy btcutil.Amount
+		expectedErr     error
+	}{
+		{
+			name:            "no limit",
+			maxForceSubsidy: 0,
+		},
+		{
+			name:            "subsidy within limit",
+			maxForceSubsidy: 10_000,
+		},
+		{
+			name:            "subsidy exceeds limit",
+			maxForceSubsidy: 10,
+			expectedErr:     ErrForceSubsidyExceeded,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+				maxInputs: maxInputs,
+			})
+			set.cfg.maxForceSubsidy = tc.maxForceSubsidy
+
+			// Force add a negative yield input, which makes the
+			// change output negative.
+			require.True(t, tryAdd(set,
+				createP2WKHInput(50), constraintsForce,
+			))
+			require.Negative(t, set.changeOutput)
+
+			err := set.AddWalletInputs(&mockWallet{})
+			require.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+}
+
 // createP2WKHInput returns a P2WKH test input with the specified amount.
 func createP2WKHInput(amt btcutil.Amount) input.Input {
 	input := createTestInput(int64(amt), input.WitnessKeyHash)
 	return &input
 }
 
+// tryAdd adds the input to the set and returns whether it was added,
+// discarding the reject reason.
+func tryAdd(set *txInputSet, inp input.Input, c addConstraints) bool {
+	added, _ := set.add(inp, c)
+	return added
+}
+
+// changeWeight returns the weight added to the tx of the given set by its
+// change outputs.
+func changeWeight(set *txInputSet) int {
+	return set.weightEstimate(true).weight() -
+		set.weightEstimate(false).weight()
+}
+
 type mockWallet struct {
 	Wallet
 }
@@ -138,7 +260,9 @@ func TestTxInputSetRequiredOutput(t *testing.T) {
 		feeRate   = 1000
 		maxInputs = 10
 	)
-	set := newTxInputSet(feeRate, 0, maxInputs)
+	set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+		maxInputs: maxInputs,
+	})
 
 	// Attempt to add an input with a required txout below the dust limit.
 	// This should fail since we cannot trim such outputs.
@@ -149,7 +273,7 @@ func TestTxInputSetRequiredOutput(t *testing.T) {
 			PkScript: make([]byte, input.P2PKHSize),
 		},
 	}
-	require.False(t, set.add(inp, constraintsRegular),
+	require.False(t, tryAdd(set, inp, constraintsRegular),
 		"expected adding dust required tx out to fail")
 
 	// Create a 1000 sat input that also has a required TxOut of 1000 sat.
@@ -161,7 +285,9 @@ func TestTxInputSetRequiredOutput(t *testing.T) {
 			PkScript: make([]byte, input.P2WPKHSize),
 		},
 	}
-	require.True(t, set.add(inp, constraintsRegular), "failed adding input")
+	require.True(
+		t, tryAdd(set, inp, constraintsRegular), "failed adding input",
+	)
 
 	// The fee needed to pay for this input and output should be 439 sats.
 	fee := set.weightEstimate(false).feeWithParent()
@@ -189,7 +315,7 @@ func TestTxInputSetRequiredOutput(t *testing.T) {
 	// transaction without a change output, but not large enough to afford
 	// adding a change output.
 	extraInput1 := weight.feeWithParent() + 100
-	require.True(t, set.add(
+	require.True(t, tryAdd(set,
 		createP2WKHInput(extraInput1), constraintsRegular,
 	), "expected add of positively yielding input to succeed")
 
@@ -212,7 +338,7 @@ func TestTxInputSetRequiredOutput(t *testing.T) {
 	extraInput2 := weight.feeWithParent() - extraInput1 + 100
 
 	// Add this input, which should result in the change now being 100 sats.
-	require.True(t, set.add(
+	require.True(t, tryAdd(set,
 		createP2WKHInput(extraInput2), constraintsRegular,
 	))
 
@@ -236,7 +362,9 @@ func TestTxInputSetRequiredOutput(t *testing.T) {
 	// We expect the change to everything that is left after paying the tx
 	// fee.
 	extraInput3 := weight.feeWithParent() - extraInput1 - extraInput2 + 1000
-	require.True(t, set.add(createP2WKHInput(extraInput3), constraintsRegular))
+	require.True(t, tryAdd(
+		set, createP2WKHInput(extraInput3), constraintsRegular,
+	))
 
 	change = set.changeOutput
 	if change != 1000 {
@@ -253,7 +381,9 @@ func TestNewBudgetInputSet(t *testing.T) {
 	rt := require.New(t)
 
 	// Pass an empty slice and expect an error.
-	set, err := NewBudgetInputSet([]SweeperInput{}, testHeight)
+	set, err := NewBudgetInputSet(
+		[]SweeperInput{}, testHeight, testHeight, BudgetInputSetConfig{},
+	)
 	rt.ErrorContains(err, "inputs slice is empty")
 	rt.Nil(set)
 
@@ -291,27 +421,79 @@ func TestNewBudgetInputSet(t *testing.T) {
 	}
 
 	// Pass a slice of inputs with different deadline heights.
-	set, err = NewBudgetInputSet([]SweeperInput{input1, input2}, testHeight)
+	set, err = NewBudgetInputSet(
+		[]SweeperInput{input1, input2}, testHeight, testHeight,
+		BudgetInputSetConfig{},
+	)
 	rt.ErrorContains(err, "input deadline height not matched")
 	rt.Nil(set)
 
 	// Pass a slice of inputs that only one input has the deadline height,
 	// but it has a different value than the specified testHeight.
-	set, err = NewBudgetInputSet([]SweeperInput{input0, input2}, testHeight)
+	set, err = NewBudgetInputSet(
+		[]SweeperInput{input0, input2}, testHeight, testHeight,
+		BudgetInputSetConfig{},
+	)
 	rt.ErrorContains(err, "input deadline height not matched")
 	rt.Nil(set)
 
 	// Pass a slice of inputs that are duplicates.
-	set, err = NewBudgetInputSet([]SweeperInput{input3, input3}, testHeight)
+	set, err = NewBudgetInputSet(
+		[]SweeperInput{input3, input3}, testHeight, testHeight,
+		BudgetInputSetConfig{},
+	)
 	rt.ErrorContains(err, "duplicate inputs")
 	rt.Nil(set)
 
 	// Pass a slice of inputs that only one input has the deadline height,
-	set, err = NewBudgetInputSet([]SweeperInput{input0, input3}, testHeight)
+	set, err = NewBudgetInputSet(
+		[]SweeperInput{input0, input3}, testHeight, testHeight,
+		BudgetInputSetConfig{},
+	)
 	rt.NoError(err)
 	rt.NotNil(set)
 }
 
+// TestNewBudgetInputSetEconomics checks that a set made only of inputs with
+// required outputs is rejected if the required outputs exceed the inputs.
+func TestNewBudgetInputSetEconomics(t *testing.T) {
+	t.Parallel()
+
+	newReqInput := func(value, required int64) SweeperInput {
+		return SweeperInput{
+			Input: &reqInput{
+				Input: createP2WKHInput(btcutil.Amount(value)),
+				txOut: &wire.TxOut{Value: required},
+			},
+			params: Params{Budget: 100},
+		}
+	}
+
+	// The required outputs exceed the inputs by 1 sat.
+	set, err := NewBudgetInputSet([]SweeperInput{
+		newReqInput(10_000, 10_000), newReqInput(5_000, 5_001),
+	}, testHeight, testHeight, BudgetInputSetConfig{})
+	require.ErrorIs(t, err, ErrRequiredOutputsExceedInputs)
+	require.Nil(t, set)
+
+	// A balanced set is accepted.
+	set, err = NewBudgetInputSet([]SweeperInput{
+		newReqInput(10_000, 10_000), newReqInput(5_000, 5_000),
+	}, testHeight, testHeight, BudgetInputSetConfig{})
+	require.NoError(t, err)
+	require.NotNil(t, set)
+
+	// A regular input can make up the difference, so it's accepted.
+	set, err = NewBudgetInputSet([]SweeperInput{
+		newReqInput(5_000, 6_000), {
+			Input:  createP2WKHInput(10_000),
+			params: Params{Budget: 100},
+		},
+	}, testHeight, testHeight, BudgetInputSetConfig{})
+	require.NoError(t, err)
+	require.NotNil(t, set)
+}
+
 // TestBudgetInputSetAddInput checks that `addInput` correctly updates the
 // budget of the input set.
 func TestBudgetInputSetAddInput(t *testing.T) {
@@ -327,7 +509,10 @@ func TestBudgetInputSetAddInput(t *testing.T) {
 	}
 
 	// Initialize an input set, which adds the above input.
-	set, err := NewBudgetInputSet([]SweeperInput{*pi}, testHeight)
+	set, err := NewBudgetInputSet(
+		[]SweeperInput{*pi}, testHeight, testHeight,
+		BudgetInputSetConfig{},
+	)
 	require.NoError(t, err)
 
 	// Add the input to the set again.
@@ -552,13 +737,40 @@ func TestAddWalletInputReturnErr(t *testing.T) {
 	err = set.AddWalletInputs(wallet)
 	require.Error(t, err)
 
-	// Mock the wallet to return empty utxos.
+	// Mock the wallet to return empty utxos, both when selecting the
+	// wallet inputs and when checking whether the wallet has any.
 	wallet.On("ListUnspentWitnessFromDefaultAccount",
-		min, max).Return([]*lnwallet.Utxo{}, nil).Once()
+		min, max).Return([]*lnwallet.Utxo{}, nil).Twice()
 
-	// Check that the error is returned from not having wallet inputs.
+	// Check that a distinct error is returned from not having wallet
+	// inputs.
 	err = set.AddWalletInputs(wallet)
-	require.ErrorIs(t, err, ErrNotEnoughInputs)
+	require.ErrorIs(t, err, ErrNoWalletUtxos)
+	require.NotErrorIs(t, err, ErrNotEnoughInputs)
+
+	// Mock the wallet to only have unconfirmed utxos, which the wallet
+	// doesn't list with the min confs used to select the wallet inputs.
+	wallet.On("ListUnspentWitnessFromDefaultAccount",
+		min, max).Return([]*lnwallet.Utxo{}, nil).Twice()
+	wallet.On("ListUnspentWitnessFromDefaultAccount",
+		int32(0), max).Return([]*lnwallet.Utxo{{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       100_000,
+	}}, nil).Maybe()
+
+	// Check that the set is reported as having no wallet utxos, as the
+	// unconfirmed ones can't be selected.
+	reqSet := &BudgetInputSet{
+		inputs: []*SweeperInput{{
+			Input: &reqInput{
+				Input: createP2WKHInput(100_000),
+				txOut: &wire.TxOut{Value: 100_000},
+			},
+			params: Params{Budget: 10_000},
+		}},
+	}
+	err = reqSet.AddWalletInputs(wallet)
+	require.ErrorIs(t, err, ErrNoWalletUtxos)
 }
 
 // TestAddWalletInputNotEnoughInputs checks that when there are not enough
@@ -599,13 +811,23 @@ func TestAddWalletInputNotEnoughInputs(t *testing.T) {
 		min, max).Return([]*lnwallet.Utxo{utxo}, nil).Once()
 
 	// Initialize an input set with the pending input.
-	set := BudgetInputSet{inputs: []*SweeperInput{pi}}
+	set := BudgetInputSet{
+		inputs:         []*SweeperInput{pi},
+		deadlineHeight: testHeight,
+	}
 
 	// Add wallet inputs to the input set, which should give us an error as
 	// the wallet cannot cover the budget.
 	err := set.AddWalletInputs(wallet)
 	require.ErrorIs(t, err, ErrNotEnoughInputs)
 
+	// The error should carry the context of the failed set.
+	var notEnoughErr *NotEnoughInputsError
+	require.ErrorAs(t, err, &notEnoughErr)
+	require.Equal(t, btcutil.Amount(1), notEnoughErr.Shortfall)
+	require.Equal(t, testHeight, notEnoughErr.DeadlineHeight)
+	require.Equal(t, 1, notEnoughErr.NumWalletUtxos)
+
 	// Check that the budget set is reverted to its initial state.
 	require.Len(t, set.inputs, 1)
 	require.Equal(t, pi, set.inputs[0])
@@ -648,6 +870,11 @@ func TestAddWalletInputSuccess(t *testing.T) {
 	mockInput.On("OutPoint").Return(wire.OutPoint{Hash: chainhash.Hash{1}})
 	mockInput.On("WitnessType").Return(input.CommitmentAnchor)
 
+	// The value of the input is checked against its required output.
+	mockInput.On("SignDesc").Return(&input.SignDescriptor{
+		Output: &wire.TxOut{Value: budget},
+	})
+
 	// Create a wallet utxo that cannot cover the budget.
 	utxo := &lnwallet.Utxo{
 		AddressType: lnwallet.WitnessPubKey,
@@ -659,7 +886,10 @@ func TestAddWalletInputSuccess(t *testing.T) {
 		min, max).Return([]*lnwallet.Utxo{utxo, utxo}, nil).Once()
 
 	// Initialize an input set with the pending input.
-	set, err := NewBudgetInputSet([]SweeperInput{*pi}, deadline)
+	set, err := NewBudgetInputSet(
+		[]SweeperInput{*pi}, deadline, testHeight,
+		BudgetInputSetConfig{},
+	)
 	require.NoError(t, err)
 
 	// Add wallet inputs to the input set, which should give us an error as
@@ -686,3 +916,4949 @@ func TestAddWalletInputSuccess(t *testing.T) {
 	// Weak check, a strong check is to open the slice and check each item.
 	require.Len(t, set.inputs, 3)
 }
+
+// TestBudgetInputSetInputsOrderedByUrgency checks that `Inputs` places the
+// required-output inputs with the earliest deadlines first.
+func TestBudgetInputSetInputsOrderedByUrgency(t *testing.T) {
+	t.Parallel()
+
+	// newReqInput creates a pending input that has a required output and
+	// the specified deadline height.
+	pkScript := make([]byte, input.P2WPKHSize)
+	newReqInput := func(deadline int32) *SweeperInput {
+		return &SweeperInput{
+			Input: &reqInput{
+				Input: createP2WKHInput(10_000),
+				txOut: &wire.TxOut{
+					Value:    10_000,
+					PkScript: pkScript,
+				},
+			},
+			params: Params{
+				Budget:         100,
+				DeadlineHeight: fn.Some(deadline),
+			},
+		}
+	}
+
+	// Create a regular input which has no required output.
+	regular := &SweeperInput{
+		Input:  createP2WKHInput(10_000),
+		params: Params{Budget: 100},
+	}
+
+	// Create three inputs with required outputs and different deadlines.
+	late := newReqInput(testHeight + 10)
+	early := newReqInput(testHeight + 1)
+	middle := newReqInput(testHeight + 5)
+
+	// Create an input with a required output but no deadline, which
+	// should inherit the set's deadline.
+	noDeadline := &SweeperInput{
+		Input:  late.Input,
+		params: Params{Budget: 100},
+	}
+
+	set := &BudgetInputSet{
+		deadlineHeight: testHeight + 3,
+		inputs: []*SweeperInput{
+			regular, late, early, noDeadline, middle,
+		},
+	}
+
+	// The required-output inputs should be placed first, ordered by their
+	// deadlines, followed by the regular input.
+	expected := []input.Input{
+		early.Input, noDeadline.Input, middle.Input, late.Input,
+		regular.Input,
+	}
+	require.Equal(t, expected, set.Inputs())
+
+	// The internal order of the inputs should not be changed.
+	require.Equal(t, regular, set.inputs[0])
+	require.Equal(t, late, set.inputs[1])
+}
+
+// TestBudgetInputSetPreserveInputOrder checks that the inputs are returned in
+// the order they were added when the set preserves its input order.
+func TestBudgetInputSetPreserveInputOrder(t *testing.T) {
+	t.Parallel()
+
+	pkScript := make([]byte, input.P2WPKHSize)
+	newReqInput := func(deadline int32) *SweeperInput {
+		return &SweeperInput{
+			Input: &reqInput{
+				Input: createP2WKHInput(10_000),
+				txOut: &wire.TxOut{
+					Value:    10_000,
+					PkScript: pkScript,
+				},
+			},
+			params: Params{
+				Budget:         100,
+				DeadlineHeight: fn.Some(deadline),
+			},
+		}
+	}
+
+	regular := &SweeperInput{
+		Input:  createP2WKHInput(10_000),
+		params: Params{Budget: 100},
+	}
+	late := newReqInput(testHeight + 10)
+	early := newReqInput(testHeight + 1)
+
+	set := &BudgetInputSet{
+		deadlineHeight: testHeight + 3,
+		inputs:         []*SweeperInput{regular, late, early},
+	}
+
+	// By default the inputs are ordered by their urgency.
+	require.False(t, set.PreservesInputOrder())
+	require.Equal(t, []input.Input{
+		early.Input, late.Input, regular.Input,
+	}, set.Inputs())
+
+	// Once the order is preserved, the insertion order is used.
+	set.cfg.PreserveInputOrder = true
+	require.True(t, set.PreservesInputOrder())
+	require.Equal(t, []input.Input{
+		regular.Input, late.Input, early.Input,
+	}, set.Inputs())
+}
+
+// TestTxInputSetValidate checks that `Validate` on a txInputSet returns an
+// error when there are not enough inputs.
+func TestTxInputSetValidate(t *testing.T) {
+	const (
+		feeRate   = 1000
+		maxInputs = 10
+	)
+	set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+		maxInputs: maxInputs,
+	})
+
+	// An empty set doesn't have enough inputs.
+	require.ErrorIs(t, set.Validate(testHeight), ErrNotEnoughInputs)
+
+	// Add a 700 sat input, which yields 213 sats and is not enough to
+	// create a non-dust output.
+	require.True(t, tryAdd(set, createP2WKHInput(700), constraintsRegular))
+	require.ErrorIs(t, set.Validate(testHeight), ErrNotEnoughInputs)
+
+	// Add a 1000 sat input, which brings the output above dust.
+	require.True(t, tryAdd(set, createP2WKHInput(1000), constraintsRegular))
+	require.NoError(t, set.Validate(testHeight))
+}
+
+// TestBudgetInputSetValidate checks that `Validate` on a BudgetInputSet
+// catches both uncovered budgets and dust required outputs.
+func TestBudgetInputSetValidate(t *testing.T) {
+	t.Parallel()
+
+	// Create an input with a dust required output.
+	dustInput := &SweeperInput{
+		Input: &reqInput{
+			Input: createP2WKHInput(1000),
+			txOut: &wire.TxOut{
+				Value:    100,
+				PkScript: make([]byte, input.P2WPKHSize),
+			},
+		},
+		params: Params{Budget: 100},
+	}
+
+	// Create an input with a non-dust required output.
+	reqOutInput := &SweeperInput{
+		Input: &reqInput{
+			Input: createP2WKHInput(10_000),
+			txOut: &wire.TxOut{
+				Value:    10_000,
+				PkScript: make([]byte, input.P2WPKHSize),
+			},
+		},
+		params: Params{Budget: 1000},
+	}
+
+	// Create a regular input that can cover the above budget.
+	regular := &SweeperInput{
+		Input:  createP2WKHInput(10_000),
+		params: Params{Budget: 1000},
+	}
+
+	// A dust required output should fail the validation.
+	set := &BudgetInputSet{
+		inputs: []*SweeperInput{dustInput, regular},
+	}
+	require.ErrorIs(t, set.Validate(testHeight), ErrDustOutput)
+
+	// A set whose budget is not covered should fail the validation.
+	set = &BudgetInputSet{inputs: []*SweeperInput{reqOutInput}}
+	require.ErrorIs(t, set.Validate(testHeight), ErrNotEnoughInputs)
+
+	// Once the budget is covered, the validation should pass.
+	set = &BudgetInputSet{
+		inputs: []*SweeperInput{reqOutInput, regular},
+	}
+	require.NoError(t, set.Validate(testHeight))
+}
+
+// TestAddWalletInputSkipLeased checks that leased wallet utxos are skipped
+// when adding wallet inputs, and the unleased ones are used instead.
+func TestAddWalletInputSkipLeased(t *testing.T) {
+	t.Parallel()
+
+	wallet := &MockWallet{}
+	defer wallet.AssertExpectations(t)
+
+	// Specify the min and max confs used in
+	// ListUnspentWitnessFromDefaultAccount.
+	min, max := int32(1), int32(math.MaxInt32)
+
+	const budget = 10_000
+
+	// Create a mock input that has required outputs.
+	mockInput := &input.MockInput{}
+	mockInput.On("RequiredTxOut").Return(&wire.TxOut{})
+	mockInput.On("OutPoint").Return(
+		wire.OutPoint{Hash: chainhash.Hash{1}},
+	).Maybe()
+	mockInput.On("WitnessType").Return(input.CommitmentAnchor).Maybe()
+	defer mockInput.AssertExpectations(t)
+
+	pi := &SweeperInput{
+		Input:  mockInput,
+		params: Params{Budget: budget},
+	}
+
+	// Create two utxos that can each cover the budget. The smaller one,
+	// which would be selected first, is leased.
+	leased := &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       budget + 1,
+		OutPoint:    wire.OutPoint{Index: 1},
+	}
+	unleased := &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       budget + 2,
+		OutPoint:    wire.OutPoint{Index: 2},
+	}
+
+	// Mock the wallet to return both utxos twice.
+	utxos := []*lnwallet.Utxo{leased, unleased}
+	wallet.On("ListUnspentWitnessFromDefaultAccount",
+		min, max).Return(utxos, nil).Twice()
+
+	isLeased := func(op wire.OutPoint) bool {
+		return op == leased.OutPoint
+	}
+
+	// When a lease checker is set, the leased utxo is skipped.
+	set := &BudgetInputSet{inputs: []*SweeperInput{pi}}
+	set.cfg.LeaseChecker = isLeased
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 2)
+	require.Equal(t, unleased.OutPoint, set.inputs[1].OutPoint())
+
+	// Without a checker, the smallest utxo is selected.
+	set = &BudgetInputSet{inputs: []*SweeperInput{pi}}
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 2)
+	require.Equal(t, leased.OutPoint, set.inputs[1].OutPoint())
+}
+
+// TestTxInputSetShortfall checks that the shortfall of a txInputSet, plus the
+// fee needed to spend the wallet input, is exactly the value needed from the
+// wallet.
+func TestTxInputSetShortfall(t *testing.T) {
+	const (
+		feeRate   = 500
+		maxInputs = 10
+	)
+	set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+		maxInputs: maxInputs,
+	})
+
+	// Add a 500 sat input to the set. It yields positively, but doesn't
+	// reach the output dust limit.
+	require.True(t, tryAdd(set, createP2WKHInput(500), constraintsRegular))
+	require.False(t, set.enoughInput())
+
+	shortfall := set.Shortfall()
+	require.Positive(t, shortfall)
+
+	// Calculate the fee needed to spend an extra wallet input.
+	weight := set.weightEstimate(true)
+	fee := weight.feeWithParent()
+	require.NoError(t, weight.add(createP2WKHInput(0)))
+	inputFee := weight.feeWithParent() - fee
+
+	// A wallet input that is one sat short is not enough.
+	clone := *set
+	require.True(t, tryAdd(&clone,
+		createP2WKHInput(shortfall+inputFee-1), constraintsWallet,
+	))
+	require.False(t, clone.enoughInput())
+
+	// A wallet input worth the shortfall plus its own fee is enough.
+	require.True(t, tryAdd(set,
+		createP2WKHInput(shortfall+inputFee), constraintsWallet,
+	))
+	require.True(t, set.enoughInput())
+	require.Zero(t, set.Shortfall())
+}
+
+// TestBudgetInputSetShortfall checks that the shortfall of a BudgetInputSet
+// equals the value pulled from the wallet to cover its budget.
+func TestBudgetInputSetShortfall(t *testing.T) {
+	t.Parallel()
+
+	wallet := &MockWallet{}
+	defer wallet.AssertExpectations(t)
+
+	// Specify the min and max confs used in
+	// ListUnspentWitnessFromDefaultAccount.
+	min, max := int32(1), int32(math.MaxInt32)
+
+	// Create an input with a required output, which needs its budget to
+	// be borrowed.
+	reqOutInput := &SweeperInput{
+		Input: &reqInput{
+			Input: createP2WKHInput(10_000),
+			txOut: &wire.TxOut{
+				Value:    10_000,
+				PkScript: make([]byte, input.P2WPKHSize),
+			},
+		},
+		params: Params{Budget: 3000},
+	}
+
+	// Create a regular input that can lend 1000 sats.
+	regular := &SweeperInput{
+		Input:  createP2WKHInput(2000),
+		params: Params{Budget: 1000},
+	}
+
+	set := &BudgetInputSet{
+		inputs: []*SweeperInput{reqOutInput, regular},
+	}
+
+	// The set needs 3000 sats but can only borrow 1000 sats.
+	shortfall := set.Shortfall()
+	require.EqualValues(t, 2000, shortfall)
+
+	// A wallet utxo that is one sat short cannot cover the budget.
+	utxo := &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       shortfall - 1,
+	}
+	wallet.On("ListUnspentWitnessFromDefaultAccount",
+		min, max).Return([]*lnwallet.Utxo{utxo}, nil).Once()
+	require.ErrorIs(t, set.AddWalletInputs(wallet), ErrNotEnoughInputs)
+
+	// A wallet utxo worth exactly the shortfall covers the budget.
+	utxo = &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       shortfall,
+	}
+	wallet.On("ListUnspentWitnessFromDefaultAccount",
+		min, max).Return([]*lnwallet.Utxo{utxo}, nil).Once()
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 3)
+	require.Zero(t, set.Shortfall())
+}
+
+// TestTxInputSetCachedWeight checks that the weight estimated from the cached
+// input weights equals the weight estimated from the inputs' witness types,
+// across inputs of different script versions.
+func TestTxInputSetCachedWeight(t *testing.T) {
+	const (
+		feeRate   = 1000
+		maxInputs = 100
+	)
+	set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+		maxInputs: maxInputs,
+	})
+
+	witnessTypes := []input.WitnessType{
+		input.WitnessKeyHash,
+		input.NestedWitnessKeyHash,
+		input.TaprootPubKeySpend,
+		input.CommitmentTimeLock,
+		input.HtlcOfferedRemoteTimeout,
+		input.TaprootHtlcAcceptedRevoke,
+	}
+
+	// Force add the inputs so they are all included regardless of their
+	// yields.
+	for _, wt := range witnessTypes {
+		inp := createTestInput(10_000, wt)
+		require.True(t, tryAdd(set, &inp, constraintsForce))
+	}
+
+	// Estimate the weight without using the cache.
+	for _, change := range []bool{true, false} {
+		expected := newWeightEstimator(feeRate, 0)
+		for _, inp := range set.inputs {
+			require.NoError(t, expected.add(inp))
+		}
+		if change {
+			expected.addP2TROutput()
+		}
+
+		require.Equal(t, expected.weight(),
+			set.weightEstimate(change).weight())
+	}
+}
+
+// BenchmarkTxInputSetAdd benchmarks adding 500 inputs to a txInputSet.
+func BenchmarkTxInputSetAdd(b *testing.B) {
+	const (
+		feeRate   = 1000
+		numInputs = 500
+	)
+
+	inputs := make([]input.Input, 0, numInputs)
+	for i := 0; i < numInputs; i++ {
+		inputs = append(inputs, createP2WKHInput(100_000))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set := newTestTxInputSet(b, feeRate, 0, txInputSetConfig{
+			maxInputs: numInputs,
+		})
+		for _, inp := range inputs {
+			tryAdd(set, inp, constraintsRegular)
+		}
+	}
+}
+
+// TestTxInputSetIncrementalWeight checks that the running weight estimate of
+// a large set matches the weight estimate built from scratch.
+func TestTxInputSetIncrementalWeight(t *testing.T) {
+	const (
+		feeRate   = 1000
+		numInputs = 300
+	)
+	set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+		maxInputs: numInputs,
+	})
+
+	witnessTypes := []input.WitnessType{
+		input.WitnessKeyHash,
+		input.NestedWitnessKeyHash,
+		input.TaprootPubKeySpend,
+		input.CommitmentTimeLock,
+	}
+
+	pkScript := make([]byte, input.P2WPKHSize)
+	for i := 0; i < numInputs; i++ {
+		// Give every input a distinct outpoint, as the set rejects
+		// duplicates.
+		wt := witnessTypes[i%len(witnessTypes)]
+		var inp input.Input = input.NewBaseInput(
+			&wire.OutPoint{Index: uint32(i)}, wt,
+			&input.SignDescriptor{
+				Output: &wire.TxOut{Value: 100_000},
+			}, 0,
+		)
+
+		// Attach a required output to every tenth input.
+		if i%10 == 0 {
+			inp = &reqInput{
+				Input: inp,
+				txOut: &wire.TxOut{
+					Value:    50_000,
+					PkScript: pkScript,
+				},
+			}
+		}
+		require.True(t, tryAdd(set, inp, constraintsRegular))
+
+		// Compare the incremental and full estimates.
+		full := set.fullWeightEstimate()
+		require.Equal(t, full.weight(),
+			set.weightEstimate(false).weight())
+		require.Equal(t, full.feeWithParent(),
+			set.weightEstimate(false).feeWithParent())
+
+		full.addP2TROutput()
+		require.Equal(t, full.weight(),
+			set.weightEstimate(true).weight())
+		require.Equal(t, full.feeWithParent(),
+			set.weightEstimate(true).feeWithParent())
+	}
+}
+
+// TestTxInputSetCloneMaxFeeRate checks that the max fee rate of a set is kept
+// when its state is cloned to add an input, so the weight estimates of the
+// set keep enforcing it.
+func TestTxInputSetCloneMaxFeeRate(t *testing.T) {
+	t.Parallel()
+
+	const (
+		feeRate    = chainfee.SatPerKWeight(1000)
+		maxFeeRate = chainfee.SatPerKWeight(5000)
+	)
+
+	set := newTestTxInputSet(t, feeRate, maxFeeRate, txInputSetConfig{
+		maxInputs: 10,
+	})
+	require.True(t, tryAdd(set, createP2WKHInput(100_000),
+		constraintsRegular))
+
+	require.Equal(t, maxFeeRate, set.maxFeeRate)
+	require.Equal(t, maxFeeRate, set.clone().maxFeeRate)
+	require.Equal(t, maxFeeRate, set.weightEstimate(true).maxFeeRate)
+}
+
+// BenchmarkTxInputSetWeightEstimate benchmarks estimating the weight of a set
+// with 500 inputs.
+func BenchmarkTxInputSetWeightEstimate(b *testing.B) {
+	const (
+		feeRate   = 1000
+		numInputs = 500
+	)
+
+	set := newTestTxInputSet(b, feeRate, 0, txInputSetConfig{
+		maxInputs: numInputs,
+	})
+	for i := 0; i < numInputs; i++ {
+		tryAdd(set, createP2WKHInput(100_000), constraintsRegular)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = set.weightEstimate(true).feeWithParent()
+	}
+}
+
+// TestAddWalletInputRunningBalance checks that the wallet inputs selected by
+// `AddWalletInputs` are the same as the ones selected by re-evaluating
+// `NeedWalletInput` after each added input.
+func TestAddWalletInputRunningBalance(t *testing.T) {
+	t.Parallel()
+
+	// Specify the min and max confs used in
+	// ListUnspentWitnessFromDefaultAccount.
+	min, max := int32(1), int32(math.MaxInt32)
+
+	// Create a set of wallet utxos with various values.
+	utxos := make([]*lnwallet.Utxo, 0, 50)
+	for i := 0; i < 50; i++ {
+		utxos = append(utxos, &lnwallet.Utxo{
+			AddressType: lnwallet.WitnessPubKey,
+			Value:       btcutil.Amount(1000 + (i*7919)%5000),
+			OutPoint:    wire.OutPoint{Index: uint32(i)},
+		})
+	}
+
+	pkScript := make([]byte, input.P2WPKHSize)
+	for _, budget := range []btcutil.Amount{1, 5000, 20_000, 100_000} {
+		// Create an input with a required output that needs the
+		// budget to be borrowed, and a regular input which can lend
+		// part of it.
+		reqOutInput := &SweeperInput{
+			Input: &reqInput{
+				Input: createP2WKHInput(200_000),
+				txOut: &wire.TxOut{
+					Value:    200_000,
+					PkScript: pkScript,
+				},
+			},
+			params: Params{Budget: budget},
+		}
+		regular := &SweeperInput{
+			Input:  createP2WKHInput(3000),
+			params: Params{Budget: 1000},
+		}
+
+		// Select the expected wallet utxos by re-evaluating
+		// NeedWalletInput after each added input.
+		expected := &BudgetInputSet{
+			inputs: []*SweeperInput{reqOutInput, regular},
+		}
+		sorted, err := fetchWalletUtxos(
+			&mockUtxoWallet{utxos: utxos}, walletUtxoFilter{},
+		)
+		require.NoError(t, err)
+
+		enough := false
+		for _, utxo := range sorted {
+			inp, err := createWalletTxInput(utxo)
+			require.NoError(t, err)
+			expected.addInput(SweeperInput{Input: inp})
+
+			if !expected.NeedWalletInput() {
+				enough = true
+				break
+			}
+		}
+
+		wallet := &MockWallet{}
+		wallet.On("ListUnspentWitnessFromDefaultAccount",
+			min, max).Return(utxos, nil).Once()
+
+		set := &BudgetInputSet{
+			inputs: []*SweeperInput{reqOutInput, regular},
+		}
+		err = set.AddWalletInputs(wallet)
+		wallet.AssertExpectations(t)
+
+		if !enough {
+			require.ErrorIs(t, err, ErrNotEnoughInputs)
+			require.Len(t, set.inputs, 2)
+
+			continue
+		}
+
+		require.NoError(t, err)
+		require.Equal(t, expected.Inputs(), set.Inputs())
+	}
+}
+
+// mockUtxoWallet is a wallet that returns a fixed list of utxos.
+type mockUtxoWallet struct {
+	Wallet
+
+	utxos []*lnwallet.Utxo
+}
+
+func (m *mockUtxoWallet) ListUnspentWitnessFromDefaultAccount(minConfs,
+	maxConfs int32) ([]*lnwallet.Utxo, error) {
+
+	// Return a copy so the caller's sorting doesn't affect the list.
+	utxos := make([]*lnwallet.Utxo, len(m.utxos))
+	copy(utxos, m.utxos)
+
+	return utxos, nil
+}
+
+// BenchmarkBudgetInputSetAddWalletInputs benchmarks adding wallet inputs from
+// a large wallet to a set.
+func BenchmarkBudgetInputSetAddWalletInputs(b *testing.B) {
+	const numUtxos = 5000
+
+	utxos := make([]*lnwallet.Utxo, 0, numUtxos)
+	for i := 0; i < numUtxos; i++ {
+		utxos = append(utxos, &lnwallet.Utxo{
+			AddressType: lnwallet.WitnessPubKey,
+			Value:       1000,
+			OutPoint:    wire.OutPoint{Index: uint32(i)},
+		})
+	}
+	wallet := &mockUtxoWallet{utxos: utxos}
+
+	// Create an input whose budget needs almost all the wallet utxos.
+	reqOutInput := &SweeperInput{
+		Input: &reqInput{
+			Input: createP2WKHInput(10_000_000),
+			txOut: &wire.TxOut{
+				Value:    10_000_000,
+				PkScript: make([]byte, input.P2WPKHSize),
+			},
+		},
+		params: Params{Budget: 1000 * (numUtxos - 1)},
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set := &BudgetInputSet{inputs: []*SweeperInput{reqOutInput}}
+		if err := set.AddWalletInputs(wallet); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// mockWarningRecorder records the dominant wallet input warnings it receives.
+type mockWarningRecorder struct {
+	warnings []DominantInputWarning
+}
+
+func (m *mockWarningRecorder) RecordDominantWalletInput(
+	warning DominantInputWarning) {
+
+	m.warnings = append(m.warnings, warning)
+}
+
+// TestAddWalletInputDominantWarning checks that a warning is recorded when a
+// single wallet utxo dominates the wallet value added to a set.
+func TestAddWalletInputDominantWarning(t *testing.T) {
+	t.Parallel()
+
+	const budget = 10_000
+
+	newUtxo := func(idx uint32, value btcutil.Amount) *lnwallet.Utxo {
+		return &lnwallet.Utxo{
+			AddressType: lnwallet.WitnessPubKey,
+			Value:       value,
+			OutPoint:    wire.OutPoint{Index: idx},
+		}
+	}
+
+	newSet := func(recorder WarningRecorder) *BudgetInputSet {
+		set := &BudgetInputSet{
+			inputs: []*SweeperInput{{
+				Input: &reqInput{
+					Input: createP2WKHInput(budget),
+					txOut: &wire.TxOut{Value: budget},
+				},
+				params: Params{Budget: budget},
+			}},
+		}
+		set.cfg.WarningRecorder = recorder
+
+		return set
+	}
+
+	// A balanced set of utxos doesn't trigger the warning.
+	recorder := &mockWarningRecorder{}
+	set := newSet(recorder)
+	wallet := &mockUtxoWallet{utxos: []*lnwallet.Utxo{
+		newUtxo(0, 5000), newUtxo(1, 5000),
+	}}
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 3)
+	require.Empty(t, recorder.warnings)
+
+	// A single utxo that contributes 90% of the wallet value triggers
+	// the warning.
+	recorder = &mockWarningRecorder{}
+	set = newSet(recorder)
+	dominant := newUtxo(1, 9000)
+	wallet = &mockUtxoWallet{utxos: []*lnwallet.Utxo{
+		newUtxo(0, 1000), dominant,
+	}}
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 3)
+	require.Equal(t, []DominantInputWarning{{
+		OutPoint:         dominant.OutPoint,
+		Value:            dominant.Value,
+		WalletInputTotal: 10_000,
+	}}, recorder.warnings)
+}
+
+// TestTxInputSetWeightReserve checks that a weight reserve makes the set pull
+// extra wallet value, and that a zero reserve keeps the current behavior.
+func TestTxInputSetWeightReserve(t *testing.T) {
+	t.Parallel()
+
+	const (
+		feeRate   = 500
+		maxInputs = 10
+	)
+
+	utxos := make([]*lnwallet.Utxo, 0, 10)
+	for i := 0; i < 10; i++ {
+		utxos = append(utxos, &lnwallet.Utxo{
+			AddressType: lnwallet.WitnessPubKey,
+			Value:       1_000,
+			OutPoint:    wire.OutPoint{Index: uint32(i)},
+		})
+	}
+	wallet := &mockUtxoWallet{utxos: utxos}
+
+	// newSet creates a set with a 550 sat input, which yields positively
+	// but doesn't reach the dust limit. Its yield still covers the fees
+	// of two wallet inputs, so the set doesn't spend more from the wallet
+	// than it gets out.
+	newSet := func(weightReserve int) *txInputSet {
+		set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+			maxInputs: maxInputs,
+		})
+		set.cfg.weightReserve = weightReserve
+		require.True(t, tryAdd(set,
+			createP2WKHInput(550), constraintsRegular,
+		))
+		require.False(t, set.enoughInput())
+
+		return set
+	}
+
+	// Without a reserve, a single wallet input is enough.
+	noReserve := newSet(0)
+	require.NoError(t, noReserve.AddWalletInputs(wallet))
+	require.Len(t, noReserve.inputs, 2)
+
+	// A reserve of 2000 weight units requires 1000 sats to be held back,
+	// which pulls in extra wallet inputs.
+	const weightReserve = 2000
+	withReserve := newSet(weightReserve)
+	require.NoError(t, withReserve.AddWalletInputs(wallet))
+	require.Greater(t, withReserve.walletInputTotal,
+		noReserve.walletInputTotal)
+
+	// The change left after holding back the reserve is above dust.
+	reserveFee := chainfee.SatPerKWeight(feeRate).FeeForWeight(
+		weightReserve,
+	)
+	require.GreaterOrEqual(t, withReserve.changeOutput-reserveFee,
+		lnwallet.DustLimitForSize(input.P2TRSize))
+}
+
+// TestTxInputSetRejectDuplicate checks that an input already in the set is
+// rejected.
+func TestTxInputSetRejectDuplicate(t *testing.T) {
+	const (
+		feeRate   = 1000
+		maxInputs = 10
+	)
+	set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+		maxInputs: maxInputs,
+	})
+
+	inp := createP2WKHInput(10_000)
+	require.True(t, tryAdd(set, inp, constraintsRegular))
+
+	// Adding the same input again should fail, regardless of the
+	// constraints used.
+	for _, c := range []addConstraints{
+		constraintsRegular, constraintsForce, constraintsWallet,
+	} {
+		require.False(t, tryAdd(set, inp, c))
+	}
+
+	// Feed a list containing a duplicate to addPositiveYieldInputs, and
+	// check only the unique inputs are added.
+	set = newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+		maxInputs: maxInputs,
+	})
+	sweeperInput := &SweeperInput{Input: inp}
+	set.addPositiveYieldInputs([]*SweeperInput{
+		sweeperInput, sweeperInput,
+	})
+	require.Len(t, set.inputs, 1)
+	require.True(t, set.enoughInput())
+	require.NoError(t, set.Validate(testHeight))
+}
+
+// TestBudgetInputSetNotBefore checks that a set with a not-before height is
+// invalid before that height and valid after.
+func TestBudgetInputSetNotBefore(t *testing.T) {
+	t.Parallel()
+
+	set := &BudgetInputSet{
+		inputs: []*SweeperInput{{
+			Input:  createP2WKHInput(10_000),
+			params: Params{Budget: 1000},
+		}},
+	}
+
+	// Without a not-before height, the set is always ready.
+	require.Zero(t, set.ReadyAt())
+	require.NoError(t, set.Validate(0))
+
+	notBefore := testHeight + 10
+	set.cfg.NotBefore = fn.Some(notBefore)
+	require.Equal(t, notBefore, set.ReadyAt())
+
+	// The set is invalid before the not-before height.
+	require.ErrorIs(t, set.Validate(notBefore-1), ErrNotReady)
+
+	// The set is valid at and after the not-before height.
+	require.NoError(t, set.Validate(notBefore))
+	require.NoError(t, set.Validate(notBefore+1))
+}
+
+// TestTxInputSetString checks that the string description of a populated
+// txInputSet contains its key fields.
+func TestTxInputSetString(t *testing.T) {
+	t.Parallel()
+
+	const feeRate = 1000
+
+	set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(set, createP2WKHInput(10_000), constraintsForce))
+
+	desc := set.String()
+	require.Contains(t, desc, "txInputSet(")
+	require.Contains(t, desc, fmt.Sprintf("fee_rate=%v",
+		chainfee.SatPerKWeight(feeRate)))
+	require.Contains(t, desc, "num_inputs=1")
+	require.Contains(t, desc, fmt.Sprintf("input_total=%v",
+		btcutil.Amount(10_000)))
+	require.Contains(t, desc, fmt.Sprintf("required_output=%v",
+		btcutil.Amount(0)))
+	require.Contains(t, desc, fmt.Sprintf("change_output=%v",
+		set.changeOutput))
+	require.Contains(t, desc, "force=true")
+	require.Contains(t, desc, inputTypeSummary(set.inputs))
+}
+
+// TestTxInputSetCoGroup checks that the inputs of a co-group are either all
+// added to the set or not at all.
+func TestTxInputSetCoGroup(t *testing.T) {
+	t.Parallel()
+
+	const feeRate = 1000
+
+	newInput := func(amt btcutil.Amount,
+		group fn.Option[uint64]) *SweeperInput {
+
+		return &SweeperInput{
+			Input:   createP2WKHInput(amt),
+			CoGroup: group,
+		}
+	}
+
+	// Create a group whose members all have a positive yield, and a
+	// group with one member that has a negative yield.
+	good1 := newInput(20_000, fn.Some[uint64](1))
+	good2 := newInput(15_000, fn.Some[uint64](1))
+	bad1 := newInput(30_000, fn.Some[uint64](2))
+	bad2 := newInput(100, fn.Some[uint64](2))
+	single := newInput(10_000, fn.None[uint64]())
+
+	// The inputs are sorted descending by yield.
+	inputs := []*SweeperInput{bad1, good1, good2, single, bad2}
+
+	set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{maxInputs: 10})
+	set.addPositiveYieldInputs(inputs)
+
+	// The second group must be rejected as a whole since bad2 cannot be
+	// added, while the first group and the ungrouped input are added.
+	require.Equal(t, []input.Input{good1, good2, single}, set.inputs)
+
+	// Without the negative yield member, the second group is added.
+	set = newTestTxInputSet(t, feeRate, 0, txInputSetConfig{maxInputs: 10})
+	set.addPositiveYieldInputs([]*SweeperInput{bad1, good1, good2})
+	require.Equal(t, []input.Input{bad1, good1, good2}, set.inputs)
+
+	// A group that doesn't fit into the max inputs is rejected as a
+	// whole, leaving room for the ungrouped input.
+	set = newTestTxInputSet(t, feeRate, 0, txInputSetConfig{maxInputs: 1})
+	set.addPositiveYieldInputs([]*SweeperInput{good1, good2, single})
+	require.Equal(t, []input.Input{single}, set.inputs)
+}
+
+// smallestFirstSelector is a coin selection strategy that picks the smallest
+// utxos first.
+type smallestFirstSelector struct{}
+
+// ArrangeCoins sorts the coins by their values in ascending order.
+func (smallestFirstSelector) ArrangeCoins(eligible []base.Coin,
+	_ btcutil.Amount) ([]base.Coin, error) {
+
+	sort.Slice(eligible, func(i, j int) bool {
+		return eligible[i].Value < eligible[j].Value
+	})
+
+	return eligible, nil
+}
+
+// TestBudgetInputSetCoinSelectionStrategy checks that the wallet utxos are
+// selected using the strategy of the most urgent input, falling back to the
+// default of the set.
+func TestBudgetInputSetCoinSelectionStrategy(t *testing.T) {
+	t.Parallel()
+
+	small := &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       10_000,
+		OutPoint:    wire.OutPoint{Index: 1},
+	}
+	medium := &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       50_000,
+		OutPoint:    wire.OutPoint{Index: 2},
+	}
+	large := &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       100_000,
+		OutPoint:    wire.OutPoint{Index: 3},
+	}
+	wallet := &mockUtxoWallet{
+		utxos: []*lnwallet.Utxo{medium, large, small},
+	}
+
+	largest := fn.Some(base.CoinSelectionLargest)
+	smallest := fn.Some[base.CoinSelectionStrategy](
+		smallestFirstSelector{},
+	)
+	none := fn.None[base.CoinSelectionStrategy]()
+
+	// newReqInput creates an input with a required output, which needs to
+	// borrow its budget from a wallet input.
+	pkScript := make([]byte, input.P2WPKHSize)
+	newReqInput := func(deadline int32,
+		strategy fn.Option[base.CoinSelectionStrategy]) *SweeperInput {
+
+		return &SweeperInput{
+			Input: &reqInput{
+				Input: createP2WKHInput(200_000),
+				txOut: &wire.TxOut{
+					Value:    200_000,
+					PkScript: pkScript,
+				},
+			},
+			params: Params{
+				Budget:                4000,
+				DeadlineHeight:        fn.Some(deadline),
+				CoinSelectionStrategy: strategy,
+			},
+		}
+	}
+
+	testCases := []struct {
+		name            string
+		urgentStrategy  fn.Option[base.CoinSelectionStrategy]
+		relaxedStrategy fn.Option[base.CoinSelectionStrategy]
+		setDefault      fn.Option[base.CoinSelectionStrategy]
+		expected        *lnwallet.Utxo
+	}{
+		{
+			name:            "no strategy uses smallest first",
+			urgentStrategy:  none,
+			relaxedStrategy: none,
+			setDefault:      none,
+			expected:        small,
+		},
+		{
+			name:            "set default is used",
+			urgentStrategy:  none,
+			relaxedStrategy: none,
+			setDefault:      largest,
+			expected:        large,
+		},
+		{
+			name:            "input strategy overrides set default",
+			urgentStrategy:  none,
+			relaxedStrategy: largest,
+			setDefault:      smallest,
+			expected:        large,
+		},
+		{
+			name:            "most urgent input strategy is used",
+			urgentStrategy:  smallest,
+			relaxedStrategy: largest,
+			setDefault:      largest,
+			expected:        small,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			set := &BudgetInputSet{
+				inputs: []*SweeperInput{
+					newReqInput(testHeight+10,
+						tc.relaxedStrategy),
+					newReqInput(testHeight,
+						tc.urgentStrategy),
+				},
+				cfg: BudgetInputSetConfig{
+					CoinSelectionStrategy: tc.setDefault.
+						UnwrapOr(nil),
+				},
+			}
+
+			require.NoError(t, set.AddWalletInputs(wallet))
+
+			// A single wallet utxo is enough to cover the budget.
+			require.Len(t, set.inputs, 3)
+			require.Equal(t, tc.expected.OutPoint,
+				set.inputs[2].OutPoint())
+		})
+	}
+}
+
+// TestTxInputSetEmpty checks that an empty set reports it doesn't have enough
+// inputs and gives an empty weight estimate without a change output.
+func TestTxInputSetEmpty(t *testing.T) {
+	t.Parallel()
+
+	set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+
+	require.False(t, set.enoughInput())
+	require.True(t, set.NeedWalletInput())
+
+	// Neither estimate should include a phantom change output.
+	empty := newWeightEstimator(1000, 0)
+	require.Equal(t, empty.weight(), set.weightEstimate(true).weight())
+	require.Equal(t, empty.weight(), set.weightEstimate(false).weight())
+	require.Equal(t, empty.fee(), set.weightEstimate(true).fee())
+}
+
+// TestTxInputSetChangeDistribution checks that the change of a set is split
+// across accounts per the configured fractions, and that a distribution
+// leaving a dust change output is rejected.
+func TestTxInputSetChangeDistribution(t *testing.T) {
+	t.Parallel()
+
+	const feeRate = 1000
+
+	genScript := func(account string) ([]byte, error) {
+		return []byte(account), nil
+	}
+
+	newSet := func(dist map[string]float64) (*txInputSet, error) {
+		return newTxInputSet(feeRate, 0, txInputSetConfig{
+			maxInputs:    10,
+			changePolicy: ChangePolicy{Distribution: dist},
+		})
+	}
+
+	// Invalid distributions are rejected.
+	for _, dist := range []map[string]float64{
+		{},
+		{"a": 0.5, "b": 0.4},
+		{"a": 1.2, "b": -0.2},
+	} {
+		_, err := newSet(dist)
+		require.ErrorIs(t, err, ErrInvalidChangeDistribution)
+	}
+
+	// Distribute the change across three accounts.
+	dist := map[string]float64{"a": 0.5, "b": 0.3, "c": 0.2}
+	set, err := newSet(dist)
+	require.NoError(t, err)
+	inp := createP2WKHInput(1_000_000)
+	require.True(t, tryAdd(set, inp, constraintsForce))
+	require.True(t, set.enoughInput())
+
+	// The weight estimate must account for all the change outputs.
+	single := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+		maxInputs: 10,
+	})
+	require.True(t, tryAdd(single, inp, constraintsForce))
+	require.Equal(t, single.weightEstimate(true).weight()+
+		2*input.P2TROutputSize*4, set.weightEstimate(true).weight())
+	require.Less(t, set.changeOutput, single.changeOutput)
+
+	txOuts, err := set.ChangeOutputs(genScript)
+	require.NoError(t, err)
+	require.Len(t, txOuts, 3)
+
+	total := int64(0)
+	for i, account := range []string{"a", "b", "c"} {
+		require.Equal(t, []byte(account), txOuts[i].PkScript)
+
+		// The last account gets the rounding remainder of the
+		// others, so each share can be off by a sat per account.
+		expected := float64(set.changeOutput) * dist[account]
+		require.InDelta(t, expected, txOuts[i].Value, float64(len(dist)))
+
+		total += txOuts[i].Value
+	}
+	require.EqualValues(t, set.changeOutput, total)
+
+	// The set keeps its own copy of the distribution.
+	dist["a"] = 0.1
+	require.Equal(t, 0.5, set.changePolicy.Distribution["a"])
+
+	// A distribution with a dust fraction needs more input.
+	set, err = newSet(map[string]float64{"a": 0.999, "b": 0.001})
+	require.NoError(t, err)
+	require.True(t, tryAdd(
+		set, createP2WKHInput(100_000), constraintsForce,
+	))
+	require.False(t, set.enoughInput())
+	require.Positive(t, set.Shortfall())
+
+	_, err = set.ChangeOutputs(genScript)
+	require.ErrorIs(t, err, ErrDustOutput)
+}
+
+// TestConflictingSets checks that the pairs of input sets sharing an outpoint
+// are reported, while disjoint sets are not.
+func TestConflictingSets(t *testing.T) {
+	t.Parallel()
+
+	inp1 := createP2WKHInput(10_000)
+	inp2 := createP2WKHInput(20_000)
+	inp3 := createP2WKHInput(30_000)
+	inp4 := createP2WKHInput(40_000)
+
+	newSet := func(inputs ...input.Input) InputSet {
+		set := &MockInputSet{}
+		set.On("Inputs").Return(inputs)
+
+		return set
+	}
+
+	set0 := newSet(inp1, inp2)
+	set1 := newSet(inp3)
+	set2 := newSet(inp2, inp4)
+	set3 := newSet(inp4)
+
+	// Check the fingerprint of a set.
+	require.Equal(t, map[wire.OutPoint]struct{}{
+		inp1.OutPoint(): {},
+		inp2.OutPoint(): {},
+	}, InputFingerprint(set0))
+
+	// Disjoint sets have no conflicts.
+	require.Empty(t, ConflictingSets([]InputSet{set0, set1, set3}))
+
+	// Set 0 and 2 share inp2, set 2 and 3 share inp4.
+	require.Equal(t, [][2]int{{0, 2}, {2, 3}},
+		ConflictingSets([]InputSet{set0, set1, set2, set3}))
+}
+
+// TestTxInputSetFutureFeeRate checks that wallet utxos which are economical
+// now but would be uneconomical at the future fee rate are prioritized.
+func TestTxInputSetFutureFeeRate(t *testing.T) {
+	t.Parallel()
+
+	// A p2tr utxo, which stays economical at the future fee rate as it's
+	// cheap to spend.
+	cheap := &lnwallet.Utxo{
+		AddressType: lnwallet.TaprootPubkey,
+		Value:       2500,
+		OutPoint:    wire.OutPoint{Index: 1},
+	}
+
+	// A slightly larger np2wkh utxo, which costs more than its value to
+	// spend at the future fee rate.
+	expensive := &lnwallet.Utxo{
+		AddressType: lnwallet.NestedWitnessPubKey,
+		Value:       2600,
+		OutPoint:    wire.OutPoint{Index: 2},
+	}
+
+	wallet := &mockUtxoWallet{
+		utxos: []*lnwallet.Utxo{expensive, cheap},
+	}
+
+	testCases := []struct {
+		name          string
+		futureFeeRate chainfee.SatPerKWeight
+		expected      *lnwallet.Utxo
+	}{
+		{
+			name:     "smallest first by default",
+			expected: cheap,
+		},
+		{
+			name:          "uneconomical utxo prioritized",
+			futureFeeRate: 10_000,
+			expected:      expensive,
+		},
+		{
+			name:          "no utxo uneconomical at future rate",
+			futureFeeRate: 2000,
+			expected:      cheap,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Create a set whose change is below dust, so it needs
+			// a single wallet utxo.
+			set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{
+				maxInputs: 10,
+			})
+			set.cfg.futureFeeRate = tc.futureFeeRate
+			require.True(t, tryAdd(set,
+				createP2WKHInput(500), constraintsForce,
+			))
+			require.False(t, set.enoughInput())
+
+			require.NoError(t, set.AddWalletInputs(wallet))
+			require.Len(t, set.inputs, 2)
+			require.Equal(t, tc.expected.OutPoint,
+				set.inputs[1].OutPoint())
+		})
+	}
+}
+
+// TestTxInputSetMaxChangeValue checks that a change exceeding the max change
+// value is split into several bounded outputs.
+func TestTxInputSetMaxChangeValue(t *testing.T) {
+	t.Parallel()
+
+	const (
+		feeRate  = 1000
+		maxValue = 100_000
+	)
+
+	genScript := func(account string) ([]byte, error) {
+		return []byte(account), nil
+	}
+
+	newSet := func(policy ChangePolicy) (*txInputSet, error) {
+		return newTxInputSet(feeRate, 0, txInputSetConfig{
+			maxInputs:    10,
+			changePolicy: policy,
+		})
+	}
+
+	// A max change value below dust is rejected.
+	_, err := newSet(ChangePolicy{MaxChangeValue: 100})
+	require.ErrorIs(t, err, ErrDustOutput)
+
+	// Split a large change into bounded outputs.
+	set, err := newSet(ChangePolicy{MaxChangeValue: maxValue})
+	require.NoError(t, err)
+	inp := createP2WKHInput(1_000_000)
+	require.True(t, tryAdd(set, inp, constraintsForce))
+	require.True(t, set.enoughInput())
+	require.Equal(t, 10, set.numChangeOutputs())
+
+	// The weight estimate must account for all the change outputs.
+	single := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+		maxInputs: 10,
+	})
+	require.True(t, tryAdd(single, inp, constraintsForce))
+	require.Equal(t, single.weightEstimate(true).weight()+
+		9*input.P2TROutputSize*4, set.weightEstimate(true).weight())
+
+	txOuts, err := set.ChangeOutputs(genScript)
+	require.NoError(t, err)
+	require.Len(t, txOuts, 10)
+
+	total := int64(0)
+	for _, txOut := range txOuts {
+		require.LessOrEqual(t, txOut.Value, int64(maxValue))
+		total += txOut.Value
+	}
+	require.EqualValues(t, set.changeOutput, total)
+
+	// When combined with a change distribution, the share of each
+	// account is split separately.
+	set, err = newSet(ChangePolicy{
+		Distribution:   map[string]float64{"a": 0.5, "b": 0.5},
+		MaxChangeValue: 300_000,
+	})
+	require.NoError(t, err)
+	require.True(t, tryAdd(set, inp, constraintsForce))
+
+	txOuts, err = set.ChangeOutputs(genScript)
+	require.NoError(t, err)
+	require.Len(t, txOuts, 4)
+	for i, account := range []string{"a", "a", "b", "b"} {
+		require.Equal(t, []byte(account), txOuts[i].PkScript)
+		require.LessOrEqual(t, txOuts[i].Value, int64(300_000))
+	}
+
+	// Splitting a small change into many outputs leaves them as dust, so
+	// the set needs more input.
+	set, err = newSet(ChangePolicy{MaxChangeValue: 400})
+	require.NoError(t, err)
+	require.True(t, tryAdd(set, createP2WKHInput(5000), constraintsForce))
+	require.False(t, set.enoughInput())
+
+	_, err = set.ChangeOutputs(genScript)
+	require.ErrorIs(t, err, ErrDustOutput)
+}
+
+// TestInputSetReplaceable checks that the replaceability of the sets is
+// surfaced via the InputSet interface, defaults to replaceable, and controls
+// the sequence of the inputs.
+func TestInputSetReplaceable(t *testing.T) {
+	t.Parallel()
+
+	// The sets are replaceable by default.
+	txSet := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	budgetSet := &BudgetInputSet{}
+	require.True(t, txSet.IsReplaceable())
+	require.True(t, budgetSet.IsReplaceable())
+
+	// They opt out of replaceability when configured to.
+	txSet = newTestTxInputSet(t, 1000, 0, txInputSetConfig{
+		maxInputs:      10,
+		nonReplaceable: true,
+	})
+	budgetSet = &BudgetInputSet{
+		cfg: BudgetInputSetConfig{NonReplaceable: true},
+	}
+	require.False(t, txSet.IsReplaceable())
+	require.False(t, budgetSet.IsReplaceable())
+
+	// A replaceable input without CSV uses a zero sequence, while a
+	// non-replaceable one opts out of RBF.
+	inp := createP2WKHInput(10_000)
+	require.Zero(t, txInSequence(inp, true))
+	require.Equal(t, uint32(wire.MaxTxInSequenceNum-1),
+		txInSequence(inp, false))
+
+	// An input with a CSV delay always uses it as its sequence.
+	csvInp := input.NewCsvInput(
+		&wire.OutPoint{Index: 1}, input.CommitmentTimeLock,
+		inp.SignDesc(), 0, 144,
+	)
+	require.EqualValues(t, 144, txInSequence(csvInp, true))
+	require.EqualValues(t, 144, txInSequence(csvInp, false))
+
+	// Thus a non-replaceable set cannot spend it.
+	txSet = newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(txSet, csvInp, constraintsForce))
+	require.True(t, tryAdd(txSet, inp, constraintsRegular))
+	require.NoError(t, txSet.Validate(testHeight))
+
+	txSet.cfg.nonReplaceable = true
+	require.ErrorIs(t, txSet.Validate(testHeight), ErrReplaceableInput)
+
+	budgetSet = &BudgetInputSet{
+		inputs: []*SweeperInput{{Input: csvInp}},
+		cfg:    BudgetInputSetConfig{NonReplaceable: true},
+	}
+	require.ErrorIs(t, budgetSet.Validate(testHeight), ErrReplaceableInput)
+}
+
+// TestBudgetInputSetLabel checks that the label of a set reflects its
+// deadline, budget and the witness types of its inputs.
+func TestBudgetInputSetLabel(t *testing.T) {
+	t.Parallel()
+
+	newInput := func(witnessType input.WitnessType,
+		budget btcutil.Amount) *SweeperInput {
+
+		inp := createTestInput(10_000, witnessType)
+
+		return &SweeperInput{
+			Input:  &inp,
+			params: Params{Budget: budget},
+		}
+	}
+
+	set := &BudgetInputSet{
+		deadlineHeight: 850_000,
+		inputs: []*SweeperInput{
+			newInput(input.HtlcOfferedRemoteTimeout, 300),
+			newInput(input.CommitmentAnchor, 200),
+			newInput(input.HtlcOfferedRemoteTimeout, 500),
+		},
+	}
+
+	require.Equal(t, "0:sweep:deadline-850000:budget-1000:inputs-"+
+		"CommitmentAnchor*1,HtlcOfferedRemoteTimeout*2", set.Label())
+
+	// A label exceeding the wallet limit is truncated.
+	for i := 0; i < 100; i++ {
+		set.inputs = append(set.inputs, newInput(
+			input.WitnessType(input.StandardWitnessType(i)), 1,
+		))
+	}
+	require.Len(t, set.Label(), wtxmgr.TxLabelLimit)
+
+	// The request falls back to the generic sweep label.
+	require.Equal(t, "0:sweep", (&BumpRequest{}).txLabel())
+	require.Equal(t, "custom", (&BumpRequest{Label: "custom"}).txLabel())
+}
+
+// TestTrimInputsPinned checks that trimInputs never drops pinned inputs in
+// favor of unpinned ones, and that the order of the inputs is preserved.
+func TestTrimInputsPinned(t *testing.T) {
+	t.Parallel()
+
+	// newInput creates a unique input with the given pinned flag.
+	newInput := func(pinned bool) SweeperInput {
+		inp := createTestInput(1000, input.CommitmentAnchor)
+
+		return SweeperInput{
+			Input:  &inp,
+			params: Params{Pinned: pinned},
+		}
+	}
+
+	u0, p1, u2, p3, u4 := newInput(false), newInput(true),
+		newInput(false), newInput(true), newInput(false)
+	inputs := []SweeperInput{u0, p1, u2, p3, u4}
+
+	// When the inputs fit, nothing is trimmed.
+	kept, trimmed := trimInputs(inputs, 5)
+	require.Equal(t, inputs, kept)
+	require.Empty(t, trimmed)
+
+	// With three slots, both pinned inputs are kept together with the
+	// first unpinned one.
+	kept, trimmed = trimInputs(inputs, 3)
+	require.Equal(t, []SweeperInput{u0, p1, p3}, kept)
+	require.Equal(t, []SweeperInput{u2, u4}, trimmed)
+
+	// With a single slot, only the first pinned input is kept.
+	kept, trimmed = trimInputs(inputs, 1)
+	require.Equal(t, []SweeperInput{p1}, kept)
+	require.Equal(t, []SweeperInput{u0, u2, p3, u4}, trimmed)
+
+	// A set can't be created when the pinned inputs alone exceed the max
+	// number of inputs.
+	_, err := NewBudgetInputSet(
+		inputs, testHeight, testHeight,
+		BudgetInputSetConfig{MaxInputs: 1},
+	)
+	require.ErrorIs(t, err, ErrTooManyPinnedInputs)
+
+	set, err := NewBudgetInputSet(
+		inputs, testHeight, testHeight,
+		BudgetInputSetConfig{MaxInputs: 2},
+	)
+	require.NoError(t, err)
+	require.Len(t, set.Inputs(), 5)
+}
+
+// stubRelayFeeProvider is a RelayFeeProvider returning a fixed relay fee.
+type stubRelayFeeProvider chainfee.SatPerKWeight
+
+// RelayFeePerKW returns the fixed relay fee.
+func (s stubRelayFeeProvider) RelayFeePerKW() chainfee.SatPerKWeight {
+	return chainfee.SatPerKWeight(s)
+}
+
+// TestTxInputSetRelayFeeDustLimit checks that the dust limit of the set is
+// derived from the relay fee provider when set, and that a raised relay fee
+// changes which sets have enough input.
+func TestTxInputSetRelayFeeDustLimit(t *testing.T) {
+	t.Parallel()
+
+	const feeRate = 1000
+
+	staticLimit := lnwallet.DustLimitForSize(input.P2TRSize)
+
+	// Without a provider, or with the relay fee at the floor, the static
+	// dust limit is used.
+	require.Equal(t, staticLimit,
+		dustLimitForRelayFee(input.P2TRSize, nil))
+	require.Equal(t, staticLimit, dustLimitForRelayFee(
+		input.P2TRSize, stubRelayFeeProvider(chainfee.FeePerKwFloor),
+	))
+
+	// A relay fee of 10 sat/vb raises the dust limit tenfold.
+	raised := stubRelayFeeProvider(2500)
+	raisedLimit := dustLimitForRelayFee(input.P2TRSize, raised)
+	require.Equal(t, 10*staticLimit, raisedLimit)
+
+	// An input leaving a change between the static and the raised dust
+	// limits is accepted by the default set.
+	inp := createP2WKHInput(2000)
+	set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(set, inp, constraintsRegular))
+	require.True(t, set.enoughInput())
+	require.Less(t, set.changeOutput, raisedLimit)
+
+	// With the raised relay fee, the same change is dust so the set needs
+	// more input.
+	set = newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+		maxInputs:        10,
+		relayFeeProvider: raised,
+	})
+	require.True(t, tryAdd(set, inp, constraintsRegular))
+	require.False(t, set.enoughInput())
+	require.Equal(t, raisedLimit-set.changeOutput, set.Shortfall())
+
+	// A max change value below the raised dust limit is rejected.
+	_, err := newTxInputSet(feeRate, 0, txInputSetConfig{
+		maxInputs:        10,
+		relayFeeProvider: raised,
+		changePolicy:     ChangePolicy{MaxChangeValue: raisedLimit - 1},
+	})
+	require.ErrorIs(t, err, ErrDustOutput)
+}
+
+// TestTxInputSetAddRejectReason checks that each rejection path of add returns
+// the matching reason, and that addPositiveYieldInputs keeps going after an
+// input specific rejection.
+func TestTxInputSetAddRejectReason(t *testing.T) {
+	t.Parallel()
+
+	const feeRate = 1000
+
+	dustInput := &reqInput{
+		Input: createP2WKHInput(500),
+		txOut: &wire.TxOut{
+			Value:    500,
+			PkScript: make([]byte, input.P2PKHSize),
+		},
+	}
+	unknownInput := createTestInput(10_000, input.StandardWitnessType(999))
+
+	testCases := []struct {
+		name        string
+		setup       func(set *txInputSet)
+		inp         input.Input
+		constraints addConstraints
+		reason      addRejectReason
+	}{
+		{
+			name:        "added",
+			inp:         createP2WKHInput(10_000),
+			constraints: constraintsRegular,
+			reason:      rejectNone,
+		},
+		{
+			name: "max inputs",
+			setup: func(set *txInputSet) {
+				set.cfg.maxInputs = 0
+			},
+			inp:         createP2WKHInput(10_000),
+			constraints: constraintsRegular,
+			reason:      rejectMaxInputs,
+		},
+		{
+			name:        "dust required output",
+			inp:         dustInput,
+			constraints: constraintsForce,
+			reason:      rejectDustOutput,
+		},
+		{
+			name:        "unknown weight",
+			inp:         &unknownInput,
+			constraints: constraintsForce,
+			reason:      rejectUnknownWeight,
+		},
+		{
+			name:        "negative yield",
+			inp:         createP2WKHInput(50),
+			constraints: constraintsRegular,
+			reason:      rejectNegativeYield,
+		},
+		{
+			name:        "negative yield wallet input",
+			inp:         createP2WKHInput(50),
+			constraints: constraintsWallet,
+			reason:      rejectNegativeYield,
+		},
+		{
+			name: "below min yield",
+			setup: func(set *txInputSet) {
+				set.cfg.minYield = 100_000
+			},
+			inp:         createP2WKHInput(10_000),
+			constraints: constraintsRegular,
+			reason:      rejectBelowMinYield,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+				maxInputs: 10,
+			})
+			if tc.setup != nil {
+				tc.setup(set)
+			}
+
+			added, reason := set.add(tc.inp, tc.constraints)
+			require.Equal(t, tc.reason == rejectNone, added)
+			require.Equal(t, tc.reason, reason)
+		})
+	}
+
+	// Adding the same input twice is rejected as a duplicate.
+	set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{maxInputs: 10})
+	inp := createP2WKHInput(10_000)
+	require.True(t, tryAdd(set, inp, constraintsRegular))
+
+	added, reason := set.add(inp, constraintsRegular)
+	require.False(t, added)
+	require.Equal(t, rejectDuplicate, reason)
+
+	// A dust required output doesn't stop the remaining inputs from being
+	// added, while a negative yield input does.
+	set = newTestTxInputSet(t, feeRate, 0, txInputSetConfig{maxInputs: 10})
+	goodInput := &SweeperInput{Input: createP2WKHInput(10_000)}
+	set.addPositiveYieldInputs([]*SweeperInput{
+		{Input: dustInput},
+		goodInput,
+		{Input: createP2WKHInput(50)},
+		{Input: createP2WKHInput(20_000)},
+	})
+	require.Equal(t, []input.Input{goodInput}, set.inputs)
+}
+
+// TestTxInputSetNoWalletUtxos checks that adding wallet inputs to a
+// txInputSet returns ErrNoWalletUtxos when the wallet is empty, and
+// ErrNotEnoughInputs when its utxos are not enough.
+func TestTxInputSetNoWalletUtxos(t *testing.T) {
+	t.Parallel()
+
+	const feeRate = 1000
+
+	// newSet returns a set whose output is below dust.
+	newSet := func(t *testing.T) *txInputSet {
+		set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+			maxInputs: 10,
+		})
+		require.True(t, tryAdd(
+			set, createP2WKHInput(500), constraintsForce,
+		))
+		require.False(t, set.enoughInput())
+
+		return set
+	}
+
+	// An empty wallet returns the distinct error.
+	set := newSet(t)
+	err := set.AddWalletInputs(&mockUtxoWallet{})
+	require.ErrorIs(t, err, ErrNoWalletUtxos)
+	require.NotErrorIs(t, err, ErrNotEnoughInputs)
+
+	// A wallet with only uneconomical utxos isn't enough.
+	set = newSet(t)
+	err = set.AddWalletInputs(&mockUtxoWallet{
+		utxos: []*lnwallet.Utxo{{
+			AddressType: lnwallet.WitnessPubKey,
+			Value:       100,
+		}},
+	})
+	require.ErrorIs(t, err, ErrNotEnoughInputs)
+	require.NotErrorIs(t, err, ErrNoWalletUtxos)
+
+	// The error should carry the shortfall of the set and the number of
+	// wallet utxos examined.
+	var notEnoughErr *NotEnoughInputsError
+	require.ErrorAs(t, err, &notEnoughErr)
+	require.Equal(t, set.Shortfall(), notEnoughErr.Shortfall)
+	require.Positive(t, notEnoughErr.Shortfall)
+	require.Zero(t, notEnoughErr.DeadlineHeight)
+	require.Equal(t, 1, notEnoughErr.NumWalletUtxos)
+
+	// When the set already has enough input, the wallet isn't needed, so
+	// an empty wallet is fine.
+	set = newTestTxInputSet(t, feeRate, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(
+		set, createP2WKHInput(10_000), constraintsRegular,
+	))
+	require.NoError(t, set.AddWalletInputs(&mockUtxoWallet{}))
+}
+
+// TestWalletHasUtxos checks that only the utxos the wallet inputs are selected
+// from count, i.e., the ones with enough confirmations in the sweep account or
+// the default account.
+func TestWalletHasUtxos(t *testing.T) {
+	t.Parallel()
+
+	unconfirmed := &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       20_000,
+		OutPoint:    wire.OutPoint{Index: 1},
+	}
+
+	// An unconfirmed utxo can't be selected with the default min confs.
+	confsWallet := &mockConfsWallet{utxos: []*lnwallet.Utxo{unconfirmed}}
+	hasUtxos, err := walletHasUtxos(confsWallet, walletUtxoFilter{})
+	require.NoError(t, err)
+	require.False(t, hasUtxos)
+
+	// Once confirmed deep enough for the filter, it counts.
+	unconfirmed.Confirmations = 3
+	hasUtxos, err = walletHasUtxos(
+		confsWallet, walletUtxoFilter{minConfs: 3},
+	)
+	require.NoError(t, err)
+	require.True(t, hasUtxos)
+
+	hasUtxos, err = walletHasUtxos(
+		confsWallet, walletUtxoFilter{minConfs: 4},
+	)
+	require.NoError(t, err)
+	require.False(t, hasUtxos)
+
+	// The utxos of the sweep account count when the filter has it.
+	accountWallet := &mockAccountWallet{
+		account:      "sweep",
+		accountUtxos: []*lnwallet.Utxo{unconfirmed},
+	}
+	hasUtxos, err = walletHasUtxos(accountWallet, walletUtxoFilter{})
+	require.NoError(t, err)
+	require.False(t, hasUtxos)
+
+	hasUtxos, err = walletHasUtxos(
+		accountWallet, walletUtxoFilter{account: "sweep"},
+	)
+	require.NoError(t, err)
+	require.True(t, hasUtxos)
+}
+
+// TestRegisterWalletInputConverter checks that wallet utxos of a custom
+// address type are swept using the registered converter.
+func TestRegisterWalletInputConverter(t *testing.T) {
+	t.Parallel()
+
+	// Use an address type unknown to the sweeper.
+	const customType = lnwallet.AddressType(200)
+
+	utxo := &lnwallet.Utxo{
+		AddressType: customType,
+		Value:       100_000,
+		PkScript:    []byte{1, 2, 3},
+		OutPoint:    wire.OutPoint{Index: 7},
+	}
+
+	// Without a converter, the utxo can't be converted.
+	_, err := createWalletTxInput(utxo)
+	require.ErrorContains(t, err, "unknown address type")
+
+	// Register a converter that spends the custom type as a p2wkh.
+	converted := 0
+	RegisterWalletInputConverter(customType,
+		func(u *lnwallet.Utxo) (input.Input, error) {
+			converted++
+
+			signDesc := &input.SignDescriptor{
+				Output: &wire.TxOut{
+					PkScript: u.PkScript,
+					Value:    int64(u.Value),
+				},
+				HashType: txscript.SigHashAll,
+			}
+
+			return input.NewBaseInput(
+				&u.OutPoint, input.WitnessKeyHash, signDesc, 0,
+			), nil
+		},
+	)
+
+	// Sweep a set whose output is below dust, which needs the custom
+	// wallet utxo.
+	set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(set, createP2WKHInput(500), constraintsForce))
+
+	wallet := &mockUtxoWallet{utxos: []*lnwallet.Utxo{utxo}}
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Equal(t, 1, converted)
+
+	require.Len(t, set.inputs, 2)
+	walletInput := set.inputs[1]
+	require.Equal(t, utxo.OutPoint, walletInput.OutPoint())
+	require.Equal(t, input.WitnessKeyHash, walletInput.WitnessType())
+	require.EqualValues(t, utxo.Value, walletInput.SignDesc().Output.Value)
+
+	// A converter registered for a built-in type is never used.
+	RegisterWalletInputConverter(lnwallet.TaprootPubkey,
+		func(*lnwallet.Utxo) (input.Input, error) {
+			return nil, errDummy
+		},
+	)
+	t.Cleanup(func() {
+		walletInputConvertersMtx.Lock()
+		delete(walletInputConverters, lnwallet.TaprootPubkey)
+		walletInputConvertersMtx.Unlock()
+	})
+
+	inp, err := createWalletTxInput(&lnwallet.Utxo{
+		AddressType: lnwallet.TaprootPubkey,
+	})
+	require.NoError(t, err)
+	require.Equal(t, input.TaprootPubKeySpend, inp.WitnessType())
+}
+
+// TestInputSetDisplayUnit checks that the descriptions of the input sets
+// render the amounts in the configured display unit.
+func TestInputSetDisplayUnit(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		unit     DisplayUnit
+		expected string
+	}{
+		{unit: DisplayUnitBTC, expected: "0.00010000 BTC"},
+		{unit: DisplayUnitSat, expected: "10000 sat"},
+		{unit: DisplayUnitMSat, expected: "10000000 mSAT"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.expected, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tc.expected, tc.unit.Format(10_000))
+
+			set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{
+				maxInputs: 10,
+			})
+			set.cfg.displayUnit = tc.unit
+			require.True(t, tryAdd(
+				set, createP2WKHInput(10_000), constraintsForce,
+			))
+			require.Contains(t, set.String(),
+				"input_total="+tc.expected)
+
+			inp := createP2WKHInput(20_000)
+			budgetSet, err := NewBudgetInputSet([]SweeperInput{{
+				Input:  inp,
+				params: Params{Budget: 10_000},
+			}}, testHeight, testHeight, BudgetInputSetConfig{})
+			require.NoError(t, err)
+			budgetSet.cfg.DisplayUnit = tc.unit
+			require.Contains(t, budgetSet.String(),
+				"budget="+tc.expected)
+		})
+	}
+
+	// Negative amounts are rendered with their sign.
+	require.Equal(t, "-5000 mSAT", DisplayUnitMSat.Format(-5))
+	require.Equal(t, "-5 sat", DisplayUnitSat.Format(-5))
+}
+
+// TestYieldPerWeightOrdering checks that ranking by yield per weight prefers a
+// lighter input over a heavier one with a higher absolute yield.
+func TestYieldPerWeightOrdering(t *testing.T) {
+	t.Parallel()
+
+	const feeRate = chainfee.SatPerKWeight(1000)
+
+	// yield returns the absolute yield of the input at the fee rate.
+	yield := func(inp input.Input) int64 {
+		iw, err := newInputWeight(inp)
+		require.NoError(t, err)
+
+		weight := int64(input.InputSize*blockchain.WitnessScaleFactor +
+			iw.witnessSize)
+
+		return inp.SignDesc().Output.Value -
+			int64(feeRate.FeeForWeight(weight))
+	}
+
+	// The heavy input has a larger value, and thus a higher yield, but a
+	// lower yield per weight than the light one.
+	heavyInput := createTestInput(10_000, input.CommitmentTimeLock)
+	heavy := &SweeperInput{Input: &heavyInput}
+	light := &SweeperInput{Input: createP2WKHInput(9_000)}
+
+	require.Greater(t, yield(heavy), yield(light))
+	require.Less(t, YieldPerWeight(heavy, feeRate),
+		YieldPerWeight(light, feeRate))
+
+	// A force sweep stays at the start of the ranked list despite its low
+	// yield per weight.
+	forceInput := createTestInput(1_000, input.CommitmentTimeLock)
+	force := &SweeperInput{
+		Input:  &forceInput,
+		params: Params{Immediate: true},
+	}
+
+	inputs := []*SweeperInput{force, heavy, light}
+	require.Equal(t, []*SweeperInput{force, light, heavy},
+		rankByYieldPerWeight(inputs, feeRate))
+
+	// The input list itself is not modified.
+	require.Equal(t, []*SweeperInput{force, heavy, light}, inputs)
+
+	// With room for a single input, the raw yield ordering picks the heavy
+	// input, while the yield per weight ordering picks the light one.
+	set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{maxInputs: 1})
+	set.addPositiveYieldInputs([]*SweeperInput{heavy, light})
+	require.Equal(t, []input.Input{heavy}, set.inputs)
+
+	set = newTestTxInputSet(t, feeRate, 0, txInputSetConfig{maxInputs: 1})
+	set.cfg.rankByYieldPerWeight = true
+	set.addPositiveYieldInputs([]*SweeperInput{heavy, light})
+	require.Equal(t, []input.Input{light}, set.inputs)
+
+	// An input with an unknown weight has a zero yield per weight.
+	unknown := createTestInput(10_000, input.StandardWitnessType(999))
+	require.Zero(t, YieldPerWeight(&unknown, feeRate))
+}
+
+// nilSignDescInput is an input without a sign descriptor.
+type nilSignDescInput struct {
+	input.Input
+}
+
+// SignDesc returns a nil sign descriptor.
+func (n *nilSignDescInput) SignDesc() *input.SignDescriptor {
+	return nil
+}
+
+// TestTxInputSetMalformedInput checks that inputs missing their sign
+// descriptor or output are rejected instead of causing a panic.
+func TestTxInputSetMalformedInput(t *testing.T) {
+	t.Parallel()
+
+	nilOutput := input.NewBaseInput(
+		&wire.OutPoint{Index: 1}, input.WitnessKeyHash,
+		&input.SignDescriptor{}, 0,
+	)
+	nilSignDesc := &nilSignDescInput{Input: createP2WKHInput(10_000)}
+
+	for _, inp := range []input.Input{nilOutput, nilSignDesc} {
+		set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{
+			maxInputs: 10,
+		})
+
+		var (
+			added  bool
+			reason addRejectReason
+		)
+		require.NotPanics(t, func() {
+			added, reason = set.add(inp, constraintsForce)
+		})
+		require.False(t, added)
+		require.Equal(t, rejectMalformed, reason)
+		require.Empty(t, set.inputs)
+		require.Zero(t, YieldPerWeight(inp, 1000))
+	}
+
+	// A malformed input doesn't stop the remaining inputs from being
+	// added.
+	set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	good := &SweeperInput{Input: createP2WKHInput(10_000)}
+	set.addPositiveYieldInputs([]*SweeperInput{
+		{Input: nilOutput},
+		good,
+	})
+	require.Equal(t, []input.Input{good}, set.inputs)
+}
+
+// TestBudgetInputSetBudgetCurve checks that the budget of a set with a budget
+// curve grows as the deadline approaches, and that the summed budget is used
+// without one.
+func TestBudgetInputSetBudgetCurve(t *testing.T) {
+	t.Parallel()
+
+	deadline := testHeight + 10
+
+	inp := createP2WKHInput(100_000)
+	set, err := NewBudgetInputSet([]SweeperInput{{
+		Input:  inp,
+		params: Params{Budget: 1000},
+	}}, deadline, testHeight, BudgetInputSetConfig{})
+	require.NoError(t, err)
+
+	// Without a curve, the static summed budget is used regardless of
+	// the height.
+	set.currentHeight = testHeight
+	require.Equal(t, btcutil.Amount(1000), set.Budget())
+
+	// Use a curve that spends more as fewer blocks are left.
+	set.cfg.BudgetCurve = func(blocksLeft int32) btcutil.Amount {
+		if blocksLeft <= 0 {
+			return 10_000
+		}
+
+		return btcutil.Amount(10_000 / blocksLeft)
+	}
+
+	var prev btcutil.Amount
+	for height := testHeight; height < deadline; height++ {
+		set.currentHeight = height
+
+		budget := set.Budget()
+		require.Greater(t, budget, prev)
+		prev = budget
+	}
+	require.Equal(t, btcutil.Amount(10_000), prev)
+
+	// Once the deadline is reached, the max budget is used.
+	set.currentHeight = deadline + 1
+	require.Equal(t, btcutil.Amount(10_000), set.Budget())
+
+	// Removing the curve restores the static budget.
+	set.cfg.BudgetCurve = nil
+	require.Equal(t, btcutil.Amount(1000), set.Budget())
+	require.False(t, set.NeedWalletInput())
+
+	// The set is created at the given height, so the curve is evaluated
+	// with the blocks left from there.
+	set, err = NewBudgetInputSet([]SweeperInput{{
+		Input:  inp,
+		params: Params{Budget: 1000},
+	}}, deadline, testHeight, BudgetInputSetConfig{})
+	require.NoError(t, err)
+
+	set.cfg.BudgetCurve = func(blocksLeft int32) btcutil.Amount {
+		require.Equal(t, deadline-testHeight, blocksLeft)
+		return 200_000
+	}
+	require.Equal(t, btcutil.Amount(200_000), set.Budget())
+
+	// A budget exceeding the value of the inputs must be funded by wallet
+	// inputs.
+	require.True(t, set.NeedWalletInput())
+	require.Equal(t, btcutil.Amount(100_000), set.Shortfall())
+}
+
+// TestTxInputSetSafeMode checks that safe mode blocks a set paying more in
+// fees than it recovers, unless a required output justifies it.
+func TestTxInputSetSafeMode(t *testing.T) {
+	t.Parallel()
+
+	const feeRate = 2000
+
+	// fee returns the fee paid by the set.
+	fee := func(set *txInputSet) btcutil.Amount {
+		return set.inputTotal - set.requiredOutput - set.changeOutput
+	}
+
+	// Create a set whose fee exceeds its non-dust change.
+	newSet := func(safeMode bool) *txInputSet {
+		set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+			maxInputs: 10,
+		})
+		set.cfg.safeMode = safeMode
+		require.True(t, tryAdd(
+			set, createP2WKHInput(1500), constraintsRegular,
+		))
+		require.True(t, set.enoughInput())
+		require.Greater(t, fee(set), set.changeOutput)
+
+		return set
+	}
+
+	// Without safe mode, the set is valid.
+	require.NoError(t, newSet(false).Validate(testHeight))
+
+	// In safe mode, the set is refused.
+	err := newSet(true).Validate(testHeight)
+	require.ErrorIs(t, err, ErrFeeExceedsValue)
+
+	// A set with a required output is allowed in safe mode, even though
+	// its fee exceeds its change. A lower fee rate is used so the
+	// required output input has a positive yield.
+	set := newTestTxInputSet(t, feeRate/2, 0, txInputSetConfig{
+		maxInputs: 10,
+	})
+	set.cfg.safeMode = true
+	require.True(t, tryAdd(set, &reqInput{
+		Input: createP2WKHInput(1000),
+		txOut: &wire.TxOut{
+			Value:    1000,
+			PkScript: make([]byte, input.P2WPKHSize),
+		},
+	}, constraintsRegular))
+	require.True(t, tryAdd(
+		set, createP2WKHInput(1500), constraintsRegular,
+	))
+	require.True(t, set.enoughInput())
+	require.Greater(t, fee(set), set.changeOutput)
+	require.NoError(t, set.Validate(testHeight))
+}
+
+// mockPagedWallet is a wallet that only lists its utxos page by page.
+type mockPagedWallet struct {
+	Wallet
+
+	utxos []*lnwallet.Utxo
+
+	// pagesLoaded records the index of every page listed.
+	pagesLoaded []uint32
+}
+
+// ListUnspentWitnessPage returns the requested page of the utxos.
+func (m *mockPagedWallet) ListUnspentWitnessPage(_, _ int32, page,
+	pageSize uint32) ([]*lnwallet.Utxo, error) {
+
+	m.pagesLoaded = append(m.pagesLoaded, page)
+
+	start := min(int(page*pageSize), len(m.utxos))
+	end := min(start+int(pageSize), len(m.utxos))
+
+	utxos := make([]*lnwallet.Utxo, end-start)
+	copy(utxos, m.utxos[start:end])
+
+	return utxos, nil
+}
+
+// newMockPagedWallet creates a paged wallet holding numUtxos utxos of the
+// given value.
+func newMockPagedWallet(numUtxos int,
+	value btcutil.Amount) *mockPagedWallet {
+
+	utxos := make([]*lnwallet.Utxo, 0, numUtxos)
+	for i := 0; i < numUtxos; i++ {
+		utxos = append(utxos, &lnwallet.Utxo{
+			AddressType: lnwallet.WitnessPubKey,
+			Value:       value,
+			OutPoint:    wire.OutPoint{Index: uint32(i)},
+		})
+	}
+
+	return &mockPagedWallet{utxos: utxos}
+}
+
+// TestAddWalletInputsPaged checks that wallet utxos are fetched page by page
+// from a wallet supporting it, and that no more pages are loaded once the goal
+// of the set is met.
+func TestAddWalletInputsPaged(t *testing.T) {
+	t.Parallel()
+
+	const numUtxos = 3 * walletUtxoPageSize
+
+	// newBudgetSet creates a budget set that needs to borrow the given
+	// budget from the wallet.
+	newBudgetSet := func(budget btcutil.Amount) *BudgetInputSet {
+		return &BudgetInputSet{inputs: []*SweeperInput{{
+			Input: &reqInput{
+				Input: createP2WKHInput(budget),
+				txOut: &wire.TxOut{Value: int64(budget)},
+			},
+			params: Params{Budget: budget},
+		}}}
+	}
+
+	// A small budget is covered by the first page.
+	wallet := newMockPagedWallet(numUtxos, 100)
+	set := newBudgetSet(1000)
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Equal(t, []uint32{0}, wallet.pagesLoaded)
+	require.Len(t, set.inputs, 11)
+
+	// A budget needing exactly two pages stops before the third one.
+	wallet = newMockPagedWallet(numUtxos, 100)
+	set = newBudgetSet(2 * walletUtxoPageSize * 100)
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Equal(t, []uint32{0, 1}, wallet.pagesLoaded)
+	require.Len(t, set.inputs, 2*walletUtxoPageSize+1)
+
+	// A budget exceeding the wallet balance loads all the pages, and the
+	// set is reverted.
+	wallet = newMockPagedWallet(numUtxos, 100)
+	set = newBudgetSet(numUtxos*100 + 1)
+	require.ErrorIs(t, set.AddWalletInputs(wallet), ErrNotEnoughInputs)
+	require.Equal(t, []uint32{0, 1, 2, 3}, wallet.pagesLoaded)
+	require.Len(t, set.inputs, 1)
+
+	// An empty paged wallet returns the distinct error.
+	wallet = newMockPagedWallet(0, 100)
+	set = newBudgetSet(1000)
+	require.ErrorIs(t, set.AddWalletInputs(wallet), ErrNoWalletUtxos)
+
+	// The yield-based set also stops after the first page once it has
+	// enough input.
+	wallet = newMockPagedWallet(numUtxos, 10_000)
+	txSet := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(txSet, createP2WKHInput(500), constraintsForce))
+	require.NoError(t, txSet.AddWalletInputs(wallet))
+	require.Equal(t, []uint32{0}, wallet.pagesLoaded)
+	require.Len(t, txSet.inputs, 2)
+}
+
+// TestTxInputSetCpfpAnchor checks that a set with a CPFP anchor pulls wallet
+// inputs until its change meets the min anchor value.
+func TestTxInputSetCpfpAnchor(t *testing.T) {
+	t.Parallel()
+
+	const (
+		feeRate   = 1000
+		minAnchor = 5000
+	)
+
+	newSet := func(policy ChangePolicy) (*txInputSet, error) {
+		return newTxInputSet(feeRate, 0, txInputSetConfig{
+			maxInputs:    10,
+			changePolicy: policy,
+		})
+	}
+
+	// The min anchor value must be above dust, and the anchor can't be
+	// split.
+	_, err := newSet(ChangePolicy{MinChange: 100})
+	require.ErrorIs(t, err, ErrDustOutput)
+
+	_, err = newSet(ChangePolicy{
+		MinChange:      minAnchor,
+		MaxChangeValue: 100_000,
+	})
+	require.Error(t, err)
+
+	set, err := newSet(ChangePolicy{MinChange: minAnchor})
+	require.NoError(t, err)
+
+	// The input alone leaves a non-dust change below the min anchor
+	// value, so the set needs more input.
+	require.True(t, tryAdd(set, createP2WKHInput(4000), constraintsRegular))
+	require.Less(t, set.changeOutput, btcutil.Amount(minAnchor))
+	require.False(t, set.enoughInput())
+	require.Equal(t, minAnchor-set.changeOutput, set.Shortfall())
+
+	// Wallet inputs are added to reach the min anchor value.
+	wallet := &mockUtxoWallet{utxos: []*lnwallet.Utxo{{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       10_000,
+	}}}
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.GreaterOrEqual(t, set.changeOutput, btcutil.Amount(minAnchor))
+
+	// A required output doesn't make the change optional when an anchor
+	// is needed.
+	set, err = newSet(ChangePolicy{MinChange: minAnchor})
+	require.NoError(t, err)
+	require.True(t, tryAdd(set, &reqInput{
+		Input: createP2WKHInput(10_000),
+		txOut: &wire.TxOut{
+			Value:    9000,
+			PkScript: make([]byte, input.P2WPKHSize),
+		},
+	}, constraintsRegular))
+	require.False(t, set.enoughInput())
+}
+
+// TestTxInputSetMaxFeePerInput checks that a regular input adding more fees
+// than the max fee per input is rejected, while lighter inputs and exempt
+// inputs are accepted.
+func TestTxInputSetMaxFeePerInput(t *testing.T) {
+	t.Parallel()
+
+	const feeRate = chainfee.SatPerKWeight(1000)
+
+	heavyInput := createTestInput(100_000, input.HtlcOfferedRemoteTimeout)
+	heavy := &heavyInput
+	light := createP2WKHInput(100_000)
+
+	// fee returns the fee for the weight of the input.
+	fee := func(inp input.Input) btcutil.Amount {
+		iw, err := newInputWeight(inp)
+		require.NoError(t, err)
+
+		return feeRate.FeeForWeight(iw.weight())
+	}
+
+	// Cap the fee per input between the fees of both inputs.
+	maxFee := (fee(heavy) + fee(light)) / 2
+	require.Greater(t, fee(heavy), maxFee)
+	require.Less(t, fee(light), maxFee)
+
+	newSet := func() *txInputSet {
+		set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+			maxInputs: 10,
+		})
+		set.cfg.maxFeePerInput = maxFee
+
+		return set
+	}
+
+	// The heavy regular input is rejected.
+	set := newSet()
+	added, reason := set.add(heavy, constraintsRegular)
+	require.False(t, added)
+	require.Equal(t, rejectFeeCapExceeded, reason)
+
+	// The light one is accepted.
+	require.True(t, tryAdd(set, light, constraintsRegular))
+
+	// Force sweeps are exempt.
+	require.True(t, tryAdd(newSet(), heavy, constraintsForce))
+
+	// So are inputs with required outputs.
+	require.True(t, tryAdd(newSet(), &reqInput{
+		Input: heavy,
+		txOut: &wire.TxOut{
+			Value:    10_000,
+			PkScript: make([]byte, input.P2WPKHSize),
+		},
+	}, constraintsRegular))
+
+	// When adding the sorted inputs, the heavy input is skipped in favor
+	// of the lighter one.
+	set = newSet()
+	lightInput := &SweeperInput{Input: light}
+	set.addPositiveYieldInputs([]*SweeperInput{
+		{Input: heavy}, lightInput,
+	})
+	require.Equal(t, []input.Input{lightInput}, set.inputs)
+}
+
+// TestChangeSpendCost checks that the future spend cost of the change is
+// estimated based on the change output type.
+func TestChangeSpendCost(t *testing.T) {
+	t.Parallel()
+
+	const feeRate = chainfee.SatPerKWeight(1000)
+
+	// A key-spend p2tr input only carries a schnorr signature, which is
+	// cheaper than the signature and public key of a p2wkh input.
+	p2trCost, err := changeSpendCost(lnwallet.TaprootPubkey, feeRate)
+	require.NoError(t, err)
+	require.EqualValues(t, input.InputSize*4+
+		input.TaprootKeyPathWitnessSize, p2trCost)
+
+	p2wkhCost, err := changeSpendCost(lnwallet.WitnessPubKey, feeRate)
+	require.NoError(t, err)
+	require.EqualValues(t, input.InputSize*4+input.P2WKHWitnessSize,
+		p2wkhCost)
+	require.Less(t, p2trCost, p2wkhCost)
+
+	// The cost scales with the fee rate.
+	doubleCost, err := changeSpendCost(lnwallet.TaprootPubkey, feeRate*2)
+	require.NoError(t, err)
+	require.Equal(t, p2trCost*2, doubleCost)
+
+	// An unknown address type returns an error.
+	_, err = changeSpendCost(lnwallet.UnknownAddressType, feeRate)
+	require.Error(t, err)
+
+	// The change of a set is p2tr.
+	set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(set, createP2WKHInput(10_000),
+		constraintsRegular))
+	require.Equal(t, p2trCost, set.ChangeSpendCost(feeRate))
+}
+
+// mockConfsWallet is a wallet that only returns the utxos within the
+// requested confirmations, and records the confs it's asked for.
+type mockConfsWallet struct {
+	Wallet
+
+	utxos []*lnwallet.Utxo
+
+	// minConfs records the min confs of the last call.
+	minConfs int32
+
+	// maxConfs records the max confs of the last call.
+	maxConfs int32
+}
+
+func (m *mockConfsWallet) ListUnspentWitnessFromDefaultAccount(minConfs,
+	maxConfs int32) ([]*lnwallet.Utxo, error) {
+
+	m.minConfs = minConfs
+	m.maxConfs = maxConfs
+
+	var utxos []*lnwallet.Utxo
+	for _, utxo := range m.utxos {
+		if utxo.Confirmations >= int64(minConfs) &&
+			utxo.Confirmations <= int64(maxConfs) {
+
+			utxos = append(utxos, utxo)
+		}
+	}
+
+	return utxos, nil
+}
+
+// TestAddWalletInputsMinConfs checks that the configured min confs is used to
+// list the wallet utxos, so the utxos with fewer confirmations are excluded.
+func TestAddWalletInputsMinConfs(t *testing.T) {
+	t.Parallel()
+
+	// The shallow utxo is smaller, so it'd be selected first if it was
+	// returned by the wallet.
+	shallow := &lnwallet.Utxo{
+		AddressType:   lnwallet.WitnessPubKey,
+		Value:         20_000,
+		Confirmations: 1,
+		OutPoint:      wire.OutPoint{Index: 1},
+	}
+	deep := &lnwallet.Utxo{
+		AddressType:   lnwallet.WitnessPubKey,
+		Value:         30_000,
+		Confirmations: 5,
+		OutPoint:      wire.OutPoint{Index: 2},
+	}
+	newWallet := func() *mockConfsWallet {
+		return &mockConfsWallet{
+			utxos: []*lnwallet.Utxo{shallow, deep},
+		}
+	}
+
+	// newTxSet returns a set whose output is below dust.
+	newTxSet := func() *txInputSet {
+		set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{
+			maxInputs: 10,
+		})
+		require.True(t, tryAdd(
+			set, createP2WKHInput(500), constraintsForce,
+		))
+
+		return set
+	}
+
+	// By default, a single confirmation is required.
+	wallet := newWallet()
+	set := newTxSet()
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Equal(t, int32(1), wallet.minConfs)
+	require.Equal(t, shallow.OutPoint, set.inputs[1].OutPoint())
+
+	// With three confirmations required, the shallow utxo is excluded.
+	wallet = newWallet()
+	set = newTxSet()
+	set.cfg.minConfs = 3
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Equal(t, int32(3), wallet.minConfs)
+	require.Len(t, set.inputs, 2)
+	require.Equal(t, deep.OutPoint, set.inputs[1].OutPoint())
+
+	// The same applies to a budget set.
+	wallet = newWallet()
+	budgetSet := &BudgetInputSet{
+		inputs: []*SweeperInput{{
+			Input: &reqInput{
+				Input: createP2WKHInput(10_000),
+				txOut: &wire.TxOut{Value: 10_000},
+			},
+			params: Params{Budget: 1_000},
+		}},
+	}
+	budgetSet.cfg.MinConfs = 3
+	require.NoError(t, budgetSet.AddWalletInputs(wallet))
+	require.Equal(t, int32(3), wallet.minConfs)
+	require.Len(t, budgetSet.inputs, 2)
+	require.Equal(t, deep.OutPoint, budgetSet.inputs[1].OutPoint())
+}
+
+// TestAddWalletInputsConfirmationBucket checks that only the wallet utxos
+// within the configured confirmation bucket are used as wallet inputs.
+func TestAddWalletInputsConfirmationBucket(t *testing.T) {
+	t.Parallel()
+
+	// The utxos are ordered by value, so the smallest one within the
+	// bucket is selected first.
+	shallow := &lnwallet.Utxo{
+		AddressType:   lnwallet.WitnessPubKey,
+		Value:         20_000,
+		Confirmations: 1,
+		OutPoint:      wire.OutPoint{Index: 1},
+	}
+	inBucket := &lnwallet.Utxo{
+		AddressType:   lnwallet.WitnessPubKey,
+		Value:         30_000,
+		Confirmations: 10,
+		OutPoint:      wire.OutPoint{Index: 2},
+	}
+	deep := &lnwallet.Utxo{
+		AddressType:   lnwallet.WitnessPubKey,
+		Value:         25_000,
+		Confirmations: 200,
+		OutPoint:      wire.OutPoint{Index: 3},
+	}
+	newWallet := func() *mockConfsWallet {
+		return &mockConfsWallet{
+			utxos: []*lnwallet.Utxo{shallow, inBucket, deep},
+		}
+	}
+	bucket := ConfirmationBucket{MinConfs: 6, MaxConfs: 100}
+
+	// Without a bucket, the wallet is asked for all confirmed utxos.
+	wallet := newWallet()
+	set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(set, createP2WKHInput(500), constraintsForce))
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Equal(t, int32(1), wallet.minConfs)
+	require.Equal(t, int32(math.MaxInt32), wallet.maxConfs)
+	require.Equal(t, shallow.OutPoint, set.inputs[1].OutPoint())
+
+	// With a bucket, both the shallow and the deep utxo are excluded.
+	wallet = newWallet()
+	set = newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(set, createP2WKHInput(500), constraintsForce))
+	set.cfg.confBucket = fn.Some(bucket)
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Equal(t, int32(6), wallet.minConfs)
+	require.Equal(t, int32(100), wallet.maxConfs)
+	require.Len(t, set.inputs, 2)
+	require.Equal(t, inBucket.OutPoint, set.inputs[1].OutPoint())
+
+	// Utxos outside of the bucket are skipped even if the wallet returns
+	// them.
+	filter := walletUtxoFilter{}.withBucket(fn.Some(bucket))
+	utxos := prepareWalletUtxos(
+		[]*lnwallet.Utxo{shallow, inBucket, deep}, filter,
+	)
+	require.Equal(t, []*lnwallet.Utxo{shallow, inBucket}, utxos)
+
+	// An invalid bucket is rejected.
+	budgetSet := &BudgetInputSet{
+		inputs: []*SweeperInput{{
+			Input: &reqInput{
+				Input: createP2WKHInput(10_000),
+				txOut: &wire.TxOut{Value: 10_000},
+			},
+			params: Params{Budget: 1_000},
+		}},
+	}
+	invalid := []ConfirmationBucket{
+		{MinConfs: 0, MaxConfs: 10},
+		{MinConfs: 10, MaxConfs: 6},
+	}
+	for _, b := range invalid {
+		cfg := BudgetInputSetConfig{ConfirmationBucket: fn.Some(b)}
+		require.Error(t, cfg.validate(nil))
+	}
+
+	// A deeper min confs takes precedence over the min of the bucket.
+	wallet = newWallet()
+	budgetSet.cfg.MinConfs = 8
+	budgetSet.cfg.ConfirmationBucket = fn.Some(bucket)
+	require.NoError(t, budgetSet.cfg.validate(nil))
+	require.NoError(t, budgetSet.AddWalletInputs(wallet))
+	require.Equal(t, int32(8), wallet.minConfs)
+	require.Equal(t, int32(100), wallet.maxConfs)
+	require.Len(t, budgetSet.inputs, 2)
+	require.Equal(t, inBucket.OutPoint, budgetSet.inputs[1].OutPoint())
+}
+
+// TestAddWalletInputsMinValue checks that the wallet utxos below the min
+// wallet input value are never considered, even if they'd be accepted.
+func TestAddWalletInputsMinValue(t *testing.T) {
+	t.Parallel()
+
+	// The small utxo would be selected first and is large enough to fund
+	// the sets on its own.
+	small := &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       5_000,
+		OutPoint:    wire.OutPoint{Index: 1},
+	}
+	large := &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       50_000,
+		OutPoint:    wire.OutPoint{Index: 2},
+	}
+	wallet := &mockUtxoWallet{utxos: []*lnwallet.Utxo{small, large}}
+
+	// The utxos below the min value are filtered out before sorting.
+	filtered := prepareWalletUtxos(
+		[]*lnwallet.Utxo{large, small},
+		walletUtxoFilter{minValue: 5_001},
+	)
+	require.Equal(t, []*lnwallet.Utxo{large}, filtered)
+
+	// Without a min value, the small utxo is used by a txInputSet.
+	set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(set, createP2WKHInput(500), constraintsForce))
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Equal(t, small.OutPoint, set.inputs[1].OutPoint())
+
+	// With a min value above it, the large utxo is used instead.
+	set = newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	set.cfg.minWalletInputValue = 10_000
+	require.True(t, tryAdd(set, createP2WKHInput(500), constraintsForce))
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 2)
+	require.Equal(t, large.OutPoint, set.inputs[1].OutPoint())
+
+	// The same applies to a budget set.
+	budgetSet := &BudgetInputSet{
+		inputs: []*SweeperInput{{
+			Input: &reqInput{
+				Input: createP2WKHInput(10_000),
+				txOut: &wire.TxOut{Value: 10_000},
+			},
+			params: Params{Budget: 1_000},
+		}},
+	}
+	budgetSet.cfg.MinWalletInputValue = 10_000
+	require.NoError(t, budgetSet.AddWalletInputs(wallet))
+	require.Len(t, budgetSet.inputs, 2)
+	require.Equal(t, large.OutPoint, budgetSet.inputs[1].OutPoint())
+}
+
+// TestTxInputSetSharedRequiredOutputScript checks that required outputs
+// sharing a pkScript are modeled as separate outputs, each of which is dust
+// checked on its own.
+func TestTxInputSetSharedRequiredOutputScript(t *testing.T) {
+	t.Parallel()
+
+	const feeRate = 1000
+
+	pkScript := make([]byte, input.P2WPKHSize)
+	newReqInput := func(value int64) input.Input {
+		return &reqInput{
+			Input: createP2WKHInput(10_000),
+			txOut: &wire.TxOut{
+				Value:    value,
+				PkScript: pkScript,
+			},
+		}
+	}
+
+	first, second := newReqInput(5_000), newReqInput(5_000)
+
+	set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(set, first, constraintsForce))
+	require.True(t, tryAdd(set, second, constraintsForce))
+	require.Equal(t, btcutil.Amount(10_000), set.requiredOutput)
+
+	// The weight estimate holds two outputs rather than a merged one.
+	expected := newWeightEstimator(feeRate, 0)
+	for _, inp := range []input.Input{first, second} {
+		require.NoError(t, expected.add(inp))
+		expected.addOutput(inp.RequiredTxOut())
+	}
+	require.Equal(t, expected.weight(), set.weightEstimate(false).weight())
+
+	// A dust required output is rejected even though its sum with the
+	// other outputs sharing its script would be above dust.
+	dust := newReqInput(100)
+	added, reason := set.add(dust, constraintsForce)
+	require.False(t, added)
+	require.Equal(t, rejectDustOutput, reason)
+}
+
+// TestInputSetIsWalletOnly checks that sets only consolidating wallet utxos
+// are told apart from the ones protecting channel funds.
+func TestInputSetIsWalletOnly(t *testing.T) {
+	t.Parallel()
+
+	newWallet := func() *mockUtxoWallet {
+		return &mockUtxoWallet{utxos: []*lnwallet.Utxo{{
+			AddressType: lnwallet.WitnessPubKey,
+			Value:       20_000,
+			OutPoint:    wire.OutPoint{Index: 1},
+		}}}
+	}
+
+	// An empty budget set is not wallet only.
+	set := &BudgetInputSet{deadlineHeight: testHeight}
+	require.False(t, set.IsWalletOnly())
+
+	// A budget set made of wallet inputs only is wallet only.
+	require.NoError(t, set.AddWalletInputs(newWallet()))
+	require.Len(t, set.inputs, 1)
+	require.True(t, set.IsWalletOnly())
+
+	// A budget set mixing an anchor with wallet inputs is not.
+	anchor := createTestInput(330, input.CommitmentAnchor)
+	set = &BudgetInputSet{inputs: []*SweeperInput{{
+		Input:  &anchor,
+		params: Params{Budget: 10_000, Immediate: true},
+	}}}
+	require.NoError(t, set.AddWalletInputs(newWallet()))
+	require.Len(t, set.inputs, 2)
+	require.False(t, set.IsWalletOnly())
+
+	// The same applies to a txInputSet mixing a regular input with a
+	// wallet input.
+	txSet := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	require.False(t, txSet.IsWalletOnly())
+	require.True(t, tryAdd(txSet, createP2WKHInput(500),
+		constraintsForce))
+	require.NoError(t, txSet.AddWalletInputs(newWallet()))
+	require.Len(t, txSet.inputs, 2)
+	require.False(t, txSet.IsWalletOnly())
+
+	// A wallet utxo offered to the sweeper is told apart by its witness
+	// type, so a txInputSet sweeping it is wallet only, unless it's a
+	// force sweep.
+	for _, constraints := range []addConstraints{
+		constraintsRegular, constraintsForce,
+	} {
+		txSet := newTestTxInputSet(
+			t, 1000, 0, txInputSetConfig{maxInputs: 10},
+		)
+		require.True(t, tryAdd(txSet, createP2WKHInput(20_000),
+			constraints))
+		require.Equal(
+			t, constraints == constraintsRegular,
+			txSet.IsWalletOnly(),
+		)
+	}
+}
+
+// TestInputSetTxVersion checks that the tx version of the sets defaults to 2,
+// is surfaced via the InputSet interface and that the size limits of v3 txns
+// are enforced by Validate.
+func TestInputSetTxVersion(t *testing.T) {
+	t.Parallel()
+
+	// The version defaults to 2 and only versions 2 and 3 are accepted.
+	txSet := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 300})
+	require.Equal(t, int32(2), txSet.TxVersion())
+	for _, version := range []int32{1, 4} {
+		_, err := newTxInputSet(1000, 0, txInputSetConfig{
+			maxInputs: 300,
+			txVersion: version,
+		})
+		require.ErrorIs(t, err, ErrUnsupportedTxVersion)
+	}
+	txSet = newTestTxInputSet(t, 1000, 0, txInputSetConfig{
+		maxInputs: 300,
+		txVersion: 3,
+	})
+
+	var set InputSet = txSet
+	require.Equal(t, int32(3), set.TxVersion())
+
+	// Add enough inputs for the tx to exceed the v3 size limit.
+	for i := 0; i < 200; i++ {
+		require.True(t, tryAdd(txSet, createP2WKHInput(10_000),
+			constraintsRegular))
+	}
+	require.Greater(t, txSet.weightEstimate(true).weight(),
+		maxTrucTxWeight)
+	require.ErrorIs(t, txSet.Validate(testHeight), ErrTxTooLarge)
+
+	// The same tx is fine with version 2.
+	txSet.cfg.txVersion = 2
+	require.NoError(t, txSet.Validate(testHeight))
+
+	// The budget set behaves the same way.
+	inputs := make([]*SweeperInput, 0, 200)
+	for i := 0; i < 200; i++ {
+		inputs = append(inputs, &SweeperInput{
+			Input:  createP2WKHInput(10_000),
+			params: Params{Budget: 100},
+		})
+	}
+	budgetSet := &BudgetInputSet{
+		inputs:         inputs,
+		deadlineHeight: testHeight,
+	}
+	require.Equal(t, int32(2), budgetSet.TxVersion())
+	require.NoError(t, budgetSet.Validate(testHeight))
+
+	cfg := BudgetInputSetConfig{TxVersion: 1}
+	require.ErrorIs(t, cfg.validate(nil), ErrUnsupportedTxVersion)
+	budgetSet.cfg.TxVersion = 3
+
+	set = budgetSet
+	require.Equal(t, int32(3), set.TxVersion())
+	require.ErrorIs(t, budgetSet.Validate(testHeight), ErrTxTooLarge)
+
+	// A small v3 set is valid.
+	budgetSet.inputs = inputs[:1]
+	require.NoError(t, budgetSet.Validate(testHeight))
+
+	// The request falls back to version 2.
+	require.Equal(t, int32(2), (&BumpRequest{}).txVersion())
+	require.Equal(t, int32(3), (&BumpRequest{TxVersion: 3}).txVersion())
+}
+
+// TestAddWalletInputsTrucConfirmed checks that the wallet inputs of a v3 set
+// are confirmed, as an unconfirmed wallet utxo would be a parent the TRUC
+// policy limits, even when no min confs is configured.
+func TestAddWalletInputsTrucConfirmed(t *testing.T) {
+	t.Parallel()
+
+	unconfirmed := &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       20_000,
+		OutPoint:    wire.OutPoint{Index: 1},
+	}
+
+	wallet := &mockConfsWallet{utxos: []*lnwallet.Utxo{unconfirmed}}
+	txSet := newTestTxInputSet(t, 1000, 0, txInputSetConfig{
+		maxInputs: 10,
+		txVersion: trucTxVersion,
+	})
+	require.True(t, tryAdd(txSet, createP2WKHInput(500), constraintsForce))
+	require.ErrorIs(t, txSet.AddWalletInputs(wallet), ErrNoWalletUtxos)
+	require.Equal(t, defaultWalletMinConfs, wallet.minConfs)
+	require.Len(t, txSet.inputs, 1)
+
+	wallet = &mockConfsWallet{utxos: []*lnwallet.Utxo{unconfirmed}}
+	budgetSet := &BudgetInputSet{
+		inputs: []*SweeperInput{{
+			Input:  createP2WKHInput(500),
+			params: Params{Budget: 10_000},
+		}},
+		deadlineHeight: testHeight,
+		cfg:            BudgetInputSetConfig{TxVersion: trucTxVersion},
+	}
+	require.ErrorIs(t, budgetSet.AddWalletInputs(wallet), ErrNoWalletUtxos)
+	require.Equal(t, defaultWalletMinConfs, wallet.minConfs)
+	require.Len(t, budgetSet.inputs, 1)
+
+	// Once confirmed, the utxo is added to both sets.
+	unconfirmed.Confirmations = 1
+	require.NoError(t, txSet.AddWalletInputs(wallet))
+	require.Len(t, txSet.inputs, 2)
+	require.NoError(t, budgetSet.AddWalletInputs(wallet))
+	require.Len(t, budgetSet.inputs, 2)
+}
+
+// TestAddWalletInputsUtxoScorer checks that a utxo scorer makes the wallet
+// utxos with lower scores be spent first, regardless of their values.
+func TestAddWalletInputsUtxoScorer(t *testing.T) {
+	t.Parallel()
+
+	// Without a scorer, the smallest utxo is selected first.
+	small := &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       5_000,
+		OutPoint:    wire.OutPoint{Index: 1},
+	}
+	medium := &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       8_000,
+		OutPoint:    wire.OutPoint{Index: 2},
+	}
+	large := &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       50_000,
+		OutPoint:    wire.OutPoint{Index: 3},
+	}
+	wallet := &mockUtxoWallet{
+		utxos: []*lnwallet.Utxo{large, small, medium},
+	}
+
+	scores := map[wire.OutPoint]float64{
+		small.OutPoint:  0.9,
+		medium.OutPoint: 0.1,
+		large.OutPoint:  0.5,
+	}
+	scorer := func(utxo *lnwallet.Utxo) float64 {
+		return scores[utxo.OutPoint]
+	}
+
+	// The scorer reorders the utxos by ascending score.
+	sorted := prepareWalletUtxos(
+		[]*lnwallet.Utxo{large, small, medium}, walletUtxoFilter{},
+	)
+	require.Equal(t, []*lnwallet.Utxo{small, medium, large}, sorted)
+
+	sorted = prepareWalletUtxos(
+		[]*lnwallet.Utxo{large, small, medium},
+		walletUtxoFilter{scorer: scorer},
+	)
+	require.Equal(t, []*lnwallet.Utxo{medium, large, small}, sorted)
+
+	// Equal scores fall back to ordering by value.
+	sorted = prepareWalletUtxos(
+		[]*lnwallet.Utxo{large, small, medium},
+		walletUtxoFilter{scorer: func(*lnwallet.Utxo) float64 {
+			return 1
+		}},
+	)
+	require.Equal(t, []*lnwallet.Utxo{small, medium, large}, sorted)
+
+	// Without a scorer, the small utxo is used by a txInputSet.
+	set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(set, createP2WKHInput(500), constraintsForce))
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Equal(t, small.OutPoint, set.inputs[1].OutPoint())
+
+	// With the scorer, the lowest scored utxo is used instead.
+	set = newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	set.cfg.utxoScorer = scorer
+	require.True(t, tryAdd(set, createP2WKHInput(500), constraintsForce))
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 2)
+	require.Equal(t, medium.OutPoint, set.inputs[1].OutPoint())
+
+	// The same applies to a budget set.
+	newBudgetSet := func() *BudgetInputSet {
+		return &BudgetInputSet{
+			inputs: []*SweeperInput{{
+				Input: &reqInput{
+					Input: createP2WKHInput(10_000),
+					txOut: &wire.TxOut{Value: 10_000},
+				},
+				params: Params{Budget: 1_000},
+			}},
+		}
+	}
+
+	budgetSet := newBudgetSet()
+	require.NoError(t, budgetSet.AddWalletInputs(wallet))
+	require.Len(t, budgetSet.inputs, 2)
+	require.Equal(t, small.OutPoint, budgetSet.inputs[1].OutPoint())
+
+	budgetSet = newBudgetSet()
+	budgetSet.cfg.UtxoScorer = scorer
+	require.NoError(t, budgetSet.AddWalletInputs(wallet))
+	require.Len(t, budgetSet.inputs, 2)
+	require.Equal(t, medium.OutPoint, budgetSet.inputs[1].OutPoint())
+}
+
+// slowUtxoWallet is a mockUtxoWallet that takes the given delay to list its
+// utxos.
+type slowUtxoWallet struct {
+	mockUtxoWallet
+
+	delay time.Duration
+}
+
+func (s *slowUtxoWallet) ListUnspentWitnessFromDefaultAccount(minConfs,
+	maxConfs int32) ([]*lnwallet.Utxo, error) {
+
+	time.Sleep(s.delay)
+
+	return s.mockUtxoWallet.ListUnspentWitnessFromDefaultAccount(
+		minConfs, maxConfs,
+	)
+}
+
+// TestAddWalletInputsCoinSelectTimeout checks that adding wallet inputs is
+// aborted and the set reverted once the coin select timeout is exceeded.
+func TestAddWalletInputsCoinSelectTimeout(t *testing.T) {
+	t.Parallel()
+
+	wallet := &slowUtxoWallet{
+		mockUtxoWallet: mockUtxoWallet{utxos: []*lnwallet.Utxo{{
+			AddressType: lnwallet.WitnessPubKey,
+			Value:       5_000,
+			OutPoint:    wire.OutPoint{Index: 1},
+		}}},
+		delay: 50 * time.Millisecond,
+	}
+
+	newSet := func() *BudgetInputSet {
+		return &BudgetInputSet{
+			inputs: []*SweeperInput{{
+				Input: &reqInput{
+					Input: createP2WKHInput(10_000),
+					txOut: &wire.TxOut{Value: 10_000},
+				},
+				params: Params{Budget: 1_000},
+			}},
+		}
+	}
+
+	// The selection times out and the set is reverted.
+	set := newSet()
+	set.cfg.CoinSelectTimeout = time.Millisecond
+	err := set.AddWalletInputs(wallet)
+	require.ErrorIs(t, err, ErrCoinSelectTimeout)
+	require.Len(t, set.inputs, 1)
+	require.Zero(t, set.walletInputTotal)
+	require.True(t, set.NeedWalletInput())
+
+	// With a generous timeout, the wallet input is added.
+	set = newSet()
+	set.cfg.CoinSelectTimeout = time.Minute
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 2)
+
+	// Without a timeout, the selection is never aborted.
+	set = newSet()
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 2)
+}
+
+// exponentialFeeBumpPolicy is a FeeBumpPolicy doubling the fee rate each
+// block, capped at a max fee rate.
+type exponentialFeeBumpPolicy struct {
+	maxFeeRate chainfee.SatPerKWeight
+}
+
+func (e *exponentialFeeBumpPolicy) NextFeeRate(
+	current chainfee.SatPerKWeight, _ int32) chainfee.SatPerKWeight {
+
+	return min(current*2, e.maxFeeRate)
+}
+
+// TestBudgetInputSetFeeBumpPolicy checks that the fee rate of a budget set is
+// escalated by its fee bump policy over the deadline window.
+func TestBudgetInputSetFeeBumpPolicy(t *testing.T) {
+	t.Parallel()
+
+	// The linear policy raises the fee rate evenly to reach the max fee
+	// rate at the deadline.
+	linear := &LinearFeeBumpPolicy{MaxFeeRate: 5_000}
+	feeRate := chainfee.SatPerKWeight(1_000)
+	for blocksLeft := int32(4); blocksLeft > 0; blocksLeft-- {
+		feeRate = linear.NextFeeRate(feeRate, blocksLeft)
+	}
+	require.Equal(t, chainfee.SatPerKWeight(5_000), feeRate)
+	require.Equal(t, chainfee.SatPerKWeight(2_000),
+		linear.NextFeeRate(1_000, 4))
+	require.Equal(t, chainfee.SatPerKWeight(6_000),
+		linear.NextFeeRate(6_000, 4))
+
+	newSet := func() *BudgetInputSet {
+		return &BudgetInputSet{
+			inputs: []*SweeperInput{{
+				Input:  createP2WKHInput(100_000),
+				params: Params{Budget: 10_000},
+			}},
+			deadlineHeight: testHeight + 5,
+		}
+	}
+
+	// A custom exponential policy drives the fee rate of the set.
+	set := newSet()
+	set.SetFeeRate(1_000)
+	set.cfg.FeeBumpPolicy = &exponentialFeeBumpPolicy{maxFeeRate: 10_000}
+
+	expected := []chainfee.SatPerKWeight{2_000, 4_000, 8_000, 10_000,
+		10_000}
+	for i, rate := range expected {
+		feeRate, err := set.BumpFeeRate(testHeight + int32(i))
+		require.NoError(t, err)
+		require.Equal(t, rate, feeRate)
+		require.Equal(t, rate, set.FeeRate())
+		require.Equal(t, fn.Some(rate), set.StartingFeeRate())
+	}
+
+	// The default policy uses the whole budget at the deadline.
+	set = newSet()
+	_, weight, err := estimateFeeAt(set.Inputs(), 0)
+	require.NoError(t, err)
+	maxFeeRate := chainfee.NewSatPerKWeight(10_000, uint64(weight))
+
+	prev := chainfee.FeePerKwFloor
+	for height := testHeight; height < set.deadlineHeight; height++ {
+		feeRate, err := set.BumpFeeRate(height)
+		require.NoError(t, err)
+		require.Greater(t, feeRate, prev)
+		prev = feeRate
+	}
+	require.Equal(t, maxFeeRate, set.FeeRate())
+}
+
+// TestAddWalletInputsMaxFeeToChangeRatio checks that a fee too high compared
+// to the change either adds more wallet value or drops the change to fees.
+func TestAddWalletInputsMaxFeeToChangeRatio(t *testing.T) {
+	t.Parallel()
+
+	// fee returns the fee currently paid by the set.
+	fee := func(set *txInputSet) btcutil.Amount {
+		return set.inputTotal - set.requiredOutput - set.changeOutput
+	}
+
+	// newSet returns a set with a small non-dust change, whose fee
+	// exceeds a tenth of the change.
+	newSet := func(inp input.Input) *txInputSet {
+		set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{
+			maxInputs:           10,
+			maxFeeToChangeRatio: 0.1,
+		})
+		require.True(t, tryAdd(set, inp, constraintsForce))
+		require.GreaterOrEqual(t, set.changeOutput,
+			set.changeDustLimit())
+		require.True(t, set.feeToChangeRatioExceeded())
+
+		return set
+	}
+
+	// Invalid ratios are rejected.
+	for _, ratio := range []float64{-1, math.NaN()} {
+		_, err := newTxInputSet(1000, 0, txInputSetConfig{
+			maxInputs:           10,
+			maxFeeToChangeRatio: ratio,
+		})
+		require.Error(t, err)
+	}
+
+	set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+
+	// Without a max ratio, the small change is accepted as is.
+	require.True(t, tryAdd(set, createP2WKHInput(1_500), constraintsForce))
+	require.NoError(t, set.AddWalletInputs(&mockUtxoWallet{}))
+	require.Len(t, set.inputs, 1)
+
+	// A wallet utxo is added to raise the change.
+	wallet := &mockUtxoWallet{utxos: []*lnwallet.Utxo{{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       50_000,
+	}}}
+	set = newSet(createP2WKHInput(1_500))
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 2)
+	require.False(t, set.changePolicy.Drop)
+	require.LessOrEqual(t, float64(fee(set)), 0.1*float64(set.changeOutput))
+
+	// Without wallet value and a required output, the tx can't drop its
+	// change, so the set is rejected and left unchanged.
+	set = newSet(createP2WKHInput(1_500))
+	change := set.changeOutput
+	err := set.AddWalletInputs(&mockUtxoWallet{})
+	require.ErrorIs(t, err, ErrFeeToChangeRatioExceeded)
+	require.Len(t, set.inputs, 1)
+	require.Equal(t, change, set.changeOutput)
+	require.False(t, set.changePolicy.Drop)
+
+	// With a required output, the change is dropped to the fees instead.
+	set = newSet(&reqInput{
+		Input: createP2WKHInput(20_000),
+		txOut: &wire.TxOut{
+			Value:    18_000,
+			PkScript: make([]byte, input.P2WPKHSize),
+		},
+	})
+	require.NoError(t, set.AddWalletInputs(&mockUtxoWallet{}))
+	require.Len(t, set.inputs, 1)
+	require.True(t, set.changePolicy.Drop)
+
+	txOuts, err := set.ChangeOutputs(func(string) ([]byte, error) {
+		return changePkScript, nil
+	})
+	require.NoError(t, err)
+	require.Empty(t, txOuts)
+}
+
+// TestFeeRateJitter checks that the jittered fee rate of a set stays within
+// its bounds and differs across sets.
+func TestFeeRateJitter(t *testing.T) {
+	t.Parallel()
+
+	const (
+		feeRate    = chainfee.SatPerKWeight(10_000)
+		maxFeeRate = chainfee.SatPerKWeight(10_500)
+	)
+
+	// The extremes of the random value scale the fee rate by the jitter
+	// in either direction, clamped to the max fee rate.
+	require.Equal(t, chainfee.SatPerKWeight(9_000),
+		jitterFeeRate(feeRate, 0, 0.1, 0))
+	require.Equal(t, chainfee.SatPerKWeight(10_000),
+		jitterFeeRate(feeRate, 0, 0.1, 0.5))
+	require.Equal(t, chainfee.SatPerKWeight(11_000),
+		jitterFeeRate(feeRate, 0, 0.1, 1))
+	require.Equal(t, maxFeeRate, jitterFeeRate(feeRate, maxFeeRate, 0.1, 1))
+
+	// The fee rate never drops below the min relay fee rate.
+	require.Equal(t, chainfee.FeePerKwFloor,
+		jitterFeeRate(chainfee.FeePerKwFloor, 0, 0.1, 0))
+
+	// Without a jitter, all sets share the same fee rate and no source is
+	// needed.
+	cfg := txInputSetConfig{maxInputs: 10}
+	set, err := newJitteredTxInputSet(feeRate, maxFeeRate, 0, nil, cfg)
+	require.NoError(t, err)
+	require.Equal(t, feeRate, set.feeRate)
+
+	// With a jitter, the fee rates stay in bounds and differ across sets.
+	source := rand.New(rand.NewSource(1))
+	feeRates := fn.NewSet[chainfee.SatPerKWeight]()
+	var drawn []chainfee.SatPerKWeight
+	for i := 0; i < 20; i++ {
+		set, err := newJitteredTxInputSet(
+			feeRate, maxFeeRate, 0.1, source, cfg,
+		)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, set.feeRate,
+			chainfee.SatPerKWeight(9_000))
+		require.LessOrEqual(t, set.feeRate, maxFeeRate)
+		require.Equal(t, set.feeRate, set.inputsEstimate.feeRate)
+
+		feeRates.Add(set.feeRate)
+		drawn = append(drawn, set.feeRate)
+	}
+	require.Greater(t, len(feeRates), 1)
+
+	// The same seed produces the same fee rates.
+	source = rand.New(rand.NewSource(1))
+	for _, expected := range drawn {
+		set, err := newJitteredTxInputSet(
+			feeRate, maxFeeRate, 0.1, source, cfg,
+		)
+		require.NoError(t, err)
+		require.Equal(t, expected, set.feeRate)
+	}
+}
+
+// TestPreimageInput checks that the witness of an input revealing a preimage
+// is sized correctly, and that an input lacking its preimage is rejected.
+func TestPreimageInput(t *testing.T) {
+	t.Parallel()
+
+	receiverKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	senderKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	revokeKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	preimage := lntypes.Preimage{1, 2, 3}
+	hash := preimage.Hash()
+	witnessScript, err := input.SenderHTLCScript(
+		senderKey.PubKey(), receiverKey.PubKey(), revokeKey.PubKey(),
+		hash[:], false,
+	)
+	require.NoError(t, err)
+	pkScript, err := input.WitnessScriptHash(witnessScript)
+	require.NoError(t, err)
+
+	signDesc := &input.SignDescriptor{
+		KeyDesc: keychain.KeyDescriptor{
+			PubKey: receiverKey.PubKey(),
+		},
+		WitnessScript: witnessScript,
+		Output:        &wire.TxOut{Value: 100_000, PkScript: pkScript},
+		HashType:      txscript.SigHashAll,
+	}
+	newInput := func(preimage []byte) *input.HtlcSucceedInput {
+		inp := input.MakeHtlcSucceedInput(
+			&wire.OutPoint{Index: 5}, signDesc, preimage, 0, 0,
+		)
+
+		return &inp
+	}
+
+	// Sign a tx spending the input to get its actual witness.
+	inp := newInput(preimage[:])
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(&wire.TxIn{PreviousOutPoint: inp.OutPoint()})
+	tx.AddTxOut(&wire.TxOut{Value: 90_000, PkScript: changePkScript})
+
+	fetcher := txscript.NewCannedPrevOutputFetcher(pkScript, 100_000)
+	signer := input.NewMockSigner(
+		[]*btcec.PrivateKey{receiverKey}, nil,
+	)
+	script, err := inp.CraftInputScript(
+		signer, tx, txscript.NewTxSigHashes(tx, fetcher), fetcher, 0,
+	)
+	require.NoError(t, err)
+	require.Equal(t, preimage[:], script.Witness[1])
+
+	// The estimate is an upper bound of the actual witness size, which is
+	// only smaller when the signature is shorter than its max size.
+	estimated, _, err := inp.WitnessType().SizeUpperBound()
+	require.NoError(t, err)
+	actual := script.Witness.SerializeSize()
+	require.LessOrEqual(t, actual, estimated)
+	require.InDelta(t, estimated, actual, 2)
+
+	// The set accounts for the estimated witness size.
+	set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(set, inp, constraintsRegular))
+	require.Equal(t, estimated, set.inputWeights[0].witnessSize)
+
+	// An input lacking its preimage is rejected before it's added.
+	set = newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	added, reason := set.add(newInput(nil), constraintsRegular)
+	require.False(t, added)
+	require.Equal(t, rejectMalformed, reason)
+	require.Empty(t, set.inputs)
+
+	// A budget set can't be created with it either.
+	_, err = NewBudgetInputSet(
+		[]SweeperInput{{Input: newInput(preimage[:16])}},
+		testHeight, testHeight, BudgetInputSetConfig{},
+	)
+	require.Error(t, err)
+}
+
+// TestBudgetInputSetCheckpoint checks that the wallet inputs borrowed by a set
+// can be checkpointed and restored without running coin selection again.
+func TestBudgetInputSetCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	cdb, err := channeldb.MakeTestDB(t)
+	require.NoError(t, err)
+	store, err := NewSweeperStore(cdb, &chainhash.Hash{})
+	require.NoError(t, err)
+
+	// newSet returns a set whose required output borrows 10k sats of
+	// budget.
+	newReqInput := func() input.Input {
+		return &reqInput{
+			Input: createP2WKHInput(100_000),
+			txOut: &wire.TxOut{Value: 100_000},
+		}
+	}
+	reqInp := newReqInput()
+	newSet := func(inp input.Input) *BudgetInputSet {
+		return &BudgetInputSet{
+			inputs: []*SweeperInput{{
+				Input:  inp,
+				params: Params{Budget: 10_000},
+			}},
+			deadlineHeight: testHeight,
+		}
+	}
+
+	wallet := &mockUtxoWallet{utxos: []*lnwallet.Utxo{{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       4_000,
+		PkScript:    []byte{0, 20, 1},
+		OutPoint:    wire.OutPoint{Index: 1},
+	}, {
+		AddressType: lnwallet.TaprootPubkey,
+		Value:       7_000,
+		PkScript:    []byte{1, 32, 2},
+		OutPoint:    wire.OutPoint{Index: 2},
+	}, {
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       30_000,
+		PkScript:    []byte{0, 20, 3},
+		OutPoint:    wire.OutPoint{Index: 3},
+	}}}
+
+	// The two smallest utxos are borrowed and checkpointed.
+	set := newSet(reqInp)
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 3)
+	require.NoError(t, set.Checkpoint(store))
+
+	// A set with other inputs sharing the deadline has no checkpoint, so
+	// nothing is restored.
+	other := newSet(newReqInput())
+	restored, err := other.RestoreWalletInputs(store, wallet, nil)
+	require.NoError(t, err)
+	require.False(t, restored)
+	require.Len(t, other.inputs, 1)
+
+	// Nor is a checkpoint stored for it, as it has no wallet inputs.
+	require.NoError(t, other.Checkpoint(store))
+	otherID, err := other.checkpointID()
+	require.NoError(t, err)
+	_, err = store.FetchWalletInputs(otherID)
+	require.ErrorIs(t, err, ErrWalletInputsNotFound)
+
+	// The exact selection is restored into a new set.
+	restoredSet := newSet(reqInp)
+	restored, err = restoredSet.RestoreWalletInputs(store, wallet, nil)
+	require.NoError(t, err)
+	require.True(t, restored)
+	require.Equal(t, set.walletInputTotal, restoredSet.walletInputTotal)
+	require.Equal(t, set.walletInputs, restoredSet.walletInputs)
+	require.Zero(t, restoredSet.Shortfall())
+	require.Len(t, restoredSet.inputs, len(set.inputs))
+	for i, inp := range set.inputs {
+		restoredInp := restoredSet.inputs[i]
+		require.Equal(t, inp.OutPoint(), restoredInp.OutPoint())
+		require.Equal(t, inp.WitnessType(), restoredInp.WitnessType())
+		require.Equal(t, inp.SignDesc().Output,
+			restoredInp.SignDesc().Output)
+	}
+
+	// Restoring again doesn't duplicate the wallet inputs.
+	restored, err = restoredSet.RestoreWalletInputs(store, wallet, nil)
+	require.NoError(t, err)
+	require.True(t, restored)
+	require.Len(t, restoredSet.inputs, len(set.inputs))
+
+	// A checkpointed utxo no longer listed by the wallet can still be
+	// restored if it's leased.
+	leasedWallet := &mockUtxoWallet{utxos: wallet.utxos[:1]}
+	leasedSet := newSet(reqInp)
+	leasedSet.cfg.LeaseChecker = func(op wire.OutPoint) bool {
+		return op.Index == 2
+	}
+	restored, err = leasedSet.RestoreWalletInputs(store, leasedWallet, nil)
+	require.NoError(t, err)
+	require.True(t, restored)
+	require.Len(t, leasedSet.inputs, len(set.inputs))
+
+	// The same applies if it's spent by our sweeping tx in the mempool.
+	sweepingSet := newSet(reqInp)
+	restored, err = sweepingSet.RestoreWalletInputs(
+		store, leasedWallet, func(op wire.OutPoint) bool {
+			return op.Index == 2
+		},
+	)
+	require.NoError(t, err)
+	require.True(t, restored)
+	require.Len(t, sweepingSet.inputs, len(set.inputs))
+
+	// Otherwise, it's been spent, so the stale checkpoint is discarded
+	// and nothing is restored.
+	spentSet := newSet(reqInp)
+	restored, err = spentSet.RestoreWalletInputs(store, leasedWallet, nil)
+	require.NoError(t, err)
+	require.False(t, restored)
+	require.Len(t, spentSet.inputs, 1)
+
+	restored, err = spentSet.RestoreWalletInputs(store, wallet, nil)
+	require.NoError(t, err)
+	require.False(t, restored)
+}
+
+// TestRestoreWalletInputsSweepAccount checks that the checkpointed utxos of
+// the sweep account are restored, as they're listed from that account.
+func TestRestoreWalletInputsSweepAccount(t *testing.T) {
+	t.Parallel()
+
+	const account = "sweep"
+
+	cdb, err := channeldb.MakeTestDB(t)
+	require.NoError(t, err)
+	store, err := NewSweeperStore(cdb, &chainhash.Hash{})
+	require.NoError(t, err)
+
+	reqInp := &reqInput{
+		Input: createP2WKHInput(10_000),
+		txOut: &wire.TxOut{Value: 10_000},
+	}
+	newSet := func() *BudgetInputSet {
+		set := &BudgetInputSet{
+			inputs: []*SweeperInput{{
+				Input:  reqInp,
+				params: Params{Budget: 1_000},
+			}},
+			deadlineHeight: testHeight,
+		}
+		set.cfg.SweepAccount = account
+
+		return set
+	}
+
+	swept := &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       5_000,
+		OutPoint:    wire.OutPoint{Index: 1},
+	}
+	wallet := &mockAccountWallet{
+		account:      account,
+		accountUtxos: []*lnwallet.Utxo{swept},
+	}
+
+	// The utxo of the sweep account is selected and checkpointed.
+	set := newSet()
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 2)
+	require.NoError(t, set.Checkpoint(store))
+
+	// It's restored although the default account doesn't list it.
+	restoredSet := newSet()
+	restored, err := restoredSet.RestoreWalletInputs(store, wallet, nil)
+	require.NoError(t, err)
+	require.True(t, restored)
+	require.Equal(t, set.walletInputs, restoredSet.walletInputs)
+}
+
+// TestEphemeralAnchor checks that an ephemeral anchor replaces the change
+// output, adding its weight to the tx without being subject to the dust limit,
+// and that the tx pays no fee, so it's only valid as a v3 tx whose inputs are
+// fully paid out to their required outputs.
+func TestEphemeralAnchor(t *testing.T) {
+	t.Parallel()
+
+	genScript := func(string) ([]byte, error) {
+		return changePkScript, nil
+	}
+
+	// newReqInput returns an input paying the given value to its required
+	// output, e.g., a presigned zero-fee HTLC tx.
+	newReqInput := func(value, reqValue int64) input.Input {
+		return &reqInput{
+			Input: createP2WKHInput(btcutil.Amount(value)),
+			txOut: &wire.TxOut{
+				Value:    reqValue,
+				PkScript: make([]byte, input.P2WPKHSize),
+			},
+		}
+	}
+	inp := newReqInput(10_000, 10_000)
+
+	anchorCfg := txInputSetConfig{
+		maxInputs:    10,
+		txVersion:    trucTxVersion,
+		changePolicy: ChangePolicy{EphemeralAnchor: true},
+	}
+	set := newTestTxInputSet(t, chainfee.FeePerKwFloor, 0, anchorCfg)
+	require.True(t, tryAdd(set, inp, constraintsForce))
+
+	regular := newTestTxInputSet(
+		t, chainfee.FeePerKwFloor, 0, txInputSetConfig{maxInputs: 10},
+	)
+	require.True(t, tryAdd(regular, inp, constraintsForce))
+
+	// The anchor adds the weight of a zero-value output with a 4-byte
+	// script, instead of the weight of a p2tr change output.
+	anchorWeight := (8 + 1 + len(ephemeralAnchorScript)) *
+		blockchain.WitnessScaleFactor
+	require.Equal(t, anchorWeight, changeWeight(set))
+	require.Equal(t, regular.weightEstimate(false).weight(),
+		set.weightEstimate(false).weight())
+
+	// The regular set can't pay its fees, while the anchored tx pays
+	// none and isn't subject to the dust limit.
+	require.False(t, regular.enoughInput())
+	require.Zero(t, set.changeOutput)
+	require.True(t, set.enoughInput())
+	require.Zero(t, set.Shortfall())
+	require.NoError(t, set.Validate(testHeight))
+
+	txOuts, err := set.ChangeOutputs(genScript)
+	require.NoError(t, err)
+	require.Equal(t, []*wire.TxOut{{
+		Value:    0,
+		PkScript: ephemeralAnchorScript,
+	}}, txOuts)
+
+	// The inputs whose value would be paid as fee are rejected, whether
+	// they leave some value on top of their required output or have none.
+	for _, inp := range []input.Input{
+		newReqInput(10_000, 9_700), createP2WKHInput(100_000),
+	} {
+		added, reason := set.add(inp, constraintsRegular)
+		require.False(t, added)
+		require.Equal(t, rejectAnchorFee, reason)
+	}
+
+	// No wallet input is added to an anchored set, as its value would be
+	// paid as fee.
+	empty := newTestTxInputSet(t, chainfee.FeePerKwFloor, 0, anchorCfg)
+	wallet := &mockUtxoWallet{utxos: []*lnwallet.Utxo{{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       20_000,
+		OutPoint:    wire.OutPoint{Index: 1},
+	}}}
+	require.ErrorIs(t, empty.AddWalletInputs(wallet), ErrNotEnoughInputs)
+	require.Empty(t, empty.inputs)
+
+	// The anchor is only relayed in a v3 tx.
+	v2Cfg := anchorCfg
+	v2Cfg.txVersion = 0
+	_, err = newTxInputSet(chainfee.FeePerKwFloor, 0, v2Cfg)
+	require.ErrorIs(t, err, ErrInvalidEphemeralAnchor)
+
+	// The anchor can't be combined with a CPFP anchor.
+	anchorCfg.changePolicy.MinChange = 1_000
+	_, err = newTxInputSet(chainfee.FeePerKwFloor, 0, anchorCfg)
+	require.Error(t, err)
+}
+
+// TestImmatureInput checks that the inputs whose time locks haven't expired
+// are rejected, and accepted once the height advances.
+func TestImmatureInput(t *testing.T) {
+	t.Parallel()
+
+	signDesc := &input.SignDescriptor{
+		Output: &wire.TxOut{Value: 100_000},
+	}
+
+	// The CSV input confirmed at height 95 can be spent in block 105,
+	// i.e., once the current height is 104.
+	csvInp := input.NewCsvInput(
+		&wire.OutPoint{Index: 1}, input.CommitmentTimeLock, signDesc,
+		95, 10,
+	)
+
+	// The CLTV input can be spent by a tx locked at height 110.
+	cltvInp := input.NewCsvInputWithCltv(
+		&wire.OutPoint{Index: 2}, input.CommitmentTimeLock, signDesc,
+		0, 0, 110,
+	)
+
+	testCases := []struct {
+		name     string
+		inp      input.Input
+		immature int32
+		mature   int32
+	}{
+		{
+			name:     "csv",
+			inp:      csvInp,
+			immature: 103,
+			mature:   104,
+		},
+		{
+			name:     "cltv",
+			inp:      cltvInp,
+			immature: 109,
+			mature:   110,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			set := newTestTxInputSet(
+				t, chainfee.FeePerKwFloor, 0,
+				txInputSetConfig{maxInputs: 10},
+			)
+			set.cfg.currentHeight = tc.immature
+
+			added, reason := set.add(tc.inp, constraintsRegular)
+			require.False(t, added)
+			require.Equal(t, rejectImmature, reason)
+			require.Empty(t, set.inputs)
+
+			// Once the height advances, the input is accepted.
+			set.cfg.currentHeight = tc.mature
+			added, reason = set.add(tc.inp, constraintsRegular)
+			require.True(t, added, reason)
+			require.Equal(t, rejectNone, reason)
+		})
+	}
+
+	// Without a current height, the maturity isn't checked.
+	set := newTestTxInputSet(
+		t, chainfee.FeePerKwFloor, 0, txInputSetConfig{maxInputs: 10},
+	)
+	require.True(t, tryAdd(set, csvInp, constraintsRegular))
+
+	// An immature input is skipped when building a set, while the
+	// remaining inputs are still added.
+	set = newTestTxInputSet(
+		t, chainfee.FeePerKwFloor, 0, txInputSetConfig{maxInputs: 10},
+	)
+	set.cfg.currentHeight = 100
+	set.addPositiveYieldInputs([]*SweeperInput{
+		{Input: csvInp}, {Input: createP2WKHInput(50_000)},
+	})
+	require.Len(t, set.inputs, 1)
+	require.NotEqual(t, csvInp.OutPoint(), set.inputs[0].OutPoint())
+}
+
+// TestTargetOutputCount checks that a set with a target output count creates
+// exactly that many non-dust change outputs, adding wallet inputs if needed.
+func TestTargetOutputCount(t *testing.T) {
+	t.Parallel()
+
+	const count = 3
+
+	genScript := func(string) ([]byte, error) {
+		return changePkScript, nil
+	}
+
+	// Invalid counts and conflicting change options are rejected.
+	invalid := []ChangePolicy{
+		{TargetOutputCount: -1},
+		{TargetOutputCount: count, MaxChangeValue: 10_000},
+	}
+	for _, policy := range invalid {
+		_, err := newTxInputSet(1000, 0, txInputSetConfig{
+			maxInputs:    10,
+			changePolicy: policy,
+		})
+		require.Error(t, err)
+	}
+
+	// A single change output is above dust without wallet inputs.
+	inp := createP2WKHInput(1_500)
+	single := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(single, inp, constraintsForce))
+	require.True(t, single.enoughInput())
+
+	// Split into three outputs, the change is too small.
+	set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{
+		maxInputs:    10,
+		changePolicy: ChangePolicy{TargetOutputCount: count},
+	})
+	require.True(t, tryAdd(set, inp, constraintsForce))
+	require.False(t, set.enoughInput())
+	require.Positive(t, set.Shortfall())
+
+	// The outputs can't be kept above dust without wallet value.
+	err := set.AddWalletInputs(&mockUtxoWallet{})
+	require.ErrorIs(t, err, ErrNoWalletUtxos)
+
+	// A wallet utxo is added to keep every output above dust.
+	wallet := &mockUtxoWallet{utxos: []*lnwallet.Utxo{{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       50_000,
+	}}}
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 2)
+
+	// The tx has exactly three non-dust change outputs splitting the
+	// change evenly.
+	require.Equal(t, count, set.numChangeOutputs())
+	txOuts, err := set.ChangeOutputs(genScript)
+	require.NoError(t, err)
+	require.Len(t, txOuts, count)
+
+	var total int64
+	for _, txOut := range txOuts {
+		require.GreaterOrEqual(t, btcutil.Amount(txOut.Value),
+			set.dustLimit(input.P2TRSize))
+		require.InDelta(t, txOuts[0].Value, txOut.Value, 1)
+
+		total += txOut.Value
+	}
+	require.EqualValues(t, set.changeOutput, total)
+
+	// The weight estimate accounts for all the outputs.
+	require.Equal(t, count*input.P2TROutputSize*4,
+		changeWeight(set))
+}
+
+// TestWalletLossGuard checks that a wallet input with a positive yield is
+// still rejected if the wallet would put more into the tx than it gets out,
+// which happens when the output value left by the other inputs doesn't exceed
+// the fee added by the wallet input.
+func TestWalletLossGuard(t *testing.T) {
+	t.Parallel()
+
+	const feeRate = chainfee.SatPerKWeight(10_000)
+
+	walletInp := createP2WKHInput(10_000)
+	iw, err := newInputWeight(walletInp)
+	require.NoError(t, err)
+	walletFee := feeRate.FeeForWeight(iw.weight())
+
+	// The regular input is only left with a small output value at the
+	// fee rate, which is below the fee added by the wallet input.
+	set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(
+		set, createP2WKHInput(6_000), constraintsRegular,
+	))
+	require.Positive(t, set.totalOutput())
+	require.Less(t, set.totalOutput(), walletFee)
+
+	// The wallet input increases the output value, so the yield check
+	// passes, but the wallet would spend more than the tx outputs.
+	added, reason := set.add(walletInp, constraintsWallet)
+	require.False(t, added)
+	require.Equal(t, rejectWalletLoss, reason)
+	require.Len(t, set.inputs, 1)
+
+	// With a larger output value from the regular input, the same wallet
+	// input is accepted.
+	set = newTestTxInputSet(t, feeRate, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(
+		set, createP2WKHInput(20_000), constraintsRegular,
+	))
+	require.Greater(t, set.totalOutput(), walletFee)
+	require.True(t, tryAdd(set, walletInp, constraintsWallet))
+
+	// A force sweep bypasses the guard.
+	set = newTestTxInputSet(t, feeRate, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(set, createP2WKHInput(6_000), constraintsForce))
+	require.True(t, tryAdd(set, walletInp, constraintsWallet))
+}
+
+// TestInputSetLockTime checks that the lock time of a set is surfaced and
+// validated against the lock times required by its CLTV-encumbered inputs.
+func TestInputSetLockTime(t *testing.T) {
+	t.Parallel()
+
+	cltv := uint32(testHeight - 5)
+
+	cltvInp := input.NewCsvInputWithCltv(
+		&wire.OutPoint{Index: 1}, input.CommitmentTimeLock,
+		&input.SignDescriptor{
+			Output: &wire.TxOut{Value: 100_000},
+		}, 0, 0, cltv,
+	)
+
+	set, err := NewBudgetInputSet([]SweeperInput{
+		{Input: cltvInp, params: Params{Budget: 1_000}},
+		{
+			Input:  createP2WKHInput(100_000),
+			params: Params{Budget: 1_000},
+		},
+	}, testHeight, testHeight, BudgetInputSetConfig{})
+	require.NoError(t, err)
+
+	// No lock time is set by default.
+	require.True(t, set.LockTime().IsNone())
+
+	// The lock time must match the CLTV of the input, and be a block
+	// height.
+	err = set.SetLockTime(uint32(testHeight))
+	require.ErrorIs(t, err, ErrLocktimeConflict)
+	require.ErrorContains(t, err, cltvInp.OutPoint().String())
+
+	err = set.SetLockTime(txscript.LockTimeThreshold)
+	require.ErrorIs(t, err, ErrInvalidLockTime)
+	require.True(t, set.LockTime().IsNone())
+
+	require.NoError(t, set.SetLockTime(cltv))
+	require.Equal(t, fn.Some(cltv), set.LockTime())
+
+	// The tx can't be broadcast before its lock time.
+	require.NoError(t, set.Validate(testHeight))
+	require.ErrorIs(t, set.Validate(int32(cltv)-1), ErrLocktimeImmature)
+
+	// A CLTV input added after the lock time was set is caught when
+	// validating the set.
+	txSet := newTestTxInputSet(
+		t, chainfee.FeePerKwFloor, 0, txInputSetConfig{maxInputs: 10},
+	)
+	require.NoError(t, txSet.SetLockTime(uint32(testHeight)))
+	require.Equal(t, fn.Some(uint32(testHeight)), txSet.LockTime())
+	require.True(t, tryAdd(txSet, cltvInp, constraintsForce))
+	require.ErrorIs(t, txSet.Validate(testHeight), ErrLocktimeConflict)
+}
+
+// TestInputSufficiency checks that a negative change, which wallet inputs can
+// make up for, is told apart from inputs leaving no output above dust.
+func TestInputSufficiency(t *testing.T) {
+	t.Parallel()
+
+	// An empty set has insufficient inputs.
+	set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	require.Equal(t, inputsInsufficient, set.inputSufficiency())
+	require.False(t, set.enoughInput())
+
+	// The required output of a force sweep takes the whole value of its
+	// input, so the change is negative.
+	inp := &reqInput{
+		Input: createP2WKHInput(10_000),
+		txOut: &wire.TxOut{
+			Value:    10_000,
+			PkScript: make([]byte, input.P2WPKHSize),
+		},
+	}
+	require.True(t, tryAdd(set, inp, constraintsForce))
+	require.Negative(t, set.changeOutput)
+	require.Equal(t, inputsNegativeChange, set.inputSufficiency())
+	require.True(t, set.NeedWalletInput())
+
+	err := set.Validate(testHeight)
+	require.ErrorIs(t, err, ErrNotEnoughInputs)
+	require.ErrorContains(t, err, inputsNegativeChange.String())
+
+	// A wallet input makes up for the negative change.
+	wallet := &mockUtxoWallet{utxos: []*lnwallet.Utxo{{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       50_000,
+	}}}
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Equal(t, inputsEnough, set.inputSufficiency())
+	require.True(t, set.enoughInput())
+
+	// An input covering the fees, but leaving a dust change without any
+	// required output, is insufficient although the change is positive.
+	set = newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(set, createP2WKHInput(700), constraintsForce))
+	require.Positive(t, set.changeOutput)
+	require.Less(t, set.changeOutput, set.changeDustLimit())
+	require.Equal(t, inputsInsufficient, set.inputSufficiency())
+	require.False(t, set.enoughInput())
+
+	err = set.Validate(testHeight)
+	require.ErrorIs(t, err, ErrNotEnoughInputs)
+	require.ErrorContains(t, err, inputsInsufficient.String())
+}
+
+// TestWalletUtxoSortStability checks that wallet utxos of equal value are
+// sorted by their outpoints, so the same utxo is selected no matter the order
+// the wallet returns them in.
+func TestWalletUtxoSortStability(t *testing.T) {
+	t.Parallel()
+
+	newUtxo := func(hash byte, index uint32) *lnwallet.Utxo {
+		return &lnwallet.Utxo{
+			AddressType: lnwallet.WitnessPubKey,
+			Value:       50_000,
+			OutPoint: wire.OutPoint{
+				Hash:  chainhash.Hash{hash},
+				Index: index,
+			},
+		}
+	}
+	a, b, c := newUtxo(1, 1), newUtxo(1, 0), newUtxo(2, 0)
+
+	// The txids are compared before the output indexes.
+	require.True(t, outpointLess(b.OutPoint, a.OutPoint))
+	require.True(t, outpointLess(a.OutPoint, c.OutPoint))
+	require.False(t, outpointLess(a.OutPoint, a.OutPoint))
+
+	orders := [][]*lnwallet.Utxo{
+		{a, b, c},
+		{c, b, a},
+		{b, c, a},
+	}
+
+	expected := []*lnwallet.Utxo{b, a, c}
+	for _, utxos := range orders {
+		sorted := prepareWalletUtxos(utxos, walletUtxoFilter{})
+		require.Equal(t, expected, sorted)
+
+		// The ties are broken the same way when a scorer is used.
+		sorted = prepareWalletUtxos(utxos, walletUtxoFilter{
+			scorer: func(*lnwallet.Utxo) float64 { return 1 },
+		})
+		require.Equal(t, expected, sorted)
+
+		// Repeated runs select the same utxo.
+		set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{
+			maxInputs: 10,
+		})
+		require.True(t, tryAdd(
+			set, createP2WKHInput(500), constraintsForce,
+		))
+		require.NoError(t, set.AddWalletInputs(
+			&mockUtxoWallet{utxos: utxos},
+		))
+		require.Len(t, set.inputs, 2)
+		require.Equal(t, b.OutPoint, set.inputs[1].OutPoint())
+	}
+}
+
+// mockAccountWallet is a mockUtxoWallet that also lists the utxos of a sweep
+// account.
+type mockAccountWallet struct {
+	mockUtxoWallet
+
+	account      string
+	accountUtxos []*lnwallet.Utxo
+}
+
+func (m *mockAccountWallet) ListUnspentWitnessFromAccount(account string,
+	minConfs, maxConfs int32) ([]*lnwallet.Utxo, error) {
+
+	if account != m.account {
+		return nil, fmt.Errorf("unknown account %v", account)
+	}
+
+	utxos := make([]*lnwallet.Utxo, len(m.accountUtxos))
+	copy(utxos, m.accountUtxos)
+
+	return utxos, nil
+}
+
+// TestAddWalletInputsSweepAccount checks that the wallet inputs are taken from
+// the sweep account first, and the default account is only used when the
+// sweep account can't cover the budget.
+func TestAddWalletInputsSweepAccount(t *testing.T) {
+	t.Parallel()
+
+	const account = "sweep"
+
+	newUtxo := func(value btcutil.Amount, index uint32) *lnwallet.Utxo {
+		return &lnwallet.Utxo{
+			AddressType: lnwallet.WitnessPubKey,
+			Value:       value,
+			OutPoint:    wire.OutPoint{Index: index},
+		}
+	}
+
+	// newBudgetSet returns a set which needs 1,000 sats from the wallet
+	// to cover the budget of its input.
+	newBudgetSet := func() *BudgetInputSet {
+		set := &BudgetInputSet{
+			inputs: []*SweeperInput{{
+				Input: &reqInput{
+					Input: createP2WKHInput(10_000),
+					txOut: &wire.TxOut{Value: 10_000},
+				},
+				params: Params{Budget: 1_000},
+			}},
+		}
+		set.cfg.SweepAccount = account
+
+		return set
+	}
+
+	// The sweep account covers the budget, so the default account isn't
+	// used.
+	swept := newUtxo(5_000, 1)
+	wallet := &mockAccountWallet{
+		mockUtxoWallet: mockUtxoWallet{utxos: []*lnwallet.Utxo{
+			newUtxo(2_000, 2),
+		}},
+		account:      account,
+		accountUtxos: []*lnwallet.Utxo{swept},
+	}
+	set := newBudgetSet()
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 2)
+	require.Equal(t, swept.OutPoint, set.inputs[1].OutPoint())
+
+	// The same applies to a txInputSet.
+	txSet := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	txSet.cfg.sweepAccount = account
+	require.True(t, tryAdd(txSet, createP2WKHInput(500), constraintsForce))
+	require.NoError(t, txSet.AddWalletInputs(wallet))
+	require.Len(t, txSet.inputs, 2)
+	require.Equal(t, swept.OutPoint, txSet.inputs[1].OutPoint())
+
+	// The sweep account is insufficient, so the default account covers
+	// the rest. The sweep account utxo listed by the default account too
+	// isn't added twice.
+	swept = newUtxo(600, 3)
+	fallback := newUtxo(600, 4)
+	wallet = &mockAccountWallet{
+		mockUtxoWallet: mockUtxoWallet{utxos: []*lnwallet.Utxo{
+			swept, fallback,
+		}},
+		account:      account,
+		accountUtxos: []*lnwallet.Utxo{swept},
+	}
+	set = newBudgetSet()
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 3)
+	require.Equal(t, swept.OutPoint, set.inputs[1].OutPoint())
+	require.Equal(t, fallback.OutPoint, set.inputs[2].OutPoint())
+
+	// Both accounts are insufficient, so the set is left unchanged.
+	wallet = &mockAccountWallet{
+		mockUtxoWallet: mockUtxoWallet{utxos: []*lnwallet.Utxo{
+			newUtxo(300, 5),
+		}},
+		account:      account,
+		accountUtxos: []*lnwallet.Utxo{newUtxo(300, 6)},
+	}
+	set = newBudgetSet()
+	err := set.AddWalletInputs(wallet)
+
+	var notEnough *NotEnoughInputsError
+	require.ErrorAs(t, err, &notEnough)
+	require.Equal(t, btcutil.Amount(400), notEnough.Shortfall)
+	require.Equal(t, 2, notEnough.NumWalletUtxos)
+	require.Len(t, set.inputs, 1)
+}
+
+// mockAncestorWallet is a mockUtxoWallet that reports the unconfirmed
+// ancestors of its utxos.
+type mockAncestorWallet struct {
+	mockUtxoWallet
+
+	ancestors map[wire.OutPoint]uint32
+}
+
+func (m *mockAncestorWallet) UnconfirmedAncestors(
+	op wire.OutPoint) (uint32, error) {
+
+	return m.ancestors[op], nil
+}
+
+// TestAddWalletInputsMaxAncestors checks that the wallet utxos with more
+// unconfirmed ancestors than the max are excluded from the wallet inputs.
+func TestAddWalletInputsMaxAncestors(t *testing.T) {
+	t.Parallel()
+
+	// The deep utxo would be selected first as it's the smallest.
+	deep := &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       5_000,
+		OutPoint:    wire.OutPoint{Index: 1},
+	}
+	shallow := &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       50_000,
+		OutPoint:    wire.OutPoint{Index: 2},
+	}
+	wallet := &mockAncestorWallet{
+		mockUtxoWallet: mockUtxoWallet{
+			utxos: []*lnwallet.Utxo{deep, shallow},
+		},
+		ancestors: map[wire.OutPoint]uint32{
+			deep.OutPoint:    30,
+			shallow.OutPoint: 2,
+		},
+	}
+
+	newBudgetSet := func() *BudgetInputSet {
+		return &BudgetInputSet{
+			inputs: []*SweeperInput{{
+				Input: &reqInput{
+					Input: createP2WKHInput(10_000),
+					txOut: &wire.TxOut{Value: 10_000},
+				},
+				params: Params{Budget: 1_000},
+			}},
+		}
+	}
+
+	// Without a max, the deep utxo is used.
+	set := newBudgetSet()
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 2)
+	require.Equal(t, deep.OutPoint, set.inputs[1].OutPoint())
+
+	// With a max below its ancestor count, the deep utxo is excluded and
+	// the shallow one is used instead.
+	set = newBudgetSet()
+	set.cfg.MaxAncestors = 24
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 2)
+	require.Equal(t, shallow.OutPoint, set.inputs[1].OutPoint())
+
+	// The same applies to a txInputSet.
+	txSet := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	txSet.cfg.maxAncestors = 24
+	require.True(t, tryAdd(txSet, createP2WKHInput(500), constraintsForce))
+	require.NoError(t, txSet.AddWalletInputs(wallet))
+	require.Len(t, txSet.inputs, 2)
+	require.Equal(t, shallow.OutPoint, txSet.inputs[1].OutPoint())
+}
+
+// TestOutputCount checks the number of outputs projected for the tx created
+// from a set, and that a max number of outputs is enforced by Validate.
+func TestOutputCount(t *testing.T) {
+	t.Parallel()
+
+	newReqInput := func() input.Input {
+		return &reqInput{
+			Input: createP2WKHInput(10_000),
+			txOut: &wire.TxOut{
+				Value:    10_000,
+				PkScript: make([]byte, input.P2WPKHSize),
+			},
+		}
+	}
+
+	// newSet returns a set with two required outputs and a change output
+	// split into the given number of outputs.
+	newSet := func(changeOutputs int) *txInputSet {
+		cfg := txInputSetConfig{maxInputs: 10}
+		if changeOutputs > 1 {
+			cfg.changePolicy.TargetOutputCount = changeOutputs
+		}
+		set := newTestTxInputSet(t, 1000, 0, cfg)
+
+		require.True(t, tryAdd(set, newReqInput(), constraintsForce))
+		require.True(t, tryAdd(set, newReqInput(), constraintsForce))
+		require.True(t, tryAdd(
+			set, createP2WKHInput(100_000), constraintsRegular,
+		))
+
+		return set
+	}
+
+	set := newSet(1)
+	require.Equal(t, 3, set.OutputCount())
+
+	set.cfg.maxOutputs = 3
+	require.NoError(t, set.Validate(testHeight))
+
+	set.cfg.maxOutputs = 2
+	require.ErrorIs(t, set.Validate(testHeight), ErrTooManyOutputs)
+
+	// Splitting the change adds outputs.
+	set = newSet(3)
+	require.Equal(t, 5, set.OutputCount())
+
+	// A set with only required outputs and no change above dust.
+	set = newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(set, newReqInput(), constraintsForce))
+	require.Equal(t, 1, set.OutputCount())
+
+	// A budget set projects a change output if the value left after the
+	// budget is above dust.
+	budgetSet := &BudgetInputSet{
+		inputs: []*SweeperInput{
+			{
+				Input:  newReqInput(),
+				params: Params{Budget: 1_000},
+			},
+			{
+				Input:  createP2WKHInput(50_000),
+				params: Params{Budget: 1_000},
+			},
+		},
+	}
+	require.Equal(t, 2, budgetSet.OutputCount())
+
+	budgetSet.cfg.MaxOutputs = 1
+	require.ErrorIs(t, budgetSet.Validate(testHeight), ErrTooManyOutputs)
+
+	// Without the value to cover a change output, only the required
+	// output is counted.
+	budgetSet.inputs[1].params.Budget = 50_000
+	require.Equal(t, 1, budgetSet.OutputCount())
+}
+
+// TestMaxTxVSize checks that Validate rejects a set whose tx exceeds the max
+// virtual size, and accepts one within it.
+func TestMaxTxVSize(t *testing.T) {
+	t.Parallel()
+
+	set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(
+		set, createP2WKHInput(100_000), constraintsRegular,
+	))
+
+	weight := set.weightEstimate(true).weight()
+	vsize := set.VirtualSize()
+	require.Equal(t, (weight+3)/4, vsize)
+
+	set.cfg.maxTxVSize = vsize
+	require.NoError(t, set.Validate(testHeight))
+
+	set.cfg.maxTxVSize = vsize - 1
+	require.ErrorIs(t, set.Validate(testHeight), ErrTxTooLarge)
+
+	// The same applies to a budget set.
+	budgetSet := &BudgetInputSet{
+		inputs: []*SweeperInput{{
+			Input:  createP2WKHInput(100_000),
+			params: Params{Budget: 1_000},
+		}},
+	}
+	vsize, err := budgetSet.VirtualSize()
+	require.NoError(t, err)
+
+	budgetSet.cfg.MaxTxVSize = vsize
+	require.NoError(t, budgetSet.Validate(testHeight))
+
+	budgetSet.cfg.MaxTxVSize = vsize - 1
+	require.ErrorIs(t, budgetSet.Validate(testHeight), ErrTxTooLarge)
+}
+
+// TestAddWalletInputsOwnChangeHint checks that the hinted change outputs of
+// prior sweeps are selected ahead of equal-value wallet utxos.
+func TestAddWalletInputsOwnChangeHint(t *testing.T) {
+	t.Parallel()
+
+	newUtxo := func(index uint32) *lnwallet.Utxo {
+		return &lnwallet.Utxo{
+			AddressType: lnwallet.WitnessPubKey,
+			Value:       50_000,
+			OutPoint:    wire.OutPoint{Index: index},
+		}
+	}
+
+	// Without a hint, the utxo with the lowest outpoint is selected.
+	other, change := newUtxo(1), newUtxo(2)
+	wallet := &mockUtxoWallet{utxos: []*lnwallet.Utxo{other, change}}
+
+	newBudgetSet := func() *BudgetInputSet {
+		return &BudgetInputSet{
+			inputs: []*SweeperInput{{
+				Input: &reqInput{
+					Input: createP2WKHInput(10_000),
+					txOut: &wire.TxOut{Value: 10_000},
+				},
+				params: Params{Budget: 1_000},
+			}},
+		}
+	}
+
+	set := newBudgetSet()
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 2)
+	require.Equal(t, other.OutPoint, set.inputs[1].OutPoint())
+
+	// With the hint, the own change is selected instead.
+	set = newBudgetSet()
+	set.ownChange = newOwnChangeHint([]wire.OutPoint{change.OutPoint})
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 2)
+	require.Equal(t, change.OutPoint, set.inputs[1].OutPoint())
+
+	// The same applies to a txInputSet.
+	txSet := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	txSet.ownChange = newOwnChangeHint([]wire.OutPoint{change.OutPoint})
+	require.True(t, tryAdd(txSet, createP2WKHInput(500), constraintsForce))
+	require.NoError(t, txSet.AddWalletInputs(wallet))
+	require.Len(t, txSet.inputs, 2)
+	require.Equal(t, change.OutPoint, txSet.inputs[1].OutPoint())
+
+	// The hint comes first even when a scorer is used.
+	sorted := prepareWalletUtxos(
+		[]*lnwallet.Utxo{other, change}, walletUtxoFilter{
+			scorer:    func(*lnwallet.Utxo) float64 { return 1 },
+			ownChange: fn.NewSet(change.OutPoint),
+		},
+	)
+	require.Equal(t, []*lnwallet.Utxo{change, other}, sorted)
+}
+
+// TestRequiredScriptClasses checks that inputs whose required output pays to
+// an unexpected script are rejected, while a p2wsh required output is
+// accepted.
+func TestRequiredScriptClasses(t *testing.T) {
+	t.Parallel()
+
+	p2wsh := append(
+		[]byte{txscript.OP_0, txscript.OP_DATA_32}, make([]byte, 32)...,
+	)
+	p2wkh := append(
+		[]byte{txscript.OP_0, txscript.OP_DATA_20}, make([]byte, 20)...,
+	)
+
+	newReqInput := func(pkScript []byte) input.Input {
+		return &reqInput{
+			Input: createP2WKHInput(10_000),
+			txOut: &wire.TxOut{Value: 10_000, PkScript: pkScript},
+		}
+	}
+	valid, unexpected := newReqInput(p2wsh), newReqInput(p2wkh)
+
+	scriptClasses := []txscript.ScriptClass{txscript.WitnessV0ScriptHashTy}
+	set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{
+		maxInputs:             10,
+		requiredScriptClasses: scriptClasses,
+	})
+
+	require.True(t, tryAdd(set, valid, constraintsForce))
+
+	added, reason := set.add(unexpected, constraintsForce)
+	require.False(t, added)
+	require.Equal(t, rejectUnexpectedScript, reason)
+	require.Len(t, set.inputs, 1)
+
+	// Without expected classes, any script is accepted.
+	set = newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(set, unexpected, constraintsForce))
+
+	// A budget set rejects the classes its required outputs don't match,
+	// and Validate enforces them.
+	budgetSet := &BudgetInputSet{
+		inputs: []*SweeperInput{
+			{Input: valid, params: Params{Budget: 1_000}},
+			{
+				Input:  createP2WKHInput(100_000),
+				params: Params{Budget: 1_000},
+			},
+		},
+	}
+	budgetSet.cfg.RequiredScriptClasses = scriptClasses
+	require.NoError(t, budgetSet.Validate(testHeight))
+
+	budgetSet.addInput(SweeperInput{
+		Input: unexpected, params: Params{Budget: 1_000},
+	})
+	require.ErrorIs(t, budgetSet.Validate(testHeight), ErrUnexpectedScript)
+
+	inputs := make([]SweeperInput, 0, len(budgetSet.inputs))
+	for _, inp := range budgetSet.inputs {
+		inputs = append(inputs, *inp)
+	}
+	cfg := BudgetInputSetConfig{RequiredScriptClasses: scriptClasses}
+	require.ErrorIs(t, cfg.validate(inputs), ErrUnexpectedScript)
+}
+
+// TestAntiFeeSnipingLockTime checks that the anti-fee-sniping lock time is the
+// current height, occasionally lowered by up to 99 blocks, unless an input
+// requires its own lock time.
+func TestAntiFeeSnipingLockTime(t *testing.T) {
+	t.Parallel()
+
+	height := uint32(testHeight)
+
+	plain := []input.Input{createP2WKHInput(100_000)}
+
+	// Most of the time, the lock time is the height.
+	require.Equal(t, height, antiFeeSnipingLockTime(height, plain, 0.1, 0))
+	require.Equal(
+		t, height, antiFeeSnipingLockTime(height, plain, 0.9, 0.5),
+	)
+
+	// Otherwise, it's lowered by up to 99 blocks.
+	require.Equal(t, height, antiFeeSnipingLockTime(height, plain, 0, 0))
+	require.Equal(
+		t, height-50, antiFeeSnipingLockTime(height, plain, 0.05, 0.5),
+	)
+	require.Equal(
+		t, height-99, antiFeeSnipingLockTime(height, plain, 0, 0.999),
+	)
+
+	// The lock time never goes below zero.
+	require.Zero(t, antiFeeSnipingLockTime(10, plain, 0, 0.5))
+
+	// A CLTV-encumbered input pins the lock time, whether it's below or
+	// above the height.
+	for _, cltv := range []uint32{height - 5, height + 5} {
+		cltvInp := input.NewCsvInputWithCltv(
+			&wire.OutPoint{Index: 1}, input.CommitmentTimeLock,
+			&input.SignDescriptor{
+				Output: &wire.TxOut{Value: 100_000},
+			}, 0, 0, cltv,
+		)
+		inputs := append([]input.Input{cltvInp}, plain...)
+
+		require.Equal(
+			t, cltv, antiFeeSnipingLockTime(height, inputs, 0, 0.5),
+		)
+	}
+
+	// The sets derive the lock time from the height source, within the
+	// randomization window.
+	source := func() (int32, error) {
+		return testHeight, nil
+	}
+
+	for i := 0; i < 20; i++ {
+		set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{
+			maxInputs: 10,
+		})
+		require.True(t, tryAdd(
+			set, createP2WKHInput(100_000), constraintsRegular,
+		))
+		require.NoError(t, set.SetAntiFeeSnipingLockTime(source))
+
+		lockTime := set.LockTime().UnsafeFromSome()
+		require.LessOrEqual(t, lockTime, height)
+		require.GreaterOrEqual(t, lockTime, height-99)
+	}
+
+	budgetSet := &BudgetInputSet{
+		inputs: []*SweeperInput{{
+			Input:  createP2WKHInput(100_000),
+			params: Params{Budget: 1_000},
+		}},
+	}
+	require.NoError(t, budgetSet.SetAntiFeeSnipingLockTime(source))
+	lockTime := budgetSet.LockTime().UnsafeFromSome()
+	require.LessOrEqual(t, lockTime, height)
+	require.GreaterOrEqual(t, lockTime, height-99)
+
+	// A failing source leaves the lock time unset.
+	budgetSet = &BudgetInputSet{}
+	err := budgetSet.SetAntiFeeSnipingLockTime(func() (int32, error) {
+		return 0, errDummy
+	})
+	require.ErrorIs(t, err, errDummy)
+	require.True(t, budgetSet.LockTime().IsNone())
+}
+
+// TestPlanBatches checks that a soon-due set is merged with an urgent one,
+// while a far-future set is left separate.
+func TestPlanBatches(t *testing.T) {
+	t.Parallel()
+
+	const lookahead = 6
+
+	shared := createP2WKHInput(50_000)
+
+	newSet := func(deadline int32, inputs ...input.Input) *BudgetInputSet {
+		sweeperInputs := make([]SweeperInput, 0, len(inputs))
+		for _, inp := range inputs {
+			sweeperInputs = append(sweeperInputs, SweeperInput{
+				Input: inp,
+				params: Params{
+					Budget:         1_000,
+					DeadlineHeight: fn.Some(deadline),
+				},
+			})
+		}
+
+		set, err := NewBudgetInputSet(
+			sweeperInputs, deadline, testHeight,
+			BudgetInputSetConfig{},
+		)
+		require.NoError(t, err)
+
+		return set
+	}
+
+	urgent := newSet(testHeight+2, createP2WKHInput(100_000), shared)
+	soon := newSet(testHeight+5, createP2WKHInput(100_000), shared)
+	far := newSet(testHeight+100, createP2WKHInput(100_000))
+
+	planned := PlanBatches(
+		[]*BudgetInputSet{far, soon, urgent}, testHeight, lookahead,
+	)
+	require.Len(t, planned, 2)
+
+	// The soon-due set rides along with the urgent one, keeping the
+	// earliest deadline and the shared input only once.
+	merged := planned[0]
+	require.Equal(t, testHeight+2, merged.DeadlineHeight())
+	require.Len(t, merged.inputs, 3)
+	for _, inp := range merged.inputs {
+		require.Equal(
+			t, fn.Some(testHeight+2), inp.params.DeadlineHeight,
+		)
+	}
+	require.Equal(t, btcutil.Amount(3_000), merged.Budget())
+
+	// The far-future set is left as it is.
+	require.Same(t, far, planned[1])
+
+	// The given sets aren't modified.
+	require.Len(t, urgent.inputs, 2)
+	require.Len(t, soon.inputs, 2)
+	require.Equal(
+		t, fn.Some(testHeight+5), soon.inputs[0].params.DeadlineHeight,
+	)
+
+	// An overdue set counts as due at the current height, so a set due
+	// within the lookahead of the current height is merged with it.
+	overdue := newSet(testHeight-10, createP2WKHInput(100_000))
+	planned = PlanBatches(
+		[]*BudgetInputSet{soon, overdue}, testHeight, lookahead,
+	)
+	require.Len(t, planned, 1)
+	require.Equal(t, testHeight-10, planned[0].DeadlineHeight())
+	require.Len(t, planned[0].inputs, 3)
+
+	// Sets whose inputs require different lock times can't share a tx,
+	// so they are left apart.
+	newLockTimeInput := func(lockTime uint32) input.Input {
+		return &testInput{
+			BaseInput: createP2WKHInput(100_000).(*input.BaseInput),
+			locktime:  &lockTime,
+		}
+	}
+	cltv1 := newSet(testHeight+2, newLockTimeInput(100))
+	cltv2 := newSet(testHeight+5, newLockTimeInput(101))
+	planned = PlanBatches(
+		[]*BudgetInputSet{cltv1, cltv2}, testHeight, lookahead,
+	)
+	require.Equal(t, []*BudgetInputSet{cltv1, cltv2}, planned)
+}
+
+// TestChangeDecision checks that keeping the change is recommended when it's
+// worth more than its spend cost, and dropping it otherwise.
+func TestChangeDecision(t *testing.T) {
+	t.Parallel()
+
+	set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(set, &reqInput{
+		Input: createP2WKHInput(10_000),
+		txOut: &wire.TxOut{
+			Value:    10_000,
+			PkScript: make([]byte, input.P2WPKHSize),
+		},
+	}, constraintsForce))
+	require.True(t, tryAdd(
+		set, createP2WKHInput(5_000), constraintsRegular,
+	))
+
+	// At a low future fee rate, spending the change later is cheap, so
+	// keeping it wins.
+	result := set.ChangeDecision(1000)
+	require.True(t, result.CanKeep)
+	require.True(t, result.CanDrop)
+	require.Equal(t, set.changeOutput, result.KeepValue)
+	require.Equal(t, set.changeOutput, result.DropExtraFee)
+	require.Equal(t, set.ChangeSpendCost(1000), result.KeepSpendCost)
+	require.Positive(t, result.KeepNetValue())
+	require.Equal(t, ChangeKeep, result.Recommendation)
+
+	// At a high future fee rate, spending the change costs more than it's
+	// worth, so dropping it wins.
+	result = set.ChangeDecision(50_000)
+	require.Negative(t, result.KeepNetValue())
+	require.Equal(t, ChangeDrop, result.Recommendation)
+
+	// Without a required output, the change can't be dropped, so it's
+	// kept whatever it costs to spend.
+	set = newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(
+		set, createP2WKHInput(5_000), constraintsRegular,
+	))
+
+	result = set.ChangeDecision(50_000)
+	require.False(t, result.CanDrop)
+	require.Negative(t, result.KeepNetValue())
+	require.Equal(t, ChangeKeep, result.Recommendation)
+}
+
+// TestBudgetInputSetSortOutputs checks that the outputs of a budget set are
+// sorted per BIP69 only when asked to and no signature commits to the
+// position of an output.
+func TestBudgetInputSetSortOutputs(t *testing.T) {
+	t.Parallel()
+
+	required := &reqInput{
+		Input: createP2WKHInput(10_000),
+		txOut: &wire.TxOut{
+			Value:    10_000,
+			PkScript: make([]byte, input.P2WPKHSize),
+		},
+	}
+	set := &BudgetInputSet{
+		inputs: []*SweeperInput{
+			{
+				Input:  createP2WKHInput(10_000),
+				params: Params{Budget: 100},
+			},
+			{
+				Input:  required,
+				params: Params{Budget: 100},
+			},
+		},
+	}
+
+	// By default the outputs are not sorted.
+	require.False(t, set.SortsOutputs())
+
+	// Once asked to, the outputs are sorted.
+	set.cfg.SortOutputs = true
+	require.True(t, set.SortsOutputs())
+
+	// The outputs are not sorted when the input order is preserved.
+	set.cfg.PreserveInputOrder = true
+	require.False(t, set.SortsOutputs())
+	set.cfg.PreserveInputOrder = false
+
+	// Nor when an input commits to its required output via
+	// SIGHASH_SINGLE.
+	required.SignDesc().HashType = txscript.SigHashSingle |
+		txscript.SigHashAnyOneCanPay
+	require.False(t, set.SortsOutputs())
+}
+
+// TestReserveChange checks that the change output of a sweeping tx assembled
+// from a budget set is predicted and leased when a reservation is set.
+func TestReserveChange(t *testing.T) {
+	t.Parallel()
+
+	reqTxOut := &wire.TxOut{
+		Value:    10_000,
+		PkScript: make([]byte, input.P2WPKHSize),
+	}
+	set := &BudgetInputSet{
+		inputs: []*SweeperInput{
+			{
+				Input:  createP2WKHInput(100_000),
+				params: Params{Budget: 1_000},
+			},
+			{
+				Input: &reqInput{
+					Input: createP2WKHInput(10_000),
+					txOut: reqTxOut,
+				},
+				params: Params{Budget: 1_000},
+			},
+		},
+	}
+
+	changeOut := &wire.TxOut{
+		Value:    99_000,
+		PkScript: changePkScript,
+	}
+	tx := wire.NewMsgTx(2)
+	tx.AddTxOut(reqTxOut)
+	tx.AddTxOut(changeOut)
+
+	// The change output follows the required output.
+	expected := wire.OutPoint{Hash: tx.TxHash(), Index: 1}
+	require.Equal(t, fn.Some(expected), set.PredictChangeOutPoint(tx))
+
+	// Without a reservation, nothing is leased.
+	leaser := newMockOutputLeaser()
+	op, err := set.ReserveChange(tx)
+	require.NoError(t, err)
+	require.True(t, op.IsNone())
+
+	// With a reservation, the change output is leased.
+	set.cfg.ChangeReservation = fn.Some(ChangeReservation{
+		ID:       wtxmgr.LockID{1},
+		Duration: time.Hour,
+		Leaser:   leaser,
+	})
+	op, err = set.ReserveChange(tx)
+	require.NoError(t, err)
+	require.Equal(t, fn.Some(expected), op)
+	require.Contains(t, leaser.leasedOutputs, expected)
+
+	// When the outputs are sorted, the change output is still found.
+	sorted := wire.NewMsgTx(2)
+	sorted.AddTxOut(&wire.TxOut{
+		Value:    5_000,
+		PkScript: changePkScript,
+	})
+	sorted.AddTxOut(reqTxOut)
+	require.Equal(t, fn.Some(wire.OutPoint{Hash: sorted.TxHash()}),
+		set.PredictChangeOutPoint(sorted))
+
+	// A tx without change has nothing to reserve.
+	noChange := wire.NewMsgTx(2)
+	noChange.AddTxOut(reqTxOut)
+	require.True(t, set.PredictChangeOutPoint(noChange).IsNone())
+
+	op, err = set.ReserveChange(noChange)
+	require.NoError(t, err)
+	require.True(t, op.IsNone())
+	require.Len(t, leaser.leasedOutputs, 1)
+}
+
+// TestAddWalletInputsWalletReserve checks that the selection of wallet utxos
+// stops before the unselected wallet balance drops below the reserve, and that
+// the set is reverted if its budget isn't covered by then.
+func TestAddWalletInputsWalletReserve(t *testing.T) {
+	t.Parallel()
+
+	small := &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       6_000,
+		OutPoint:    wire.OutPoint{Index: 1},
+	}
+	medium := &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       7_000,
+		OutPoint:    wire.OutPoint{Index: 2},
+	}
+	large := &lnwallet.Utxo{
+		AddressType: lnwallet.WitnessPubKey,
+		Value:       50_000,
+		OutPoint:    wire.OutPoint{Index: 3},
+	}
+
+	newBudgetSet := func() *BudgetInputSet {
+		return &BudgetInputSet{
+			inputs: []*SweeperInput{{
+				Input: &reqInput{
+					Input: createP2WKHInput(10_000),
+					txOut: &wire.TxOut{Value: 10_000},
+				},
+				params: Params{Budget: 10_000},
+			}},
+		}
+	}
+
+	wallet := &mockUtxoWallet{
+		utxos: []*lnwallet.Utxo{small, medium},
+	}
+
+	// Without a reserve, the whole wallet balance can be selected.
+	set := newBudgetSet()
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 3)
+
+	// With a reserve, selecting the medium utxo would leave nothing
+	// unselected, so the selection stops short of the budget and the set
+	// is reverted.
+	set = newBudgetSet()
+	set.cfg.WalletReserve = 5_000
+	err := set.AddWalletInputs(wallet)
+	require.ErrorIs(t, err, ErrNotEnoughInputs)
+
+	var notEnough *NotEnoughInputsError
+	require.ErrorAs(t, err, &notEnough)
+	require.Equal(t, btcutil.Amount(5_000), notEnough.WalletReserve)
+	require.Equal(t, btcutil.Amount(4_000), notEnough.Shortfall)
+	require.Contains(t, err.Error(), "wallet_reserve=")
+	require.Len(t, set.inputs, 1)
+
+	// With a larger wallet, the budget is covered while the reserve stays
+	// unselected.
+	wallet.utxos = append(wallet.utxos, large)
+	set = newBudgetSet()
+	set.cfg.WalletReserve = 5_000
+	require.NoError(t, set.AddWalletInputs(wallet))
+	require.Len(t, set.inputs, 3)
+	require.Equal(t, small.OutPoint, set.inputs[1].OutPoint())
+	require.Equal(t, medium.OutPoint, set.inputs[2].OutPoint())
+}
+
+// TestBudgetImpliedMaxFeeRate checks that the fee rate afforded by the budget
+// caps the max fee rate of a set when it's tighter than the configured one.
+func TestBudgetImpliedMaxFeeRate(t *testing.T) {
+	t.Parallel()
+
+	inp := createP2WKHInput(100_000)
+	_, weight, err := estimateFeeAt([]input.Input{inp}, 0)
+	require.NoError(t, err)
+
+	// The budget covers the fee of the tx up to 5,000 sat/kw.
+	set := &BudgetInputSet{
+		inputs: []*SweeperInput{{
+			Input: inp,
+			params: Params{
+				Budget: btcutil.Amount(weight * 5),
+			},
+		}},
+	}
+	require.Equal(t, chainfee.SatPerKWeight(5000),
+		set.BudgetImpliedMaxFeeRate())
+
+	// By default, the configured max fee rate is used as is.
+	require.Equal(t, chainfee.SatPerKWeight(10_000),
+		set.EffectiveMaxFeeRate(10_000))
+
+	// Once the budget caps the fee rate, the tighter cap wins.
+	set.cfg.BudgetCapsFeeRate = true
+	require.Equal(t, chainfee.SatPerKWeight(5000),
+		set.EffectiveMaxFeeRate(10_000))
+	require.Equal(t, chainfee.SatPerKWeight(2000),
+		set.EffectiveMaxFeeRate(2000))
+
+	// Without a configured max fee rate, the budget is the only cap.
+	require.Equal(t, chainfee.SatPerKWeight(5000),
+		set.EffectiveMaxFeeRate(0))
+}
+
+// TestMergeDuplicateRequiredOutputs checks that sets whose inputs commit to
+// identical required outputs are not merged.
+func TestMergeDuplicateRequiredOutputs(t *testing.T) {
+	t.Parallel()
+
+	pkScript := make([]byte, input.P2WPKHSize)
+	newSet := func(deadline int32, txOut *wire.TxOut) *BudgetInputSet {
+		return &BudgetInputSet{
+			deadlineHeight: deadline,
+			inputs: []*SweeperInput{{
+				Input: &reqInput{
+					Input: createP2WKHInput(10_000),
+					txOut: txOut,
+				},
+				params: Params{
+					Budget:         1_000,
+					DeadlineHeight: fn.Some(deadline),
+				},
+			}},
+		}
+	}
+
+	// Two distinct inputs commit to the same required output, so the
+	// merge is rejected.
+	first := newSet(testHeight+2, &wire.TxOut{
+		Value:    10_000,
+		PkScript: pkScript,
+	})
+	second := newSet(testHeight+3, &wire.TxOut{
+		Value:    10_000,
+		PkScript: pkScript,
+	})
+	_, err := mergeBudgetInputSets([]*BudgetInputSet{first, second})
+	require.ErrorIs(t, err, ErrDuplicateRequiredOutput)
+
+	// PlanBatches leaves such sets as they are.
+	planned := PlanBatches(
+		[]*BudgetInputSet{first, second}, testHeight, 6,
+	)
+	require.Len(t, planned, 2)
+	require.Same(t, first, planned[0])
+	require.Same(t, second, planned[1])
+
+	// Required outputs differing in value are merged.
+	third := newSet(testHeight+3, &wire.TxOut{
+		Value:    9_000,
+		PkScript: pkScript,
+	})
+	merged, err := mergeBudgetInputSets([]*BudgetInputSet{first, third})
+	require.NoError(t, err)
+	require.Len(t, merged.inputs, 2)
+}
+
+// TestNextRBFFee checks that the next RBF fee of a set is the smallest fee
+// satisfying the BIP125 rule 4 for the weight of its tx.
+func TestNextRBFFee(t *testing.T) {
+	t.Parallel()
+
+	const currentFee = btcutil.Amount(1_000)
+
+	// assertRule4 asserts the bump pays for the size of the replacement
+	// at the incremental relay fee rate, i.e., 1 sat/vbyte, and is the
+	// smallest such bump.
+	assertRule4 := func(next btcutil.Amount, weight int) {
+		vsize := btcutil.Amount(virtualSize(weight))
+		require.GreaterOrEqual(t, next-currentFee, vsize)
+		require.GreaterOrEqual(t, next-currentFee,
+			incrementalRelayFeeRate.FeeForWeight(int64(weight)))
+		require.Equal(t, currentFee+vsize, next)
+	}
+
+	inp := createP2WKHInput(100_000)
+
+	set := newTestTxInputSet(t, 1000, 0, txInputSetConfig{maxInputs: 10})
+	require.True(t, tryAdd(set, inp, constraintsRegular))
+	assertRule4(
+		set.NextRBFFee(currentFee), set.weightEstimate(true).weight(),
+	)
+
+	budgetSet := &BudgetInputSet{
+		inputs: []*SweeperInput{{
+			Input:  inp,
+			params: Params{Budget: 1_000},
+		}},
+	}
+	_, weight, err := estimateFeeAt(budgetSet.Inputs(), 0)
+	require.NoError(t, err)
+	assertRule4(budgetSet.NextRBFFee(currentFee), weight)
+}
+
+// TestChangeTypePreference checks that the change output type is the first
+// preferred type supported by the wallet, and that it drives the weight and
+// the dust limit of the change output.
+func TestChangeTypePreference(t *testing.T) {
+	t.Parallel()
+
+	const feeRate = chainfee.SatPerKWeight(1000)
+
+	// The preferred p2tr type is picked when the wallet supports it.
+	// newSet returns a set whose change type is the one selected from the
+	// given supported types.
+	newSet := func(supported []lnwallet.AddressType) (*txInputSet,
+		lnwallet.AddressType) {
+
+		changeType, err := SelectChangeType(
+			DefaultChangeTypePreference, supported,
+		)
+		require.NoError(t, err)
+
+		set := newTestTxInputSet(t, feeRate, 0, txInputSetConfig{
+			maxInputs:    10,
+			changePolicy: ChangePolicy{ChangeType: changeType},
+		})
+
+		return set, changeType
+	}
+
+	set, changeType := newSet([]lnwallet.AddressType{
+		lnwallet.WitnessPubKey, lnwallet.TaprootPubkey,
+	})
+	require.Equal(t, lnwallet.TaprootPubkey, changeType)
+	require.True(t, tryAdd(set, createP2WKHInput(10_000),
+		constraintsRegular))
+	require.Equal(t, input.P2TROutputSize*4, changeWeight(set))
+	require.Equal(t, lnwallet.DustLimitForSize(input.P2TRSize),
+		set.changeDustLimit())
+
+	// The set falls back to p2wkh when the wallet doesn't support p2tr.
+	set, changeType = newSet(
+		[]lnwallet.AddressType{lnwallet.WitnessPubKey},
+	)
+	require.Equal(t, lnwallet.WitnessPubKey, changeType)
+	require.True(t, tryAdd(set, createP2WKHInput(10_000),
+		constraintsRegular))
+	require.Equal(t, input.P2WKHOutputSize*4, changeWeight(set))
+	require.Equal(t, lnwallet.DustLimitForSize(input.P2WPKHSize),
+		set.changeDustLimit())
+
+	p2wkhCost, err := changeSpendCost(lnwallet.WitnessPubKey, feeRate)
+	require.NoError(t, err)
+	require.Equal(t, p2wkhCost, set.ChangeSpendCost(feeRate))
+
+	// An error is returned if no preferred type is supported.
+	_, err = SelectChangeType(
+		[]lnwallet.AddressType{lnwallet.TaprootPubkey},
+		[]lnwallet.AddressType{lnwallet.NestedWitnessPubKey},
+	)
+	require.ErrorIs(t, err, ErrUnsupportedChangeType)
+}
@@ -0,0 +1,200 @@
+package sweep
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/input"
+)
+
+// validateNonReplaceable checks that none of the inputs of a set opting out of
+// replaceability has a CSV delay, as it's used as the sequence of the input,
+// which signals replaceability per BIP125 regardless of the set.
+func validateNonReplaceable(nonReplaceable bool,
+	inputs []input.Input) error {
+
+	if !nonReplaceable {
+		return nil
+	}
+
+	for _, inp := range inputs {
+		if inp.BlocksToMaturity() == 0 {
+			continue
+		}
+
+		return fmt.Errorf("%w: input=%v has csv=%v",
+			ErrReplaceableInput, inp.OutPoint(),
+			inp.BlocksToMaturity())
+	}
+
+	return nil
+}
+
+// validateLockTime checks that the given lock time is a block height that
+// matches the lock time required by the CLTV-encumbered inputs, as they commit
+// to the lock time of the tx spending them.
+func validateLockTime(lockTime uint32, inputs []input.Input) error {
+	if lockTime >= txscript.LockTimeThreshold {
+		return fmt.Errorf("%w: %v is not a block height",
+			ErrInvalidLockTime, lockTime)
+	}
+
+	for _, inp := range inputs {
+		required, ok := inp.RequiredLockTime()
+		if !ok || required == lockTime {
+			continue
+		}
+
+		return fmt.Errorf("%w: input=%v requires lock time=%v, got %v",
+			ErrLocktimeConflict, inp.OutPoint(), required, lockTime)
+	}
+
+	return nil
+}
+
+// hasOutputPositionCommitment returns true if an input has a required output
+// that its signature commits to via SIGHASH_SINGLE, in which case the output
+// must stay at the index of the input and the outputs can't be reordered.
+func hasOutputPositionCommitment(inputs []input.Input) bool {
+	for _, inp := range inputs {
+		if inp.RequiredTxOut() == nil {
+			continue
+		}
+
+		hashType := inp.SignDesc().HashType &^
+			txscript.SigHashAnyOneCanPay
+		if hashType == txscript.SigHashSingle {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HeightSource returns the height of the current best block.
+type HeightSource func() (int32, error)
+
+const (
+	// antiFeeSnipingRandomChance is the chance that the anti-fee-sniping
+	// lock time is lowered below the current height, as done by bitcoind.
+	antiFeeSnipingRandomChance = 0.1
+
+	// antiFeeSnipingMaxOffset is the max number of blocks the
+	// anti-fee-sniping lock time is lowered by.
+	antiFeeSnipingMaxOffset = 99
+)
+
+// antiFeeSnipingLockTime returns the lock time discouraging fee sniping for a
+// tx spending the given inputs at the given height. As done by bitcoind, the
+// lock time is the height, except for one in ten txns whose lock time is up to
+// 99 blocks below it, so the txns delayed by high latency don't stand out.
+// The random values roll and offset, in [0, 1), decide whether and by how
+// much the lock time is lowered. If an input is CLTV-encumbered, its required
+// lock time is used instead, as the input commits to the lock time of the tx
+// spending it.
+func antiFeeSnipingLockTime(height uint32, inputs []input.Input, roll,
+	offset float64) uint32 {
+
+	for _, inp := range inputs {
+		if required, ok := inp.RequiredLockTime(); ok {
+			return required
+		}
+	}
+
+	if roll >= antiFeeSnipingRandomChance {
+		return height
+	}
+
+	lower := uint32(offset * (antiFeeSnipingMaxOffset + 1))
+
+	return height - min(lower, height)
+}
+
+// deriveAntiFeeSnipingLockTime returns the anti-fee-sniping lock time of a tx
+// spending the given inputs, using the height from the given source.
+func deriveAntiFeeSnipingLockTime(source HeightSource,
+	inputs []input.Input) (uint32, error) {
+
+	height, err := source()
+	if err != nil {
+		return 0, fmt.Errorf("get current height: %w", err)
+	}
+
+	if height <= 0 {
+		return 0, fmt.Errorf("%w: invalid current height %v",
+			ErrInvalidLockTime, height)
+	}
+
+	return antiFeeSnipingLockTime(
+		uint32(height), inputs, rand.Float64(), rand.Float64(),
+	), nil
+}
+
+// validateTxVersion checks that the given tx version is supported.
+func validateTxVersion(version int32) error {
+	switch version {
+	case defaultTxVersion, trucTxVersion:
+		return nil
+
+	default:
+		return fmt.Errorf("%w: %v", ErrUnsupportedTxVersion, version)
+	}
+}
+
+// checkTxVersionWeight checks that the given tx weight is within the limits
+// implied by the tx version. Version 3 txns must not exceed the TRUC size
+// limit to be relayed.
+func checkTxVersionWeight(version int32, weight int) error {
+	if version == trucTxVersion && weight > maxTrucTxWeight {
+		return fmt.Errorf("%w: v3 tx weight=%v exceeds max=%v",
+			ErrTxTooLarge, weight, maxTrucTxWeight)
+	}
+
+	return nil
+}
+
+// virtualSize returns the virtual size in vbytes of a tx with the given
+// weight, rounded up as done by the mempool policy.
+func virtualSize(weight int) int {
+	return (weight + blockchain.WitnessScaleFactor - 1) /
+		blockchain.WitnessScaleFactor
+}
+
+// checkTxVSize checks that the given virtual size of a tx doesn't exceed the
+// max, if one is set.
+func checkTxVSize(vsize, maxVSize int) error {
+	if maxVSize > 0 && vsize > maxVSize {
+		return fmt.Errorf("%w: vsize=%v exceeds max=%v", ErrTxTooLarge,
+			vsize, maxVSize)
+	}
+
+	return nil
+}
+
+// checkOutputCount checks that the given number of outputs doesn't exceed the
+// max, if one is set.
+func checkOutputCount(count, maxOutputs int) error {
+	if maxOutputs > 0 && count > maxOutputs {
+		return fmt.Errorf("%w: outputs=%v, max=%v", ErrTooManyOutputs,
+			count, maxOutputs)
+	}
+
+	return nil
+}
+
+// txInSequence returns the nSequence to use for the given input in a sweeping
+// tx. An input with a CSV delay must use it as its sequence, which always
+// signals replaceability. Otherwise, the sequence is zero for a replaceable
+// tx, and the max non-final sequence for a non-replaceable one so the tx can
+// still use a locktime.
+func txInSequence(inp input.Input, replaceable bool) uint32 {
+	csv := inp.BlocksToMaturity()
+	if csv != 0 || replaceable {
+		return csv
+	}
+
+	return wire.MaxTxInSequenceNum - 1
+}
@@ -242,8 +242,11 @@ func getWeightEstimate(inputs []input.Input, outputs []*wire.TxOut,
 	// up creating a sweep tx without a change output. It is okay to add the
 	// change output to the weight estimate regardless, since the estimated
 	// fee will just be subtracted from this already dust output, and
-	// trimmed.
+	// trimmed. No change output is added if no script is given, as the
+	// change is given up to the fees.
 	switch {
+	case len(outputPkScript) == 0:
+
 	case txscript.IsPayToTaproot(outputPkScript):
 		weightEstimate.addP2TROutput()
 
@@ -0,0 +1,729 @@
+package sweep
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	base "github.com/btcsuite/btcwallet/wallet"
+	"github.com/lightningnetwork/lnd/fn"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
+)
+
+// LeaseChecker is a function that returns true if the given wallet utxo is
+// currently leased by another subsystem, such as the funding manager, and
+// must not be selected for sweeping.
+type LeaseChecker func(op wire.OutPoint) bool
+
+// SpendChecker is a function that returns true if the given outpoint has
+// already been spent, e.g., by a sweep that confirmed via a competing path, so
+// an input spending it would make the sweeping tx invalid.
+type SpendChecker func(op wire.OutPoint) bool
+
+// UtxoScorer is a function that returns a score of the given wallet utxo,
+// such as its privacy risk. Wallet utxos with lower scores are spent first.
+type UtxoScorer func(utxo *lnwallet.Utxo) float64
+
+// DominantInputWarning describes a wallet input that contributes the majority
+// of the wallet value added to a sweep, which is often a sign of poor UTXO
+// management.
+type DominantInputWarning struct {
+	// OutPoint is the outpoint of the dominant wallet input.
+	OutPoint wire.OutPoint
+
+	// Value is the value of the dominant wallet input.
+	Value btcutil.Amount
+
+	// WalletInputTotal is the total value of the wallet inputs added.
+	WalletInputTotal btcutil.Amount
+}
+
+// WarningRecorder records the structured warnings emitted when adding wallet
+// inputs to an input set.
+type WarningRecorder interface {
+	// RecordDominantWalletInput is called when a single wallet input
+	// dominates the wallet value added to a sweep.
+	RecordDominantWalletInput(warning DominantInputWarning)
+}
+
+// prioritizeUneconomical reorders the given utxos by placing the ones whose
+// standalone spend would be uneconomical at the future fee rate, i.e., the fee
+// to spend them is at least their value, at the start of the slice. The order
+// within each group is preserved.
+func prioritizeUneconomical(utxos []*lnwallet.Utxo,
+	futureFeeRate chainfee.SatPerKWeight) ([]*lnwallet.Utxo, error) {
+
+	var uneconomical, rest []*lnwallet.Utxo
+	for _, utxo := range utxos {
+		inp, err := createWalletTxInput(utxo)
+		if err != nil {
+			return nil, err
+		}
+
+		// Calculate the weight added by spending this input.
+		var estimator input.TxWeightEstimator
+		baseWeight := estimator.Weight()
+		err = inp.WitnessType().AddWeightEstimation(&estimator)
+		if err != nil {
+			return nil, err
+		}
+		weight := int64(estimator.Weight() - baseWeight)
+
+		if futureFeeRate.FeeForWeight(weight) >= utxo.Value {
+			log.Debugf("Prioritizing wallet utxo %v which is "+
+				"uneconomical at fee rate %v", utxo.OutPoint,
+				futureFeeRate)
+
+			uneconomical = append(uneconomical, utxo)
+
+			continue
+		}
+
+		rest = append(rest, utxo)
+	}
+
+	return append(uneconomical, rest...), nil
+}
+
+// checkDominantWalletInput emits a warning if a single wallet utxo
+// contributes more than dominantWalletInputPercent of the total value of the
+// added wallet utxos. The warning is logged and passed to the recorder if one
+// is given. Nothing is checked if fewer than two utxos were added, as a
+// single utxo always dominates.
+func checkDominantWalletInput(added []*lnwallet.Utxo,
+	recorder WarningRecorder) {
+
+	if len(added) < 2 {
+		return
+	}
+
+	var total btcutil.Amount
+	for _, utxo := range added {
+		total += utxo.Value
+	}
+
+	for _, utxo := range added {
+		if utxo.Value*100 <= total*dominantWalletInputPercent {
+			continue
+		}
+
+		log.Warnf("Wallet input %v of %v dominates the wallet input "+
+			"total %v used in sweep", utxo.OutPoint, utxo.Value,
+			total)
+
+		if recorder != nil {
+			recorder.RecordDominantWalletInput(
+				DominantInputWarning{
+					OutPoint:         utxo.OutPoint,
+					Value:            utxo.Value,
+					WalletInputTotal: total,
+				},
+			)
+		}
+	}
+}
+
+// walletUtxoFilter defines which wallet utxos can be used as wallet inputs.
+type walletUtxoFilter struct {
+	// isLeased is an optional checker used to skip the utxos leased by
+	// other subsystems.
+	isLeased LeaseChecker
+
+	// minConfs is the min number of confirmations of the utxos. Zero means
+	// defaultWalletMinConfs.
+	minConfs int32
+
+	// maxConfs is the max number of confirmations of the utxos. Zero means
+	// no max.
+	maxConfs int32
+
+	// minValue is the min value of the utxos. Zero means no min.
+	minValue btcutil.Amount
+
+	// scorer is an optional scorer used to order the utxos by ascending
+	// score before their values.
+	scorer UtxoScorer
+
+	// account is the optional wallet account the utxos are taken from
+	// first. The default account is used as a fallback.
+	account string
+
+	// exclude holds the utxos to skip, such as the ones already taken
+	// from the sweep account or already in the set.
+	exclude fn.Set[wire.OutPoint]
+
+	// maxAncestors is the max number of unconfirmed ancestors of the
+	// utxos, which is only enforced if the wallet implements
+	// AncestorCounter. Zero means no max.
+	maxAncestors uint32
+
+	// ownChange holds the change outputs of prior sweeps, which are
+	// selected ahead of the other utxos.
+	ownChange fn.Set[wire.OutPoint]
+}
+
+// ConfirmationBucket is a range of confirmation depths, so all the wallet
+// inputs of a sweep have a similar reorg-safety.
+type ConfirmationBucket struct {
+	// MinConfs is the min number of confirmations, which must be at least
+	// one.
+	MinConfs int32
+
+	// MaxConfs is the max number of confirmations, which must be no less
+	// than MinConfs.
+	MaxConfs int32
+}
+
+// validate checks that the bucket is a non-empty range of confirmed depths.
+func (c ConfirmationBucket) validate() error {
+	if c.MinConfs < 1 || c.MaxConfs < c.MinConfs {
+		return fmt.Errorf("invalid confirmation bucket: min=%v, max=%v",
+			c.MinConfs, c.MaxConfs)
+	}
+
+	return nil
+}
+
+// withBucket returns the filter with its confirmation range narrowed to the
+// given bucket, if any. The min confs of the filter still applies if it's
+// deeper than the min of the bucket.
+func (f walletUtxoFilter) withBucket(
+	bucket fn.Option[ConfirmationBucket]) walletUtxoFilter {
+
+	bucket.WhenSome(func(c ConfirmationBucket) {
+		f.minConfs = max(walletMinConfs(f.minConfs), c.MinConfs)
+		f.maxConfs = c.MaxConfs
+	})
+
+	return f
+}
+
+// fetchWalletUtxos retrieves the wallet utxos that can be used for sweeping.
+// Only utxos with at least the min confirmations of the filter, one by
+// default, and no more than its max confirmations, if any, are considered to
+// prevent problems around RBF rules for unconfirmed inputs. The utxos rejected
+// by the filter are skipped, and the remaining ones are sorted by putting
+// smaller values at the start of the slice to avoid locking large UTXO for
+// sweeping.
+//
+// TODO(yy): add more choices to CoinSelectionStrategy and use the configured
+// value here.
+func fetchWalletUtxos(wallet Wallet,
+	filter walletUtxoFilter) ([]*lnwallet.Utxo, error) {
+
+	utxos, err := wallet.ListUnspentWitnessFromDefaultAccount(
+		walletMinConfs(filter.minConfs),
+		walletMaxConfs(filter.maxConfs),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list unspent witness: %w", err)
+	}
+
+	return excludeDeepAncestors(
+		wallet, prepareWalletUtxos(utxos, filter), filter,
+	)
+}
+
+// walletHasUtxos returns true if the wallet lists any utxo that
+// forEachWalletUtxoBatch selects from, i.e., a utxo with the confirmations of
+// the filter in its sweep account or in the default account. The other
+// filters used to select the wallet inputs aren't applied, so it tells a
+// wallet without spendable utxos apart from one whose utxos were all filtered
+// out. If the wallet implements UtxoPager, only the first utxo of the default
+// account is fetched.
+func walletHasUtxos(wallet Wallet, filter walletUtxoFilter) (bool, error) {
+	minConfs := walletMinConfs(filter.minConfs)
+	maxConfs := walletMaxConfs(filter.maxConfs)
+
+	lister, ok := wallet.(AccountUtxoLister)
+	if ok && filter.account != "" {
+		utxos, err := lister.ListUnspentWitnessFromAccount(
+			filter.account, minConfs, maxConfs,
+		)
+		if err != nil {
+			return false, fmt.Errorf("list unspent witness from "+
+				"account %v: %w", filter.account, err)
+		}
+
+		if len(utxos) > 0 {
+			return true, nil
+		}
+	}
+
+	var (
+		utxos []*lnwallet.Utxo
+		err   error
+	)
+	if pager, ok := wallet.(UtxoPager); ok {
+		utxos, err = pager.ListUnspentWitnessPage(
+			minConfs, maxConfs, 0, 1,
+		)
+	} else {
+		utxos, err = wallet.ListUnspentWitnessFromDefaultAccount(
+			minConfs, maxConfs,
+		)
+	}
+	if err != nil {
+		return false, fmt.Errorf("list unspent witness: %w", err)
+	}
+
+	return len(utxos) > 0, nil
+}
+
+// excludeDeepAncestors returns the given utxos without the ones having more
+// unconfirmed ancestors than the max of the filter, keeping their order. The
+// utxos are returned as is if the filter has no max or the wallet can't count
+// the ancestors.
+func excludeDeepAncestors(wallet Wallet, utxos []*lnwallet.Utxo,
+	filter walletUtxoFilter) ([]*lnwallet.Utxo, error) {
+
+	counter, ok := wallet.(AncestorCounter)
+	if filter.maxAncestors == 0 || !ok {
+		return utxos, nil
+	}
+
+	kept := make([]*lnwallet.Utxo, 0, len(utxos))
+	for _, utxo := range utxos {
+		count, err := counter.UnconfirmedAncestors(utxo.OutPoint)
+		if err != nil {
+			return nil, fmt.Errorf("count ancestors of utxo %v: %w",
+				utxo.OutPoint, err)
+		}
+
+		if count > filter.maxAncestors {
+			log.Debugf("Skipped wallet utxo %v with %v "+
+				"unconfirmed ancestors above max %v",
+				utxo.OutPoint, count, filter.maxAncestors)
+
+			continue
+		}
+
+		kept = append(kept, utxo)
+	}
+
+	return kept, nil
+}
+
+// walletMinConfs returns the min number of confirmations used to list the
+// wallet utxos, which is defaultWalletMinConfs unless a positive value is
+// given.
+func walletMinConfs(minConfs int32) int32 {
+	if minConfs <= 0 {
+		return defaultWalletMinConfs
+	}
+
+	return minConfs
+}
+
+// walletMaxConfs returns the max number of confirmations used to list the
+// wallet utxos, which is unbounded unless a positive value is given.
+func walletMaxConfs(maxConfs int32) int32 {
+	if maxConfs <= 0 {
+		return math.MaxInt32
+	}
+
+	return maxConfs
+}
+
+// prepareWalletUtxos filters out the leased utxos if a checker is supplied,
+// the utxos deeper than the max confirmations, in case the wallet doesn't
+// enforce it, and the utxos below the min value, then sorts the remaining
+// ones ascending by value. If a scorer is supplied, the utxos are sorted
+// ascending by score first, and the value only breaks ties. The change
+// outputs of prior sweeps hinted by the filter are placed ahead of all the
+// other utxos, so sweep change is recycled first. Utxos that tie are ordered
+// by their outpoints, so the selection is the same across runs no matter the
+// order the wallet returns them in. Filtering before sorting saves the work on
+// utxos that are never used.
+func prepareWalletUtxos(utxos []*lnwallet.Utxo,
+	filter walletUtxoFilter) []*lnwallet.Utxo {
+
+	filtered := make([]*lnwallet.Utxo, 0, len(utxos))
+	for _, utxo := range utxos {
+		if filter.exclude.Contains(utxo.OutPoint) {
+			continue
+		}
+
+		if filter.isLeased != nil && filter.isLeased(utxo.OutPoint) {
+			log.Debugf("Skipped leased wallet utxo %v",
+				utxo.OutPoint)
+
+			continue
+		}
+
+		if filter.maxConfs > 0 &&
+			utxo.Confirmations > int64(filter.maxConfs) {
+
+			log.Tracef("Skipped wallet utxo %v with %v confs "+
+				"above max confs %v", utxo.OutPoint,
+				utxo.Confirmations, filter.maxConfs)
+
+			continue
+		}
+
+		if utxo.Value < filter.minValue {
+			log.Tracef("Skipped wallet utxo %v with value %v "+
+				"below min value %v", utxo.OutPoint,
+				utxo.Value, filter.minValue)
+
+			continue
+		}
+
+		filtered = append(filtered, utxo)
+	}
+
+	// isOwnChange returns true if the utxo at index i is hinted as the
+	// change of a prior sweep.
+	isOwnChange := func(i int) bool {
+		return filter.ownChange.Contains(filtered[i].OutPoint)
+	}
+
+	if filter.scorer == nil {
+		sort.SliceStable(filtered, func(i, j int) bool {
+			if ci, cj := isOwnChange(i), isOwnChange(j); ci != cj {
+				return ci
+			}
+
+			if filtered[i].Value != filtered[j].Value {
+				return filtered[i].Value < filtered[j].Value
+			}
+
+			return outpointLess(
+				filtered[i].OutPoint, filtered[j].OutPoint,
+			)
+		})
+
+		return filtered
+	}
+
+	// Score each utxo once, as the scorer may be expensive.
+	scores := make(map[wire.OutPoint]float64, len(filtered))
+	for _, utxo := range filtered {
+		scores[utxo.OutPoint] = filter.scorer(utxo)
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		if ci, cj := isOwnChange(i), isOwnChange(j); ci != cj {
+			return ci
+		}
+
+		si := scores[filtered[i].OutPoint]
+		sj := scores[filtered[j].OutPoint]
+		if si != sj {
+			return si < sj
+		}
+
+		if filtered[i].Value != filtered[j].Value {
+			return filtered[i].Value < filtered[j].Value
+		}
+
+		return outpointLess(filtered[i].OutPoint, filtered[j].OutPoint)
+	})
+
+	return filtered
+}
+
+// outpointLess reports whether outpoint a sorts before outpoint b, comparing
+// the txids first and the output indexes second. It's used as the last tie
+// breaker when sorting utxos to make the order deterministic.
+func outpointLess(a, b wire.OutPoint) bool {
+	if cmp := bytes.Compare(a.Hash[:], b.Hash[:]); cmp != 0 {
+		return cmp < 0
+	}
+
+	return a.Index < b.Index
+}
+
+// walletUtxoPageSize is the number of utxos fetched per page from a wallet
+// implementing UtxoPager.
+const walletUtxoPageSize = 500
+
+// forEachWalletUtxoBatch passes the wallet utxos that can be used for sweeping
+// to cb in batches. Each batch is sorted by prepareWalletUtxos on its own, so
+// when the wallet is paged, the utxos are only ordered within a page and the
+// order across pages is the one of the wallet. If the filter has an account
+// and the wallet implements AccountUtxoLister, the utxos of that account are
+// passed first in a single batch. The utxos of the default account are only
+// passed if cb doesn't return true for it, so a sweep falls back to the
+// default account when the sweep account can't fund it. The number of utxos
+// passed to cb is returned.
+//
+// NOTE: must be called with the wallet lock held via `WithCoinSelectLock`, so
+// both accounts are selected from under the same lock.
+func forEachWalletUtxoBatch(wallet Wallet, filter walletUtxoFilter,
+	cb func(utxos []*lnwallet.Utxo) (bool, error)) (int, error) {
+
+	if filter.account == "" {
+		return forEachDefaultUtxoBatch(wallet, filter, cb)
+	}
+
+	lister, ok := wallet.(AccountUtxoLister)
+	if !ok {
+		log.Debugf("Wallet can't list the utxos of sweep account %v, "+
+			"using the default account", filter.account)
+
+		return forEachDefaultUtxoBatch(wallet, filter, cb)
+	}
+
+	utxos, err := lister.ListUnspentWitnessFromAccount(
+		filter.account, walletMinConfs(filter.minConfs),
+		walletMaxConfs(filter.maxConfs),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("list unspent witness from account %v: "+
+			"%w", filter.account, err)
+	}
+
+	utxos, err = excludeDeepAncestors(
+		wallet, prepareWalletUtxos(utxos, filter), filter,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(utxos) > 0 {
+		done, err := cb(utxos)
+		if err != nil || done {
+			return len(utxos), err
+		}
+	}
+
+	log.Debugf("Sweep account %v can't fund the set with %v utxos, "+
+		"falling back to the default account", filter.account,
+		len(utxos))
+
+	// Skip the utxos already passed in case the default account lists
+	// them too, on top of the ones already excluded.
+	exclude := fn.NewSet[wire.OutPoint]()
+	for op := range filter.exclude {
+		exclude.Add(op)
+	}
+	for _, utxo := range utxos {
+		exclude.Add(utxo.OutPoint)
+	}
+	filter.exclude = exclude
+
+	total, err := forEachDefaultUtxoBatch(wallet, filter, cb)
+
+	return len(utxos) + total, err
+}
+
+// forEachDefaultUtxoBatch passes the utxos of the default wallet account that
+// can be used for sweeping to cb in batches. If the wallet implements
+// UtxoPager, each page is a batch and no more pages are fetched once cb
+// returns true. Otherwise, all the utxos are fetched at once and passed in a
+// single batch. The number of utxos passed to cb is returned.
+func forEachDefaultUtxoBatch(wallet Wallet, filter walletUtxoFilter,
+	cb func(utxos []*lnwallet.Utxo) (bool, error)) (int, error) {
+
+	pager, ok := wallet.(UtxoPager)
+	if !ok {
+		utxos, err := fetchWalletUtxos(wallet, filter)
+		if err != nil {
+			return 0, err
+		}
+
+		if len(utxos) == 0 {
+			return 0, nil
+		}
+
+		_, err = cb(utxos)
+
+		return len(utxos), err
+	}
+
+	total := 0
+	for page := uint32(0); ; page++ {
+		utxos, err := pager.ListUnspentWitnessPage(
+			walletMinConfs(filter.minConfs),
+			walletMaxConfs(filter.maxConfs), page,
+			walletUtxoPageSize,
+		)
+		if err != nil {
+			return total, fmt.Errorf("list unspent witness page "+
+				"%d: %w", page, err)
+		}
+		lastPage := len(utxos) < walletUtxoPageSize
+
+		utxos, err = excludeDeepAncestors(
+			wallet, prepareWalletUtxos(utxos, filter), filter,
+		)
+		if err != nil {
+			return total, err
+		}
+
+		if len(utxos) > 0 {
+			total += len(utxos)
+
+			done, err := cb(utxos)
+			if err != nil || done {
+				return total, err
+			}
+		}
+
+		if lastPage {
+			return total, nil
+		}
+	}
+}
+
+// WalletInputConverter converts a wallet utxo into an input that can be swept.
+type WalletInputConverter func(*lnwallet.Utxo) (input.Input, error)
+
+var (
+	// walletInputConverters holds the converters registered for address
+	// types that aren't supported natively by the sweeper.
+	walletInputConverters = make(
+		map[lnwallet.AddressType]WalletInputConverter,
+	)
+
+	// walletInputConvertersMtx guards walletInputConverters.
+	walletInputConvertersMtx sync.RWMutex
+)
+
+// RegisterWalletInputConverter registers a converter used to build the inputs
+// of the wallet utxos with the given address type, so custom script types can
+// be swept without modifying the sweeper. Registering a converter again for
+// the same address type replaces it. The built-in address types are always
+// converted by the sweeper itself, so a converter registered for one of them
+// is never used.
+func RegisterWalletInputConverter(addrType lnwallet.AddressType,
+	converter WalletInputConverter) {
+
+	walletInputConvertersMtx.Lock()
+	defer walletInputConvertersMtx.Unlock()
+
+	walletInputConverters[addrType] = converter
+}
+
+// registeredWalletInputConverter returns the converter registered for the
+// given address type, if any.
+func registeredWalletInputConverter(
+	addrType lnwallet.AddressType) (WalletInputConverter, bool) {
+
+	walletInputConvertersMtx.RLock()
+	defer walletInputConvertersMtx.RUnlock()
+
+	converter, ok := walletInputConverters[addrType]
+
+	return converter, ok
+}
+
+// createWalletTxInput converts a wallet utxo into an object that can be added
+// to the other inputs to sweep. Utxos of an address type that isn't built-in
+// are converted by the converter registered for it, if any.
+func createWalletTxInput(utxo *lnwallet.Utxo) (input.Input, error) {
+	signDesc := &input.SignDescriptor{
+		Output: &wire.TxOut{
+			PkScript: utxo.PkScript,
+			Value:    int64(utxo.Value),
+		},
+		HashType: txscript.SigHashAll,
+	}
+
+	var witnessType input.WitnessType
+	switch {
+	case utxo.AddressType == lnwallet.WitnessPubKey:
+		witnessType = input.WitnessKeyHash
+
+	case utxo.AddressType == lnwallet.NestedWitnessPubKey:
+		witnessType = input.NestedWitnessKeyHash
+
+	case utxo.AddressType == lnwallet.TaprootPubkey:
+		witnessType = input.TaprootPubKeySpend
+		signDesc.HashType = txscript.SigHashDefault
+
+	default:
+		converter, ok := registeredWalletInputConverter(
+			utxo.AddressType,
+		)
+		if !ok {
+			return nil, fmt.Errorf("unknown address type %v",
+				utxo.AddressType)
+		}
+
+		return converter(utxo)
+	}
+
+	// A height hint doesn't need to be set, because we don't monitor these
+	// inputs for spend.
+	heightHint := uint32(0)
+
+	return input.NewBaseInput(
+		&utxo.OutPoint, witnessType, signDesc, heightHint,
+	), nil
+}
+
+// walletAddressType returns the address type of a wallet utxo spent with the
+// given witness type, which reverses the mapping done by createWalletTxInput.
+// False is returned for witness types not created from a known address type.
+func walletAddressType(
+	witnessType input.WitnessType) (lnwallet.AddressType, bool) {
+
+	switch witnessType {
+	case input.WitnessKeyHash:
+		return lnwallet.WitnessPubKey, true
+
+	case input.NestedWitnessKeyHash:
+		return lnwallet.NestedWitnessPubKey, true
+
+	case input.TaprootPubKeySpend:
+		return lnwallet.TaprootPubkey, true
+
+	default:
+		return 0, false
+	}
+}
+
+// isWalletUtxoSpend returns true if the given witness type spends a wallet
+// utxo, i.e., it's one of the key spends created by createWalletTxInput for
+// the built-in wallet address types.
+func isWalletUtxoSpend(witnessType input.WitnessType) bool {
+	_, ok := walletAddressType(witnessType)
+
+	return ok
+}
+
+// arrangeUtxos orders the given utxos using the specified coin selection
+// strategy. The utxos are returned unchanged if the strategy is nil.
+func arrangeUtxos(utxos []*lnwallet.Utxo,
+	strategy base.CoinSelectionStrategy) ([]*lnwallet.Utxo, error) {
+
+	if strategy == nil {
+		return utxos, nil
+	}
+
+	coins := make([]base.Coin, 0, len(utxos))
+	utxoIndex := make(map[wire.OutPoint]*lnwallet.Utxo, len(utxos))
+	for _, utxo := range utxos {
+		coins = append(coins, base.Coin{
+			TxOut: wire.TxOut{
+				Value:    int64(utxo.Value),
+				PkScript: utxo.PkScript,
+			},
+			OutPoint: utxo.OutPoint,
+		})
+		utxoIndex[utxo.OutPoint] = utxo
+	}
+
+	// The budget of the set is used to pay the fees, so no fee rate is
+	// given to the strategy.
+	arranged, err := strategy.ArrangeCoins(coins, 0)
+	if err != nil {
+		return nil, fmt.Errorf("arrange coins: %w", err)
+	}
+
+	result := make([]*lnwallet.Utxo, 0, len(arranged))
+	for _, coin := range arranged {
+		result = append(result, utxoIndex[coin.OutPoint])
+	}
+
+	return result, nil
+}
@@ -1,13 +1,54 @@
 package sweep
 
 import (
+	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/lnwallet"
 	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
 )
 
+// inputWeight caches the parameters used to estimate the weight an input adds
+// to a tx, so they don't need to be recomputed every time the weight of a tx
+// is estimated.
+type inputWeight struct {
+	// witnessSize is the upper bound of the input's witness size.
+	witnessSize int
+
+	// nestedP2SH indicates the input spends a P2SH output with a nested
+	// witness script.
+	nestedP2SH bool
+}
+
+// weight returns the weight the input adds to a tx, which includes the
+// outpoint, sequence and script sig of the input, and the upper bound of its
+// witness.
+func (iw inputWeight) weight() int64 {
+	// A nested P2SH input carries an additional push in its sig script.
+	inputSize := input.InputSize
+	if iw.nestedP2SH {
+		inputSize += input.NestedP2WSHSize
+	}
+
+	return int64(inputSize*blockchain.WitnessScaleFactor + iw.witnessSize)
+}
+
+// newInputWeight computes the weight estimation parameters of the given
+// input.
+func newInputWeight(inp input.Input) (inputWeight, error) {
+	size, nestedP2SH, err := inp.WitnessType().SizeUpperBound()
+	if err != nil {
+		return inputWeight{}, err
+	}
+
+	return inputWeight{
+		witnessSize: size,
+		nestedP2SH:  nestedP2SH,
+	}, nil
+}
+
 // weightEstimator wraps a standard weight estimator instance and adds to that
 // support for child-pays-for-parent.
 type weightEstimator struct {
@@ -32,6 +73,23 @@ func newWeightEstimator(
 	}
 }
 
+// clone returns a deep copy of the weight estimator.
+func (w *weightEstimator) clone() *weightEstimator {
+	parents := make(map[chainhash.Hash]struct{}, len(w.parents))
+	for hash := range w.parents {
+		parents[hash] = struct{}{}
+	}
+
+	return &weightEstimator{
+		estimator:     w.estimator,
+		feeRate:       w.feeRate,
+		parents:       parents,
+		parentsFee:    w.parentsFee,
+		parentsWeight: w.parentsWeight,
+		maxFeeRate:    w.maxFeeRate,
+	}
+}
+
 // add adds the weight of the given input to the weight estimate.
 func (w *weightEstimator) add(inp input.Input) error {
 	// If there is a parent tx, add the parent's fee and weight.
@@ -42,6 +100,21 @@ func (w *weightEstimator) add(inp input.Input) error {
 	return wt.AddWeightEstimation(&w.estimator)
 }
 
+// addWithWeight adds the weight of the given input to the weight estimate
+// using its precomputed weight estimation parameters.
+func (w *weightEstimator) addWithWeight(inp input.Input, iw inputWeight) {
+	// If there is a parent tx, add the parent's fee and weight.
+	w.tryAddParent(inp)
+
+	// If this is a nested P2SH input, then we'll need to factor in the
+	// additional data push within the sigScript.
+	if iw.nestedP2SH {
+		w.estimator.AddNestedP2WSHInput(iw.witnessSize)
+	} else {
+		w.estimator.AddWitnessInput(iw.witnessSize)
+	}
+}
+
 // tryAddParent examines the input and updates parent tx totals if required for
 // cpfp.
 func (w *weightEstimator) tryAddParent(inp input.Input) {
@@ -89,6 +162,22 @@ func (w *weightEstimator) addP2TROutput() {
 	w.estimator.AddP2TROutput()
 }
 
+// addChangeOutput updates the weight estimate to account for an additional
+// change output of the given address type. The unknown address type is
+// counted as a p2tr output.
+func (w *weightEstimator) addChangeOutput(addrType lnwallet.AddressType) {
+	switch addrType {
+	case lnwallet.WitnessPubKey:
+		w.estimator.AddP2WKHOutput()
+
+	case lnwallet.NestedWitnessPubKey:
+		w.estimator.AddP2SHOutput()
+
+	default:
+		w.estimator.AddP2TROutput()
+	}
+}
+
 // addP2WSHOutput updates the weight estimate to account for an additional
 // segwit v0 P2WSH output.
 func (w *weightEstimator) addP2WSHOutput() {
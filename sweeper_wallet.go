@@ -2,9 +2,15 @@ package lnd
 
 import (
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/sweep"
 )
 
+// Compile-time constraint to ensure sweeperWallet can list the utxos of the
+// sweep account.
+var _ sweep.AccountUtxoLister = (*sweeperWallet)(nil)
+
 // sweeperWallet is a wrapper around the LightningWallet that implements the
 // sweeper's Wallet interface.
 type sweeperWallet struct {
@@ -27,3 +33,36 @@ func (s *sweeperWallet) CancelRebroadcast(txid chainhash.Hash) {
 		s.Cfg.Rebroadcaster.MarkAsConfirmed(txid)
 	}
 }
+
+// ListUnspentWitnessFromAccount returns all unspent outputs which are version 0
+// witness programs from the given wallet account, with a confirmation count in
+// the given range.
+//
+// NOTE: This method requires the global coin selection lock to be held.
+func (s *sweeperWallet) ListUnspentWitnessFromAccount(account string, minConfs,
+	maxConfs int32) ([]*lnwallet.Utxo, error) {
+
+	return s.WalletController.ListUnspentWitness(
+		minConfs, maxConfs, account,
+	)
+}
+
+// isLeased returns true if the given wallet utxo is currently leased, e.g., by
+// the funding manager or via the LeaseOutput RPC, so the sweeper must not
+// select it.
+func (s *sweeperWallet) isLeased(op wire.OutPoint) bool {
+	leases, err := s.ListLeasedOutputs()
+	if err != nil {
+		srvrLog.Errorf("Unable to list leased outputs: %v", err)
+
+		return false
+	}
+
+	for _, lease := range leases {
+		if lease.Outpoint == op {
+			return true
+		}
+	}
+
+	return false
+}